@@ -3,7 +3,7 @@ package main
 import (
 	"context"
 	"github.com/gin-gonic/gin"
-	"github.com/jackc/pgx/v5"
+	"libary-service/internal/database"
 	"libary-service/internal/direct-service/app"
 	"libary-service/internal/direct-service/repository"
 	"log"
@@ -13,14 +13,13 @@ import (
 func main() {
 	//Connect to DB
 	dbURL := os.Getenv("DATABASE_URL")
-	var err error
-	conn, err := pgx.Connect(context.Background(), dbURL)
+	pool, err := database.NewPool(context.Background(), dbURL)
 	if err != nil {
 		panic(err)
 	}
 	log.Printf("Successfully connected to database")
-	repository.DB = conn
-	defer conn.Close(context.Background())
+	repository.DB = pool
+	defer pool.Close()
 
 	//HTTP Router
 	router := gin.Default()
@@ -43,6 +42,8 @@ func main() {
 	router.PUT("/lendings/:id", gin.WrapF(app.UpdateLending))
 	router.DELETE("/lendings/:id", gin.WrapF(app.DeleteLending))
 
+	router.GET("/stats/database", gin.WrapF(app.GetDatabaseStats))
+
 	if err := router.Run(":8080"); err != nil {
 		panic(err)
 	}
@@ -1,23 +1,43 @@
 package main
 
 import (
+	"context"
 	"libary-service/internal/injected-service/app"
+	"libary-service/internal/injected-service/config"
+	"libary-service/internal/injected-service/repository"
+	"libary-service/internal/injected-service/repository/inmemoryrepository"
 	"libary-service/internal/injected-service/repository/postgres"
+	"libary-service/internal/injected-service/repository/sqlite"
 	"libary-service/internal/injected-service/router/gin"
 	"libary-service/internal/injected-service/validation/validator"
 	"log"
 )
 
 func main() {
-	repository := postgresrepository.New()
-	if err := repository.Connect(); err != nil {
+	cfg, err := config.FromEnv()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	repo := newRepository(cfg.Backend)
+	if err := repo.Connect(context.Background()); err != nil {
 		log.Fatalf("Failed to connect to database: %v", err)
 	}
-	defer repository.Disconnect()
-	validator := validator.New(repository)
-	service := app.NewLibaryService(repository, validator)
+	defer repo.Disconnect(context.Background())
+	validator := validator.New(repo)
+	service := app.NewLibaryService(repo, validator, cfg)
 	router := gin.NewGinRouter(service)
 	if err := router.Serve(":8080"); err != nil {
 		log.Fatalf("Failed to serve: %v", err)
 	}
 }
+
+// newRepository returns the repository of the configured backend.
+func newRepository(backend config.Backend) repository.Repository {
+	switch backend {
+	case config.BackendSQLite:
+		return sqliterepository.New()
+	case config.BackendMemory:
+		return inmemoryrepository.New()
+	}
+	return postgresrepository.New()
+}
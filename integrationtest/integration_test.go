@@ -31,6 +31,8 @@ func clearDB(t *testing.T) {
 
 	_, err = conn.Exec(context.Background(), "DELETE FROM lendings")
 	require.NoError(t, err, "Failed to clean lendings table")
+	_, err = conn.Exec(context.Background(), "DELETE FROM book_copies")
+	require.NoError(t, err, "Failed to clean book_copies table")
 	_, err = conn.Exec(context.Background(), "DELETE FROM users")
 	require.NoError(t, err, "Failed to clean users table")
 	_, err = conn.Exec(context.Background(), "DELETE FROM books")
@@ -38,9 +40,19 @@ func clearDB(t *testing.T) {
 }
 
 func makeRequest(t *testing.T, method, url string, body []byte) *http.Response {
+	return makeConditionalRequest(t, method, url, "", body)
+}
+
+// makeConditionalRequest sends an update or delete with the entity tag it is
+// based on as If-Match. The direct service hands out no entity tags, so the
+// header is left out when etag is empty.
+func makeConditionalRequest(t *testing.T, method, url, etag string, body []byte) *http.Response {
 	req, err := http.NewRequest(method, url, bytes.NewBuffer(body))
 	require.NoError(t, err, "Failed to create request")
 	req.Header.Set("Content-Type", "application/json")
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
 
 	client := &http.Client{}
 	resp, err := client.Do(req)
@@ -50,9 +62,13 @@ func makeRequest(t *testing.T, method, url string, body []byte) *http.Response {
 }
 
 func makeJsonRequest(t *testing.T, method, url string, filePath string) *http.Response {
+	return makeConditionalJsonRequest(t, method, url, "", filePath)
+}
+
+func makeConditionalJsonRequest(t *testing.T, method, url, etag string, filePath string) *http.Response {
 	jsonBytes, err := os.ReadFile(filePath)
 	require.NoError(t, err, "Failed to read JSON file")
-	return makeRequest(t, method, url, jsonBytes)
+	return makeConditionalRequest(t, method, url, etag, jsonBytes)
 }
 
 func decodeResponse(t *testing.T, resp *http.Response, target interface{}) {
@@ -69,30 +85,36 @@ func decodeResponse(t *testing.T, resp *http.Response, target interface{}) {
 }
 
 func TestServices(t *testing.T) {
-	t.Run("Testing Direct-Service", func(t *testing.T) { serviceTest(t, directServiceURL) })
-	t.Run("Testing Injected-Service", func(t *testing.T) { serviceTest(t, injectedServiceURL) })
+	t.Run("Testing Direct-Service", func(t *testing.T) { serviceTest(t, directServiceURL, false) })
+	t.Run("Testing Injected-Service", func(t *testing.T) { serviceTest(t, injectedServiceURL, true) })
 }
 
 type reqLending struct {
 	ID         *string   `json:"id,omitempty" db:"id"`
 	BookID     string    `json:"book_id" db:"book_id"`
+	CopyID     string    `json:"copy_id,omitempty" db:"copy_id"`
 	UserID     string    `json:"user_id" db:"user_id"`
 	LendDate   time.Time `json:"lend_date" db:"lend_date"`
 	ReturnDate time.Time `json:"return_date,omitempty" db:"return_date"`
 }
 
-func serviceTest(t *testing.T, baseURL string) {
+// serviceTest runs the shared scenario. Only the injected service tracks
+// individual book copies, so inventory enables the copy specific steps.
+func serviceTest(t *testing.T, baseURL string, inventory bool) {
 	clearDB(t)
 
 	t.Run("Testing Books", func(t *testing.T) { testBooks(t, baseURL) })
 	t.Run("Testing Users", func(t *testing.T) { testUsers(t, baseURL) })
-	t.Run("Testing Lendings", func(t *testing.T) { testLendings(t, baseURL) })
+	t.Run("Testing Lendings", func(t *testing.T) { testLendings(t, baseURL, inventory) })
 }
 
 func testBooks(t *testing.T, baseURL string) {
 	book := domain.Book{
-		Title:  "The Fellowship of the Ring",
-		Author: "J. R. R. Tolkien",
+		Title:           "The Fellowship of the Ring",
+		Author:          "J. R. R. Tolkien",
+		Authors:         []string{"J. R. R. Tolkien"},
+		PublicationYear: 1954,
+		Subjects:        []string{"Fantasy", "Adventure"},
 	}
 
 	resp := makeJsonRequest(t, http.MethodPost, baseURL+"/books", "book_create.json")
@@ -104,17 +126,22 @@ func testBooks(t *testing.T, baseURL string) {
 	assert.NotEmpty(t, createdBook.ID)
 	assert.Equal(t, book.Title, createdBook.Title)
 	assert.Equal(t, book.Author, createdBook.Author)
+	assert.Equal(t, book.Authors, createdBook.Authors)
+	assert.Equal(t, book.PublicationYear, createdBook.PublicationYear)
+	assert.Equal(t, book.Subjects, createdBook.Subjects)
 
 	resp = makeRequest(t, http.MethodGet, fmt.Sprintf("%s/books/%s", baseURL, createdBook.ID), nil)
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 
+	etag := resp.Header.Get("ETag")
 	var retrievedBook domain.Book
 	decodeResponse(t, resp, &retrievedBook)
 	assert.Equal(t, createdBook, retrievedBook)
 
-	resp = makeJsonRequest(t, http.MethodPut, fmt.Sprintf("%s/books/%s", baseURL, createdBook.ID), "book_update.json")
+	resp = makeConditionalJsonRequest(t, http.MethodPut, fmt.Sprintf("%s/books/%s", baseURL, createdBook.ID), etag, "book_update.json")
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 
+	etag = resp.Header.Get("ETag")
 	var updatedBook domain.Book
 	decodeResponse(t, resp, &updatedBook)
 	assert.Equal(t, "The Two Towers", updatedBook.Title)
@@ -127,7 +154,7 @@ func testBooks(t *testing.T, baseURL string) {
 	assert.Equal(t, len(books), 1)
 	assert.Equal(t, books[0], updatedBook)
 
-	resp = makeRequest(t, http.MethodDelete, fmt.Sprintf("%s/books/%s", baseURL, createdBook.ID), nil)
+	resp = makeConditionalRequest(t, http.MethodDelete, fmt.Sprintf("%s/books/%s", baseURL, createdBook.ID), etag, nil)
 	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
 
 	resp = makeRequest(t, http.MethodGet, fmt.Sprintf("%s/books/%s", baseURL, createdBook.ID), nil)
@@ -148,11 +175,12 @@ func testUsers(t *testing.T, baseURL string) {
 	resp = makeRequest(t, http.MethodGet, fmt.Sprintf("%s/users/%s", baseURL, createdUser.ID), nil)
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 
+	etag := resp.Header.Get("ETag")
 	var retrievedUser domain.User
 	decodeResponse(t, resp, &retrievedUser)
 	assert.Equal(t, createdUser.ID, retrievedUser.ID)
 
-	resp = makeJsonRequest(t, http.MethodPut, fmt.Sprintf("%s/users/%s", baseURL, retrievedUser.ID), "user_update.json")
+	resp = makeConditionalJsonRequest(t, http.MethodPut, fmt.Sprintf("%s/users/%s", baseURL, retrievedUser.ID), etag, "user_update.json")
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 
 	var updatedUser domain.User
@@ -169,16 +197,18 @@ func testUsers(t *testing.T, baseURL string) {
 	assert.Equal(t, updatedUser, users[0])
 }
 
-func testLendings(t *testing.T, baseURL string) {
+func testLendings(t *testing.T, baseURL string, inventory bool) {
 	var createdBook domain.Book
 	var createdUser domain.User
 
 	resp := makeJsonRequest(t, http.MethodPost, baseURL+"/books", "book_create.json")
 	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	bookETag := resp.Header.Get("ETag")
 	decodeResponse(t, resp, &createdBook)
 
 	resp = makeJsonRequest(t, http.MethodPost, baseURL+"/users", "user_create.json")
 	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	userETag := resp.Header.Get("ETag")
 	decodeResponse(t, resp, &createdUser)
 
 	lending := reqLending{
@@ -187,6 +217,18 @@ func testLendings(t *testing.T, baseURL string) {
 		LendDate: time.Now(),
 	}
 
+	if inventory {
+		copyBytes, err := json.Marshal(domain.BookCopy{Barcode: "LIB-0001", Condition: domain.CopyConditionNew})
+		assert.NoError(t, err)
+		resp = makeRequest(t, http.MethodPost, fmt.Sprintf("%s/books/%s/copies", baseURL, createdBook.ID), copyBytes)
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+
+		var createdCopy domain.BookCopy
+		decodeResponse(t, resp, &createdCopy)
+		assert.Equal(t, domain.CopyStatusAvailable, createdCopy.Status)
+		lending.CopyID = createdCopy.ID
+	}
+
 	lendingBytes, err := json.Marshal(lending)
 	assert.NoError(t, err)
 	resp = makeRequest(t, http.MethodPost, baseURL+"/lendings", lendingBytes)
@@ -202,16 +244,33 @@ func testLendings(t *testing.T, baseURL string) {
 	resp = makeRequest(t, http.MethodGet, fmt.Sprintf("%s/lendings/%s", baseURL, createdLending.ID), nil)
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 
+	lendingETag := resp.Header.Get("ETag")
 	var retrievedLending domain.Lending
 	decodeResponse(t, resp, &retrievedLending)
 	assert.Equal(t, createdLending.ID, retrievedLending.ID)
 
-	lending.ReturnDate = time.Now().Add(7 * 24 * time.Hour)
-	lendingBytes, err = json.Marshal(lending)
-	assert.NoError(t, err)
-	resp = makeRequest(t, http.MethodPut, fmt.Sprintf("%s/lendings/%s", baseURL, retrievedLending.ID), lendingBytes)
+	if inventory {
+		resp = makeRequest(t, http.MethodGet, fmt.Sprintf("%s/books/%s", baseURL, createdBook.ID), nil)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var inventoryBook domain.BookInventory
+		decodeResponse(t, resp, &inventoryBook)
+		assert.Equal(t, 1, inventoryBook.TotalCopies)
+		assert.Equal(t, 0, inventoryBook.AvailableCopies)
+	}
+
+	if inventory {
+		// Only returning a lending may set its return date.
+		resp = makeRequest(t, http.MethodPost, fmt.Sprintf("%s/lendings/%s/return", baseURL, retrievedLending.ID), nil)
+	} else {
+		lending.ReturnDate = time.Now().Add(7 * 24 * time.Hour)
+		lendingBytes, err = json.Marshal(lending)
+		assert.NoError(t, err)
+		resp = makeConditionalRequest(t, http.MethodPut, fmt.Sprintf("%s/lendings/%s", baseURL, retrievedLending.ID), lendingETag, lendingBytes)
+	}
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 
+	lendingETag = resp.Header.Get("ETag")
 	var updatedLending domain.Lending
 	decodeResponse(t, resp, &updatedLending)
 	assert.NotZero(t, updatedLending.ReturnDate)
@@ -223,12 +282,12 @@ func testLendings(t *testing.T, baseURL string) {
 	decodeResponse(t, resp, &lendings)
 	assert.GreaterOrEqual(t, len(lendings), 1)
 
-	resp = makeRequest(t, http.MethodDelete, fmt.Sprintf("%s/lendings/%s", baseURL, createdLending.ID), nil)
+	resp = makeConditionalRequest(t, http.MethodDelete, fmt.Sprintf("%s/lendings/%s", baseURL, createdLending.ID), lendingETag, nil)
 	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
 
-	resp = makeRequest(t, http.MethodDelete, fmt.Sprintf("%s/books/%s", baseURL, createdBook.ID), nil)
+	resp = makeConditionalRequest(t, http.MethodDelete, fmt.Sprintf("%s/books/%s", baseURL, createdBook.ID), bookETag, nil)
 	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
 
-	resp = makeRequest(t, http.MethodDelete, fmt.Sprintf("%s/users/%s", baseURL, createdUser.ID), nil)
+	resp = makeConditionalRequest(t, http.MethodDelete, fmt.Sprintf("%s/users/%s", baseURL, createdUser.ID), userETag, nil)
 	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
 }
@@ -0,0 +1,115 @@
+// Package database opens the Postgres connection pools shared by both services.
+package database
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"os"
+	"strconv"
+	"time"
+)
+
+// NewPool connects a pool to url. The pool size, health checks and idle
+// timeouts are read from the environment:
+//
+//	DB_MIN_CONNS, DB_MAX_CONNS                   connection counts
+//	DB_MAX_CONN_IDLE_TIME, DB_MAX_CONN_LIFETIME  durations such as 5m
+//	DB_HEALTH_CHECK_PERIOD                       duration such as 30s
+//
+// Unset variables keep the pgxpool defaults.
+func NewPool(ctx context.Context, url string) (*pgxpool.Pool, error) {
+	config, err := pgxpool.ParseConfig(url)
+	if err != nil {
+		return nil, err
+	}
+	if err := int32FromEnv("DB_MIN_CONNS", &config.MinConns); err != nil {
+		return nil, err
+	}
+	if err := int32FromEnv("DB_MAX_CONNS", &config.MaxConns); err != nil {
+		return nil, err
+	}
+	if config.MaxConns < 1 || config.MinConns > config.MaxConns {
+		return nil, fmt.Errorf("DB_MIN_CONNS (%d) must not exceed DB_MAX_CONNS (%d), which must be positive", config.MinConns, config.MaxConns)
+	}
+	if err := durationFromEnv("DB_MAX_CONN_IDLE_TIME", &config.MaxConnIdleTime); err != nil {
+		return nil, err
+	}
+	if err := durationFromEnv("DB_MAX_CONN_LIFETIME", &config.MaxConnLifetime); err != nil {
+		return nil, err
+	}
+	if err := durationFromEnv("DB_HEALTH_CHECK_PERIOD", &config.HealthCheckPeriod); err != nil {
+		return nil, err
+	}
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	// NewWithConfig connects lazily; fail at startup if the database is unreachable.
+	if err := pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return pool, nil
+}
+
+// PoolStats is a snapshot of the state of a connection pool.
+type PoolStats struct {
+	MaxConns             int32         `json:"max_conns"`
+	TotalConns           int32         `json:"total_conns"`
+	IdleConns            int32         `json:"idle_conns"`
+	AcquiredConns        int32         `json:"acquired_conns"`
+	ConstructingConns    int32         `json:"constructing_conns"`
+	AcquireCount         int64         `json:"acquire_count"`
+	EmptyAcquireCount    int64         `json:"empty_acquire_count"`
+	CanceledAcquireCount int64         `json:"canceled_acquire_count"`
+	AcquireDuration      time.Duration `json:"acquire_duration_ns"`
+	NewConnsCount        int64         `json:"new_conns_count"`
+	IdleDestroyCount     int64         `json:"idle_destroy_count"`
+	LifetimeDestroyCount int64         `json:"lifetime_destroy_count"`
+}
+
+// StatsOf takes a snapshot of the statistics of pool.
+func StatsOf(pool *pgxpool.Pool) PoolStats {
+	stat := pool.Stat()
+	return PoolStats{
+		MaxConns:             stat.MaxConns(),
+		TotalConns:           stat.TotalConns(),
+		IdleConns:            stat.IdleConns(),
+		AcquiredConns:        stat.AcquiredConns(),
+		ConstructingConns:    stat.ConstructingConns(),
+		AcquireCount:         stat.AcquireCount(),
+		EmptyAcquireCount:    stat.EmptyAcquireCount(),
+		CanceledAcquireCount: stat.CanceledAcquireCount(),
+		AcquireDuration:      stat.AcquireDuration(),
+		NewConnsCount:        stat.NewConnsCount(),
+		IdleDestroyCount:     stat.MaxIdleDestroyCount(),
+		LifetimeDestroyCount: stat.MaxLifetimeDestroyCount(),
+	}
+}
+
+func int32FromEnv(key string, target *int32) error {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return nil
+	}
+	parsed, err := strconv.ParseInt(value, 10, 32)
+	if err != nil || parsed < 0 {
+		return fmt.Errorf("%s must be a non-negative integer, got %q", key, value)
+	}
+	*target = int32(parsed)
+	return nil
+}
+
+func durationFromEnv(key string, target *time.Duration) error {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 {
+		return fmt.Errorf("%s must be a non-negative duration such as 30s, got %q", key, value)
+	}
+	*target = parsed
+	return nil
+}
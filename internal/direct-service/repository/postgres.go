@@ -5,11 +5,17 @@ import (
 	"database/sql"
 	"errors"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"libary-service/internal/database"
 	"libary-service/internal/domain"
 	"time"
 )
 
-var DB *pgx.Conn
+var DB *pgxpool.Pool
+
+func PoolStats() database.PoolStats {
+	return database.StatsOf(DB)
+}
 
 func GetBooks() ([]domain.Book, error) {
 	rows, err := DB.Query(context.Background(), "SELECT id, title, author FROM books")
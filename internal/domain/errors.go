@@ -0,0 +1,35 @@
+package domain
+
+import "errors"
+
+// Error kinds shared by every layer. Repositories and validators return errors
+// that wrap one of them so that callers can tell failures apart with errors.Is.
+var (
+	ErrNotFound   = errors.New("not found")
+	ErrConflict   = errors.New("conflict")
+	ErrValidation = errors.New("validation failed")
+)
+
+// Error is an error of one of the kinds above with its own message.
+type Error struct {
+	Kind    error
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Kind
+}
+
+// NotFoundError reports that no entity of the named type has the requested ID.
+func NotFoundError(entity string) error {
+	return &Error{Kind: ErrNotFound, Message: entity + " not found"}
+}
+
+// ConflictError reports that a change clashes with the current state.
+func ConflictError(message string) error {
+	return &Error{Kind: ErrConflict, Message: message}
+}
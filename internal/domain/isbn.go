@@ -0,0 +1,66 @@
+package domain
+
+import "strings"
+
+// NormalizeISBN drops the hyphens and spaces of an ISBN and turns a valid
+// ISBN-10 into the ISBN-13 of the same book. Anything else is returned as it
+// was typed, just without separators, so that validation can reject it.
+func NormalizeISBN(isbn string) string {
+	isbn = strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(isbn))
+	if ValidISBN10(isbn) {
+		return isbn10To13(isbn)
+	}
+	return isbn
+}
+
+// ValidISBN10 reports whether isbn is nine digits and a check digit, which
+// may be X, with a correct checksum.
+func ValidISBN10(isbn string) bool {
+	if len(isbn) != 10 {
+		return false
+	}
+	sum := 0
+	for i := 0; i < 10; i++ {
+		switch c := isbn[i]; {
+		case c >= '0' && c <= '9':
+			sum += (10 - i) * int(c-'0')
+		case c == 'X' && i == 9:
+			sum += 10
+		default:
+			return false
+		}
+	}
+	return sum%11 == 0
+}
+
+// ValidISBN13 reports whether isbn is thirteen digits with a correct checksum.
+func ValidISBN13(isbn string) bool {
+	if len(isbn) != 13 {
+		return false
+	}
+	for i := 0; i < 13; i++ {
+		if isbn[i] < '0' || isbn[i] > '9' {
+			return false
+		}
+	}
+	return isbn13CheckDigit(isbn[:12]) == isbn[12]
+}
+
+// isbn10To13 prefixes a valid ISBN-10 with 978 and computes the new check digit.
+func isbn10To13(isbn string) string {
+	prefix := "978" + isbn[:9]
+	return prefix + string(isbn13CheckDigit(prefix))
+}
+
+// isbn13CheckDigit computes the check digit of the first twelve digits of an ISBN-13.
+func isbn13CheckDigit(digits string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += weight * int(digits[i]-'0')
+	}
+	return byte('0' + (10-sum%10)%10)
+}
@@ -1,23 +1,217 @@
 package domain
 
-import "time"
+import (
+	"math"
+	"strings"
+	"time"
+)
 
 type Book struct {
-	ID     string `json:"id,omitempty" db:"id"`
-	Title  string `json:"title" db:"title"`
+	ID    string `json:"id,omitempty" db:"id"`
+	Title string `json:"title" db:"title"`
+	// Author is the first of Authors. Lists are sorted by it and the search
+	// only looks at it.
 	Author string `json:"author" db:"author"`
+	// Authors names everyone who wrote the book, in the order of the title page.
+	Authors []string `json:"authors,omitempty" db:"-"`
+	// ISBN is the ISBN-13 of the book. Books catalogued before ISBNs were
+	// recorded have none.
+	ISBN            string `json:"isbn,omitempty" db:"isbn"`
+	Publisher       string `json:"publisher,omitempty" db:"publisher"`
+	PublicationYear int    `json:"publication_year,omitempty" db:"publication_year"`
+	Edition         string `json:"edition,omitempty" db:"edition"`
+	// Language is the ISO 639-1 code of the language the book is written in.
+	Language  string `json:"language,omitempty" db:"language"`
+	PageCount int    `json:"page_count,omitempty" db:"page_count"`
+	// Subjects are the subjects and genres the book is catalogued under.
+	Subjects    []string `json:"subjects,omitempty" db:"subjects"`
+	Description string   `json:"description,omitempty" db:"description"`
+	// DeletedAt is set once the book was deleted. Deleted books can be
+	// restored until they are purged.
+	DeletedAt *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	// Version starts at 1 and goes up with every update. The API hands it out
+	// as the ETag of the book.
+	Version int `json:"version" db:"version"`
+}
+
+// NormalizeAuthors fills in Authors or Author when a book comes with only one
+// of them, as books from clients that know a single author do.
+func (b *Book) NormalizeAuthors() {
+	if len(b.Authors) == 0 && b.Author != "" {
+		b.Authors = []string{b.Author}
+	} else if b.Author == "" && len(b.Authors) > 0 {
+		b.Author = b.Authors[0]
+	}
+}
+
+// BookInventory is a book together with the number of copies the library owns.
+type BookInventory struct {
+	Book
+	TotalCopies     int `json:"total_copies"`
+	AvailableCopies int `json:"available_copies"`
 }
 
+// BookSearchHit is a book found by the catalogue search. The highlights repeat
+// the title and the authors, joined by commas, with every matched term wrapped
+// in <b></b>.
+type BookSearchHit struct {
+	Book
+	Rank            float64 `json:"rank"`
+	TitleHighlight  string  `json:"title_highlight"`
+	AuthorHighlight string  `json:"author_highlight"`
+}
+
+type CopyCondition string
+
+const (
+	CopyConditionNew     CopyCondition = "new"
+	CopyConditionGood    CopyCondition = "good"
+	CopyConditionFair    CopyCondition = "fair"
+	CopyConditionPoor    CopyCondition = "poor"
+	CopyConditionDamaged CopyCondition = "damaged"
+)
+
+type CopyStatus string
+
+const (
+	CopyStatusAvailable CopyStatus = "available"
+	CopyStatusOnLoan    CopyStatus = "on_loan"
+	CopyStatusOnHold    CopyStatus = "on_hold"
+	CopyStatusInRepair  CopyStatus = "in_repair"
+	CopyStatusLost      CopyStatus = "lost"
+	CopyStatusWithdrawn CopyStatus = "withdrawn"
+)
+
+// BookCopy is a single physical item of a book.
+type BookCopy struct {
+	ID        string        `json:"id,omitempty" db:"id"`
+	BookID    string        `json:"book_id" db:"book_id"`
+	Barcode   string        `json:"barcode" db:"barcode"`
+	Condition CopyCondition `json:"condition" db:"condition"`
+	Status    CopyStatus    `json:"status" db:"status"`
+}
+
+// UserCategory decides the borrowing privileges of a patron.
+type UserCategory string
+
+const (
+	UserCategoryStudent UserCategory = "student"
+	UserCategoryStaff   UserCategory = "staff"
+	UserCategoryGuest   UserCategory = "guest"
+)
+
 type User struct {
-	ID    string `json:"id,omitempty" db:"id"`
-	Name  string `json:"name" db:"name"`
-	Email string `json:"email" db:"email"`
+	ID       string       `json:"id,omitempty" db:"id"`
+	Name     string       `json:"name" db:"name"`
+	Email    string       `json:"email" db:"email"`
+	Category UserCategory `json:"category,omitempty" db:"category"`
+	// DeletedAt is set once the user was deleted.
+	DeletedAt *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	// Version goes up with every update of the user.
+	Version int `json:"version" db:"version"`
+}
+
+// NormalizeEmail trims an email address and lowercases it, so that one
+// address is stored the same way however it was typed.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+type HoldStatus string
+
+const (
+	HoldStatusWaiting   HoldStatus = "waiting"
+	HoldStatusReady     HoldStatus = "ready"
+	HoldStatusFulfilled HoldStatus = "fulfilled"
+	HoldStatusCancelled HoldStatus = "cancelled"
+	HoldStatusExpired   HoldStatus = "expired"
+)
+
+// Hold is a patron's place in the queue for a book that is lent out.
+// Once a copy comes back it is set aside for the first waiting hold, which
+// then has to be picked up before ExpiresAt.
+type Hold struct {
+	ID        string     `json:"id" db:"id"`
+	BookID    string     `json:"book_id" db:"book_id"`
+	UserID    string     `json:"user_id" db:"user_id"`
+	CopyID    string     `json:"copy_id,omitempty" db:"copy_id"`
+	Status    HoldStatus `json:"status" db:"status"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	ReadyAt   time.Time  `json:"ready_at,omitempty" db:"ready_at"`
+	ExpiresAt time.Time  `json:"expires_at,omitempty" db:"expires_at"`
+}
+
+// IsActive reports whether the hold still has a place in the queue.
+func (h Hold) IsActive() bool {
+	return h.Status == HoldStatusWaiting || h.Status == HoldStatusReady
 }
 
 type Lending struct {
-	ID         string    `json:"id" db:"id"`
-	BookID     string    `json:"book_id" db:"book_id"`
-	UserID     string    `json:"user_id" db:"user_id"`
-	LendDate   time.Time `json:"lend_date" db:"lend_date"`
-	ReturnDate time.Time `json:"return_date,omitempty" db:"return_date"`
+	ID           string    `json:"id" db:"id"`
+	BookID       string    `json:"book_id" db:"book_id"`
+	CopyID       string    `json:"copy_id" db:"copy_id"`
+	UserID       string    `json:"user_id" db:"user_id"`
+	LendDate     time.Time `json:"lend_date" db:"lend_date"`
+	DueDate      time.Time `json:"due_date" db:"due_date"`
+	ReturnDate   time.Time `json:"return_date,omitempty" db:"return_date"`
+	RenewalCount int       `json:"renewal_count" db:"renewal_count"`
+	// DeletedAt is set once the lending was deleted.
+	DeletedAt *time.Time `json:"deleted_at,omitempty" db:"deleted_at"`
+	// Version goes up with every update of the lending.
+	Version int `json:"version" db:"version"`
+}
+
+// DaysOverdue counts every started day between the due date and at.
+func (l Lending) DaysOverdue(at time.Time) int {
+	if l.DueDate.IsZero() || !at.After(l.DueDate) {
+		return 0
+	}
+	return int(math.Ceil(at.Sub(l.DueDate).Hours() / 24))
+}
+
+// IsOverdue reports whether the lending is still out after its due date.
+func (l Lending) IsOverdue(now time.Time) bool {
+	return l.ReturnDate.IsZero() && l.DaysOverdue(now) > 0
+}
+
+// OverdueLending is a lending listed in the overdue report.
+type OverdueLending struct {
+	Lending
+	DaysOverdue int `json:"days_overdue"`
+}
+
+// PurgeReport counts the deleted rows a purge removed for good. Rows that
+// went with them, like the copies of a purged book, are not counted.
+type PurgeReport struct {
+	Books    int `json:"books"`
+	Users    int `json:"users"`
+	Lendings int `json:"lendings"`
+}
+
+type FineReason string
+
+const (
+	FineReasonOverdue FineReason = "overdue"
+	FineReasonLost    FineReason = "lost"
+)
+
+type FineStatus string
+
+const (
+	FineStatusUnpaid FineStatus = "unpaid"
+	FineStatusPaid   FineStatus = "paid"
+	FineStatusWaived FineStatus = "waived"
+)
+
+// Fine is an entry in a patron's fines ledger.
+// Amount is kept in minor currency units (cents) so that no rounding can creep in.
+type Fine struct {
+	ID        string     `json:"id" db:"id"`
+	UserID    string     `json:"user_id" db:"user_id"`
+	LendingID string     `json:"lending_id" db:"lending_id"`
+	Reason    FineReason `json:"reason" db:"reason"`
+	Amount    int64      `json:"amount" db:"amount"`
+	Status    FineStatus `json:"status" db:"status"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	SettledAt time.Time  `json:"settled_at,omitempty" db:"settled_at"`
 }
@@ -7,20 +7,50 @@ import (
 
 type Service interface {
 	GetBooks(w http.ResponseWriter, r *http.Request)
+	SearchBooks(w http.ResponseWriter, r *http.Request)
 	GetBookByID(w http.ResponseWriter, r *http.Request)
+	GetBookByISBN(w http.ResponseWriter, r *http.Request)
 	CreateBook(w http.ResponseWriter, r *http.Request)
 	UpdateBook(w http.ResponseWriter, r *http.Request)
+	PatchBook(w http.ResponseWriter, r *http.Request)
 	DeleteBook(w http.ResponseWriter, r *http.Request)
+	RestoreBook(w http.ResponseWriter, r *http.Request)
+
+	GetBookCopies(w http.ResponseWriter, r *http.Request)
+	GetBookCopyByID(w http.ResponseWriter, r *http.Request)
+	CreateBookCopy(w http.ResponseWriter, r *http.Request)
+	UpdateBookCopy(w http.ResponseWriter, r *http.Request)
+	DeleteBookCopy(w http.ResponseWriter, r *http.Request)
+
+	GetBookHolds(w http.ResponseWriter, r *http.Request)
+	CreateHold(w http.ResponseWriter, r *http.Request)
+	GetHoldByID(w http.ResponseWriter, r *http.Request)
+	CancelHold(w http.ResponseWriter, r *http.Request)
 
 	GetUsers(w http.ResponseWriter, r *http.Request)
 	GetUserByID(w http.ResponseWriter, r *http.Request)
 	CreateUser(w http.ResponseWriter, r *http.Request)
 	UpdateUser(w http.ResponseWriter, r *http.Request)
+	PatchUser(w http.ResponseWriter, r *http.Request)
 	DeleteUser(w http.ResponseWriter, r *http.Request)
+	RestoreUser(w http.ResponseWriter, r *http.Request)
+	GetUserFines(w http.ResponseWriter, r *http.Request)
 
 	GetLendings(w http.ResponseWriter, r *http.Request)
+	GetOverdueLendings(w http.ResponseWriter, r *http.Request)
 	GetLendingByID(w http.ResponseWriter, r *http.Request)
 	CreateLending(w http.ResponseWriter, r *http.Request)
 	UpdateLending(w http.ResponseWriter, r *http.Request)
+	PatchLending(w http.ResponseWriter, r *http.Request)
 	DeleteLending(w http.ResponseWriter, r *http.Request)
+	RestoreLending(w http.ResponseWriter, r *http.Request)
+	ReturnLending(w http.ResponseWriter, r *http.Request)
+	RenewLending(w http.ResponseWriter, r *http.Request)
+	ReportLostLending(w http.ResponseWriter, r *http.Request)
+
+	PayFine(w http.ResponseWriter, r *http.Request)
+	WaiveFine(w http.ResponseWriter, r *http.Request)
+
+	PurgeDeleted(w http.ResponseWriter, r *http.Request)
+	GetDatabaseStats(w http.ResponseWriter, r *http.Request)
 }
@@ -1,24 +1,40 @@
 package app
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
 	"libary-service/internal/domain"
+	"libary-service/internal/injected-service/config"
 	"libary-service/internal/injected-service/repository"
 	"libary-service/internal/injected-service/validation"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type LibaryService struct {
 	repository repository.Repository
 	validation validation.Validation
+	config     config.Config
+	now        func() time.Time
 }
 
-func NewLibaryService(repository repository.Repository, validation validation.Validation) *LibaryService {
-	return &LibaryService{repository: repository, validation: validation}
+func NewLibaryService(repository repository.Repository, validation validation.Validation, config config.Config) *LibaryService {
+	return &LibaryService{repository: repository, validation: validation, config: config, now: time.Now}
+}
+
+// requestContext bounds the work done for one request by the configured
+// request timeout. The context is also cancelled when the client goes away.
+func (s *LibaryService) requestContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if s.config.RequestTimeout <= 0 {
+		return context.WithCancel(r.Context())
+	}
+	return context.WithTimeout(r.Context(), s.config.RequestTimeout)
 }
 
 // extractID parses an ID from the request URL path.
@@ -33,293 +49,1686 @@ func extractID(r *http.Request, basePath string) (string, error) {
 	return idStr, nil
 }
 
+// extractNestedID parses the parent ID from a nested resource path.
+// The basePath should be formated like this: "/books/" and the suffix like this: "/copies"
+func extractNestedID(r *http.Request, basePath, suffix string) (string, error) {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	if !strings.HasPrefix(path, basePath) || !strings.HasSuffix(path, suffix) {
+		return "", errors.New("invalid path")
+	}
+	idStr := strings.TrimSuffix(strings.TrimPrefix(path, basePath), suffix)
+	if idStr == "" || strings.Contains(idStr, "/") {
+		return "", errors.New("invalid path")
+	}
+	return idStr, nil
+}
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// listOptions reads the limit, cursor and sort query parameters of a list request.
+func listOptions(r *http.Request) (repository.ListOptions, error) {
+	query := r.URL.Query()
+	options := repository.ListOptions{Limit: defaultPageSize, Cursor: query.Get("cursor"), Sort: query.Get("sort")}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageSize {
+			return repository.ListOptions{}, fmt.Errorf("limit must be between 1 and %d", maxPageSize)
+		}
+		options.Limit = limit
+	}
+	return options, nil
+}
+
+// writePage writes the items of a page as a JSON array and reports the total
+// count and the cursor of the next page in headers.
+func writePage[T any](w http.ResponseWriter, page repository.Page[T]) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	if page.NextCursor != "" {
+		w.Header().Set("X-Next-Cursor", page.NextCursor)
+	}
+	json.NewEncoder(w).Encode(page.Items)
+}
+
+// repositoryError answers a failed repository call. Missing entities,
+// conflicts, invalid data and timeouts get their own status codes; any other
+// failure is an internal error reported with message.
+func repositoryError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, domain.ErrNotFound):
+		writeProblem(w, http.StatusNotFound, sentence(err))
+	case errors.Is(err, domain.ErrConflict):
+		writeProblem(w, http.StatusConflict, sentence(err))
+	case errors.Is(err, domain.ErrValidation):
+		writeValidationProblem(w, err)
+	case errors.Is(err, context.DeadlineExceeded):
+		writeProblem(w, http.StatusServiceUnavailable, "Request timed out")
+	default:
+		writeProblem(w, http.StatusInternalServerError, message)
+	}
+}
+
+// sentence turns an error message into a response message.
+func sentence(err error) string {
+	message := err.Error()
+	if message == "" {
+		return message
+	}
+	return strings.ToUpper(message[:1]) + message[1:]
+}
+
+// listError answers a failed list query. Bad cursors and sorts are client errors.
+func listError(w http.ResponseWriter, err error, message string) {
+	switch {
+	case errors.Is(err, repository.ErrInvalidCursor):
+		writeProblem(w, http.StatusBadRequest, "Invalid cursor")
+	case errors.Is(err, repository.ErrInvalidSort):
+		writeProblem(w, http.StatusBadRequest, "Invalid sort")
+	default:
+		repositoryError(w, err, message)
+	}
+}
+
+// boolQuery reads a boolean query parameter that defaults to false.
+func boolQuery(r *http.Request, name string) (bool, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(value)
+}
+
+// deleteError answers a failed delete of a book, copy or user. The active
+// lendings that blocked it are listed in the problem, a lost race is answered
+// like in updateError.
+func deleteError(w http.ResponseWriter, err error, message string) {
+	var active *repository.ActiveLendingsError
+	if errors.As(err, &active) {
+		encodeProblem(w, Problem{
+			Type:       "about:blank",
+			Title:      http.StatusText(http.StatusConflict),
+			Status:     http.StatusConflict,
+			Detail:     sentence(err),
+			LendingIDs: active.LendingIDs,
+		})
+		return
+	}
+	updateError(w, err, message)
+}
+
+// etag formats the version of a book, user or lending as its entity tag.
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// ifMatch reads the If-Match header that updates and deletes have to send, so
+// that they cannot overwrite a change they have not seen.
+func ifMatch(w http.ResponseWriter, r *http.Request) (string, bool) {
+	match := r.Header.Get("If-Match")
+	if match == "" {
+		writeProblem(w, http.StatusPreconditionRequired, "If-Match header is required")
+		return "", false
+	}
+	return match, true
+}
+
+// matchesETag reports whether an If-Match header lists the entity tag of
+// version or is "*". Weak tags never match.
+func matchesETag(match string, version int) bool {
+	for _, tag := range strings.Split(match, ",") {
+		if tag = strings.TrimSpace(tag); tag == "*" || tag == etag(version) {
+			return true
+		}
+	}
+	return false
+}
+
+// updateError answers a failed update or delete. One that lost the race against
+// an update failed its precondition just as if the If-Match was stale.
+func updateError(w http.ResponseWriter, err error, message string) {
+	if errors.Is(err, repository.ErrVersionMismatch) {
+		writeProblem(w, http.StatusPreconditionFailed, sentence(err))
+		return
+	}
+	repositoryError(w, err, message)
+}
+
+// forceDelete reads the force option of a delete. Only admins may force a
+// delete past the active lendings that block it.
+func (s *LibaryService) forceDelete(w http.ResponseWriter, r *http.Request) (force bool, ok bool) {
+	force, err := boolQuery(r, "force")
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid force option")
+		return false, false
+	}
+	if force && !s.isAdmin(r) {
+		writeProblem(w, http.StatusForbidden, "Admin access required")
+		return false, false
+	}
+	return force, true
+}
+
+// isAdmin reports whether the request carries the configured admin token as a
+// bearer token. Without a configured token nobody is an admin.
+func (s *LibaryService) isAdmin(r *http.Request) bool {
+	if s.config.AdminToken == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(s.config.AdminToken)) == 1
+}
+
 func (s *LibaryService) GetBooks(w http.ResponseWriter, r *http.Request) {
-	books, err := s.repository.GetBooks()
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	options, err := listOptions(r)
 	if err != nil {
-		http.Error(w, "Error retrieving books", http.StatusInternalServerError)
+		writeProblem(w, http.StatusBadRequest, err.Error())
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(books)
+	filter := repository.BookFilter{
+		Author:      r.URL.Query().Get("author"),
+		TitlePrefix: r.URL.Query().Get("title_prefix"),
+	}
+	if filter.IncludeDeleted, err = boolQuery(r, "include_deleted"); err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid include_deleted filter")
+		return
+	}
+
+	page, err := s.repository.GetBooks(ctx, filter, options)
+	if err != nil {
+		listError(w, err, "Error retrieving books")
+		return
+	}
+	writePage(w, page)
+}
+
+func (s *LibaryService) SearchBooks(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+	if query == "" {
+		writeProblem(w, http.StatusBadRequest, "Search query is required")
+		return
+	}
+	options, err := listOptions(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, err := s.repository.SearchBooks(ctx, query, options)
+	if err != nil {
+		listError(w, err, "Error searching books")
+		return
+	}
+	writePage(w, page)
 }
 
 func (s *LibaryService) GetBookByID(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
 	id, err := extractID(r, "/books/")
 	if err != nil {
-		http.Error(w, "Invalid book ID", http.StatusBadRequest)
+		writeProblem(w, http.StatusBadRequest, "Invalid book ID")
+		return
+	}
+
+	book, err := s.repository.GetBookByID(ctx, id)
+	if err != nil {
+		repositoryError(w, err, "Error retrieving book")
+		return
+	}
+	s.writeBookInventory(ctx, w, book)
+}
+
+// GetBookByISBN looks a book up by its ISBN. An ISBN-10 finds the book under
+// the ISBN-13 it is stored as.
+func (s *LibaryService) GetBookByISBN(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	isbn, err := extractID(r, "/books/isbn/")
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid ISBN")
+		return
+	}
+	isbn = domain.NormalizeISBN(isbn)
+	if !domain.ValidISBN13(isbn) {
+		writeProblem(w, http.StatusBadRequest, "Invalid ISBN")
+		return
+	}
+
+	page, err := s.repository.GetBooks(ctx, repository.BookFilter{ISBN: isbn}, repository.ListOptions{Limit: 1})
+	if err != nil {
+		repositoryError(w, err, "Error retrieving book")
+		return
+	}
+	if len(page.Items) == 0 {
+		writeProblem(w, http.StatusNotFound, sentence(repository.ErrBookNotFound))
 		return
 	}
+	s.writeBookInventory(ctx, w, page.Items[0])
+}
 
-	book, err := s.repository.GetBookByID(id)
+// writeBookInventory answers with the book and the number of its copies.
+func (s *LibaryService) writeBookInventory(ctx context.Context, w http.ResponseWriter, book domain.Book) {
+	copies, err := s.repository.GetBookCopies(ctx, book.ID)
 	if err != nil {
-		http.Error(w, "Book not found", http.StatusNotFound)
+		repositoryError(w, err, "Error retrieving book copies")
 		return
 	}
 
+	inventory := domain.BookInventory{Book: book, TotalCopies: len(copies)}
+	for _, c := range copies {
+		if c.Status == domain.CopyStatusAvailable {
+			inventory.AvailableCopies++
+		}
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(book)
+	w.Header().Set("ETag", etag(book.Version))
+	json.NewEncoder(w).Encode(inventory)
 }
 
 func (s *LibaryService) CreateBook(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
 	var book domain.Book
 	if err := json.NewDecoder(r.Body).Decode(&book); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		writeProblem(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
+	book.NormalizeAuthors()
+	book.ISBN = domain.NormalizeISBN(book.ISBN)
 
-	if err := s.validation.CheckBook(book); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err := s.validation.CheckBook(ctx, book); err != nil {
+		writeValidationProblem(w, err)
 		return
 	}
 
 	book.ID = uuid.New().String()
 
-	createdBook, err := s.repository.CreateBook(book)
+	createdBook, err := s.repository.CreateBook(ctx, book)
 	if err != nil {
-		http.Error(w, "Error creating book", http.StatusInternalServerError)
+		repositoryError(w, err, "Error creating book")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(createdBook.Version))
 	w.WriteHeader(http.StatusCreated)
 	json.NewEncoder(w).Encode(createdBook)
 }
 
 func (s *LibaryService) UpdateBook(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
 	id, err := extractID(r, "/books/")
 	if err != nil {
-		http.Error(w, "Invalid book ID", http.StatusBadRequest)
+		writeProblem(w, http.StatusBadRequest, "Invalid book ID")
+		return
+	}
+
+	match, ok := ifMatch(w, r)
+	if !ok {
 		return
 	}
 
 	var book domain.Book
 	if err := json.NewDecoder(r.Body).Decode(&book); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		writeProblem(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	book.NormalizeAuthors()
+	book.ISBN = domain.NormalizeISBN(book.ISBN)
+
+	current, err := s.repository.GetBookByID(ctx, id)
+	if err != nil {
+		repositoryError(w, err, "Error retrieving book")
+		return
+	}
+	if !matchesETag(match, current.Version) {
+		writeProblem(w, http.StatusPreconditionFailed, "Book has been changed since it was read")
+		return
+	}
+
+	if err := s.validation.CheckBookUpdate(ctx, current, book); err != nil {
+		writeValidationProblem(w, err)
+		return
+	}
+
+	book.ID = id
+	book.Version = current.Version
+
+	updatedBook, err := s.repository.UpdateBook(ctx, book)
+	if err != nil {
+		updateError(w, err, "Error updating book")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(updatedBook.Version))
+	json.NewEncoder(w).Encode(updatedBook)
+}
+
+// PatchBook applies a JSON merge patch to a book. Only the merged book is
+// validated, so a patch may leave out every field it does not change.
+func (s *LibaryService) PatchBook(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	id, err := extractID(r, "/books/")
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid book ID")
+		return
+	}
+
+	match, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	patch, ok := readMergePatch(w, r)
+	if !ok {
 		return
 	}
 
-	if err := s.validation.CheckBook(book); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	current, err := s.repository.GetBookByID(ctx, id)
+	if err != nil {
+		repositoryError(w, err, "Error retrieving book")
+		return
+	}
+	if !matchesETag(match, current.Version) {
+		writeProblem(w, http.StatusPreconditionFailed, "Book has been changed since it was read")
+		return
+	}
+
+	book, err := applyMergePatch(current, patch)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	// A patch that changes only one of author and authors decides the other.
+	_, patchesAuthor := patch["author"]
+	_, patchesAuthors := patch["authors"]
+	if patchesAuthor && !patchesAuthors {
+		book.Authors = nil
+	} else if patchesAuthors && !patchesAuthor {
+		book.Author = ""
+	}
+	book.NormalizeAuthors()
+	book.ISBN = domain.NormalizeISBN(book.ISBN)
+
+	if err := s.validation.CheckBookUpdate(ctx, current, book); err != nil {
+		writeValidationProblem(w, err)
 		return
 	}
 
 	book.ID = id
+	book.Version = current.Version
 
-	updatedBook, err := s.repository.UpdateBook(book)
+	updatedBook, err := s.repository.UpdateBook(ctx, book)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error updating book: %v", err), http.StatusInternalServerError)
+		updateError(w, err, "Error updating book")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(updatedBook.Version))
 	json.NewEncoder(w).Encode(updatedBook)
 }
 
 func (s *LibaryService) DeleteBook(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
 	id, err := extractID(r, "/books/")
 	if err != nil {
-		http.Error(w, "Invalid book ID", http.StatusBadRequest)
+		writeProblem(w, http.StatusBadRequest, "Invalid book ID")
+		return
+	}
+
+	force, ok := s.forceDelete(w, r)
+	if !ok {
+		return
+	}
+	match, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	book, err := s.repository.GetBookByID(ctx, id)
+	if err != nil {
+		repositoryError(w, err, "Error retrieving book")
+		return
+	}
+	if !matchesETag(match, book.Version) {
+		writeProblem(w, http.StatusPreconditionFailed, "Book has been changed since it was read")
 		return
 	}
 
-	if err := s.repository.DeleteBook(id); err != nil {
-		http.Error(w, "Error deleting book", http.StatusInternalServerError)
+	if err := s.repository.DeleteBook(ctx, id, book.Version, s.now(), force); err != nil {
+		deleteError(w, err, "Error deleting book")
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func (s *LibaryService) GetUsers(w http.ResponseWriter, r *http.Request) {
-	users, err := s.repository.GetUsers()
+func (s *LibaryService) RestoreBook(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	id, err := extractNestedID(r, "/books/", "/restore")
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid book ID")
+		return
+	}
+
+	book, err := s.repository.RestoreBook(ctx, id)
 	if err != nil {
-		http.Error(w, "Error retrieving users", http.StatusInternalServerError)
+		repositoryError(w, err, "Error restoring book")
 		return
 	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(users)
+	w.Header().Set("ETag", etag(book.Version))
+	json.NewEncoder(w).Encode(book)
 }
 
-func (s *LibaryService) GetUserByID(w http.ResponseWriter, r *http.Request) {
-	id, err := extractID(r, "/users/")
+func (s *LibaryService) GetBookCopies(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	bookID, err := extractNestedID(r, "/books/", "/copies")
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid book ID")
+		return
+	}
+
+	if _, err := s.repository.GetBookByID(ctx, bookID); err != nil {
+		repositoryError(w, err, "Error retrieving book")
+		return
+	}
+
+	copies, err := s.repository.GetBookCopies(ctx, bookID)
+	if err != nil {
+		repositoryError(w, err, "Error retrieving book copies")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(copies)
+}
+
+func (s *LibaryService) GetBookCopyByID(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	id, err := extractID(r, "/copies/")
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		writeProblem(w, http.StatusBadRequest, "Invalid copy ID")
 		return
 	}
 
-	user, err := s.repository.GetUserByID(id)
+	bookCopy, err := s.repository.GetBookCopyByID(ctx, id)
 	if err != nil {
-		http.Error(w, "User not found", http.StatusNotFound)
+		repositoryError(w, err, "Error retrieving copy")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(user)
+	json.NewEncoder(w).Encode(bookCopy)
 }
 
-func (s *LibaryService) CreateUser(w http.ResponseWriter, r *http.Request) {
-	var user domain.User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+func (s *LibaryService) CreateBookCopy(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	bookID, err := extractNestedID(r, "/books/", "/copies")
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid book ID")
 		return
 	}
 
-	if err := s.validation.CheckUser(user); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	var bookCopy domain.BookCopy
+	if err := json.NewDecoder(r.Body).Decode(&bookCopy); err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 
-	user.ID = uuid.New().String()
+	bookCopy.BookID = bookID
+	if bookCopy.Status == "" {
+		bookCopy.Status = domain.CopyStatusAvailable
+	}
+
+	if err := s.validation.CheckBookCopy(ctx, bookCopy); err != nil {
+		writeValidationProblem(w, err)
+		return
+	}
 
-	createdUser, err := s.repository.CreateUser(user)
+	bookCopy.ID = uuid.New().String()
+
+	createdCopy, err := s.repository.CreateBookCopy(ctx, bookCopy)
 	if err != nil {
-		http.Error(w, "Error creating user", http.StatusInternalServerError)
+		repositoryError(w, err, "Error creating copy")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(createdUser)
+	json.NewEncoder(w).Encode(createdCopy)
 }
 
-func (s *LibaryService) UpdateUser(w http.ResponseWriter, r *http.Request) {
-	id, err := extractID(r, "/users/")
+func (s *LibaryService) UpdateBookCopy(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	id, err := extractID(r, "/copies/")
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		writeProblem(w, http.StatusBadRequest, "Invalid copy ID")
 		return
 	}
 
-	var user domain.User
-	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	var bookCopy domain.BookCopy
+	if err := json.NewDecoder(r.Body).Decode(&bookCopy); err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
 
-	if err := s.validation.CheckUser(user); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	stored, err := s.repository.GetBookCopyByID(ctx, id)
+	if err != nil {
+		repositoryError(w, err, "Error retrieving copy")
 		return
 	}
 
-	user.ID = id
+	// Copies never move between books, and the on_loan and on_hold statuses
+	// belong to the lending or hold.
+	bookCopy.BookID = stored.BookID
+	if bookCopy.Status == "" || stored.Status == domain.CopyStatusOnLoan || stored.Status == domain.CopyStatusOnHold {
+		bookCopy.Status = stored.Status
+	}
+
+	if err := s.validation.CheckBookCopyUpdate(ctx, stored, bookCopy); err != nil {
+		writeValidationProblem(w, err)
+		return
+	}
 
-	updatedUser, err := s.repository.UpdateUser(user)
+	bookCopy.ID = id
+
+	updatedCopy, err := s.repository.UpdateBookCopy(ctx, bookCopy)
 	if err != nil {
-		http.Error(w, "Error updating user", http.StatusInternalServerError)
+		repositoryError(w, err, "Error updating copy")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(updatedUser)
+	json.NewEncoder(w).Encode(updatedCopy)
 }
 
-func (s *LibaryService) DeleteUser(w http.ResponseWriter, r *http.Request) {
-	id, err := extractID(r, "/users/")
+func (s *LibaryService) DeleteBookCopy(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	id, err := extractID(r, "/copies/")
 	if err != nil {
-		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		writeProblem(w, http.StatusBadRequest, "Invalid copy ID")
 		return
 	}
 
-	if err := s.repository.DeleteUser(id); err != nil {
-		http.Error(w, "Error deleting user", http.StatusInternalServerError)
+	if err := s.repository.DeleteBookCopy(ctx, id); err != nil {
+		deleteError(w, err, "Error deleting copy")
 		return
 	}
 
 	w.WriteHeader(http.StatusNoContent)
 }
 
-func (s *LibaryService) GetLendings(w http.ResponseWriter, r *http.Request) {
-	lendings, err := s.repository.GetLendings()
+func (s *LibaryService) GetBookHolds(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	bookID, err := extractNestedID(r, "/books/", "/holds")
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid book ID")
+		return
+	}
+
+	if err := s.expireHolds(ctx); err != nil {
+		repositoryError(w, err, "Error expiring holds")
+		return
+	}
+
+	holds, err := s.repository.GetHolds(ctx, bookID)
 	if err != nil {
-		http.Error(w, "Error retrieving lendings", http.StatusInternalServerError)
+		repositoryError(w, err, "Error retrieving holds")
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(lendings)
+	json.NewEncoder(w).Encode(holds)
 }
 
-func (s *LibaryService) GetLendingByID(w http.ResponseWriter, r *http.Request) {
-	id, err := extractID(r, "/lendings/")
+func (s *LibaryService) CreateHold(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	bookID, err := extractNestedID(r, "/books/", "/holds")
 	if err != nil {
-		http.Error(w, "Invalid lending ID", http.StatusBadRequest)
+		writeProblem(w, http.StatusBadRequest, "Invalid book ID")
 		return
 	}
 
-	lending, err := s.repository.GetLendingByID(id)
-	if err != nil {
-		http.Error(w, "Lending not found", http.StatusNotFound)
+	var hold domain.Hold
+	if err := json.NewDecoder(r.Body).Decode(&hold); err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid request payload")
 		return
 	}
+	hold.BookID = bookID
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(lending)
-}
+	if err := s.validation.CheckHold(ctx, hold); err != nil {
+		writeValidationProblem(w, err)
+		return
+	}
 
-func (s *LibaryService) CreateLending(w http.ResponseWriter, r *http.Request) {
-	var lending domain.Lending
-	if err := json.NewDecoder(r.Body).Decode(&lending); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	if err := s.expireHolds(ctx); err != nil {
+		repositoryError(w, err, "Error expiring holds")
 		return
 	}
 
-	if err := s.validation.CheckLending(lending); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	// Holds only queue for titles that are lent out; a copy on the shelf can be lent right away.
+	copies, err := s.repository.GetBookCopies(ctx, bookID)
+	if err != nil {
+		repositoryError(w, err, "Error retrieving copies")
 		return
 	}
+	for _, c := range copies {
+		if c.Status == domain.CopyStatusAvailable {
+			writeProblem(w, http.StatusConflict, "Book has an available copy")
+			return
+		}
+	}
 
-	lending.ID = uuid.New().String()
+	hold.ID = uuid.New().String()
+	hold.Status = domain.HoldStatusWaiting
+	hold.CreatedAt = s.now()
 
-	createdLending, err := s.repository.CreateLending(lending)
+	createdHold, err := s.repository.CreateHold(ctx, hold)
 	if err != nil {
-		http.Error(w, "Error creating lending", http.StatusInternalServerError)
+		repositoryError(w, err, "Error creating hold")
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(createdLending)
+	json.NewEncoder(w).Encode(createdHold)
 }
 
-func (s *LibaryService) UpdateLending(w http.ResponseWriter, r *http.Request) {
-	id, err := extractID(r, "/lendings/")
+func (s *LibaryService) GetHoldByID(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	id, err := extractID(r, "/holds/")
 	if err != nil {
-		http.Error(w, "Invalid lending ID", http.StatusBadRequest)
+		writeProblem(w, http.StatusBadRequest, "Invalid hold ID")
 		return
 	}
 
-	var lending domain.Lending
-	if err := json.NewDecoder(r.Body).Decode(&lending); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+	hold, err := s.repository.GetHoldByID(ctx, id)
+	if err != nil {
+		repositoryError(w, err, "Error retrieving hold")
 		return
 	}
 
-	if err := s.validation.CheckLending(lending); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hold)
+}
+
+// CancelHold takes a hold out of the queue. A copy that was already set aside
+// for it goes to the next patron in line.
+func (s *LibaryService) CancelHold(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	id, err := extractID(r, "/holds/")
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid hold ID")
 		return
 	}
 
-	lending.ID = id
+	hold, err := s.repository.GetHoldByID(ctx, id)
+	if err != nil {
+		repositoryError(w, err, "Error retrieving hold")
+		return
+	}
+
+	if !hold.IsActive() {
+		writeProblem(w, http.StatusConflict, fmt.Sprintf("Hold is already %s", hold.Status))
+		return
+	}
 
-	updatedLending, err := s.repository.UpdateLending(lending)
+	hold.Status = domain.HoldStatusCancelled
+
+	err = s.repository.WithTx(ctx, func(tx repository.Repository) error {
+		if _, err := tx.UpdateHold(ctx, hold); err != nil {
+			return err
+		}
+		return s.readyNextHold(ctx, tx, hold.CopyID)
+	})
 	if err != nil {
-		http.Error(w, "Error updating lending", http.StatusInternalServerError)
+		repositoryError(w, err, "Error cancelling hold")
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(updatedLending)
+	w.WriteHeader(http.StatusNoContent)
 }
 
-func (s *LibaryService) DeleteLending(w http.ResponseWriter, r *http.Request) {
-	id, err := extractID(r, "/lendings/")
+// readyNextHold sets a copy that just came back aside for the next waiting hold.
+func (s *LibaryService) readyNextHold(ctx context.Context, repo repository.Repository, copyID string) error {
+	if copyID == "" {
+		return nil
+	}
+	now := s.now()
+	_, err := repo.ReadyNextHold(ctx, copyID, now, now.Add(s.config.HoldPickupWindow))
+	return err
+}
+
+// expireHolds lapses holds that were not picked up in time and passes their
+// copies on. Holds expire lazily whenever the queue is looked at.
+func (s *LibaryService) expireHolds(ctx context.Context) error {
+	return s.repository.WithTx(ctx, func(tx repository.Repository) error {
+		expired, err := tx.ExpireHolds(ctx, s.now())
+		if err != nil {
+			return err
+		}
+		for _, h := range expired {
+			if err := s.readyNextHold(ctx, tx, h.CopyID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *LibaryService) GetUsers(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	options, err := listOptions(r)
 	if err != nil {
-		http.Error(w, "Invalid lending ID", http.StatusBadRequest)
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter := repository.UserFilter{Category: domain.UserCategory(r.URL.Query().Get("category"))}
+	if filter.IncludeDeleted, err = boolQuery(r, "include_deleted"); err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid include_deleted filter")
 		return
 	}
 
-	if err := s.repository.DeleteLending(id); err != nil {
-		http.Error(w, "Error deleting lending", http.StatusInternalServerError)
+	page, err := s.repository.GetUsers(ctx, filter, options)
+	if err != nil {
+		listError(w, err, "Error retrieving users")
 		return
 	}
+	writePage(w, page)
+}
 
-	w.WriteHeader(http.StatusNoContent)
+func (s *LibaryService) GetUserByID(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	id, err := extractID(r, "/users/")
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	user, err := s.repository.GetUserByID(ctx, id)
+	if err != nil {
+		repositoryError(w, err, "Error retrieving user")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(user.Version))
+	json.NewEncoder(w).Encode(user)
+}
+
+func (s *LibaryService) CreateUser(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	var user domain.User
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	user.Email = domain.NormalizeEmail(user.Email)
+
+	if err := s.validation.CheckUser(ctx, user); err != nil {
+		writeValidationProblem(w, err)
+		return
+	}
+
+	if user.Category == "" {
+		user.Category = domain.UserCategoryStudent
+	}
+
+	user.ID = uuid.New().String()
+
+	createdUser, err := s.repository.CreateUser(ctx, user)
+	if err != nil {
+		repositoryError(w, err, "Error creating user")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(createdUser.Version))
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(createdUser)
+}
+
+func (s *LibaryService) UpdateUser(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	id, err := extractID(r, "/users/")
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	match, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	var user domain.User
+	if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	user.Email = domain.NormalizeEmail(user.Email)
+
+	current, err := s.repository.GetUserByID(ctx, id)
+	if err != nil {
+		repositoryError(w, err, "Error retrieving user")
+		return
+	}
+	if !matchesETag(match, current.Version) {
+		writeProblem(w, http.StatusPreconditionFailed, "User has been changed since it was read")
+		return
+	}
+
+	if err := s.validation.CheckUserUpdate(ctx, current, user); err != nil {
+		writeValidationProblem(w, err)
+		return
+	}
+
+	if user.Category == "" {
+		user.Category = domain.UserCategoryStudent
+	}
+
+	user.ID = id
+	user.Version = current.Version
+
+	updatedUser, err := s.repository.UpdateUser(ctx, user)
+	if err != nil {
+		updateError(w, err, "Error updating user")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(updatedUser.Version))
+	json.NewEncoder(w).Encode(updatedUser)
+}
+
+// PatchUser applies a JSON merge patch to a user. Only the merged user is
+// validated, so a patch may leave out every field it does not change.
+func (s *LibaryService) PatchUser(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	id, err := extractID(r, "/users/")
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	match, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	patch, ok := readMergePatch(w, r)
+	if !ok {
+		return
+	}
+
+	current, err := s.repository.GetUserByID(ctx, id)
+	if err != nil {
+		repositoryError(w, err, "Error retrieving user")
+		return
+	}
+	if !matchesETag(match, current.Version) {
+		writeProblem(w, http.StatusPreconditionFailed, "User has been changed since it was read")
+		return
+	}
+
+	user, err := applyMergePatch(current, patch)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+	user.Email = domain.NormalizeEmail(user.Email)
+
+	if err := s.validation.CheckUserUpdate(ctx, current, user); err != nil {
+		writeValidationProblem(w, err)
+		return
+	}
+
+	if user.Category == "" {
+		user.Category = domain.UserCategoryStudent
+	}
+
+	user.ID = id
+	user.Version = current.Version
+
+	updatedUser, err := s.repository.UpdateUser(ctx, user)
+	if err != nil {
+		updateError(w, err, "Error updating user")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(updatedUser.Version))
+	json.NewEncoder(w).Encode(updatedUser)
+}
+
+func (s *LibaryService) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	id, err := extractID(r, "/users/")
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	force, ok := s.forceDelete(w, r)
+	if !ok {
+		return
+	}
+	match, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	user, err := s.repository.GetUserByID(ctx, id)
+	if err != nil {
+		repositoryError(w, err, "Error retrieving user")
+		return
+	}
+	if !matchesETag(match, user.Version) {
+		writeProblem(w, http.StatusPreconditionFailed, "User has been changed since it was read")
+		return
+	}
+
+	if err := s.repository.DeleteUser(ctx, id, user.Version, s.now(), force); err != nil {
+		deleteError(w, err, "Error deleting user")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *LibaryService) RestoreUser(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	id, err := extractNestedID(r, "/users/", "/restore")
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	user, err := s.repository.RestoreUser(ctx, id)
+	if err != nil {
+		repositoryError(w, err, "Error restoring user")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(user.Version))
+	json.NewEncoder(w).Encode(user)
+}
+
+func (s *LibaryService) GetUserFines(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	id, err := extractNestedID(r, "/users/", "/fines")
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid user ID")
+		return
+	}
+
+	if _, err := s.repository.GetUserByID(ctx, id); err != nil {
+		repositoryError(w, err, "Error retrieving user")
+		return
+	}
+
+	fines, err := s.repository.GetFinesByUserID(ctx, id)
+	if err != nil {
+		repositoryError(w, err, "Error retrieving fines")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(fines)
+}
+
+func (s *LibaryService) GetLendings(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	options, err := listOptions(r)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	filter := repository.LendingFilter{
+		UserID: r.URL.Query().Get("user_id"),
+		BookID: r.URL.Query().Get("book_id"),
+	}
+	for name, id := range map[string]string{"user_id": filter.UserID, "book_id": filter.BookID} {
+		if id != "" && uuid.Validate(id) != nil {
+			writeProblem(w, http.StatusBadRequest, "Invalid "+name+" filter")
+			return
+		}
+	}
+	if value := r.URL.Query().Get("active"); value != "" {
+		active, err := strconv.ParseBool(value)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, "Invalid active filter")
+			return
+		}
+		filter.Active = &active
+	}
+	if value := r.URL.Query().Get("overdue"); value != "" {
+		overdue, err := strconv.ParseBool(value)
+		if err != nil {
+			writeProblem(w, http.StatusBadRequest, "Invalid overdue filter")
+			return
+		}
+		filter.Overdue = &overdue
+		filter.Now = s.now()
+	}
+	if filter.IncludeDeleted, err = boolQuery(r, "include_deleted"); err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid include_deleted filter")
+		return
+	}
+
+	page, err := s.repository.GetLendings(ctx, filter, options)
+	if err != nil {
+		listError(w, err, "Error retrieving lendings")
+		return
+	}
+	writePage(w, page)
+}
+
+func (s *LibaryService) GetOverdueLendings(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	now := s.now()
+	lendings, err := s.repository.GetOverdueLendings(ctx, now)
+	if err != nil {
+		repositoryError(w, err, "Error retrieving overdue lendings")
+		return
+	}
+
+	report := make([]domain.OverdueLending, 0, len(lendings))
+	for _, l := range lendings {
+		report = append(report, domain.OverdueLending{Lending: l, DaysOverdue: l.DaysOverdue(now)})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func (s *LibaryService) GetLendingByID(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	id, err := extractID(r, "/lendings/")
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid lending ID")
+		return
+	}
+
+	lending, err := s.repository.GetLendingByID(ctx, id)
+	if err != nil {
+		repositoryError(w, err, "Error retrieving lending")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(lending.Version))
+	json.NewEncoder(w).Encode(lending)
+}
+
+func (s *LibaryService) CreateLending(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	var lending domain.Lending
+	if err := json.NewDecoder(r.Body).Decode(&lending); err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if err := s.validation.CheckLending(ctx, lending); err != nil {
+		writeValidationProblem(w, err)
+		return
+	}
+
+	if err := s.expireHolds(ctx); err != nil {
+		repositoryError(w, err, "Error expiring holds")
+		return
+	}
+
+	// The user stays locked from the privilege check until the lending is
+	// written, so that concurrent requests cannot both take the last loan.
+	var createdLending domain.Lending
+	err := s.repository.WithTx(ctx, func(tx repository.Repository) error {
+		user, err := tx.LockUser(ctx, lending.UserID)
+		if err != nil {
+			return err
+		}
+		category, err := s.checkBorrowingPrivileges(ctx, tx, user)
+		if err != nil {
+			return err
+		}
+		lending.ID = uuid.New().String()
+		lending.DueDate = lending.LendDate.Add(category.LoanPeriod)
+		createdLending, err = tx.CreateLending(ctx, lending)
+		return err
+	})
+	if errors.Is(err, errBorrowingRefused) {
+		writeProblem(w, http.StatusForbidden, err.Error())
+		return
+	} else if err != nil {
+		repositoryError(w, err, "Error creating lending")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(createdLending)
+}
+
+// errBorrowingRefused marks a lending the user is not allowed to take out.
+var errBorrowingRefused = errors.New("borrowing refused")
+
+// categoryOf looks up the borrowing privileges of the user's category.
+func (s *LibaryService) categoryOf(user domain.User) (config.Category, error) {
+	category, ok := s.config.Categories[user.Category]
+	if !ok {
+		return config.Category{}, fmt.Errorf("%w: user category %q has no borrowing privileges", errBorrowingRefused, user.Category)
+	}
+	return category, nil
+}
+
+// checkBorrowingPrivileges refuses a new lending once the user has as many
+// loans out as the category allows or owes more than the fine threshold.
+func (s *LibaryService) checkBorrowingPrivileges(ctx context.Context, repo repository.Repository, user domain.User) (config.Category, error) {
+	category, err := s.categoryOf(user)
+	if err != nil {
+		return config.Category{}, err
+	}
+
+	active, err := repo.CountActiveLendings(ctx, user.ID)
+	if err != nil {
+		return config.Category{}, err
+	}
+	if active >= category.MaxLoans {
+		return config.Category{}, fmt.Errorf("%w: user has reached the limit of %d concurrent loans", errBorrowingRefused, category.MaxLoans)
+	}
+
+	fines, err := repo.GetFinesByUserID(ctx, user.ID)
+	if err != nil {
+		return config.Category{}, err
+	}
+	var unpaid int64
+	for _, f := range fines {
+		if f.Status == domain.FineStatusUnpaid {
+			unpaid += f.Amount
+		}
+	}
+	if unpaid > s.config.FineThreshold {
+		return config.Category{}, fmt.Errorf("%w: user has %d cents in unpaid fines", errBorrowingRefused, unpaid)
+	}
+	return category, nil
+}
+
+func (s *LibaryService) UpdateLending(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	id, err := extractID(r, "/lendings/")
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid lending ID")
+		return
+	}
+
+	match, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	var lending domain.Lending
+	if err := json.NewDecoder(r.Body).Decode(&lending); err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	current, err := s.repository.GetLendingByID(ctx, id)
+	if err != nil {
+		repositoryError(w, err, "Error retrieving lending")
+		return
+	}
+	if !matchesETag(match, current.Version) {
+		writeProblem(w, http.StatusPreconditionFailed, "Lending has been changed since it was read")
+		return
+	}
+
+	if err := s.validation.CheckLendingUpdate(ctx, current, lending); err != nil {
+		writeValidationProblem(w, err)
+		return
+	}
+
+	lending.ID = id
+	lending.Version = current.Version
+
+	updatedLending, err := s.saveLending(ctx, current, lending)
+	if err != nil {
+		updateError(w, err, "Error updating lending")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(updatedLending.Version))
+	json.NewEncoder(w).Encode(updatedLending)
+}
+
+// PatchLending applies a JSON merge patch to a lending. Only the merged lending is
+// validated, so a patch may leave out every field it does not change.
+func (s *LibaryService) PatchLending(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	id, err := extractID(r, "/lendings/")
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid lending ID")
+		return
+	}
+
+	match, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	patch, ok := readMergePatch(w, r)
+	if !ok {
+		return
+	}
+
+	current, err := s.repository.GetLendingByID(ctx, id)
+	if err != nil {
+		repositoryError(w, err, "Error retrieving lending")
+		return
+	}
+	if !matchesETag(match, current.Version) {
+		writeProblem(w, http.StatusPreconditionFailed, "Lending has been changed since it was read")
+		return
+	}
+
+	lending, err := applyMergePatch(current, patch)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid request payload")
+		return
+	}
+
+	if err := s.validation.CheckLendingUpdate(ctx, current, lending); err != nil {
+		writeValidationProblem(w, err)
+		return
+	}
+
+	lending.ID = id
+	lending.Version = current.Version
+
+	updatedLending, err := s.saveLending(ctx, current, lending)
+	if err != nil {
+		updateError(w, err, "Error updating lending")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(updatedLending.Version))
+	json.NewEncoder(w).Encode(updatedLending)
+}
+
+// saveLending updates a lending. When an active lending moves to another
+// copy, the copy it leaves goes to the next waiting hold in the same
+// transaction.
+func (s *LibaryService) saveLending(ctx context.Context, current, lending domain.Lending) (domain.Lending, error) {
+	var updatedLending domain.Lending
+	err := s.repository.WithTx(ctx, func(tx repository.Repository) error {
+		var err error
+		if updatedLending, err = tx.UpdateLending(ctx, lending); err != nil {
+			return err
+		}
+		if !current.ReturnDate.IsZero() || current.CopyID == updatedLending.CopyID {
+			return nil
+		}
+		return s.readyNextHold(ctx, tx, current.CopyID)
+	})
+	return updatedLending, err
+}
+
+func (s *LibaryService) DeleteLending(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	id, err := extractID(r, "/lendings/")
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid lending ID")
+		return
+	}
+
+	match, ok := ifMatch(w, r)
+	if !ok {
+		return
+	}
+
+	lending, err := s.repository.GetLendingByID(ctx, id)
+	if err != nil {
+		repositoryError(w, err, "Error retrieving lending")
+		return
+	}
+	if !matchesETag(match, lending.Version) {
+		writeProblem(w, http.StatusPreconditionFailed, "Lending has been changed since it was read")
+		return
+	}
+
+	// The copy of an active lending goes back on the shelf, and from there to
+	// the next waiting hold in the same transaction.
+	err = s.repository.WithTx(ctx, func(tx repository.Repository) error {
+		if err := tx.DeleteLending(ctx, id, lending.Version, s.now()); err != nil {
+			return err
+		}
+		if !lending.ReturnDate.IsZero() {
+			return nil
+		}
+		return s.readyNextHold(ctx, tx, lending.CopyID)
+	})
+	if err != nil {
+		updateError(w, err, "Error deleting lending")
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RestoreLending brings a deleted lending back. A lending that is still out
+// takes its copy again, so the copy must not have been lent out since.
+func (s *LibaryService) RestoreLending(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	id, err := extractNestedID(r, "/lendings/", "/restore")
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid lending ID")
+		return
+	}
+
+	lending, err := s.repository.RestoreLending(ctx, id)
+	if err != nil {
+		repositoryError(w, err, "Error restoring lending")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(lending.Version))
+	json.NewEncoder(w).Encode(lending)
+}
+
+func (s *LibaryService) ReturnLending(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	id, err := extractNestedID(r, "/lendings/", "/return")
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid lending ID")
+		return
+	}
+
+	lending, err := s.repository.GetLendingByID(ctx, id)
+	if err != nil {
+		repositoryError(w, err, "Error retrieving lending")
+		return
+	}
+
+	if !lending.ReturnDate.IsZero() {
+		writeProblem(w, http.StatusConflict, "Lending has already been returned")
+		return
+	}
+
+	lending.ReturnDate = s.now()
+
+	// The return, its overdue fine and the hand-over to the next hold succeed or fail together.
+	var returnedLending domain.Lending
+	err = s.repository.WithTx(ctx, func(tx repository.Repository) error {
+		var err error
+		if returnedLending, err = tx.UpdateLending(ctx, lending); err != nil {
+			return err
+		}
+		if amount := s.config.OverdueFine(returnedLending.DaysOverdue(returnedLending.ReturnDate)); amount > 0 {
+			if _, err := tx.CreateFine(ctx, s.newFine(returnedLending, domain.FineReasonOverdue, amount)); err != nil {
+				return err
+			}
+		}
+		return s.readyNextHold(ctx, tx, returnedLending.CopyID)
+	})
+	if err != nil {
+		repositoryError(w, err, "Error returning lending")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(returnedLending.Version))
+	json.NewEncoder(w).Encode(returnedLending)
+}
+
+func (s *LibaryService) RenewLending(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	id, err := extractNestedID(r, "/lendings/", "/renew")
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid lending ID")
+		return
+	}
+
+	lending, err := s.repository.GetLendingByID(ctx, id)
+	if err != nil {
+		repositoryError(w, err, "Error retrieving lending")
+		return
+	}
+
+	if !lending.ReturnDate.IsZero() {
+		writeProblem(w, http.StatusConflict, "Lending has already been returned")
+		return
+	}
+	if lending.RenewalCount >= s.config.MaxRenewals {
+		writeProblem(w, http.StatusConflict, fmt.Sprintf("Lending has reached the maximum of %d renewals", s.config.MaxRenewals))
+		return
+	}
+
+	user, err := s.repository.GetUserByID(ctx, lending.UserID)
+	if err != nil {
+		repositoryError(w, err, "Error retrieving user")
+		return
+	}
+	category, err := s.categoryOf(user)
+	if err != nil {
+		writeProblem(w, http.StatusForbidden, err.Error())
+		return
+	}
+
+	// A renewal adds one loan period, counted from today once the lending is
+	// overdue. The days it is already late are charged now, since the new due
+	// date no longer shows them when the lending is returned.
+	now := s.now()
+	fine := s.config.OverdueFine(lending.DaysOverdue(now))
+	dueFrom := lending.DueDate
+	if now.After(dueFrom) {
+		dueFrom = now
+	}
+	lending.DueDate = dueFrom.Add(category.LoanPeriod)
+	lending.RenewalCount++
+
+	var renewedLending domain.Lending
+	err = s.repository.WithTx(ctx, func(tx repository.Repository) error {
+		var err error
+		if renewedLending, err = tx.UpdateLending(ctx, lending); err != nil {
+			return err
+		}
+		if fine > 0 {
+			_, err = tx.CreateFine(ctx, s.newFine(renewedLending, domain.FineReasonOverdue, fine))
+		}
+		return err
+	})
+	if err != nil {
+		repositoryError(w, err, "Error renewing lending")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(renewedLending.Version))
+	json.NewEncoder(w).Encode(renewedLending)
+}
+
+// ReportLostLending closes a lending whose copy will not come back, takes the
+// copy out of circulation and charges the lost item fee.
+func (s *LibaryService) ReportLostLending(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	id, err := extractNestedID(r, "/lendings/", "/lost")
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid lending ID")
+		return
+	}
+
+	lending, err := s.repository.GetLendingByID(ctx, id)
+	if err != nil {
+		repositoryError(w, err, "Error retrieving lending")
+		return
+	}
+
+	if !lending.ReturnDate.IsZero() {
+		writeProblem(w, http.StatusConflict, "Lending has already been returned")
+		return
+	}
+
+	lending.ReturnDate = s.now()
+
+	var closedLending domain.Lending
+	err = s.repository.WithTx(ctx, func(tx repository.Repository) error {
+		// The copy is marked lost first so that closing the lending does not put it back on the shelf.
+		if lending.CopyID != "" {
+			bookCopy, err := tx.GetBookCopyByID(ctx, lending.CopyID)
+			if err != nil {
+				return err
+			}
+			bookCopy.Status = domain.CopyStatusLost
+			if _, err := tx.UpdateBookCopy(ctx, bookCopy); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		if closedLending, err = tx.UpdateLending(ctx, lending); err != nil {
+			return err
+		}
+		if s.config.LostItemFee > 0 {
+			_, err = tx.CreateFine(ctx, s.newFine(closedLending, domain.FineReasonLost, s.config.LostItemFee))
+		}
+		return err
+	})
+	if err != nil {
+		repositoryError(w, err, "Error reporting lending lost")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag(closedLending.Version))
+	json.NewEncoder(w).Encode(closedLending)
+}
+
+func (s *LibaryService) newFine(lending domain.Lending, reason domain.FineReason, amount int64) domain.Fine {
+	return domain.Fine{
+		ID:        uuid.New().String(),
+		UserID:    lending.UserID,
+		LendingID: lending.ID,
+		Reason:    reason,
+		Amount:    amount,
+		Status:    domain.FineStatusUnpaid,
+		CreatedAt: s.now(),
+	}
+}
+
+func (s *LibaryService) PayFine(w http.ResponseWriter, r *http.Request) {
+	s.settleFine(w, r, "/pay", domain.FineStatusPaid)
+}
+
+func (s *LibaryService) WaiveFine(w http.ResponseWriter, r *http.Request) {
+	s.settleFine(w, r, "/waive", domain.FineStatusWaived)
+}
+
+// settleFine moves an unpaid fine into its final status.
+func (s *LibaryService) settleFine(w http.ResponseWriter, r *http.Request, suffix string, status domain.FineStatus) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	id, err := extractNestedID(r, "/fines/", suffix)
+	if err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid fine ID")
+		return
+	}
+
+	fine, err := s.repository.GetFineByID(ctx, id)
+	if err != nil {
+		repositoryError(w, err, "Error retrieving fine")
+		return
+	}
+
+	if fine.Status != domain.FineStatusUnpaid {
+		writeProblem(w, http.StatusConflict, fmt.Sprintf("Fine has already been %s", fine.Status))
+		return
+	}
+
+	fine.Status = status
+	fine.SettledAt = s.now()
+
+	settledFine, err := s.repository.UpdateFine(ctx, fine)
+	if err != nil {
+		repositoryError(w, err, "Error updating fine")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settledFine)
+}
+
+// PurgeDeleted removes the books, users and lendings that were deleted longer
+// than the retention period ago for good. Only admins may purge.
+func (s *LibaryService) PurgeDeleted(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := s.requestContext(r)
+	defer cancel()
+	if !s.isAdmin(r) {
+		writeProblem(w, http.StatusForbidden, "Admin access required")
+		return
+	}
+
+	report, err := s.repository.PurgeDeleted(ctx, s.now().Add(-s.config.DeletedRetention))
+	if err != nil {
+		repositoryError(w, err, "Error purging deleted rows")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// GetDatabaseStats reports the connection pool of the repository, if it has one.
+func (s *LibaryService) GetDatabaseStats(w http.ResponseWriter, r *http.Request) {
+	reporter, ok := s.repository.(repository.StatsReporter)
+	if !ok {
+		writeProblem(w, http.StatusNotFound, "Database statistics are not available")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reporter.PoolStats())
 }
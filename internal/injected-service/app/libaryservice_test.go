@@ -2,11 +2,19 @@ package app
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"libary-service/internal/database"
 	"libary-service/internal/domain"
+	"libary-service/internal/injected-service/config"
+	"libary-service/internal/injected-service/repository"
+	"libary-service/internal/injected-service/validation"
+	"libary-service/internal/injected-service/validation/validator"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -16,6 +24,16 @@ import (
 	"libary-service/generated/mocks"
 )
 
+// errInvalid stands in for the field errors of a failed validation.
+var errInvalid = validation.Errors{{Field: "title", Message: "title is required"}}
+
+// runTransactions lets mockRepo run the transactions of the service on itself.
+func runTransactions(mockRepo *mocks.Repository) {
+	mockRepo.On("WithTx", mock.Anything, mock.Anything).Return(func(_ context.Context, fn func(tx repository.Repository) error) error {
+		return fn(mockRepo)
+	}).Maybe()
+}
+
 func TestExtractID(t *testing.T) {
 	id := uuid.NewString()
 	testCases := []struct {
@@ -44,28 +62,122 @@ func TestExtractID(t *testing.T) {
 	}
 }
 
-func TestGetBooks(t *testing.T) {
-	id1 := uuid.NewString()
-	id2 := uuid.NewString()
+func TestProblemResponses(t *testing.T) {
+	fieldErrors := validation.Errors{{Field: "title", Message: "title is required"}}
+	testCases := []struct {
+		name            string
+		validationErr   error
+		repositoryErr   error
+		expectedProblem Problem
+	}{
+		{"field errors", fieldErrors, nil,
+			Problem{Type: "about:blank", Title: "Unprocessable Entity", Status: http.StatusUnprocessableEntity, Detail: "The request body is invalid", Errors: fieldErrors}},
+		{"failed validation", errors.New("database error"), nil,
+			Problem{Type: "about:blank", Title: "Internal Server Error", Status: http.StatusInternalServerError, Detail: "Error validating request"}},
+		{"validation timed out", context.DeadlineExceeded, nil,
+			Problem{Type: "about:blank", Title: "Service Unavailable", Status: http.StatusServiceUnavailable, Detail: "Request timed out"}},
+		{"conflicting field", validation.Conflict{Field: "title", Message: "title is already taken"}, nil,
+			Problem{Type: "about:blank", Title: "Conflict", Status: http.StatusConflict, Detail: "Title is already taken",
+				Errors: []validation.FieldError{{Field: "title", Message: "title is already taken"}}}},
+		{"conflict", nil, repository.ErrBarcodeExists,
+			Problem{Type: "about:blank", Title: "Conflict", Status: http.StatusConflict, Detail: "Barcode already exists"}},
+		{"internal error", nil, errors.New("database error"),
+			Problem{Type: "about:blank", Title: "Internal Server Error", Status: http.StatusInternalServerError, Detail: "Error creating book"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			mockValidation := new(mocks.Validation)
+			mockValidation.On("CheckBook", mock.Anything, mock.AnythingOfType("domain.Book")).Return(tc.validationErr)
+			mockRepo.On("CreateBook", mock.Anything, mock.AnythingOfType("domain.Book")).Return(domain.Book{}, tc.repositoryErr).Maybe()
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
+			req, _ := http.NewRequest("POST", "/books", bytes.NewBufferString(`{"title":"","author":"J.R.R. Tolkien"}`))
+			rr := httptest.NewRecorder()
+			service.CreateBook(rr, req)
+			assert.Equal(t, tc.expectedProblem.Status, rr.Code)
+			assert.Equal(t, "application/problem+json", rr.Header().Get("Content-Type"))
+			var problem Problem
+			assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &problem))
+			assert.Equal(t, tc.expectedProblem, problem)
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRequestDeadline(t *testing.T) {
+	bookID := uuid.NewString()
 	testCases := []struct {
 		name           string
-		books          []domain.Book
+		timeout        time.Duration
 		repositoryErr  error
+		expectDeadline bool
 		expectedStatus int
 	}{
-		{"success", []domain.Book{
-			{ID: id1, Title: "The Fellowship of the Ring", Author: "J.R.R. Tolkien"},
-			{ID: id2, Title: "The Two Towers", Author: "J.R.R. Tolkien"},
-		}, nil, http.StatusOK},
-		{"repository error", nil, errors.New("database error"), http.StatusInternalServerError},
+		{"deadline", time.Second, nil, true, http.StatusOK},
+		{"no deadline", 0, nil, false, http.StatusOK},
+		{"timed out", time.Second, context.DeadlineExceeded, true, http.StatusServiceUnavailable},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			mockRepo.On("GetBookByID", mock.Anything, bookID).Run(func(args mock.Arguments) {
+				_, ok := args.Get(0).(context.Context).Deadline()
+				assert.Equal(t, tc.expectDeadline, ok)
+			}).Return(domain.Book{ID: bookID}, tc.repositoryErr)
+			mockRepo.On("GetBookCopies", mock.Anything, bookID).Return(nil, nil).Maybe()
+			cfg := config.Default()
+			cfg.RequestTimeout = tc.timeout
+			service := NewLibaryService(mockRepo, new(mocks.Validation), cfg)
+			req, _ := http.NewRequest("GET", "/books/"+bookID, nil)
+			rr := httptest.NewRecorder()
+			service.GetBookByID(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetBooks(t *testing.T) {
+	id1 := uuid.NewString()
+	id2 := uuid.NewString()
+	books := []domain.Book{
+		{ID: id1, Title: "The Fellowship of the Ring", Author: "J.R.R. Tolkien"},
+		{ID: id2, Title: "The Two Towers", Author: "J.R.R. Tolkien"},
+	}
+	testCases := []struct {
+		name               string
+		query              string
+		filter             repository.BookFilter
+		options            repository.ListOptions
+		page               repository.Page[domain.Book]
+		repositoryErr      error
+		expectedStatus     int
+		expectedNextCursor string
+	}{
+		{"success", "", repository.BookFilter{}, repository.ListOptions{Limit: 50},
+			repository.Page[domain.Book]{Items: books, Total: 2}, nil, http.StatusOK, ""},
+		{"filtered page", "?author=J.R.R.+Tolkien&title_prefix=The&limit=2&cursor=abc&sort=-title",
+			repository.BookFilter{Author: "J.R.R. Tolkien", TitlePrefix: "The"}, repository.ListOptions{Limit: 2, Cursor: "abc", Sort: "-title"},
+			repository.Page[domain.Book]{Items: books, NextCursor: "next", Total: 7}, nil, http.StatusOK, "next"},
+		{"including deleted", "?include_deleted=true", repository.BookFilter{IncludeDeleted: true}, repository.ListOptions{Limit: 50},
+			repository.Page[domain.Book]{Items: books, Total: 2}, nil, http.StatusOK, ""},
+		{"invalid include_deleted", "?include_deleted=maybe", repository.BookFilter{}, repository.ListOptions{}, repository.Page[domain.Book]{}, nil, http.StatusBadRequest, ""},
+		{"invalid limit", "?limit=0", repository.BookFilter{}, repository.ListOptions{}, repository.Page[domain.Book]{}, nil, http.StatusBadRequest, ""},
+		{"limit too large", "?limit=201", repository.BookFilter{}, repository.ListOptions{}, repository.Page[domain.Book]{}, nil, http.StatusBadRequest, ""},
+		{"invalid cursor", "?cursor=abc", repository.BookFilter{}, repository.ListOptions{Limit: 50, Cursor: "abc"},
+			repository.Page[domain.Book]{}, repository.ErrInvalidCursor, http.StatusBadRequest, ""},
+		{"invalid sort", "?sort=pages", repository.BookFilter{}, repository.ListOptions{Limit: 50, Sort: "pages"},
+			repository.Page[domain.Book]{}, repository.ErrInvalidSort, http.StatusBadRequest, ""},
+		{"repository error", "", repository.BookFilter{}, repository.ListOptions{Limit: 50},
+			repository.Page[domain.Book]{}, errors.New("database error"), http.StatusInternalServerError, ""},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			mockRepo := new(mocks.Repository)
-			mockRepo.On("GetBooks").Return(tc.books, tc.repositoryErr)
+			mockRepo.On("GetBooks", mock.Anything, tc.filter, tc.options).Return(tc.page, tc.repositoryErr).Maybe()
 			mockValidation := new(mocks.Validation)
-			service := NewLibaryService(mockRepo, mockValidation)
-			req, _ := http.NewRequest("GET", "/books", nil)
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
+			req, _ := http.NewRequest("GET", "/books"+tc.query, nil)
 			rr := httptest.NewRecorder()
 			service.GetBooks(rr, req)
 			assert.Equal(t, tc.expectedStatus, rr.Code)
@@ -73,7 +185,57 @@ func TestGetBooks(t *testing.T) {
 				var responseBooks []domain.Book
 				err := json.Unmarshal(rr.Body.Bytes(), &responseBooks)
 				assert.NoError(t, err)
-				assert.Equal(t, tc.books, responseBooks)
+				assert.Equal(t, tc.page.Items, responseBooks)
+				assert.Equal(t, strconv.Itoa(tc.page.Total), rr.Header().Get("X-Total-Count"))
+				assert.Equal(t, tc.expectedNextCursor, rr.Header().Get("X-Next-Cursor"))
+			}
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSearchBooks(t *testing.T) {
+	hits := []domain.BookSearchHit{{
+		Book:            domain.Book{ID: uuid.NewString(), Title: "The Two Towers", Author: "J.R.R. Tolkien"},
+		Rank:            0.6,
+		TitleHighlight:  "The Two <b>Towers</b>",
+		AuthorHighlight: "J.R.R. Tolkien",
+	}}
+	testCases := []struct {
+		name           string
+		query          string
+		options        repository.ListOptions
+		page           repository.Page[domain.BookSearchHit]
+		repositoryErr  error
+		expectedStatus int
+	}{
+		{"success", "?q=towers", repository.ListOptions{Limit: 50},
+			repository.Page[domain.BookSearchHit]{Items: hits, Total: 1}, nil, http.StatusOK},
+		{"next page", "?q=towers&limit=1&cursor=abc", repository.ListOptions{Limit: 1, Cursor: "abc"},
+			repository.Page[domain.BookSearchHit]{Items: hits, Total: 3, NextCursor: "next"}, nil, http.StatusOK},
+		{"missing query", "?q=+", repository.ListOptions{}, repository.Page[domain.BookSearchHit]{}, nil, http.StatusBadRequest},
+		{"sorted", "?q=towers&sort=title", repository.ListOptions{Limit: 50, Sort: "title"},
+			repository.Page[domain.BookSearchHit]{}, repository.ErrInvalidSort, http.StatusBadRequest},
+		{"repository error", "?q=towers", repository.ListOptions{Limit: 50},
+			repository.Page[domain.BookSearchHit]{}, errors.New("database error"), http.StatusInternalServerError},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			mockRepo.On("SearchBooks", mock.Anything, "towers", tc.options).Return(tc.page, tc.repositoryErr).Maybe()
+			mockValidation := new(mocks.Validation)
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
+			req, _ := http.NewRequest("GET", "/books/search"+tc.query, nil)
+			rr := httptest.NewRecorder()
+			service.SearchBooks(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedStatus == http.StatusOK {
+				var responseHits []domain.BookSearchHit
+				err := json.Unmarshal(rr.Body.Bytes(), &responseHits)
+				assert.NoError(t, err)
+				assert.Equal(t, tc.page.Items, responseHits)
+				assert.Equal(t, strconv.Itoa(tc.page.Total), rr.Header().Get("X-Total-Count"))
+				assert.Equal(t, tc.page.NextCursor, rr.Header().Get("X-Next-Cursor"))
 			}
 			mockRepo.AssertExpectations(t)
 		})
@@ -82,33 +244,85 @@ func TestGetBooks(t *testing.T) {
 
 func TestGetBookByID(t *testing.T) {
 	bookID := uuid.NewString()
-	book := domain.Book{ID: bookID, Title: "The Fellowship of the Ring", Author: "J.R.R. Tolkien"}
+	book := domain.Book{ID: bookID, Title: "The Fellowship of the Ring", Author: "J.R.R. Tolkien", Version: 2}
+	copies := []domain.BookCopy{
+		{ID: uuid.NewString(), BookID: bookID, Barcode: "LIB-0001", Condition: domain.CopyConditionGood, Status: domain.CopyStatusAvailable},
+		{ID: uuid.NewString(), BookID: bookID, Barcode: "LIB-0002", Condition: domain.CopyConditionFair, Status: domain.CopyStatusOnLoan},
+	}
+	testCases := []struct {
+		name              string
+		path              string
+		book              domain.Book
+		repositoryErr     error
+		copies            []domain.BookCopy
+		copiesErr         error
+		expectedStatus    int
+		expectedInventory domain.BookInventory
+	}{
+		{"success", "/books/" + bookID, book, nil, copies, nil, http.StatusOK, domain.BookInventory{Book: book, TotalCopies: 2, AvailableCopies: 1}},
+		{"invalid id", "/invalid/" + bookID, domain.Book{}, nil, nil, nil, http.StatusBadRequest, domain.BookInventory{}},
+		{"book not found", "/books/" + bookID, domain.Book{}, repository.ErrBookNotFound, nil, nil, http.StatusNotFound, domain.BookInventory{}},
+		{"copies error", "/books/" + bookID, book, nil, nil, errors.New("database error"), http.StatusInternalServerError, domain.BookInventory{}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			mockRepo.On("GetBookByID", mock.Anything, bookID).Return(tc.book, tc.repositoryErr).Maybe()
+			mockRepo.On("GetBookCopies", mock.Anything, bookID).Return(tc.copies, tc.copiesErr).Maybe()
+			mockValidation := new(mocks.Validation)
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
+			req, _ := http.NewRequest("GET", tc.path, nil)
+			rr := httptest.NewRecorder()
+			service.GetBookByID(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedStatus == http.StatusOK {
+				var responseInventory domain.BookInventory
+				err := json.Unmarshal(rr.Body.Bytes(), &responseInventory)
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedInventory, responseInventory)
+				assert.Equal(t, `"2"`, rr.Header().Get("ETag"))
+			}
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetBookByISBN(t *testing.T) {
+	book := domain.Book{ID: uuid.NewString(), Title: "The Hobbit", Author: "J.R.R. Tolkien", ISBN: "9780261102217", Version: 1}
+	copies := []domain.BookCopy{
+		{ID: uuid.NewString(), BookID: book.ID, Barcode: "LIB-0001", Condition: domain.CopyConditionGood, Status: domain.CopyStatusAvailable},
+	}
 	testCases := []struct {
 		name           string
 		path           string
-		book           domain.Book
+		books          []domain.Book
 		repositoryErr  error
 		expectedStatus int
 	}{
-		{"success", "/books/" + bookID, book, nil, http.StatusOK},
-		{"invalid id", "/invalid/" + bookID, domain.Book{}, nil, http.StatusBadRequest},
-		{"book not found", "/books/" + bookID, domain.Book{}, errors.New("book not found"), http.StatusNotFound},
+		{"isbn-13", "/books/isbn/978-0-261-10221-7", []domain.Book{book}, nil, http.StatusOK},
+		{"isbn-10", "/books/isbn/0261102214", []domain.Book{book}, nil, http.StatusOK},
+		{"wrong check digit", "/books/isbn/9780261102218", nil, nil, http.StatusBadRequest},
+		{"not an isbn", "/books/isbn/hobbit", nil, nil, http.StatusBadRequest},
+		{"book not found", "/books/isbn/9780261102217", []domain.Book{}, nil, http.StatusNotFound},
+		{"repository error", "/books/isbn/9780261102217", nil, errors.New("database error"), http.StatusInternalServerError},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			mockRepo := new(mocks.Repository)
-			mockRepo.On("GetBookByID", bookID).Return(tc.book, tc.repositoryErr).Maybe()
-			mockValidation := new(mocks.Validation)
-			service := NewLibaryService(mockRepo, mockValidation)
+			mockRepo.On("GetBooks", mock.Anything, repository.BookFilter{ISBN: book.ISBN}, repository.ListOptions{Limit: 1}).
+				Return(repository.Page[domain.Book]{Items: tc.books, Total: len(tc.books)}, tc.repositoryErr).Maybe()
+			mockRepo.On("GetBookCopies", mock.Anything, book.ID).Return(copies, nil).Maybe()
+			service := NewLibaryService(mockRepo, new(mocks.Validation), config.Default())
 			req, _ := http.NewRequest("GET", tc.path, nil)
 			rr := httptest.NewRecorder()
-			service.GetBookByID(rr, req)
+			service.GetBookByISBN(rr, req)
 			assert.Equal(t, tc.expectedStatus, rr.Code)
 			if tc.expectedStatus == http.StatusOK {
-				var responseBook domain.Book
-				err := json.Unmarshal(rr.Body.Bytes(), &responseBook)
+				var responseInventory domain.BookInventory
+				err := json.Unmarshal(rr.Body.Bytes(), &responseInventory)
 				assert.NoError(t, err)
-				assert.Equal(t, tc.book, responseBook)
+				assert.Equal(t, domain.BookInventory{Book: book, TotalCopies: 1, AvailableCopies: 1}, responseInventory)
+				assert.Equal(t, `"1"`, rr.Header().Get("ETag"))
 			}
 			mockRepo.AssertExpectations(t)
 		})
@@ -116,7 +330,7 @@ func TestGetBookByID(t *testing.T) {
 }
 
 func TestCreateBook(t *testing.T) {
-	validBook := domain.Book{Title: "The Fellowship of the Ring", Author: "J.R.R. Tolkien"}
+	validBook := domain.Book{Title: "The Fellowship of the Ring", Author: "J.R.R. Tolkien", ISBN: "0-261-10235-4"}
 	newID := uuid.NewString()
 	testCases := []struct {
 		name           string
@@ -126,9 +340,10 @@ func TestCreateBook(t *testing.T) {
 		repositoryErr  error
 		expectedStatus int
 	}{
-		{"success", validBook, nil, domain.Book{ID: newID, Title: "The Fellowship of the Ring", Author: "J.R.R. Tolkien"}, nil, http.StatusCreated},
+		{"success", validBook, nil, domain.Book{ID: newID, Title: "The Fellowship of the Ring", Author: "J.R.R. Tolkien", ISBN: "9780261102354"}, nil, http.StatusCreated},
 		{"invalid request body", "invalid json", nil, domain.Book{}, nil, http.StatusBadRequest},
-		{"validation error", validBook, errors.New("validation error"), domain.Book{}, nil, http.StatusBadRequest},
+		{"validation error", validBook, errInvalid, domain.Book{}, nil, http.StatusUnprocessableEntity},
+		{"isbn taken", validBook, validation.Conflict{Field: "isbn", Message: "isbn is already taken"}, domain.Book{}, nil, http.StatusConflict},
 		{"repository error", validBook, nil, domain.Book{}, errors.New("database error"), http.StatusInternalServerError},
 	}
 	for _, tc := range testCases {
@@ -143,9 +358,11 @@ func TestCreateBook(t *testing.T) {
 				requestBytes, err = json.Marshal(tc.requestBody)
 				assert.NoError(t, err)
 			}
-			mockValidation.On("CheckBook", mock.AnythingOfType("domain.Book")).Return(tc.validationErr).Maybe()
-			mockRepo.On("CreateBook", mock.AnythingOfType("domain.Book")).Return(tc.createdBook, tc.repositoryErr).Maybe()
-			service := NewLibaryService(mockRepo, mockValidation)
+			mockValidation.On("CheckBook", mock.Anything, mock.AnythingOfType("domain.Book")).Return(tc.validationErr).Maybe()
+			mockRepo.On("CreateBook", mock.Anything, mock.MatchedBy(func(b domain.Book) bool {
+				return b.ISBN == "9780261102354" && len(b.Authors) == 1 && b.Authors[0] == "J.R.R. Tolkien"
+			})).Return(tc.createdBook, tc.repositoryErr).Maybe()
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
 			req, _ := http.NewRequest("POST", "/books", bytes.NewBuffer(requestBytes))
 			rr := httptest.NewRecorder()
 			service.CreateBook(rr, req)
@@ -157,6 +374,7 @@ func TestCreateBook(t *testing.T) {
 				assert.NotEmpty(t, responseBook.ID)
 				assert.Equal(t, tc.createdBook.Title, responseBook.Title)
 				assert.Equal(t, tc.createdBook.Author, responseBook.Author)
+				assert.Equal(t, tc.createdBook.ISBN, responseBook.ISBN)
 			}
 			mockRepo.AssertExpectations(t)
 			mockValidation.AssertExpectations(t)
@@ -179,8 +397,9 @@ func TestUpdateBook(t *testing.T) {
 		{"success", "/books/" + bookID, validBook, nil, domain.Book{ID: bookID, Title: "The Two Towers", Author: "J.R.R. Tolkien"}, nil, http.StatusOK},
 		{"invalid path", "/invalid/" + bookID, validBook, nil, domain.Book{}, nil, http.StatusBadRequest},
 		{"invalid request body", "/books/" + bookID, "invalid json", nil, domain.Book{}, nil, http.StatusBadRequest},
-		{"validation error", "/books/" + bookID, validBook, errors.New("validation error"), domain.Book{}, nil, http.StatusBadRequest},
+		{"validation error", "/books/" + bookID, validBook, errInvalid, domain.Book{}, nil, http.StatusUnprocessableEntity},
 		{"repository error", "/books/" + bookID, validBook, nil, domain.Book{}, errors.New("database error"), http.StatusInternalServerError},
+		{"book not found", "/books/" + bookID, validBook, nil, domain.Book{}, repository.ErrBookNotFound, http.StatusNotFound},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -194,10 +413,12 @@ func TestUpdateBook(t *testing.T) {
 				requestBytes, err = json.Marshal(tc.requestBody)
 				assert.NoError(t, err)
 			}
-			mockValidation.On("CheckBook", mock.AnythingOfType("domain.Book")).Return(tc.validationErr).Maybe()
-			mockRepo.On("UpdateBook", mock.AnythingOfType("domain.Book")).Return(tc.updatedBook, tc.repositoryErr).Maybe()
-			service := NewLibaryService(mockRepo, mockValidation)
+			mockValidation.On("CheckBookUpdate", mock.Anything, mock.AnythingOfType("domain.Book"), mock.AnythingOfType("domain.Book")).Return(tc.validationErr).Maybe()
+			mockRepo.On("GetBookByID", mock.Anything, bookID).Return(domain.Book{ID: bookID, Version: 1}, nil).Maybe()
+			mockRepo.On("UpdateBook", mock.Anything, mock.AnythingOfType("domain.Book")).Return(tc.updatedBook, tc.repositoryErr).Maybe()
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
 			req, _ := http.NewRequest("PUT", tc.path, bytes.NewBuffer(requestBytes))
+			req.Header.Set("If-Match", `"1"`)
 			rr := httptest.NewRecorder()
 			service.UpdateBook(rr, req)
 			assert.Equal(t, tc.expectedStatus, rr.Code)
@@ -213,119 +434,386 @@ func TestUpdateBook(t *testing.T) {
 	}
 }
 
+func TestMergePatch(t *testing.T) {
+	// The examples of RFC 7396, Appendix A.
+	testCases := []struct {
+		target   string
+		patch    string
+		expected string
+	}{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"c"}`, `{"a":["b"]}`, `{"a":["b"]}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"a":"b"}`, `["c"]`, `["c"]`},
+		{`{"a":"foo"}`, `null`, `null`},
+		{`{"a":"foo"}`, `"bar"`, `"bar"`},
+		{`{"e":null}`, `{"a":1}`, `{"e":null,"a":1}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+		{`{}`, `{"a":{"bb":{"ccc":null}}}`, `{"a":{"bb":{}}}`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.patch, func(t *testing.T) {
+			var target, patch any
+			assert.NoError(t, json.Unmarshal([]byte(tc.target), &target))
+			assert.NoError(t, json.Unmarshal([]byte(tc.patch), &patch))
+			merged, err := json.Marshal(mergePatch(target, patch))
+			assert.NoError(t, err)
+			assert.JSONEq(t, tc.expected, string(merged))
+		})
+	}
+}
+
+func TestPatchBook(t *testing.T) {
+	bookID := uuid.NewString()
+	stored := domain.Book{ID: bookID, Title: "The Two Towers", Author: "J.R.R. Tolkien", Authors: []string{"J.R.R. Tolkien"}, Version: 2}
+	testCases := []struct {
+		name           string
+		path           string
+		contentType    string
+		ifMatch        string
+		patch          string
+		storedErr      error
+		validationErr  error
+		expectedBook   domain.Book
+		repositoryErr  error
+		expectedStatus int
+	}{
+		{"success", "/books/" + bookID, "application/merge-patch+json", `"2"`, `{"title":"The Return of the King"}`, nil, nil,
+			domain.Book{ID: bookID, Title: "The Return of the King", Author: "J.R.R. Tolkien", Authors: []string{"J.R.R. Tolkien"}, Version: 2}, nil, http.StatusOK},
+		{"plain json", "/books/" + bookID, "application/json", `"2"`, `{"author":"Tolkien"}`, nil, nil,
+			domain.Book{ID: bookID, Title: "The Two Towers", Author: "Tolkien", Authors: []string{"Tolkien"}, Version: 2}, nil, http.StatusOK},
+		{"authors decide author", "/books/" + bookID, "application/merge-patch+json", `"2"`, `{"authors":["Christopher Tolkien","J.R.R. Tolkien"]}`, nil, nil,
+			domain.Book{ID: bookID, Title: "The Two Towers", Author: "Christopher Tolkien", Authors: []string{"Christopher Tolkien", "J.R.R. Tolkien"}, Version: 2},
+			nil, http.StatusOK},
+		{"version is kept", "/books/" + bookID, "application/merge-patch+json", `"2"`, `{"version":7}`, nil, nil,
+			stored, nil, http.StatusOK},
+		{"removed field is validated", "/books/" + bookID, "application/merge-patch+json", `"2"`, `{"author":null}`, nil, errInvalid,
+			domain.Book{ID: bookID, Title: "The Two Towers", Version: 2}, nil, http.StatusUnprocessableEntity},
+		{"invalid path", "/invalid/" + bookID, "application/merge-patch+json", `"2"`, `{}`, nil, nil, domain.Book{}, nil, http.StatusBadRequest},
+		{"missing If-Match", "/books/" + bookID, "application/merge-patch+json", "", `{}`, nil, nil, domain.Book{}, nil, http.StatusPreconditionRequired},
+		{"unsupported content type", "/books/" + bookID, "text/plain", `"2"`, `{}`, nil, nil, domain.Book{}, nil, http.StatusUnsupportedMediaType},
+		{"invalid json", "/books/" + bookID, "application/merge-patch+json", `"2"`, `{`, nil, nil, domain.Book{}, nil, http.StatusBadRequest},
+		{"patch not an object", "/books/" + bookID, "application/merge-patch+json", `"2"`, `["title"]`, nil, nil, domain.Book{}, nil, http.StatusBadRequest},
+		{"wrong field type", "/books/" + bookID, "application/merge-patch+json", `"2"`, `{"title":5}`, nil, nil, domain.Book{}, nil, http.StatusBadRequest},
+		{"book not found", "/books/" + bookID, "application/merge-patch+json", `"2"`, `{}`, repository.ErrBookNotFound, nil, domain.Book{}, nil, http.StatusNotFound},
+		{"stale version", "/books/" + bookID, "application/merge-patch+json", `"1"`, `{}`, nil, nil, domain.Book{}, nil, http.StatusPreconditionFailed},
+		{"lost race", "/books/" + bookID, "application/merge-patch+json", `"2"`, `{}`, nil, nil, stored, repository.ErrVersionMismatch, http.StatusPreconditionFailed},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			mockValidation := new(mocks.Validation)
+			mockRepo.On("GetBookByID", mock.Anything, bookID).Return(stored, tc.storedErr).Maybe()
+			mockValidation.On("CheckBookUpdate", mock.Anything, stored, mock.AnythingOfType("domain.Book")).Return(tc.validationErr).Maybe()
+			mockRepo.On("UpdateBook", mock.Anything, tc.expectedBook).Return(func(_ context.Context, b domain.Book) domain.Book {
+				b.Version++
+				return b
+			}, tc.repositoryErr).Maybe()
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
+			req, _ := http.NewRequest("PATCH", tc.path, strings.NewReader(tc.patch))
+			req.Header.Set("Content-Type", tc.contentType)
+			req.Header.Set("If-Match", tc.ifMatch)
+			rr := httptest.NewRecorder()
+			service.PatchBook(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedStatus == http.StatusOK {
+				var responseBook domain.Book
+				assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &responseBook))
+				tc.expectedBook.Version++
+				assert.Equal(t, tc.expectedBook, responseBook)
+				assert.Equal(t, `"3"`, rr.Header().Get("ETag"))
+			}
+			mockRepo.AssertExpectations(t)
+			mockValidation.AssertExpectations(t)
+		})
+	}
+}
+
 func TestDeleteBook(t *testing.T) {
 	bookID := uuid.NewString()
+	blocked := &repository.ActiveLendingsError{Entity: "book", LendingIDs: []string{uuid.NewString(), uuid.NewString()}}
 	testCases := []struct {
 		name           string
 		path           string
+		authorization  string
+		force          bool
 		repositoryErr  error
 		expectedStatus int
 	}{
-		{"success", "/books/" + bookID, nil, http.StatusNoContent},
-		{"invalid path", "/invalid/" + bookID, nil, http.StatusBadRequest},
-		{"repository error", "/books/" + bookID, errors.New("database error"), http.StatusInternalServerError},
+		{"success", "/books/" + bookID, "", false, nil, http.StatusNoContent},
+		{"invalid path", "/invalid/" + bookID, "", false, nil, http.StatusBadRequest},
+		{"repository error", "/books/" + bookID, "", false, errors.New("database error"), http.StatusInternalServerError},
+		{"book not found", "/books/" + bookID, "", false, repository.ErrBookNotFound, http.StatusNotFound},
+		{"active lendings", "/books/" + bookID, "", false, blocked, http.StatusConflict},
+		{"forced by admin", "/books/" + bookID + "?force=true", "Bearer secret", true, nil, http.StatusNoContent},
+		{"forced without admin", "/books/" + bookID + "?force=true", "Bearer guess", true, nil, http.StatusForbidden},
+		{"invalid force", "/books/" + bookID + "?force=always", "Bearer secret", false, nil, http.StatusBadRequest},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			mockRepo := new(mocks.Repository)
 			mockValidation := new(mocks.Validation)
-			mockRepo.On("DeleteBook", bookID).Return(tc.repositoryErr).Maybe()
-			service := NewLibaryService(mockRepo, mockValidation)
+			mockRepo.On("GetBookByID", mock.Anything, bookID).Return(domain.Book{ID: bookID, Version: 1}, nil).Maybe()
+			mockRepo.On("DeleteBook", mock.Anything, bookID, 1, mock.Anything, tc.force).Return(tc.repositoryErr).Maybe()
+			cfg := config.Default()
+			cfg.AdminToken = "secret"
+			service := NewLibaryService(mockRepo, mockValidation, cfg)
 			req, _ := http.NewRequest("DELETE", tc.path, nil)
+			req.Header.Set("If-Match", `"1"`)
+			req.Header.Set("Authorization", tc.authorization)
 			rr := httptest.NewRecorder()
 			service.DeleteBook(rr, req)
 			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedStatus == http.StatusConflict {
+				var problem Problem
+				assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &problem))
+				assert.Equal(t, "Book has active lendings", problem.Detail)
+				assert.Equal(t, blocked.LendingIDs, problem.LendingIDs)
+			}
+			if tc.expectedStatus == http.StatusForbidden {
+				mockRepo.AssertNotCalled(t, "DeleteBook", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			}
 			mockRepo.AssertExpectations(t)
 		})
 	}
 }
 
-func TestGetUsers(t *testing.T) {
-	userID1 := uuid.NewString()
-	userID2 := uuid.NewString()
+func TestRestore(t *testing.T) {
+	id := uuid.NewString()
 	testCases := []struct {
 		name           string
-		users          []domain.User
+		method         string
+		path           string
+		handler        func(s *LibaryService) http.HandlerFunc
+		restored       any
 		repositoryErr  error
 		expectedStatus int
 	}{
-		{"success", []domain.User{
-			{ID: userID1, Name: "Max Mustermann", Email: "max@mustermann.de"},
-			{ID: userID2, Name: "Erika Mustermann", Email: "erika@mustermann.de"},
-		}, nil, http.StatusOK},
-		{"repository error", nil, errors.New("database error"), http.StatusInternalServerError},
+		{"book", "RestoreBook", "/books/" + id + "/restore", func(s *LibaryService) http.HandlerFunc { return s.RestoreBook },
+			domain.Book{ID: id, Title: "The Hobbit", Author: "J.R.R. Tolkien"}, nil, http.StatusOK},
+		{"user", "RestoreUser", "/users/" + id + "/restore", func(s *LibaryService) http.HandlerFunc { return s.RestoreUser },
+			domain.User{ID: id, Name: "Frodo", Email: "frodo@shire.me", Category: domain.UserCategoryStudent}, nil, http.StatusOK},
+		{"lending", "RestoreLending", "/lendings/" + id + "/restore", func(s *LibaryService) http.HandlerFunc { return s.RestoreLending },
+			domain.Lending{ID: id, BookID: uuid.NewString(), CopyID: uuid.NewString(), UserID: uuid.NewString()}, nil, http.StatusOK},
+		{"invalid path", "RestoreBook", "/books/" + id, func(s *LibaryService) http.HandlerFunc { return s.RestoreBook },
+			domain.Book{}, nil, http.StatusBadRequest},
+		{"not deleted", "RestoreUser", "/users/" + id + "/restore", func(s *LibaryService) http.HandlerFunc { return s.RestoreUser },
+			domain.User{}, repository.ErrUserNotFound, http.StatusNotFound},
+		{"copy lent out again", "RestoreLending", "/lendings/" + id + "/restore", func(s *LibaryService) http.HandlerFunc { return s.RestoreLending },
+			domain.Lending{}, repository.ErrCopyUnavailable, http.StatusConflict},
+		{"email taken", "RestoreUser", "/users/" + id + "/restore", func(s *LibaryService) http.HandlerFunc { return s.RestoreUser },
+			domain.User{}, repository.ErrEmailExists, http.StatusConflict},
+		{"isbn taken", "RestoreBook", "/books/" + id + "/restore", func(s *LibaryService) http.HandlerFunc { return s.RestoreBook },
+			domain.Book{}, repository.ErrISBNExists, http.StatusConflict},
+		{"repository error", "RestoreBook", "/books/" + id + "/restore", func(s *LibaryService) http.HandlerFunc { return s.RestoreBook },
+			domain.Book{}, errors.New("database error"), http.StatusInternalServerError},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			mockRepo.On(tc.method, mock.Anything, id).Return(tc.restored, tc.repositoryErr).Maybe()
+			service := NewLibaryService(mockRepo, new(mocks.Validation), config.Default())
+			req, _ := http.NewRequest("POST", tc.path, nil)
+			rr := httptest.NewRecorder()
+			tc.handler(service)(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedStatus == http.StatusOK {
+				expected, err := json.Marshal(tc.restored)
+				assert.NoError(t, err)
+				assert.JSONEq(t, string(expected), rr.Body.String())
+				assert.NotContains(t, rr.Body.String(), "deleted_at", "restored rows are not deleted")
+			}
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestIfMatch(t *testing.T) {
+	bookID := uuid.NewString()
+	stored := domain.Book{ID: bookID, Title: "The Hobbit", Author: "J.R.R. Tolkien", Version: 3}
+	testCases := []struct {
+		name           string
+		method         string
+		ifMatch        string
+		updateErr      error
+		expectedStatus int
+	}{
+		{"update", "PUT", `"3"`, nil, http.StatusOK},
+		{"update any version", "PUT", "*", nil, http.StatusOK},
+		{"update listed version", "PUT", `"2", "3"`, nil, http.StatusOK},
+		{"update without If-Match", "PUT", "", nil, http.StatusPreconditionRequired},
+		{"update stale version", "PUT", `"2"`, nil, http.StatusPreconditionFailed},
+		{"update weak tag", "PUT", `W/"3"`, nil, http.StatusPreconditionFailed},
+		{"update lost race", "PUT", `"3"`, repository.ErrVersionMismatch, http.StatusPreconditionFailed},
+		{"delete", "DELETE", `"3"`, nil, http.StatusNoContent},
+		{"delete without If-Match", "DELETE", "", nil, http.StatusPreconditionRequired},
+		{"delete stale version", "DELETE", `"2"`, nil, http.StatusPreconditionFailed},
+		{"delete lost race", "DELETE", `"3"`, repository.ErrVersionMismatch, http.StatusPreconditionFailed},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			mockRepo := new(mocks.Repository)
-			mockRepo.On("GetUsers").Return(tc.users, tc.repositoryErr)
 			mockValidation := new(mocks.Validation)
-			service := NewLibaryService(mockRepo, mockValidation)
-			req, _ := http.NewRequest("GET", "/users", nil)
+			mockRepo.On("GetBookByID", mock.Anything, bookID).Return(stored, nil).Maybe()
+			mockValidation.On("CheckBookUpdate", mock.Anything, mock.AnythingOfType("domain.Book"), mock.AnythingOfType("domain.Book")).Return(nil).Maybe()
+			mockRepo.On("UpdateBook", mock.Anything, mock.AnythingOfType("domain.Book")).Return(func(_ context.Context, b domain.Book) domain.Book {
+				b.Version++
+				return b
+			}, tc.updateErr).Maybe()
+			mockRepo.On("DeleteBook", mock.Anything, bookID, 3, mock.Anything, false).Return(tc.updateErr).Maybe()
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
+			body, err := json.Marshal(domain.Book{Title: "The Hobbit", Author: "J.R.R. Tolkien"})
+			assert.NoError(t, err)
+			req, _ := http.NewRequest(tc.method, "/books/"+bookID, bytes.NewBuffer(body))
+			req.Header.Set("If-Match", tc.ifMatch)
 			rr := httptest.NewRecorder()
-			service.GetUsers(rr, req)
+			if tc.method == "PUT" {
+				service.UpdateBook(rr, req)
+			} else {
+				service.DeleteBook(rr, req)
+			}
 			assert.Equal(t, tc.expectedStatus, rr.Code)
 			if tc.expectedStatus == http.StatusOK {
-				var responseUsers []domain.User
-				err := json.Unmarshal(rr.Body.Bytes(), &responseUsers)
+				assert.Equal(t, `"4"`, rr.Header().Get("ETag"))
+			}
+			if tc.updateErr == nil && tc.expectedStatus >= http.StatusBadRequest {
+				mockRepo.AssertNotCalled(t, "UpdateBook", mock.Anything, mock.Anything)
+				mockRepo.AssertNotCalled(t, "DeleteBook", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			}
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestExtractNestedID(t *testing.T) {
+	id := uuid.NewString()
+	testCases := []struct {
+		name          string
+		path          string
+		expectedID    string
+		expectedError bool
+	}{
+		{"valid path", "/books/" + id + "/copies", id, false},
+		{"valid path with trailing slash", "/books/" + id + "/copies/", id, false},
+		{"missing id", "/books//copies", "", true},
+		{"wrong suffix", "/books/" + id + "/holds", "", true},
+		{"invalid path", "/invalid/" + id + "/copies", "", true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", tc.path, nil)
+			got, err := extractNestedID(req, "/books/", "/copies")
+			if tc.expectedError {
+				assert.Error(t, err)
+				assert.Empty(t, got)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedID, got)
+			}
+		})
+	}
+}
+
+func TestGetBookCopies(t *testing.T) {
+	bookID := uuid.NewString()
+	copies := []domain.BookCopy{
+		{ID: uuid.NewString(), BookID: bookID, Barcode: "LIB-0001", Condition: domain.CopyConditionNew, Status: domain.CopyStatusAvailable},
+		{ID: uuid.NewString(), BookID: bookID, Barcode: "LIB-0002", Condition: domain.CopyConditionGood, Status: domain.CopyStatusOnLoan},
+	}
+	testCases := []struct {
+		name           string
+		path           string
+		bookErr        error
+		copies         []domain.BookCopy
+		repositoryErr  error
+		expectedStatus int
+	}{
+		{"success", "/books/" + bookID + "/copies", nil, copies, nil, http.StatusOK},
+		{"invalid path", "/invalid/" + bookID + "/copies", nil, nil, nil, http.StatusBadRequest},
+		{"book not found", "/books/" + bookID + "/copies", repository.ErrBookNotFound, nil, nil, http.StatusNotFound},
+		{"repository error", "/books/" + bookID + "/copies", nil, nil, errors.New("database error"), http.StatusInternalServerError},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			mockRepo.On("GetBookByID", mock.Anything, bookID).Return(domain.Book{ID: bookID}, tc.bookErr).Maybe()
+			mockRepo.On("GetBookCopies", mock.Anything, bookID).Return(tc.copies, tc.repositoryErr).Maybe()
+			mockValidation := new(mocks.Validation)
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
+			req, _ := http.NewRequest("GET", tc.path, nil)
+			rr := httptest.NewRecorder()
+			service.GetBookCopies(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedStatus == http.StatusOK {
+				var responseCopies []domain.BookCopy
+				err := json.Unmarshal(rr.Body.Bytes(), &responseCopies)
 				assert.NoError(t, err)
-				assert.Equal(t, tc.users, responseUsers)
+				assert.Equal(t, tc.copies, responseCopies)
 			}
 			mockRepo.AssertExpectations(t)
 		})
 	}
 }
 
-func TestGetUserByID(t *testing.T) {
-	userID := uuid.NewString()
-	user := domain.User{ID: userID, Name: "Max Mustermann", Email: "max@mustermann.de"}
+func TestGetBookCopyByID(t *testing.T) {
+	copyID := uuid.NewString()
+	bookCopy := domain.BookCopy{ID: copyID, BookID: uuid.NewString(), Barcode: "LIB-0001", Condition: domain.CopyConditionGood, Status: domain.CopyStatusAvailable}
 	testCases := []struct {
 		name           string
 		path           string
-		user           domain.User
+		bookCopy       domain.BookCopy
 		repositoryErr  error
 		expectedStatus int
 	}{
-		{"success", "/users/" + userID, user, nil, http.StatusOK},
-		{"invalid id", "/invalid/" + userID, domain.User{}, nil, http.StatusBadRequest},
-		{"user not found", "/users/" + userID, domain.User{}, errors.New("user not found"), http.StatusNotFound},
+		{"success", "/copies/" + copyID, bookCopy, nil, http.StatusOK},
+		{"invalid id", "/invalid/" + copyID, domain.BookCopy{}, nil, http.StatusBadRequest},
+		{"copy not found", "/copies/" + copyID, domain.BookCopy{}, repository.ErrBookCopyNotFound, http.StatusNotFound},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			mockRepo := new(mocks.Repository)
-			mockRepo.On("GetUserByID", userID).Return(tc.user, tc.repositoryErr).Maybe()
+			mockRepo.On("GetBookCopyByID", mock.Anything, copyID).Return(tc.bookCopy, tc.repositoryErr).Maybe()
 			mockValidation := new(mocks.Validation)
-			service := NewLibaryService(mockRepo, mockValidation)
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
 			req, _ := http.NewRequest("GET", tc.path, nil)
 			rr := httptest.NewRecorder()
-			service.GetUserByID(rr, req)
+			service.GetBookCopyByID(rr, req)
 			assert.Equal(t, tc.expectedStatus, rr.Code)
 			if tc.expectedStatus == http.StatusOK {
-				var responseUser domain.User
-				err := json.Unmarshal(rr.Body.Bytes(), &responseUser)
+				var responseCopy domain.BookCopy
+				err := json.Unmarshal(rr.Body.Bytes(), &responseCopy)
 				assert.NoError(t, err)
-				assert.Equal(t, tc.user, responseUser)
+				assert.Equal(t, tc.bookCopy, responseCopy)
 			}
 			mockRepo.AssertExpectations(t)
 		})
 	}
 }
 
-func TestCreateUser(t *testing.T) {
-	validUser := domain.User{Name: "Max Mustermann", Email: "max@mustermann.de"}
-	newUserID := uuid.NewString()
+func TestCreateBookCopy(t *testing.T) {
+	bookID := uuid.NewString()
+	validCopy := domain.BookCopy{Barcode: "LIB-0001", Condition: domain.CopyConditionNew}
+	createdCopy := domain.BookCopy{ID: uuid.NewString(), BookID: bookID, Barcode: "LIB-0001", Condition: domain.CopyConditionNew, Status: domain.CopyStatusAvailable}
 	testCases := []struct {
 		name           string
+		path           string
 		requestBody    interface{}
 		validationErr  error
-		createdUser    domain.User
+		createdCopy    domain.BookCopy
 		repositoryErr  error
 		expectedStatus int
 	}{
-		{"success", validUser, nil, domain.User{ID: newUserID, Name: "Max Mustermann", Email: "max@mustermann.de"}, nil, http.StatusCreated},
-		{"invalid request body", "invalid json", nil, domain.User{}, nil, http.StatusBadRequest},
-		{"validation error", validUser, errors.New("validation error"), domain.User{}, nil, http.StatusBadRequest},
-		{"repository error", validUser, nil, domain.User{}, errors.New("database error"), http.StatusInternalServerError},
+		{"success", "/books/" + bookID + "/copies", validCopy, nil, createdCopy, nil, http.StatusCreated},
+		{"invalid path", "/invalid/" + bookID + "/copies", validCopy, nil, domain.BookCopy{}, nil, http.StatusBadRequest},
+		{"invalid request body", "/books/" + bookID + "/copies", "invalid json", nil, domain.BookCopy{}, nil, http.StatusBadRequest},
+		{"validation error", "/books/" + bookID + "/copies", validCopy, errInvalid, domain.BookCopy{}, nil, http.StatusUnprocessableEntity},
+		{"repository error", "/books/" + bookID + "/copies", validCopy, nil, domain.BookCopy{}, errors.New("database error"), http.StatusInternalServerError},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -339,20 +827,20 @@ func TestCreateUser(t *testing.T) {
 				requestBytes, err = json.Marshal(tc.requestBody)
 				assert.NoError(t, err)
 			}
-			mockValidation.On("CheckUser", mock.AnythingOfType("domain.User")).Return(tc.validationErr).Maybe()
-			mockRepo.On("CreateUser", mock.AnythingOfType("domain.User")).Return(tc.createdUser, tc.repositoryErr).Maybe()
-			service := NewLibaryService(mockRepo, mockValidation)
-			req, _ := http.NewRequest("POST", "/users", bytes.NewBuffer(requestBytes))
+			mockValidation.On("CheckBookCopy", mock.Anything, mock.MatchedBy(func(c domain.BookCopy) bool {
+				return c.BookID == bookID && c.Status == domain.CopyStatusAvailable
+			})).Return(tc.validationErr).Maybe()
+			mockRepo.On("CreateBookCopy", mock.Anything, mock.AnythingOfType("domain.BookCopy")).Return(tc.createdCopy, tc.repositoryErr).Maybe()
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
+			req, _ := http.NewRequest("POST", tc.path, bytes.NewBuffer(requestBytes))
 			rr := httptest.NewRecorder()
-			service.CreateUser(rr, req)
+			service.CreateBookCopy(rr, req)
 			assert.Equal(t, tc.expectedStatus, rr.Code)
 			if tc.expectedStatus == http.StatusCreated {
-				var responseUser domain.User
-				err := json.Unmarshal(rr.Body.Bytes(), &responseUser)
+				var responseCopy domain.BookCopy
+				err := json.Unmarshal(rr.Body.Bytes(), &responseCopy)
 				assert.NoError(t, err)
-				assert.NotEmpty(t, responseUser.ID)
-				assert.Equal(t, tc.createdUser.Name, responseUser.Name)
-				assert.Equal(t, tc.createdUser.Email, responseUser.Email)
+				assert.Equal(t, tc.createdCopy, responseCopy)
 			}
 			mockRepo.AssertExpectations(t)
 			mockValidation.AssertExpectations(t)
@@ -360,23 +848,40 @@ func TestCreateUser(t *testing.T) {
 	}
 }
 
-func TestUpdateUser(t *testing.T) {
-	validUser := domain.User{Name: "Erika Mustermann", Email: "erika@mustermann.de"}
-	userID := uuid.NewString()
+func TestUpdateBookCopy(t *testing.T) {
+	copyID := uuid.NewString()
+	bookID := uuid.NewString()
+	stored := domain.BookCopy{ID: copyID, BookID: bookID, Barcode: "LIB-0001", Condition: domain.CopyConditionGood, Status: domain.CopyStatusAvailable}
+	storedOnLoan := domain.BookCopy{ID: copyID, BookID: bookID, Barcode: "LIB-0001", Condition: domain.CopyConditionGood, Status: domain.CopyStatusOnLoan}
+	storedOnHold := domain.BookCopy{ID: copyID, BookID: bookID, Barcode: "LIB-0001", Condition: domain.CopyConditionGood, Status: domain.CopyStatusOnHold}
+	update := domain.BookCopy{Barcode: "LIB-0001", Condition: domain.CopyConditionDamaged, Status: domain.CopyStatusInRepair}
+	lend := domain.BookCopy{Condition: domain.CopyConditionGood, Status: domain.CopyStatusOnLoan}
+	shelve := domain.BookCopy{Barcode: "LIB-0001", Condition: domain.CopyConditionGood, Status: domain.CopyStatusAvailable}
+	invalid := errInvalid
 	testCases := []struct {
 		name           string
 		path           string
 		requestBody    interface{}
+		stored         domain.BookCopy
+		storedErr      error
 		validationErr  error
-		updatedUser    domain.User
+		expectedCopy   domain.BookCopy
 		repositoryErr  error
 		expectedStatus int
 	}{
-		{"success", "/users/" + userID, validUser, nil, domain.User{ID: userID, Name: "Erika Mustermann", Email: "erika@mustermann.de"}, nil, http.StatusOK},
-		{"invalid path", "/invalid/" + userID, validUser, nil, domain.User{}, nil, http.StatusBadRequest},
-		{"invalid request body", "/users/" + userID, "invalid json", nil, domain.User{}, nil, http.StatusBadRequest},
-		{"validation error", "/users/" + userID, validUser, errors.New("validation error"), domain.User{}, nil, http.StatusBadRequest},
-		{"repository error", "/users/" + userID, validUser, nil, domain.User{}, errors.New("database error"), http.StatusInternalServerError},
+		{"success", "/copies/" + copyID, update, stored, nil, nil,
+			domain.BookCopy{ID: copyID, BookID: bookID, Barcode: "LIB-0001", Condition: domain.CopyConditionDamaged, Status: domain.CopyStatusInRepair}, nil, http.StatusOK},
+		{"keeps on loan status", "/copies/" + copyID, update, storedOnLoan, nil, nil,
+			domain.BookCopy{ID: copyID, BookID: bookID, Barcode: "LIB-0001", Condition: domain.CopyConditionDamaged, Status: domain.CopyStatusOnLoan}, nil, http.StatusOK},
+		{"keeps on hold status", "/copies/" + copyID, shelve, storedOnHold, nil, nil,
+			domain.BookCopy{ID: copyID, BookID: bookID, Barcode: "LIB-0001", Condition: domain.CopyConditionGood, Status: domain.CopyStatusOnHold}, nil, http.StatusOK},
+		{"client sets on loan", "/copies/" + copyID, lend, stored, nil, invalid, domain.BookCopy{}, nil, http.StatusUnprocessableEntity},
+		{"invalid path", "/invalid/" + copyID, update, stored, nil, nil, domain.BookCopy{}, nil, http.StatusBadRequest},
+		{"invalid request body", "/copies/" + copyID, "invalid json", stored, nil, nil, domain.BookCopy{}, nil, http.StatusBadRequest},
+		{"copy not found", "/copies/" + copyID, update, domain.BookCopy{}, repository.ErrBookCopyNotFound, nil, domain.BookCopy{}, nil, http.StatusNotFound},
+		{"validation error", "/copies/" + copyID, update, stored, nil, invalid, domain.BookCopy{}, nil, http.StatusUnprocessableEntity},
+		{"repository error", "/copies/" + copyID, update, stored, nil, nil, domain.BookCopy{}, errors.New("database error"), http.StatusInternalServerError},
+		{"barcode taken", "/copies/" + copyID, update, stored, nil, nil, domain.BookCopy{}, repository.ErrBarcodeExists, http.StatusConflict},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
@@ -390,18 +895,27 @@ func TestUpdateUser(t *testing.T) {
 				requestBytes, err = json.Marshal(tc.requestBody)
 				assert.NoError(t, err)
 			}
-			mockValidation.On("CheckUser", mock.AnythingOfType("domain.User")).Return(tc.validationErr).Maybe()
-			mockRepo.On("UpdateUser", mock.AnythingOfType("domain.User")).Return(tc.updatedUser, tc.repositoryErr).Maybe()
-			service := NewLibaryService(mockRepo, mockValidation)
+			mockRepo.On("GetBookCopyByID", mock.Anything, copyID).Return(tc.stored, tc.storedErr).Maybe()
+			mockValidation.On("CheckBookCopyUpdate", mock.Anything, tc.stored, mock.AnythingOfType("domain.BookCopy")).Return(tc.validationErr).Maybe()
+			mockRepo.On("UpdateBookCopy", mock.Anything, mock.AnythingOfType("domain.BookCopy")).Return(func(_ context.Context, c domain.BookCopy) domain.BookCopy {
+				return c
+			}, tc.repositoryErr).Maybe()
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
 			req, _ := http.NewRequest("PUT", tc.path, bytes.NewBuffer(requestBytes))
 			rr := httptest.NewRecorder()
-			service.UpdateUser(rr, req)
+			service.UpdateBookCopy(rr, req)
 			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.storedErr == nil && tc.expectedStatus != http.StatusBadRequest {
+				mockValidation.AssertCalled(t, "CheckBookCopyUpdate", mock.Anything, tc.stored, mock.AnythingOfType("domain.BookCopy"))
+			}
+			if tc.validationErr != nil {
+				mockRepo.AssertNotCalled(t, "UpdateBookCopy", mock.Anything, mock.Anything)
+			}
 			if tc.expectedStatus == http.StatusOK {
-				var responseUser domain.User
-				err := json.Unmarshal(rr.Body.Bytes(), &responseUser)
+				var responseCopy domain.BookCopy
+				err := json.Unmarshal(rr.Body.Bytes(), &responseCopy)
 				assert.NoError(t, err)
-				assert.Equal(t, tc.updatedUser, responseUser)
+				assert.Equal(t, tc.expectedCopy, responseCopy)
 			}
 			mockRepo.AssertExpectations(t)
 			mockValidation.AssertExpectations(t)
@@ -409,79 +923,648 @@ func TestUpdateUser(t *testing.T) {
 	}
 }
 
-func TestDeleteUser(t *testing.T) {
-	userID := uuid.NewString()
+func TestDeleteBookCopy(t *testing.T) {
+	copyID := uuid.NewString()
 	testCases := []struct {
 		name           string
 		path           string
 		repositoryErr  error
 		expectedStatus int
 	}{
-		{"success", "/users/" + userID, nil, http.StatusNoContent},
-		{"invalid path", "/invalid/" + userID, nil, http.StatusBadRequest},
-		{"repository error", "/users/" + userID, errors.New("database error"), http.StatusInternalServerError},
+		{"success", "/copies/" + copyID, nil, http.StatusNoContent},
+		{"invalid path", "/invalid/" + copyID, nil, http.StatusBadRequest},
+		{"repository error", "/copies/" + copyID, errors.New("database error"), http.StatusInternalServerError},
+		{"copy not found", "/copies/" + copyID, repository.ErrBookCopyNotFound, http.StatusNotFound},
+		{"copy on loan", "/copies/" + copyID, &repository.ActiveLendingsError{Entity: "copy", LendingIDs: []string{uuid.NewString()}}, http.StatusConflict},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			mockRepo := new(mocks.Repository)
 			mockValidation := new(mocks.Validation)
-			mockRepo.On("DeleteUser", userID).Return(tc.repositoryErr).Maybe()
-			service := NewLibaryService(mockRepo, mockValidation)
+			mockRepo.On("DeleteBookCopy", mock.Anything, copyID).Return(tc.repositoryErr).Maybe()
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
 			req, _ := http.NewRequest("DELETE", tc.path, nil)
 			rr := httptest.NewRecorder()
-			service.DeleteUser(rr, req)
+			service.DeleteBookCopy(rr, req)
 			assert.Equal(t, tc.expectedStatus, rr.Code)
 			mockRepo.AssertExpectations(t)
 		})
 	}
 }
 
-func TestGetLendings(t *testing.T) {
-	lendDate1 := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
-	returnDate1 := lendDate1.Add(7 * 24 * time.Hour)
-	lendDate2 := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
-	returnDate2 := lendDate2.Add(14 * 24 * time.Hour)
-	id1 := uuid.NewString()
-	id2 := uuid.NewString()
-	bookID1 := uuid.NewString()
-	bookID2 := uuid.NewString()
-	userID1 := uuid.NewString()
-	userID2 := uuid.NewString()
+func TestGetBookHolds(t *testing.T) {
+	now := time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+	bookID := uuid.NewString()
+	holds := []domain.Hold{{ID: uuid.NewString(), BookID: bookID, UserID: uuid.NewString(), Status: domain.HoldStatusWaiting, CreatedAt: now}}
 	testCases := []struct {
 		name           string
-		lendings       []domain.Lending
+		path           string
+		expireErr      error
 		repositoryErr  error
 		expectedStatus int
 	}{
-		{"success", []domain.Lending{
-			{ID: id1, BookID: bookID1, UserID: userID1, LendDate: lendDate1, ReturnDate: returnDate1},
-			{ID: id2, BookID: bookID2, UserID: userID2, LendDate: lendDate2, ReturnDate: returnDate2},
-		}, nil, http.StatusOK},
-		{"repository error", nil, errors.New("database error"), http.StatusInternalServerError},
+		{"success", "/books/" + bookID + "/holds", nil, nil, http.StatusOK},
+		{"invalid path", "/invalid/" + bookID + "/holds", nil, nil, http.StatusBadRequest},
+		{"expire error", "/books/" + bookID + "/holds", errors.New("database error"), nil, http.StatusInternalServerError},
+		{"repository error", "/books/" + bookID + "/holds", nil, errors.New("database error"), http.StatusInternalServerError},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			mockRepo := new(mocks.Repository)
-			mockRepo.On("GetLendings").Return(tc.lendings, tc.repositoryErr)
+			runTransactions(mockRepo)
+			mockRepo.On("ExpireHolds", mock.Anything, now).Return([]domain.Hold{}, tc.expireErr).Maybe()
+			mockRepo.On("GetHolds", mock.Anything, bookID).Return(holds, tc.repositoryErr).Maybe()
 			mockValidation := new(mocks.Validation)
-			service := NewLibaryService(mockRepo, mockValidation)
-			req, _ := http.NewRequest("GET", "/lendings", nil)
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
+			service.now = func() time.Time { return now }
+			req, _ := http.NewRequest("GET", tc.path, nil)
 			rr := httptest.NewRecorder()
-			service.GetLendings(rr, req)
+			service.GetBookHolds(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedStatus == http.StatusOK {
+				var responseHolds []domain.Hold
+				err := json.Unmarshal(rr.Body.Bytes(), &responseHolds)
+				assert.NoError(t, err)
+				assert.Equal(t, holds, responseHolds)
+			}
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCreateHold(t *testing.T) {
+	now := time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+	bookID := uuid.NewString()
+	userID := uuid.NewString()
+	lentOut := []domain.BookCopy{{ID: uuid.NewString(), BookID: bookID, Barcode: "LIB-0001", Status: domain.CopyStatusOnLoan}}
+	onShelf := []domain.BookCopy{{ID: uuid.NewString(), BookID: bookID, Barcode: "LIB-0002", Status: domain.CopyStatusAvailable}}
+	testCases := []struct {
+		name           string
+		path           string
+		body           string
+		validationErr  error
+		copies         []domain.BookCopy
+		repositoryErr  error
+		expectedStatus int
+	}{
+		{"success", "/books/" + bookID + "/holds", `{"user_id":"` + userID + `"}`, nil, lentOut, nil, http.StatusCreated},
+		{"invalid path", "/invalid/" + bookID + "/holds", `{"user_id":"` + userID + `"}`, nil, lentOut, nil, http.StatusBadRequest},
+		{"invalid payload", "/books/" + bookID + "/holds", `{"user_id":`, nil, lentOut, nil, http.StatusBadRequest},
+		{"validation error", "/books/" + bookID + "/holds", `{"user_id":"` + userID + `"}`, validation.Errors{{Field: "user_id", Message: "user not found"}}, lentOut, nil, http.StatusUnprocessableEntity},
+		{"copy on the shelf", "/books/" + bookID + "/holds", `{"user_id":"` + userID + `"}`, nil, onShelf, nil, http.StatusConflict},
+		{"already holding", "/books/" + bookID + "/holds", `{"user_id":"` + userID + `"}`, nil, lentOut, repository.ErrHoldExists, http.StatusConflict},
+		{"repository error", "/books/" + bookID + "/holds", `{"user_id":"` + userID + `"}`, nil, lentOut, errors.New("database error"), http.StatusInternalServerError},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			runTransactions(mockRepo)
+			mockValidation := new(mocks.Validation)
+			mockValidation.On("CheckHold", mock.Anything, mock.AnythingOfType("domain.Hold")).Return(tc.validationErr).Maybe()
+			mockRepo.On("ExpireHolds", mock.Anything, now).Return([]domain.Hold{}, nil).Maybe()
+			mockRepo.On("GetBookCopies", mock.Anything, bookID).Return(tc.copies, nil).Maybe()
+			mockRepo.On("CreateHold", mock.Anything, mock.MatchedBy(func(h domain.Hold) bool {
+				return h.BookID == bookID && h.UserID == userID && h.Status == domain.HoldStatusWaiting && h.CreatedAt.Equal(now)
+			})).Return(func(_ context.Context, h domain.Hold) domain.Hold { return h }, tc.repositoryErr).Maybe()
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
+			service.now = func() time.Time { return now }
+			req, _ := http.NewRequest("POST", tc.path, bytes.NewBufferString(tc.body))
+			rr := httptest.NewRecorder()
+			service.CreateHold(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedStatus == http.StatusCreated {
+				var responseHold domain.Hold
+				err := json.Unmarshal(rr.Body.Bytes(), &responseHold)
+				assert.NoError(t, err)
+				assert.NotEmpty(t, responseHold.ID)
+				assert.Equal(t, domain.HoldStatusWaiting, responseHold.Status)
+			}
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetHoldByID(t *testing.T) {
+	holdID := uuid.NewString()
+	hold := domain.Hold{ID: holdID, BookID: uuid.NewString(), UserID: uuid.NewString(), Status: domain.HoldStatusWaiting}
+	testCases := []struct {
+		name           string
+		path           string
+		repositoryErr  error
+		expectedStatus int
+	}{
+		{"success", "/holds/" + holdID, nil, http.StatusOK},
+		{"invalid path", "/invalid/" + holdID, nil, http.StatusBadRequest},
+		{"hold not found", "/holds/" + holdID, repository.ErrHoldNotFound, http.StatusNotFound},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			mockRepo.On("GetHoldByID", mock.Anything, holdID).Return(hold, tc.repositoryErr).Maybe()
+			mockValidation := new(mocks.Validation)
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
+			req, _ := http.NewRequest("GET", tc.path, nil)
+			rr := httptest.NewRecorder()
+			service.GetHoldByID(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCancelHold(t *testing.T) {
+	now := time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+	holdID := uuid.NewString()
+	copyID := uuid.NewString()
+	waiting := domain.Hold{ID: holdID, BookID: uuid.NewString(), UserID: uuid.NewString(), Status: domain.HoldStatusWaiting}
+	ready := waiting
+	ready.Status = domain.HoldStatusReady
+	ready.CopyID = copyID
+	fulfilled := waiting
+	fulfilled.Status = domain.HoldStatusFulfilled
+	testCases := []struct {
+		name           string
+		path           string
+		stored         domain.Hold
+		storedErr      error
+		repositoryErr  error
+		expectReady    bool
+		expectedStatus int
+	}{
+		{"waiting hold", "/holds/" + holdID, waiting, nil, nil, false, http.StatusNoContent},
+		{"ready hold passes its copy on", "/holds/" + holdID, ready, nil, nil, true, http.StatusNoContent},
+		{"invalid path", "/invalid/" + holdID, waiting, nil, nil, false, http.StatusBadRequest},
+		{"hold not found", "/holds/" + holdID, domain.Hold{}, repository.ErrHoldNotFound, nil, false, http.StatusNotFound},
+		{"already fulfilled", "/holds/" + holdID, fulfilled, nil, nil, false, http.StatusConflict},
+		{"repository error", "/holds/" + holdID, waiting, nil, errors.New("database error"), false, http.StatusInternalServerError},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			runTransactions(mockRepo)
+			mockValidation := new(mocks.Validation)
+			mockRepo.On("GetHoldByID", mock.Anything, holdID).Return(tc.stored, tc.storedErr).Maybe()
+			mockRepo.On("UpdateHold", mock.Anything, mock.MatchedBy(func(h domain.Hold) bool {
+				return h.ID == holdID && h.Status == domain.HoldStatusCancelled
+			})).Return(func(_ context.Context, h domain.Hold) domain.Hold { return h }, tc.repositoryErr).Maybe()
+			if tc.expectReady {
+				mockRepo.On("ReadyNextHold", mock.Anything, copyID, now, now.Add(7*24*time.Hour)).Return(domain.Hold{}, nil)
+			}
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
+			service.now = func() time.Time { return now }
+			req, _ := http.NewRequest("DELETE", tc.path, nil)
+			rr := httptest.NewRecorder()
+			service.CancelHold(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetUsers(t *testing.T) {
+	userID1 := uuid.NewString()
+	userID2 := uuid.NewString()
+	users := []domain.User{
+		{ID: userID1, Name: "Max Mustermann", Email: "max@mustermann.de"},
+		{ID: userID2, Name: "Erika Mustermann", Email: "erika@mustermann.de"},
+	}
+	testCases := []struct {
+		name           string
+		query          string
+		filter         repository.UserFilter
+		options        repository.ListOptions
+		page           repository.Page[domain.User]
+		repositoryErr  error
+		expectedStatus int
+	}{
+		{"success", "", repository.UserFilter{}, repository.ListOptions{Limit: 50},
+			repository.Page[domain.User]{Items: users, Total: 2}, nil, http.StatusOK},
+		{"category filter", "?category=staff&sort=email", repository.UserFilter{Category: domain.UserCategoryStaff}, repository.ListOptions{Limit: 50, Sort: "email"},
+			repository.Page[domain.User]{Items: users[:1], Total: 1}, nil, http.StatusOK},
+		{"invalid limit", "?limit=ten", repository.UserFilter{}, repository.ListOptions{}, repository.Page[domain.User]{}, nil, http.StatusBadRequest},
+		{"repository error", "", repository.UserFilter{}, repository.ListOptions{Limit: 50},
+			repository.Page[domain.User]{}, errors.New("database error"), http.StatusInternalServerError},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			mockRepo.On("GetUsers", mock.Anything, tc.filter, tc.options).Return(tc.page, tc.repositoryErr).Maybe()
+			mockValidation := new(mocks.Validation)
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
+			req, _ := http.NewRequest("GET", "/users"+tc.query, nil)
+			rr := httptest.NewRecorder()
+			service.GetUsers(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedStatus == http.StatusOK {
+				var responseUsers []domain.User
+				err := json.Unmarshal(rr.Body.Bytes(), &responseUsers)
+				assert.NoError(t, err)
+				assert.Equal(t, tc.page.Items, responseUsers)
+				assert.Equal(t, strconv.Itoa(tc.page.Total), rr.Header().Get("X-Total-Count"))
+			}
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetUserByID(t *testing.T) {
+	userID := uuid.NewString()
+	user := domain.User{ID: userID, Name: "Max Mustermann", Email: "max@mustermann.de", Version: 2}
+	testCases := []struct {
+		name           string
+		path           string
+		user           domain.User
+		repositoryErr  error
+		expectedStatus int
+	}{
+		{"success", "/users/" + userID, user, nil, http.StatusOK},
+		{"invalid id", "/invalid/" + userID, domain.User{}, nil, http.StatusBadRequest},
+		{"user not found", "/users/" + userID, domain.User{}, repository.ErrUserNotFound, http.StatusNotFound},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			mockRepo.On("GetUserByID", mock.Anything, userID).Return(tc.user, tc.repositoryErr).Maybe()
+			mockValidation := new(mocks.Validation)
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
+			req, _ := http.NewRequest("GET", tc.path, nil)
+			rr := httptest.NewRecorder()
+			service.GetUserByID(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedStatus == http.StatusOK {
+				var responseUser domain.User
+				err := json.Unmarshal(rr.Body.Bytes(), &responseUser)
+				assert.NoError(t, err)
+				assert.Equal(t, tc.user, responseUser)
+				assert.Equal(t, `"2"`, rr.Header().Get("ETag"))
+			}
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCreateUser(t *testing.T) {
+	validUser := domain.User{Name: "Max Mustermann", Email: " Max@Mustermann.DE "}
+	newUserID := uuid.NewString()
+	testCases := []struct {
+		name           string
+		requestBody    interface{}
+		validationErr  error
+		createdUser    domain.User
+		repositoryErr  error
+		expectedStatus int
+	}{
+		{"success", validUser, nil, domain.User{ID: newUserID, Name: "Max Mustermann", Email: "max@mustermann.de"}, nil, http.StatusCreated},
+		{"invalid request body", "invalid json", nil, domain.User{}, nil, http.StatusBadRequest},
+		{"validation error", validUser, errInvalid, domain.User{}, nil, http.StatusUnprocessableEntity},
+		{"email taken", validUser, validation.Conflict{Field: "email", Message: "email is already taken"}, domain.User{}, nil, http.StatusConflict},
+		{"repository error", validUser, nil, domain.User{}, errors.New("database error"), http.StatusInternalServerError},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			mockValidation := new(mocks.Validation)
+			var requestBytes []byte
+			var err error
+			if str, ok := tc.requestBody.(string); ok {
+				requestBytes = []byte(str)
+			} else {
+				requestBytes, err = json.Marshal(tc.requestBody)
+				assert.NoError(t, err)
+			}
+			mockValidation.On("CheckUser", mock.Anything, mock.AnythingOfType("domain.User")).Return(tc.validationErr).Maybe()
+			mockRepo.On("CreateUser", mock.Anything, mock.MatchedBy(func(u domain.User) bool {
+				return u.Category == domain.UserCategoryStudent && u.Email == "max@mustermann.de"
+			})).Return(tc.createdUser, tc.repositoryErr).Maybe()
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
+			req, _ := http.NewRequest("POST", "/users", bytes.NewBuffer(requestBytes))
+			rr := httptest.NewRecorder()
+			service.CreateUser(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedStatus == http.StatusCreated {
+				var responseUser domain.User
+				err := json.Unmarshal(rr.Body.Bytes(), &responseUser)
+				assert.NoError(t, err)
+				assert.NotEmpty(t, responseUser.ID)
+				assert.Equal(t, tc.createdUser.Name, responseUser.Name)
+				assert.Equal(t, tc.createdUser.Email, responseUser.Email)
+			}
+			mockRepo.AssertExpectations(t)
+			mockValidation.AssertExpectations(t)
+		})
+	}
+}
+
+func TestUpdateUser(t *testing.T) {
+	validUser := domain.User{Name: "Erika Mustermann", Email: "erika@mustermann.de"}
+	userID := uuid.NewString()
+	testCases := []struct {
+		name           string
+		path           string
+		requestBody    interface{}
+		validationErr  error
+		updatedUser    domain.User
+		repositoryErr  error
+		expectedStatus int
+	}{
+		{"success", "/users/" + userID, validUser, nil, domain.User{ID: userID, Name: "Erika Mustermann", Email: "erika@mustermann.de"}, nil, http.StatusOK},
+		{"invalid path", "/invalid/" + userID, validUser, nil, domain.User{}, nil, http.StatusBadRequest},
+		{"invalid request body", "/users/" + userID, "invalid json", nil, domain.User{}, nil, http.StatusBadRequest},
+		{"validation error", "/users/" + userID, validUser, errInvalid, domain.User{}, nil, http.StatusUnprocessableEntity},
+		{"repository error", "/users/" + userID, validUser, nil, domain.User{}, errors.New("database error"), http.StatusInternalServerError},
+		{"user not found", "/users/" + userID, validUser, nil, domain.User{}, repository.ErrUserNotFound, http.StatusNotFound},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			mockValidation := new(mocks.Validation)
+			var requestBytes []byte
+			var err error
+			if str, ok := tc.requestBody.(string); ok {
+				requestBytes = []byte(str)
+			} else {
+				requestBytes, err = json.Marshal(tc.requestBody)
+				assert.NoError(t, err)
+			}
+			mockValidation.On("CheckUserUpdate", mock.Anything, mock.AnythingOfType("domain.User"), mock.AnythingOfType("domain.User")).Return(tc.validationErr).Maybe()
+			mockRepo.On("GetUserByID", mock.Anything, userID).Return(domain.User{ID: userID, Version: 1}, nil).Maybe()
+			mockRepo.On("UpdateUser", mock.Anything, mock.AnythingOfType("domain.User")).Return(tc.updatedUser, tc.repositoryErr).Maybe()
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
+			req, _ := http.NewRequest("PUT", tc.path, bytes.NewBuffer(requestBytes))
+			req.Header.Set("If-Match", `"1"`)
+			rr := httptest.NewRecorder()
+			service.UpdateUser(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedStatus == http.StatusOK {
+				var responseUser domain.User
+				err := json.Unmarshal(rr.Body.Bytes(), &responseUser)
+				assert.NoError(t, err)
+				assert.Equal(t, tc.updatedUser, responseUser)
+			}
+			mockRepo.AssertExpectations(t)
+			mockValidation.AssertExpectations(t)
+		})
+	}
+}
+
+func TestPatchUser(t *testing.T) {
+	userID := uuid.NewString()
+	stored := domain.User{ID: userID, Name: "Erika Mustermann", Email: "erika@mustermann.de", Category: domain.UserCategoryStaff, Version: 1}
+	testCases := []struct {
+		name           string
+		patch          string
+		expectedUser   domain.User
+		expectedStatus int
+	}{
+		{"email only", `{"email":"erika@example.com"}`,
+			domain.User{ID: userID, Name: "Erika Mustermann", Email: "erika@example.com", Category: domain.UserCategoryStaff, Version: 1}, http.StatusOK},
+		{"category reset", `{"category":null}`,
+			domain.User{ID: userID, Name: "Erika Mustermann", Email: "erika@mustermann.de", Category: domain.UserCategoryStudent, Version: 1}, http.StatusOK},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			mockValidation := new(mocks.Validation)
+			mockRepo.On("GetUserByID", mock.Anything, userID).Return(stored, nil)
+			mockValidation.On("CheckUserUpdate", mock.Anything, stored, mock.AnythingOfType("domain.User")).Return(nil)
+			mockRepo.On("UpdateUser", mock.Anything, tc.expectedUser).Return(tc.expectedUser, nil)
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
+			req, _ := http.NewRequest("PATCH", "/users/"+userID, strings.NewReader(tc.patch))
+			req.Header.Set("Content-Type", "application/merge-patch+json")
+			req.Header.Set("If-Match", `"1"`)
+			rr := httptest.NewRecorder()
+			service.PatchUser(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			mockRepo.AssertExpectations(t)
+			mockValidation.AssertExpectations(t)
+		})
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	userID := uuid.NewString()
+	blocked := &repository.ActiveLendingsError{Entity: "user", LendingIDs: []string{uuid.NewString(), uuid.NewString()}}
+	testCases := []struct {
+		name           string
+		path           string
+		authorization  string
+		force          bool
+		repositoryErr  error
+		expectedStatus int
+	}{
+		{"success", "/users/" + userID, "", false, nil, http.StatusNoContent},
+		{"invalid path", "/invalid/" + userID, "", false, nil, http.StatusBadRequest},
+		{"repository error", "/users/" + userID, "", false, errors.New("database error"), http.StatusInternalServerError},
+		{"user not found", "/users/" + userID, "", false, repository.ErrUserNotFound, http.StatusNotFound},
+		{"active lendings", "/users/" + userID, "", false, blocked, http.StatusConflict},
+		{"lost race", "/users/" + userID, "", false, repository.ErrVersionMismatch, http.StatusPreconditionFailed},
+		{"forced by admin", "/users/" + userID + "?force=true", "Bearer secret", true, nil, http.StatusNoContent},
+		{"forced without admin", "/users/" + userID + "?force=true", "Bearer guess", true, nil, http.StatusForbidden},
+		{"invalid force", "/users/" + userID + "?force=always", "Bearer secret", false, nil, http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			mockValidation := new(mocks.Validation)
+			mockRepo.On("GetUserByID", mock.Anything, userID).Return(domain.User{ID: userID, Version: 1}, nil).Maybe()
+			mockRepo.On("DeleteUser", mock.Anything, userID, 1, mock.Anything, tc.force).Return(tc.repositoryErr).Maybe()
+			cfg := config.Default()
+			cfg.AdminToken = "secret"
+			service := NewLibaryService(mockRepo, mockValidation, cfg)
+			req, _ := http.NewRequest("DELETE", tc.path, nil)
+			req.Header.Set("If-Match", `"1"`)
+			req.Header.Set("Authorization", tc.authorization)
+			rr := httptest.NewRecorder()
+			service.DeleteUser(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedStatus == http.StatusConflict {
+				var problem Problem
+				assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &problem))
+				assert.Equal(t, "User has active lendings", problem.Detail)
+				assert.Equal(t, blocked.LendingIDs, problem.LendingIDs)
+			}
+			if tc.expectedStatus == http.StatusForbidden {
+				mockRepo.AssertNotCalled(t, "DeleteUser", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			}
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetUserFines(t *testing.T) {
+	userID := uuid.NewString()
+	fines := []domain.Fine{{ID: uuid.NewString(), UserID: userID, Reason: domain.FineReasonOverdue, Amount: 150, Status: domain.FineStatusUnpaid}}
+	testCases := []struct {
+		name           string
+		path           string
+		userErr        error
+		repositoryErr  error
+		expectedStatus int
+	}{
+		{"success", "/users/" + userID + "/fines", nil, nil, http.StatusOK},
+		{"invalid path", "/invalid/" + userID + "/fines", nil, nil, http.StatusBadRequest},
+		{"user not found", "/users/" + userID + "/fines", repository.ErrUserNotFound, nil, http.StatusNotFound},
+		{"repository error", "/users/" + userID + "/fines", nil, errors.New("database error"), http.StatusInternalServerError},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			mockRepo.On("GetUserByID", mock.Anything, userID).Return(domain.User{ID: userID}, tc.userErr).Maybe()
+			mockRepo.On("GetFinesByUserID", mock.Anything, userID).Return(fines, tc.repositoryErr).Maybe()
+			mockValidation := new(mocks.Validation)
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
+			req, _ := http.NewRequest("GET", tc.path, nil)
+			rr := httptest.NewRecorder()
+			service.GetUserFines(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedStatus == http.StatusOK {
+				var responseFines []domain.Fine
+				err := json.Unmarshal(rr.Body.Bytes(), &responseFines)
+				assert.NoError(t, err)
+				assert.Equal(t, fines, responseFines)
+			}
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetLendings(t *testing.T) {
+	lendDate1 := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+	returnDate1 := lendDate1.Add(7 * 24 * time.Hour)
+	lendDate2 := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+	returnDate2 := lendDate2.Add(14 * 24 * time.Hour)
+	id1 := uuid.NewString()
+	id2 := uuid.NewString()
+	bookID1 := uuid.NewString()
+	bookID2 := uuid.NewString()
+	userID1 := uuid.NewString()
+	userID2 := uuid.NewString()
+	lendings := []domain.Lending{
+		{ID: id1, BookID: bookID1, UserID: userID1, LendDate: lendDate1, ReturnDate: returnDate1},
+		{ID: id2, BookID: bookID2, UserID: userID2, LendDate: lendDate2, ReturnDate: returnDate2},
+	}
+	active := true
+	testCases := []struct {
+		name           string
+		query          string
+		filter         repository.LendingFilter
+		options        repository.ListOptions
+		lendings       []domain.Lending
+		repositoryErr  error
+		expectedStatus int
+	}{
+		{"success", "", repository.LendingFilter{}, repository.ListOptions{Limit: 50}, lendings, nil, http.StatusOK},
+		{"filtered", "?user_id=" + userID1 + "&book_id=" + bookID1 + "&active=true&limit=10",
+			repository.LendingFilter{UserID: userID1, BookID: bookID1, Active: &active}, repository.ListOptions{Limit: 10}, lendings[:1], nil, http.StatusOK},
+		{"invalid active filter", "?active=maybe", repository.LendingFilter{}, repository.ListOptions{}, nil, nil, http.StatusBadRequest},
+		{"invalid user filter", "?user_id=42", repository.LendingFilter{}, repository.ListOptions{}, nil, nil, http.StatusBadRequest},
+		{"repository error", "", repository.LendingFilter{}, repository.ListOptions{Limit: 50}, nil, errors.New("database error"), http.StatusInternalServerError},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			page := repository.Page[domain.Lending]{Items: tc.lendings, Total: len(tc.lendings)}
+			mockRepo.On("GetLendings", mock.Anything, tc.filter, tc.options).Return(page, tc.repositoryErr).Maybe()
+			mockValidation := new(mocks.Validation)
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
+			req, _ := http.NewRequest("GET", "/lendings"+tc.query, nil)
+			rr := httptest.NewRecorder()
+			service.GetLendings(rr, req)
 			assert.Equal(t, tc.expectedStatus, rr.Code)
 			if tc.expectedStatus == http.StatusOK {
 				var responseLendings []domain.Lending
 				err := json.Unmarshal(rr.Body.Bytes(), &responseLendings)
 				assert.NoError(t, err)
-				assert.Equal(t, len(tc.lendings), len(responseLendings))
-				for i, expectedLending := range tc.lendings {
-					actualLending := responseLendings[i]
-					assert.Equal(t, expectedLending.ID, actualLending.ID)
-					assert.Equal(t, expectedLending.BookID, actualLending.BookID)
-					assert.Equal(t, expectedLending.UserID, actualLending.UserID)
-					assert.Equal(t, expectedLending.LendDate, actualLending.LendDate)
-					assert.Equal(t, expectedLending.ReturnDate, actualLending.ReturnDate)
-				}
+				assert.Equal(t, len(tc.lendings), len(responseLendings))
+				for i, expectedLending := range tc.lendings {
+					actualLending := responseLendings[i]
+					assert.Equal(t, expectedLending.ID, actualLending.ID)
+					assert.Equal(t, expectedLending.BookID, actualLending.BookID)
+					assert.Equal(t, expectedLending.UserID, actualLending.UserID)
+					assert.Equal(t, expectedLending.LendDate, actualLending.LendDate)
+					assert.Equal(t, expectedLending.ReturnDate, actualLending.ReturnDate)
+				}
+			}
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetLendingsOverdueFilter(t *testing.T) {
+	now := time.Date(2025, time.February, 1, 0, 0, 0, 0, time.UTC)
+	userID := uuid.NewString()
+	overdue := []domain.Lending{{ID: uuid.NewString(), UserID: userID, LendDate: now.Add(-30 * 24 * time.Hour), DueDate: now.Add(-9 * 24 * time.Hour)}}
+	yes, no := true, false
+	testCases := []struct {
+		name           string
+		query          string
+		filter         repository.LendingFilter
+		options        repository.ListOptions
+		repositoryErr  error
+		expectedStatus int
+	}{
+		{"overdue only", "?overdue=true", repository.LendingFilter{Overdue: &yes, Now: now}, repository.ListOptions{Limit: 50}, nil, http.StatusOK},
+		{"not overdue", "?overdue=false", repository.LendingFilter{Overdue: &no, Now: now}, repository.ListOptions{Limit: 50}, nil, http.StatusOK},
+		{"with other filters", "?overdue=true&user_id=" + userID + "&limit=10",
+			repository.LendingFilter{UserID: userID, Overdue: &yes, Now: now}, repository.ListOptions{Limit: 10}, nil, http.StatusOK},
+		{"invalid filter", "?overdue=maybe", repository.LendingFilter{}, repository.ListOptions{}, nil, http.StatusBadRequest},
+		{"repository error", "?overdue=1", repository.LendingFilter{Overdue: &yes, Now: now}, repository.ListOptions{Limit: 50}, errors.New("database error"), http.StatusInternalServerError},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			page := repository.Page[domain.Lending]{Items: overdue, Total: len(overdue)}
+			mockRepo.On("GetLendings", mock.Anything, tc.filter, tc.options).Return(page, tc.repositoryErr).Maybe()
+			mockValidation := new(mocks.Validation)
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
+			service.now = func() time.Time { return now }
+			req, _ := http.NewRequest("GET", "/lendings"+tc.query, nil)
+			rr := httptest.NewRecorder()
+			service.GetLendings(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedStatus == http.StatusOK {
+				var responseLendings []domain.Lending
+				err := json.Unmarshal(rr.Body.Bytes(), &responseLendings)
+				assert.NoError(t, err)
+				assert.Len(t, responseLendings, 1)
+				assert.Equal(t, overdue[0].ID, responseLendings[0].ID)
+				assert.Equal(t, "1", rr.Header().Get("X-Total-Count"))
+			}
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestGetOverdueLendings(t *testing.T) {
+	now := time.Date(2025, time.February, 1, 12, 0, 0, 0, time.UTC)
+	lending := domain.Lending{ID: uuid.NewString(), LendDate: now.Add(-30 * 24 * time.Hour), DueDate: now.Add(-(9*24 + 1) * time.Hour)}
+	testCases := []struct {
+		name           string
+		lendings       []domain.Lending
+		repositoryErr  error
+		expectedStatus int
+	}{
+		{"success", []domain.Lending{lending}, nil, http.StatusOK},
+		{"repository error", nil, errors.New("database error"), http.StatusInternalServerError},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			mockRepo.On("GetOverdueLendings", mock.Anything, now).Return(tc.lendings, tc.repositoryErr)
+			mockValidation := new(mocks.Validation)
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
+			service.now = func() time.Time { return now }
+			req, _ := http.NewRequest("GET", "/lendings/overdue", nil)
+			rr := httptest.NewRecorder()
+			service.GetOverdueLendings(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedStatus == http.StatusOK {
+				var report []domain.OverdueLending
+				err := json.Unmarshal(rr.Body.Bytes(), &report)
+				assert.NoError(t, err)
+				assert.Len(t, report, 1)
+				assert.Equal(t, lending.ID, report[0].ID)
+				assert.Equal(t, 10, report[0].DaysOverdue)
 			}
 			mockRepo.AssertExpectations(t)
 		})
@@ -504,14 +1587,14 @@ func TestGetLendingByID(t *testing.T) {
 	}{
 		{"success", "/lendings/" + lendingID, lending, nil, http.StatusOK},
 		{"invalid id", "/invalid/" + lendingID, domain.Lending{}, nil, http.StatusBadRequest},
-		{"lending not found", "/lendings/" + lendingID, domain.Lending{}, errors.New("lending not found"), http.StatusNotFound},
+		{"lending not found", "/lendings/" + lendingID, domain.Lending{}, repository.ErrLendingNotFound, http.StatusNotFound},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			mockRepo := new(mocks.Repository)
-			mockRepo.On("GetLendingByID", lendingID).Return(tc.lending, tc.repositoryErr).Maybe()
+			mockRepo.On("GetLendingByID", mock.Anything, lendingID).Return(tc.lending, tc.repositoryErr).Maybe()
 			mockValidation := new(mocks.Validation)
-			service := NewLibaryService(mockRepo, mockValidation)
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
 			req, _ := http.NewRequest("GET", tc.path, nil)
 			rr := httptest.NewRecorder()
 			service.GetLendingByID(rr, req)
@@ -549,12 +1632,18 @@ func TestCreateLending(t *testing.T) {
 	}{
 		{"success", validLending, nil, createdLending, nil, http.StatusCreated},
 		{"invalid request body", "invalid json", nil, domain.Lending{}, nil, http.StatusBadRequest},
-		{"validation error", validLending, errors.New("validation error"), domain.Lending{}, nil, http.StatusBadRequest},
+		{"validation error", validLending, errInvalid, domain.Lending{}, nil, http.StatusUnprocessableEntity},
+		{"copy unavailable", validLending, nil, domain.Lending{}, repository.ErrCopyUnavailable, http.StatusConflict},
 		{"repository error", validLending, nil, domain.Lending{}, errors.New("database error"), http.StatusInternalServerError},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			mockRepo := new(mocks.Repository)
+			runTransactions(mockRepo)
+			mockRepo.On("ExpireHolds", mock.Anything, mock.AnythingOfType("time.Time")).Return([]domain.Hold{}, nil).Maybe()
+			mockRepo.On("LockUser", mock.Anything, userID).Return(domain.User{ID: userID, Category: domain.UserCategoryStudent}, nil).Maybe()
+			mockRepo.On("CountActiveLendings", mock.Anything, userID).Return(0, nil).Maybe()
+			mockRepo.On("GetFinesByUserID", mock.Anything, userID).Return([]domain.Fine{}, nil).Maybe()
 			mockValidation := new(mocks.Validation)
 			var requestBytes []byte
 			var err error
@@ -564,9 +1653,11 @@ func TestCreateLending(t *testing.T) {
 				requestBytes, err = json.Marshal(tc.requestBody)
 				assert.NoError(t, err)
 			}
-			mockValidation.On("CheckLending", mock.AnythingOfType("domain.Lending")).Return(tc.validationErr).Maybe()
-			mockRepo.On("CreateLending", mock.AnythingOfType("domain.Lending")).Return(tc.createdLending, tc.repositoryErr).Maybe()
-			service := NewLibaryService(mockRepo, mockValidation)
+			mockValidation.On("CheckLending", mock.Anything, mock.AnythingOfType("domain.Lending")).Return(tc.validationErr).Maybe()
+			mockRepo.On("CreateLending", mock.Anything, mock.MatchedBy(func(l domain.Lending) bool {
+				return l.DueDate.Equal(lendDate.Add(config.Default().Categories[domain.UserCategoryStudent].LoanPeriod))
+			})).Return(tc.createdLending, tc.repositoryErr).Maybe()
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
 			req, _ := http.NewRequest("POST", "/lendings", bytes.NewBuffer(requestBytes))
 			rr := httptest.NewRecorder()
 			service.CreateLending(rr, req)
@@ -607,12 +1698,15 @@ func TestUpdateLending(t *testing.T) {
 		{"success", "/lendings/" + lendingID, validLending, nil, updatedLending, nil, http.StatusOK},
 		{"invalid path", "/invalid/" + lendingID, validLending, nil, domain.Lending{}, nil, http.StatusBadRequest},
 		{"invalid request body", "/lendings/" + lendingID, "invalid json", nil, domain.Lending{}, nil, http.StatusBadRequest},
-		{"validation error", "/lendings/" + lendingID, validLending, errors.New("validation error"), domain.Lending{}, nil, http.StatusBadRequest},
+		{"validation error", "/lendings/" + lendingID, validLending, errInvalid, domain.Lending{}, nil, http.StatusUnprocessableEntity},
+		{"copy unavailable", "/lendings/" + lendingID, validLending, nil, domain.Lending{}, repository.ErrCopyUnavailable, http.StatusConflict},
 		{"repository error", "/lendings/" + lendingID, validLending, nil, domain.Lending{}, errors.New("database error"), http.StatusInternalServerError},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			mockRepo := new(mocks.Repository)
+			runTransactions(mockRepo)
+			mockRepo.On("ReadyNextHold", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(domain.Hold{}, nil).Maybe()
 			mockValidation := new(mocks.Validation)
 			var requestBytes []byte
 			var err error
@@ -622,10 +1716,12 @@ func TestUpdateLending(t *testing.T) {
 				requestBytes, err = json.Marshal(tc.requestBody)
 				assert.NoError(t, err)
 			}
-			mockValidation.On("CheckLending", mock.AnythingOfType("domain.Lending")).Return(tc.validationErr).Maybe()
-			mockRepo.On("UpdateLending", mock.AnythingOfType("domain.Lending")).Return(tc.updatedLending, tc.repositoryErr).Maybe()
-			service := NewLibaryService(mockRepo, mockValidation)
+			mockValidation.On("CheckLendingUpdate", mock.Anything, mock.AnythingOfType("domain.Lending"), mock.AnythingOfType("domain.Lending")).Return(tc.validationErr).Maybe()
+			mockRepo.On("GetLendingByID", mock.Anything, lendingID).Return(domain.Lending{ID: lendingID, Version: 1}, nil).Maybe()
+			mockRepo.On("UpdateLending", mock.Anything, mock.AnythingOfType("domain.Lending")).Return(tc.updatedLending, tc.repositoryErr).Maybe()
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
 			req, _ := http.NewRequest("PUT", tc.path, bytes.NewBuffer(requestBytes))
+			req.Header.Set("If-Match", `"1"`)
 			rr := httptest.NewRecorder()
 			service.UpdateLending(rr, req)
 			assert.Equal(t, tc.expectedStatus, rr.Code)
@@ -645,6 +1741,79 @@ func TestUpdateLending(t *testing.T) {
 	}
 }
 
+func TestPatchLending(t *testing.T) {
+	lendingID := uuid.NewString()
+	copyID := uuid.NewString()
+	lendDate := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+	stored := domain.Lending{ID: lendingID, BookID: uuid.NewString(), CopyID: copyID, UserID: uuid.NewString(),
+		LendDate: lendDate, DueDate: lendDate.AddDate(0, 0, 14), Version: 1}
+	moved := stored
+	moved.CopyID = uuid.NewString()
+
+	// The copy the lending leaves goes to the next waiting hold.
+	mockRepo := new(mocks.Repository)
+	runTransactions(mockRepo)
+	mockValidation := new(mocks.Validation)
+	mockRepo.On("GetLendingByID", mock.Anything, lendingID).Return(stored, nil)
+	mockValidation.On("CheckLendingUpdate", mock.Anything, stored, moved).Return(nil)
+	mockRepo.On("UpdateLending", mock.Anything, moved).Return(moved, nil)
+	mockRepo.On("ReadyNextHold", mock.Anything, copyID, mock.Anything, mock.Anything).Return(domain.Hold{}, nil)
+	service := NewLibaryService(mockRepo, mockValidation, config.Default())
+	req, _ := http.NewRequest("PATCH", "/lendings/"+lendingID, strings.NewReader(`{"copy_id":"`+moved.CopyID+`"}`))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	req.Header.Set("If-Match", `"1"`)
+	rr := httptest.NewRecorder()
+	service.PatchLending(rr, req)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	mockRepo.AssertExpectations(t)
+	mockValidation.AssertExpectations(t)
+}
+
+// TestUpdateLendingManagedFields makes sure that neither PUT nor PATCH can
+// return or renew a lending, which would skip the due date rules and fines.
+func TestUpdateLendingManagedFields(t *testing.T) {
+	lendDate := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+	stored := domain.Lending{ID: uuid.NewString(), BookID: uuid.NewString(), CopyID: uuid.NewString(), UserID: uuid.NewString(),
+		LendDate: lendDate, DueDate: lendDate.AddDate(0, 0, 28), RenewalCount: 2, Version: 1}
+	testCases := []struct {
+		name   string
+		patch  string
+		update func(l *domain.Lending)
+	}{
+		{"return_date", `{"return_date":"2025-03-31T00:00:00Z"}`, func(l *domain.Lending) { l.ReturnDate = lendDate.AddDate(0, 3, 0) }},
+		{"renewal_count", `{"renewal_count":0}`, func(l *domain.Lending) { l.RenewalCount = 0 }},
+		{"due_date", `{"due_date":null}`, func(l *domain.Lending) { l.DueDate = time.Time{} }},
+		{"lend_date", `{"lend_date":"2025-02-01T00:00:00Z"}`, func(l *domain.Lending) { l.LendDate = lendDate.AddDate(0, 1, 0) }},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			mockRepo.On("GetLendingByID", mock.Anything, stored.ID).Return(stored, nil)
+			service := NewLibaryService(mockRepo, validator.New(mockRepo), config.Default())
+
+			updated := stored
+			tc.update(&updated)
+			body, err := json.Marshal(updated)
+			assert.NoError(t, err)
+			req, _ := http.NewRequest("PUT", "/lendings/"+stored.ID, bytes.NewBuffer(body))
+			req.Header.Set("If-Match", `"1"`)
+			rr := httptest.NewRecorder()
+			service.UpdateLending(rr, req)
+			assert.Equal(t, http.StatusUnprocessableEntity, rr.Code, "PUT")
+			assert.Contains(t, rr.Body.String(), tc.name)
+
+			req, _ = http.NewRequest("PATCH", "/lendings/"+stored.ID, strings.NewReader(tc.patch))
+			req.Header.Set("Content-Type", "application/merge-patch+json")
+			req.Header.Set("If-Match", `"1"`)
+			rr = httptest.NewRecorder()
+			service.PatchLending(rr, req)
+			assert.Equal(t, http.StatusUnprocessableEntity, rr.Code, "PATCH")
+			assert.Contains(t, rr.Body.String(), tc.name)
+			mockRepo.AssertNotCalled(t, "UpdateLending", mock.Anything, mock.Anything)
+		})
+	}
+}
+
 func TestDeleteLending(t *testing.T) {
 	lendingID := uuid.NewString()
 	testCases := []struct {
@@ -656,14 +1825,375 @@ func TestDeleteLending(t *testing.T) {
 		{"success", "/lendings/" + lendingID, nil, http.StatusNoContent},
 		{"invalid path", "/invalid/" + lendingID, nil, http.StatusBadRequest},
 		{"repository error", "/lendings/" + lendingID, errors.New("database error"), http.StatusInternalServerError},
+		{"lending not found", "/lendings/" + lendingID, repository.ErrLendingNotFound, http.StatusNotFound},
+		{"lost race", "/lendings/" + lendingID, repository.ErrVersionMismatch, http.StatusPreconditionFailed},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			mockRepo := new(mocks.Repository)
+			runTransactions(mockRepo)
 			mockValidation := new(mocks.Validation)
-			mockRepo.On("DeleteLending", lendingID).Return(tc.repositoryErr).Maybe()
-			service := NewLibaryService(mockRepo, mockValidation)
+			mockRepo.On("GetLendingByID", mock.Anything, lendingID).Return(domain.Lending{ID: lendingID, Version: 1}, nil).Maybe()
+			mockRepo.On("DeleteLending", mock.Anything, lendingID, 1, mock.Anything).Return(tc.repositoryErr).Maybe()
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
 			req, _ := http.NewRequest("DELETE", tc.path, nil)
+			req.Header.Set("If-Match", `"1"`)
+			rr := httptest.NewRecorder()
+			service.DeleteLending(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestReturnLending(t *testing.T) {
+	lendDate := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+	now := lendDate.Add(10 * 24 * time.Hour)
+	lendingID := uuid.NewString()
+	active := domain.Lending{ID: lendingID, BookID: uuid.NewString(), CopyID: uuid.NewString(), UserID: uuid.NewString(), LendDate: lendDate}
+	returned := active
+	returned.ReturnDate = lendDate.Add(24 * time.Hour)
+	testCases := []struct {
+		name           string
+		path           string
+		stored         domain.Lending
+		storedErr      error
+		repositoryErr  error
+		expectedStatus int
+	}{
+		{"success", "/lendings/" + lendingID + "/return", active, nil, nil, http.StatusOK},
+		{"invalid path", "/invalid/" + lendingID + "/return", active, nil, nil, http.StatusBadRequest},
+		{"lending not found", "/lendings/" + lendingID + "/return", domain.Lending{}, repository.ErrLendingNotFound, nil, http.StatusNotFound},
+		{"already returned", "/lendings/" + lendingID + "/return", returned, nil, nil, http.StatusConflict},
+		{"repository error", "/lendings/" + lendingID + "/return", active, nil, errors.New("database error"), http.StatusInternalServerError},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			runTransactions(mockRepo)
+			mockRepo.On("ReadyNextHold", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(domain.Hold{}, nil).Maybe()
+			mockValidation := new(mocks.Validation)
+			mockRepo.On("GetLendingByID", mock.Anything, lendingID).Return(tc.stored, tc.storedErr).Maybe()
+			mockRepo.On("UpdateLending", mock.Anything, mock.MatchedBy(func(l domain.Lending) bool {
+				return l.ID == lendingID && l.ReturnDate.Equal(now)
+			})).Return(func(_ context.Context, l domain.Lending) domain.Lending { return l }, tc.repositoryErr).Maybe()
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
+			service.now = func() time.Time { return now }
+			req, _ := http.NewRequest("POST", tc.path, nil)
+			rr := httptest.NewRecorder()
+			service.ReturnLending(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedStatus == http.StatusOK {
+				var responseLending domain.Lending
+				err := json.Unmarshal(rr.Body.Bytes(), &responseLending)
+				assert.NoError(t, err)
+				assert.Equal(t, now, responseLending.ReturnDate)
+			}
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRenewLending(t *testing.T) {
+	lendDate := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+	now := lendDate.Add(10 * 24 * time.Hour)
+	lendingID := uuid.NewString()
+	active := domain.Lending{ID: lendingID, BookID: uuid.NewString(), CopyID: uuid.NewString(), UserID: uuid.NewString(), LendDate: lendDate, DueDate: lendDate.Add(14 * 24 * time.Hour), RenewalCount: 1}
+	overdue := active
+	overdue.DueDate = lendDate.Add(7 * 24 * time.Hour)
+	exhausted := active
+	exhausted.RenewalCount = 2
+	returned := active
+	returned.ReturnDate = lendDate.Add(24 * time.Hour)
+	testCases := []struct {
+		name            string
+		path            string
+		stored          domain.Lending
+		storedErr       error
+		repositoryErr   error
+		expectedDueDate time.Time
+		expectedStatus  int
+	}{
+		{"success", "/lendings/" + lendingID + "/renew", active, nil, nil, lendDate.Add(28 * 24 * time.Hour), http.StatusOK},
+		{"overdue renews from today", "/lendings/" + lendingID + "/renew", overdue, nil, nil, now.Add(14 * 24 * time.Hour), http.StatusOK},
+		{"invalid path", "/invalid/" + lendingID + "/renew", active, nil, nil, time.Time{}, http.StatusBadRequest},
+		{"lending not found", "/lendings/" + lendingID + "/renew", domain.Lending{}, repository.ErrLendingNotFound, nil, time.Time{}, http.StatusNotFound},
+		{"already returned", "/lendings/" + lendingID + "/renew", returned, nil, nil, time.Time{}, http.StatusConflict},
+		{"maximum renewals reached", "/lendings/" + lendingID + "/renew", exhausted, nil, nil, time.Time{}, http.StatusConflict},
+		{"repository error", "/lendings/" + lendingID + "/renew", active, nil, errors.New("database error"), lendDate.Add(28 * 24 * time.Hour), http.StatusInternalServerError},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			runTransactions(mockRepo)
+			mockValidation := new(mocks.Validation)
+			mockRepo.On("GetLendingByID", mock.Anything, lendingID).Return(tc.stored, tc.storedErr).Maybe()
+			mockRepo.On("UpdateLending", mock.Anything, mock.MatchedBy(func(l domain.Lending) bool {
+				return l.ID == lendingID && l.RenewalCount == tc.stored.RenewalCount+1 && l.DueDate.Equal(tc.expectedDueDate)
+			})).Return(func(_ context.Context, l domain.Lending) domain.Lending { return l }, tc.repositoryErr).Maybe()
+			mockRepo.On("GetUserByID", mock.Anything, tc.stored.UserID).Return(domain.User{ID: tc.stored.UserID, Category: domain.UserCategoryGuest}, nil).Maybe()
+			cfg := config.Config{Categories: map[domain.UserCategory]config.Category{domain.UserCategoryGuest: {MaxLoans: 2, LoanPeriod: 14 * 24 * time.Hour}}, MaxRenewals: 2}
+			service := NewLibaryService(mockRepo, mockValidation, cfg)
+			service.now = func() time.Time { return now }
+			req, _ := http.NewRequest("POST", tc.path, nil)
+			rr := httptest.NewRecorder()
+			service.RenewLending(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedStatus == http.StatusOK {
+				var responseLending domain.Lending
+				err := json.Unmarshal(rr.Body.Bytes(), &responseLending)
+				assert.NoError(t, err)
+				assert.Equal(t, 2, responseLending.RenewalCount)
+				assert.Equal(t, tc.expectedDueDate, responseLending.DueDate)
+			}
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestReturnLendingCreatesFine(t *testing.T) {
+	dueDate := time.Date(2025, time.January, 22, 0, 0, 0, 0, time.UTC)
+	lending := domain.Lending{ID: uuid.NewString(), BookID: uuid.NewString(), CopyID: uuid.NewString(), UserID: uuid.NewString(), LendDate: dueDate.AddDate(0, 0, -21), DueDate: dueDate}
+	testCases := []struct {
+		name           string
+		now            time.Time
+		fineErr        error
+		expectedAmount int64
+		expectedStatus int
+	}{
+		{"returned on time", dueDate.Add(-time.Hour), nil, 0, http.StatusOK},
+		{"three days late", dueDate.Add(2*24*time.Hour + time.Hour), nil, 150, http.StatusOK},
+		{"capped", dueDate.AddDate(0, 0, 40), nil, 1000, http.StatusOK},
+		{"fine error", dueDate.AddDate(0, 0, 3), errors.New("database error"), 150, http.StatusInternalServerError},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			runTransactions(mockRepo)
+			mockRepo.On("ReadyNextHold", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(domain.Hold{}, nil).Maybe()
+			mockValidation := new(mocks.Validation)
+			mockRepo.On("GetLendingByID", mock.Anything, lending.ID).Return(lending, nil)
+			mockRepo.On("UpdateLending", mock.Anything, mock.AnythingOfType("domain.Lending")).Return(func(_ context.Context, l domain.Lending) domain.Lending { return l }, nil)
+			if tc.expectedAmount > 0 {
+				mockRepo.On("CreateFine", mock.Anything, mock.MatchedBy(func(f domain.Fine) bool {
+					return f.UserID == lending.UserID && f.LendingID == lending.ID && f.Reason == domain.FineReasonOverdue &&
+						f.Amount == tc.expectedAmount && f.Status == domain.FineStatusUnpaid && f.CreatedAt.Equal(tc.now)
+				})).Return(func(_ context.Context, f domain.Fine) domain.Fine { return f }, tc.fineErr)
+			}
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
+			service.now = func() time.Time { return tc.now }
+			req, _ := http.NewRequest("POST", "/lendings/"+lending.ID+"/return", nil)
+			rr := httptest.NewRecorder()
+			service.ReturnLending(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestRenewLendingChargesOverdueFine(t *testing.T) {
+	dueDate := time.Date(2025, time.January, 22, 0, 0, 0, 0, time.UTC)
+	lending := domain.Lending{ID: uuid.NewString(), BookID: uuid.NewString(), CopyID: uuid.NewString(), UserID: uuid.NewString(), LendDate: dueDate.AddDate(0, 0, -21), DueDate: dueDate}
+	testCases := []struct {
+		name           string
+		now            time.Time
+		fineErr        error
+		expectedAmount int64
+		expectedStatus int
+	}{
+		{"renewed on time", dueDate.Add(-time.Hour), nil, 0, http.StatusOK},
+		{"three days late", dueDate.Add(2*24*time.Hour + time.Hour), nil, 150, http.StatusOK},
+		{"fine error", dueDate.AddDate(0, 0, 3), errors.New("database error"), 150, http.StatusInternalServerError},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			runTransactions(mockRepo)
+			mockValidation := new(mocks.Validation)
+			mockRepo.On("GetLendingByID", mock.Anything, lending.ID).Return(lending, nil)
+			mockRepo.On("GetUserByID", mock.Anything, lending.UserID).Return(domain.User{ID: lending.UserID, Category: domain.UserCategoryStudent}, nil)
+			mockRepo.On("UpdateLending", mock.Anything, mock.AnythingOfType("domain.Lending")).Return(func(_ context.Context, l domain.Lending) domain.Lending { return l }, nil)
+			if tc.expectedAmount > 0 {
+				mockRepo.On("CreateFine", mock.Anything, mock.MatchedBy(func(f domain.Fine) bool {
+					return f.UserID == lending.UserID && f.LendingID == lending.ID && f.Reason == domain.FineReasonOverdue &&
+						f.Amount == tc.expectedAmount && f.Status == domain.FineStatusUnpaid && f.CreatedAt.Equal(tc.now)
+				})).Return(func(_ context.Context, f domain.Fine) domain.Fine { return f }, tc.fineErr)
+			}
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
+			service.now = func() time.Time { return tc.now }
+			req, _ := http.NewRequest("POST", "/lendings/"+lending.ID+"/renew", nil)
+			rr := httptest.NewRecorder()
+			service.RenewLending(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestReportLostLending(t *testing.T) {
+	now := time.Date(2025, time.January, 10, 0, 0, 0, 0, time.UTC)
+	lendingID := uuid.NewString()
+	bookCopy := domain.BookCopy{ID: uuid.NewString(), BookID: uuid.NewString(), Barcode: "LIB-0001", Condition: domain.CopyConditionGood, Status: domain.CopyStatusOnLoan}
+	active := domain.Lending{ID: lendingID, BookID: bookCopy.BookID, CopyID: bookCopy.ID, UserID: uuid.NewString(), LendDate: now.AddDate(0, 0, -5), DueDate: now.AddDate(0, 0, 16)}
+	returned := active
+	returned.ReturnDate = now.AddDate(0, 0, -1)
+	testCases := []struct {
+		name           string
+		path           string
+		stored         domain.Lending
+		storedErr      error
+		copyErr        error
+		fineErr        error
+		expectedStatus int
+	}{
+		{"success", "/lendings/" + lendingID + "/lost", active, nil, nil, nil, http.StatusOK},
+		{"invalid path", "/invalid/" + lendingID + "/lost", active, nil, nil, nil, http.StatusBadRequest},
+		{"lending not found", "/lendings/" + lendingID + "/lost", domain.Lending{}, repository.ErrLendingNotFound, nil, nil, http.StatusNotFound},
+		{"already returned", "/lendings/" + lendingID + "/lost", returned, nil, nil, nil, http.StatusConflict},
+		{"copy error", "/lendings/" + lendingID + "/lost", active, nil, errors.New("database error"), nil, http.StatusInternalServerError},
+		{"fine error", "/lendings/" + lendingID + "/lost", active, nil, nil, errors.New("database error"), http.StatusInternalServerError},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			runTransactions(mockRepo)
+			mockValidation := new(mocks.Validation)
+			mockRepo.On("GetLendingByID", mock.Anything, lendingID).Return(tc.stored, tc.storedErr).Maybe()
+			mockRepo.On("GetBookCopyByID", mock.Anything, bookCopy.ID).Return(bookCopy, nil).Maybe()
+			mockRepo.On("UpdateBookCopy", mock.Anything, mock.MatchedBy(func(c domain.BookCopy) bool {
+				return c.ID == bookCopy.ID && c.Status == domain.CopyStatusLost
+			})).Return(func(_ context.Context, c domain.BookCopy) domain.BookCopy { return c }, tc.copyErr).Maybe()
+			mockRepo.On("UpdateLending", mock.Anything, mock.MatchedBy(func(l domain.Lending) bool {
+				return l.ID == lendingID && l.ReturnDate.Equal(now)
+			})).Return(func(_ context.Context, l domain.Lending) domain.Lending { return l }, nil).Maybe()
+			mockRepo.On("CreateFine", mock.Anything, mock.MatchedBy(func(f domain.Fine) bool {
+				return f.LendingID == lendingID && f.Reason == domain.FineReasonLost && f.Amount == 2500
+			})).Return(func(_ context.Context, f domain.Fine) domain.Fine { return f }, tc.fineErr).Maybe()
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
+			service.now = func() time.Time { return now }
+			req, _ := http.NewRequest("POST", tc.path, nil)
+			rr := httptest.NewRecorder()
+			service.ReportLostLending(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedStatus == http.StatusOK {
+				var responseLending domain.Lending
+				err := json.Unmarshal(rr.Body.Bytes(), &responseLending)
+				assert.NoError(t, err)
+				assert.Equal(t, now, responseLending.ReturnDate)
+			}
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestSettleFine(t *testing.T) {
+	now := time.Date(2025, time.February, 1, 0, 0, 0, 0, time.UTC)
+	fineID := uuid.NewString()
+	unpaid := domain.Fine{ID: fineID, UserID: uuid.NewString(), Reason: domain.FineReasonOverdue, Amount: 300, Status: domain.FineStatusUnpaid, CreatedAt: now.AddDate(0, 0, -3)}
+	paid := unpaid
+	paid.Status = domain.FineStatusPaid
+	testCases := []struct {
+		name           string
+		path           string
+		handler        func(s *LibaryService) http.HandlerFunc
+		stored         domain.Fine
+		storedErr      error
+		repositoryErr  error
+		expectedFine   domain.FineStatus
+		expectedStatus int
+	}{
+		{"pay", "/fines/" + fineID + "/pay", func(s *LibaryService) http.HandlerFunc { return s.PayFine }, unpaid, nil, nil, domain.FineStatusPaid, http.StatusOK},
+		{"waive", "/fines/" + fineID + "/waive", func(s *LibaryService) http.HandlerFunc { return s.WaiveFine }, unpaid, nil, nil, domain.FineStatusWaived, http.StatusOK},
+		{"invalid path", "/invalid/" + fineID + "/pay", func(s *LibaryService) http.HandlerFunc { return s.PayFine }, unpaid, nil, nil, "", http.StatusBadRequest},
+		{"fine not found", "/fines/" + fineID + "/pay", func(s *LibaryService) http.HandlerFunc { return s.PayFine }, domain.Fine{}, repository.ErrFineNotFound, nil, "", http.StatusNotFound},
+		{"already paid", "/fines/" + fineID + "/waive", func(s *LibaryService) http.HandlerFunc { return s.WaiveFine }, paid, nil, nil, "", http.StatusConflict},
+		{"repository error", "/fines/" + fineID + "/pay", func(s *LibaryService) http.HandlerFunc { return s.PayFine }, unpaid, nil, errors.New("database error"), domain.FineStatusPaid, http.StatusInternalServerError},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			mockValidation := new(mocks.Validation)
+			mockRepo.On("GetFineByID", mock.Anything, fineID).Return(tc.stored, tc.storedErr).Maybe()
+			mockRepo.On("UpdateFine", mock.Anything, mock.MatchedBy(func(f domain.Fine) bool {
+				return f.ID == fineID && f.Status == tc.expectedFine && f.SettledAt.Equal(now)
+			})).Return(func(_ context.Context, f domain.Fine) domain.Fine { return f }, tc.repositoryErr).Maybe()
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
+			service.now = func() time.Time { return now }
+			req, _ := http.NewRequest("POST", tc.path, nil)
+			rr := httptest.NewRecorder()
+			tc.handler(service)(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedStatus == http.StatusOK {
+				var responseFine domain.Fine
+				err := json.Unmarshal(rr.Body.Bytes(), &responseFine)
+				assert.NoError(t, err)
+				assert.Equal(t, tc.expectedFine, responseFine.Status)
+				assert.Equal(t, now, responseFine.SettledAt)
+			}
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestReturnLendingReadiesHold(t *testing.T) {
+	now := time.Date(2025, time.January, 10, 0, 0, 0, 0, time.UTC)
+	lending := domain.Lending{ID: uuid.NewString(), BookID: uuid.NewString(), CopyID: uuid.NewString(), UserID: uuid.NewString(), LendDate: now.AddDate(0, 0, -5), DueDate: now.AddDate(0, 0, 16)}
+	testCases := []struct {
+		name           string
+		holdErr        error
+		expectedStatus int
+	}{
+		{"copy set aside", nil, http.StatusOK},
+		{"repository error", errors.New("database error"), http.StatusInternalServerError},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			runTransactions(mockRepo)
+			mockValidation := new(mocks.Validation)
+			mockRepo.On("GetLendingByID", mock.Anything, lending.ID).Return(lending, nil)
+			mockRepo.On("UpdateLending", mock.Anything, mock.AnythingOfType("domain.Lending")).Return(func(_ context.Context, l domain.Lending) domain.Lending { return l }, nil)
+			mockRepo.On("ReadyNextHold", mock.Anything, lending.CopyID, now, now.Add(3*24*time.Hour)).Return(domain.Hold{}, tc.holdErr)
+			service := NewLibaryService(mockRepo, mockValidation, config.Config{HoldPickupWindow: 3 * 24 * time.Hour})
+			service.now = func() time.Time { return now }
+			req, _ := http.NewRequest("POST", "/lendings/"+lending.ID+"/return", nil)
+			rr := httptest.NewRecorder()
+			service.ReturnLending(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestDeleteLendingReadiesHold(t *testing.T) {
+	now := time.Date(2025, time.January, 10, 0, 0, 0, 0, time.UTC)
+	active := domain.Lending{ID: uuid.NewString(), BookID: uuid.NewString(), CopyID: uuid.NewString(), UserID: uuid.NewString(), LendDate: now.AddDate(0, 0, -5), DueDate: now.AddDate(0, 0, 16), Version: 1}
+	returned := active
+	returned.ReturnDate = now.AddDate(0, 0, -1)
+	testCases := []struct {
+		name           string
+		lending        domain.Lending
+		holdErr        error
+		expectedStatus int
+	}{
+		{"copy set aside", active, nil, http.StatusNoContent},
+		{"repository error", active, errors.New("database error"), http.StatusInternalServerError},
+		{"returned lending", returned, nil, http.StatusNoContent},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			runTransactions(mockRepo)
+			mockValidation := new(mocks.Validation)
+			mockRepo.On("GetLendingByID", mock.Anything, tc.lending.ID).Return(tc.lending, nil)
+			mockRepo.On("DeleteLending", mock.Anything, tc.lending.ID, 1, now).Return(nil)
+			if tc.lending.ReturnDate.IsZero() {
+				mockRepo.On("ReadyNextHold", mock.Anything, tc.lending.CopyID, now, now.Add(3*24*time.Hour)).Return(domain.Hold{}, tc.holdErr)
+			}
+			service := NewLibaryService(mockRepo, mockValidation, config.Config{HoldPickupWindow: 3 * 24 * time.Hour})
+			service.now = func() time.Time { return now }
+			req, _ := http.NewRequest("DELETE", "/lendings/"+tc.lending.ID, nil)
+			req.Header.Set("If-Match", `"1"`)
 			rr := httptest.NewRecorder()
 			service.DeleteLending(rr, req)
 			assert.Equal(t, tc.expectedStatus, rr.Code)
@@ -671,3 +2201,191 @@ func TestDeleteLending(t *testing.T) {
 		})
 	}
 }
+
+func TestCreateLendingExpiresHolds(t *testing.T) {
+	now := time.Date(2025, time.January, 10, 0, 0, 0, 0, time.UTC)
+	copyID := uuid.NewString()
+	expired := []domain.Hold{{ID: uuid.NewString(), CopyID: copyID, Status: domain.HoldStatusExpired}}
+	lending := domain.Lending{BookID: uuid.NewString(), UserID: uuid.NewString(), LendDate: now}
+	body, _ := json.Marshal(lending)
+
+	mockRepo := new(mocks.Repository)
+	runTransactions(mockRepo)
+	mockValidation := new(mocks.Validation)
+	mockValidation.On("CheckLending", mock.Anything, mock.AnythingOfType("domain.Lending")).Return(nil)
+	mockRepo.On("LockUser", mock.Anything, lending.UserID).Return(domain.User{ID: lending.UserID, Category: domain.UserCategoryStudent}, nil)
+	mockRepo.On("CountActiveLendings", mock.Anything, lending.UserID).Return(0, nil)
+	mockRepo.On("GetFinesByUserID", mock.Anything, lending.UserID).Return([]domain.Fine{}, nil)
+	mockRepo.On("ExpireHolds", mock.Anything, now).Return(expired, nil)
+	mockRepo.On("ReadyNextHold", mock.Anything, copyID, now, now.Add(7*24*time.Hour)).Return(domain.Hold{}, nil)
+	mockRepo.On("CreateLending", mock.Anything, mock.AnythingOfType("domain.Lending")).Return(func(_ context.Context, l domain.Lending) domain.Lending { return l }, nil)
+	service := NewLibaryService(mockRepo, mockValidation, config.Default())
+	service.now = func() time.Time { return now }
+	req, _ := http.NewRequest("POST", "/lendings", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	service.CreateLending(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCreateLendingBorrowingPrivileges(t *testing.T) {
+	lendDate := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+	userID := uuid.NewString()
+	lending := domain.Lending{BookID: uuid.NewString(), UserID: userID, LendDate: lendDate}
+	unpaid := []domain.Fine{
+		{Amount: 800, Status: domain.FineStatusUnpaid},
+		{Amount: 300, Status: domain.FineStatusUnpaid},
+	}
+	settled := []domain.Fine{
+		{Amount: 800, Status: domain.FineStatusPaid},
+		{Amount: 300, Status: domain.FineStatusUnpaid},
+	}
+	testCases := []struct {
+		name            string
+		category        domain.UserCategory
+		activeLendings  int
+		fines           []domain.Fine
+		countErr        error
+		expectedDueDate time.Time
+		expectedStatus  int
+	}{
+		{"staff loan period", domain.UserCategoryStaff, 0, nil, nil, lendDate.Add(42 * 24 * time.Hour), http.StatusCreated},
+		{"guest loan period", domain.UserCategoryGuest, 1, nil, nil, lendDate.Add(14 * 24 * time.Hour), http.StatusCreated},
+		{"guest limit reached", domain.UserCategoryGuest, 2, nil, nil, time.Time{}, http.StatusForbidden},
+		{"unknown category", "visitor", 0, nil, nil, time.Time{}, http.StatusForbidden},
+		{"unpaid fines above threshold", domain.UserCategoryStudent, 0, unpaid, nil, time.Time{}, http.StatusForbidden},
+		{"paid fines do not count", domain.UserCategoryStudent, 0, settled, nil, lendDate.Add(21 * 24 * time.Hour), http.StatusCreated},
+		{"repository error", domain.UserCategoryStudent, 0, nil, errors.New("database error"), time.Time{}, http.StatusInternalServerError},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			runTransactions(mockRepo)
+			mockValidation := new(mocks.Validation)
+			mockValidation.On("CheckLending", mock.Anything, mock.AnythingOfType("domain.Lending")).Return(nil)
+			mockRepo.On("LockUser", mock.Anything, userID).Return(domain.User{ID: userID, Category: tc.category}, nil)
+			mockRepo.On("CountActiveLendings", mock.Anything, userID).Return(tc.activeLendings, tc.countErr).Maybe()
+			mockRepo.On("GetFinesByUserID", mock.Anything, userID).Return(tc.fines, nil).Maybe()
+			mockRepo.On("ExpireHolds", mock.Anything, mock.AnythingOfType("time.Time")).Return([]domain.Hold{}, nil).Maybe()
+			mockRepo.On("CreateLending", mock.Anything, mock.MatchedBy(func(l domain.Lending) bool {
+				return l.DueDate.Equal(tc.expectedDueDate)
+			})).Return(func(_ context.Context, l domain.Lending) domain.Lending { return l }, nil).Maybe()
+			service := NewLibaryService(mockRepo, mockValidation, config.Default())
+			body, _ := json.Marshal(lending)
+			req, _ := http.NewRequest("POST", "/lendings", bytes.NewReader(body))
+			rr := httptest.NewRecorder()
+			service.CreateLending(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCreateLendingChecksPrivilegesInTransaction(t *testing.T) {
+	lending := domain.Lending{BookID: uuid.NewString(), UserID: uuid.NewString(), LendDate: time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)}
+	body, _ := json.Marshal(lending)
+
+	// Everything from locking the user to creating the lending goes through tx.
+	tx := new(mocks.Repository)
+	tx.On("LockUser", mock.Anything, lending.UserID).Return(domain.User{ID: lending.UserID, Category: domain.UserCategoryStudent}, nil)
+	tx.On("CountActiveLendings", mock.Anything, lending.UserID).Return(0, nil)
+	tx.On("GetFinesByUserID", mock.Anything, lending.UserID).Return([]domain.Fine{}, nil)
+	tx.On("CreateLending", mock.Anything, mock.AnythingOfType("domain.Lending")).Return(func(_ context.Context, l domain.Lending) domain.Lending { return l }, nil)
+	mockRepo := new(mocks.Repository)
+	mockRepo.On("ExpireHolds", mock.Anything, mock.AnythingOfType("time.Time")).Return([]domain.Hold{}, nil)
+	// Expiring the holds comes first and runs in a transaction of its own.
+	mockRepo.On("WithTx", mock.Anything, mock.Anything).Return(func(_ context.Context, fn func(tx repository.Repository) error) error {
+		return fn(mockRepo)
+	}).Once()
+	mockRepo.On("WithTx", mock.Anything, mock.Anything).Return(func(_ context.Context, fn func(tx repository.Repository) error) error {
+		return fn(tx)
+	}).Once()
+	mockValidation := new(mocks.Validation)
+	mockValidation.On("CheckLending", mock.Anything, mock.AnythingOfType("domain.Lending")).Return(nil)
+	service := NewLibaryService(mockRepo, mockValidation, config.Default())
+	req, _ := http.NewRequest("POST", "/lendings", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	service.CreateLending(rr, req)
+	assert.Equal(t, http.StatusCreated, rr.Code)
+	mockRepo.AssertExpectations(t)
+	tx.AssertExpectations(t)
+}
+
+// pooledRepository is a repository that reports connection pool statistics.
+type pooledRepository struct {
+	*mocks.Repository
+	stats database.PoolStats
+}
+
+func (r pooledRepository) PoolStats() database.PoolStats {
+	return r.stats
+}
+
+func TestGetDatabaseStats(t *testing.T) {
+	stats := database.PoolStats{MaxConns: 10, TotalConns: 3, IdleConns: 2, AcquiredConns: 1, AcquireCount: 42}
+	testCases := []struct {
+		name           string
+		repository     repository.Repository
+		expectedStatus int
+	}{
+		{"pooled repository", pooledRepository{new(mocks.Repository), stats}, http.StatusOK},
+		{"no pool", new(mocks.Repository), http.StatusNotFound},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			service := NewLibaryService(tc.repository, new(mocks.Validation), config.Default())
+			req, _ := http.NewRequest("GET", "/stats/database", nil)
+			rr := httptest.NewRecorder()
+			service.GetDatabaseStats(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedStatus == http.StatusOK {
+				var responseStats database.PoolStats
+				assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &responseStats))
+				assert.Equal(t, stats, responseStats)
+			}
+		})
+	}
+}
+
+func TestPurgeDeleted(t *testing.T) {
+	now := time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+	report := domain.PurgeReport{Books: 1, Users: 2, Lendings: 3}
+	testCases := []struct {
+		name           string
+		adminToken     string
+		authorization  string
+		repositoryErr  error
+		expectedStatus int
+	}{
+		{"admin", "secret", "Bearer secret", nil, http.StatusOK},
+		{"no token", "secret", "", nil, http.StatusForbidden},
+		{"wrong token", "secret", "Bearer guess", nil, http.StatusForbidden},
+		{"admin disabled", "", "Bearer ", nil, http.StatusForbidden},
+		{"repository error", "secret", "Bearer secret", errors.New("database error"), http.StatusInternalServerError},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			cfg := config.Default()
+			cfg.AdminToken = tc.adminToken
+			mockRepo.On("PurgeDeleted", mock.Anything, now.Add(-cfg.DeletedRetention)).Return(report, tc.repositoryErr).Maybe()
+			service := NewLibaryService(mockRepo, new(mocks.Validation), cfg)
+			service.now = func() time.Time { return now }
+			req, _ := http.NewRequest("POST", "/admin/purge", nil)
+			if tc.authorization != "" {
+				req.Header.Set("Authorization", tc.authorization)
+			}
+			rr := httptest.NewRecorder()
+			service.PurgeDeleted(rr, req)
+			assert.Equal(t, tc.expectedStatus, rr.Code)
+			if tc.expectedStatus == http.StatusOK {
+				var responseReport domain.PurgeReport
+				assert.NoError(t, json.Unmarshal(rr.Body.Bytes(), &responseReport))
+				assert.Equal(t, report, responseReport)
+			} else if tc.expectedStatus == http.StatusForbidden {
+				mockRepo.AssertNotCalled(t, "PurgeDeleted", mock.Anything, mock.Anything)
+			}
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package app
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+)
+
+const mergePatchType = "application/merge-patch+json"
+
+// readMergePatch reads the JSON merge patch (RFC 7396) of a PATCH request.
+// Bodies sent as plain application/json are accepted as well.
+func readMergePatch(w http.ResponseWriter, r *http.Request) (map[string]any, bool) {
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		mediaType, _, err := mime.ParseMediaType(contentType)
+		if err != nil || (mediaType != mergePatchType && mediaType != "application/json") {
+			w.Header().Set("Accept-Patch", mergePatchType)
+			writeProblem(w, http.StatusUnsupportedMediaType, "Content-Type must be "+mergePatchType)
+			return nil, false
+		}
+	}
+
+	var patch any
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeProblem(w, http.StatusBadRequest, "Invalid request payload")
+		return nil, false
+	}
+	object, ok := patch.(map[string]any)
+	if !ok {
+		writeProblem(w, http.StatusBadRequest, "Merge patch must be a JSON object")
+		return nil, false
+	}
+	return object, true
+}
+
+// applyMergePatch merges patch into the JSON form of entity and decodes the
+// result as a new entity. Members set to null are reset to their zero value.
+func applyMergePatch[T any](entity T, patch map[string]any) (T, error) {
+	var merged T
+	data, err := json.Marshal(entity)
+	if err != nil {
+		return merged, err
+	}
+	var document any
+	if err := json.Unmarshal(data, &document); err != nil {
+		return merged, err
+	}
+	if data, err = json.Marshal(mergePatch(document, patch)); err != nil {
+		return merged, err
+	}
+	err = json.Unmarshal(data, &merged)
+	return merged, err
+}
+
+// mergePatch implements the MergePatch function of RFC 7396 on decoded JSON.
+func mergePatch(target, patch any) any {
+	patchObject, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObject, ok := target.(map[string]any)
+	if !ok {
+		targetObject = map[string]any{}
+	}
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+		} else {
+			targetObject[name] = mergePatch(targetObject[name], value)
+		}
+	}
+	return targetObject
+}
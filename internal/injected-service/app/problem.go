@@ -0,0 +1,70 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"libary-service/internal/domain"
+	"libary-service/internal/injected-service/validation"
+	"net/http"
+)
+
+// Problem is an RFC 7807 problem details response body.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Errors lists the invalid or conflicting fields of a request body.
+	Errors []validation.FieldError `json:"errors,omitempty"`
+	// LendingIDs lists the active lendings that keep a book or user from being deleted.
+	LendingIDs []string `json:"lending_ids,omitempty"`
+}
+
+// writeProblem answers a request with an application/problem+json body. The
+// problem type is about:blank, so the title is the text of the status code.
+func writeProblem(w http.ResponseWriter, status int, detail string) {
+	encodeProblem(w, Problem{Type: "about:blank", Title: http.StatusText(status), Status: status, Detail: detail})
+}
+
+// writeValidationProblem answers a request whose body failed validation and
+// lists the offending fields when the error carries them. A field that
+// conflicts with another entity is answered with 409 instead of 422. Any other
+// error means the body could not be checked, which is answered like a failed
+// repository call.
+func writeValidationProblem(w http.ResponseWriter, err error) {
+	var conflict validation.Conflict
+	if errors.As(err, &conflict) {
+		encodeProblem(w, Problem{
+			Type:   "about:blank",
+			Title:  http.StatusText(http.StatusConflict),
+			Status: http.StatusConflict,
+			Detail: sentence(conflict),
+			Errors: []validation.FieldError{validation.FieldError(conflict)},
+		})
+		return
+	}
+	if !errors.Is(err, domain.ErrValidation) {
+		repositoryError(w, err, "Error validating request")
+		return
+	}
+	problem := Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(http.StatusUnprocessableEntity),
+		Status: http.StatusUnprocessableEntity,
+		Detail: "The request body is invalid",
+	}
+	var fieldErrors validation.Errors
+	if errors.As(err, &fieldErrors) {
+		problem.Errors = fieldErrors
+	} else {
+		problem.Detail = err.Error()
+	}
+	encodeProblem(w, problem)
+}
+
+func encodeProblem(w http.ResponseWriter, problem Problem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
@@ -0,0 +1,188 @@
+package config
+
+import (
+	"fmt"
+	"libary-service/internal/domain"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const day = 24 * time.Hour
+
+// Category holds the borrowing privileges of a patron category.
+type Category struct {
+	// MaxLoans is how many lendings a patron may have out at the same time.
+	MaxLoans int
+	// LoanPeriod is the time between lending a copy and its due date.
+	LoanPeriod time.Duration
+}
+
+// Backend names a repository implementation the service can run on.
+type Backend string
+
+const (
+	BackendPostgres Backend = "postgres"
+	BackendSQLite   Backend = "sqlite"
+	BackendMemory   Backend = "memory"
+)
+
+// Config holds the library policies of the injected service.
+type Config struct {
+	// Backend is the repository the service keeps its data in.
+	Backend Backend
+	// Categories holds the borrowing privileges of every patron category.
+	Categories map[domain.UserCategory]Category
+	// FineThreshold refuses new lendings once a patron owes more than this in unpaid fines, in cents.
+	FineThreshold int64
+	// MaxRenewals is how often a single lending may be renewed.
+	MaxRenewals int
+	// FinePerDay is charged for every started day a lending is returned late, in cents.
+	FinePerDay int64
+	// FineCap is the most a single overdue fine can add up to, in cents. Zero disables the cap.
+	FineCap int64
+	// LostItemFee is charged when a lent copy is reported lost, in cents.
+	LostItemFee int64
+	// HoldPickupWindow is how long a copy stays set aside for a ready hold.
+	HoldPickupWindow time.Duration
+	// RequestTimeout is the deadline for handling a single request. Zero disables it.
+	RequestTimeout time.Duration
+	// DeletedRetention is how long deleted rows can still be restored before a purge removes them.
+	DeletedRetention time.Duration
+	// AdminToken is the bearer token admin requests have to present. Empty disables admin requests.
+	AdminToken string
+}
+
+// Default returns the policies used when nothing is configured.
+func Default() Config {
+	return Config{
+		Backend: BackendPostgres,
+		Categories: map[domain.UserCategory]Category{
+			domain.UserCategoryStudent: {MaxLoans: 5, LoanPeriod: 21 * day},
+			domain.UserCategoryStaff:   {MaxLoans: 10, LoanPeriod: 42 * day},
+			domain.UserCategoryGuest:   {MaxLoans: 2, LoanPeriod: 14 * day},
+		},
+		FineThreshold:    1000,
+		MaxRenewals:      2,
+		FinePerDay:       50,
+		FineCap:          1000,
+		LostItemFee:      2500,
+		HoldPickupWindow: 7 * day,
+		RequestTimeout:   10 * time.Second,
+		DeletedRetention: 30 * day,
+	}
+}
+
+// FromEnv starts from Default and overrides every value that is set in the environment.
+func FromEnv() (Config, error) {
+	cfg := Default()
+	if err := backendFromEnv("REPOSITORY_BACKEND", &cfg.Backend); err != nil {
+		return Config{}, err
+	}
+	for name, category := range cfg.Categories {
+		prefix := strings.ToUpper(string(name))
+		if err := intFromEnv(prefix+"_MAX_LOANS", &category.MaxLoans); err != nil {
+			return Config{}, err
+		}
+		if err := daysFromEnv(prefix+"_LOAN_PERIOD_DAYS", &category.LoanPeriod); err != nil {
+			return Config{}, err
+		}
+		cfg.Categories[name] = category
+	}
+	if err := int64FromEnv("FINE_THRESHOLD_CENTS", &cfg.FineThreshold); err != nil {
+		return Config{}, err
+	}
+	if err := intFromEnv("MAX_RENEWALS", &cfg.MaxRenewals); err != nil {
+		return Config{}, err
+	}
+	if err := int64FromEnv("FINE_PER_DAY_CENTS", &cfg.FinePerDay); err != nil {
+		return Config{}, err
+	}
+	if err := int64FromEnv("FINE_CAP_CENTS", &cfg.FineCap); err != nil {
+		return Config{}, err
+	}
+	if err := int64FromEnv("LOST_ITEM_FEE_CENTS", &cfg.LostItemFee); err != nil {
+		return Config{}, err
+	}
+	if err := daysFromEnv("HOLD_PICKUP_DAYS", &cfg.HoldPickupWindow); err != nil {
+		return Config{}, err
+	}
+	if err := durationFromEnv("REQUEST_TIMEOUT", &cfg.RequestTimeout); err != nil {
+		return Config{}, err
+	}
+	if err := daysFromEnv("DELETED_RETENTION_DAYS", &cfg.DeletedRetention); err != nil {
+		return Config{}, err
+	}
+	cfg.AdminToken = os.Getenv("ADMIN_TOKEN")
+	return cfg, nil
+}
+
+// OverdueFine is the amount charged for returning a lending daysOverdue days late.
+func (c Config) OverdueFine(daysOverdue int) int64 {
+	if daysOverdue <= 0 {
+		return 0
+	}
+	amount := int64(daysOverdue) * c.FinePerDay
+	if c.FineCap > 0 && amount > c.FineCap {
+		return c.FineCap
+	}
+	return amount
+}
+
+func backendFromEnv(key string, target *Backend) error {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return nil
+	}
+	switch backend := Backend(value); backend {
+	case BackendPostgres, BackendSQLite, BackendMemory:
+		*target = backend
+		return nil
+	}
+	return fmt.Errorf("%s must be one of postgres, sqlite or memory, got %q", key, value)
+}
+
+func intFromEnv(key string, target *int) error {
+	value := int64(*target)
+	if err := int64FromEnv(key, &value); err != nil {
+		return err
+	}
+	*target = int(value)
+	return nil
+}
+
+func daysFromEnv(key string, target *time.Duration) error {
+	days := int(*target / day)
+	if err := intFromEnv(key, &days); err != nil {
+		return err
+	}
+	*target = time.Duration(days) * day
+	return nil
+}
+
+func durationFromEnv(key string, target *time.Duration) error {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed < 0 {
+		return fmt.Errorf("%s must be a non-negative duration such as 10s, got %q", key, value)
+	}
+	*target = parsed
+	return nil
+}
+
+func int64FromEnv(key string, target *int64) error {
+	value, ok := os.LookupEnv(key)
+	if !ok || value == "" {
+		return nil
+	}
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || parsed < 0 {
+		return fmt.Errorf("%s must be a non-negative integer, got %q", key, value)
+	}
+	*target = parsed
+	return nil
+}
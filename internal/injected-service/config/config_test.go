@@ -0,0 +1,81 @@
+package config
+
+import (
+	"libary-service/internal/domain"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFromEnv(t *testing.T) {
+	testCases := []struct {
+		name          string
+		env           map[string]string
+		expected      func(cfg *Config)
+		expectedError bool
+	}{
+		{"defaults", map[string]string{}, func(cfg *Config) {}, false},
+		{"max renewals", map[string]string{"MAX_RENEWALS": "5"}, func(cfg *Config) { cfg.MaxRenewals = 5 }, false},
+		{"category limits", map[string]string{"STUDENT_MAX_LOANS": "3", "GUEST_LOAN_PERIOD_DAYS": "7"}, func(cfg *Config) {
+			cfg.Categories[domain.UserCategoryStudent] = Category{MaxLoans: 3, LoanPeriod: 21 * day}
+			cfg.Categories[domain.UserCategoryGuest] = Category{MaxLoans: 2, LoanPeriod: 7 * day}
+		}, false},
+		{"fine threshold", map[string]string{"FINE_THRESHOLD_CENTS": "0"}, func(cfg *Config) { cfg.FineThreshold = 0 }, false},
+		{"fines", map[string]string{"FINE_PER_DAY_CENTS": "25", "FINE_CAP_CENTS": "0", "LOST_ITEM_FEE_CENTS": "4000"}, func(cfg *Config) {
+			cfg.FinePerDay = 25
+			cfg.FineCap = 0
+			cfg.LostItemFee = 4000
+		}, false},
+		{"hold pickup window", map[string]string{"HOLD_PICKUP_DAYS": "3"}, func(cfg *Config) { cfg.HoldPickupWindow = 3 * day }, false},
+		{"request timeout", map[string]string{"REQUEST_TIMEOUT": "2.5s"}, func(cfg *Config) { cfg.RequestTimeout = 2500 * time.Millisecond }, false},
+		{"deleted retention", map[string]string{"DELETED_RETENTION_DAYS": "90"}, func(cfg *Config) { cfg.DeletedRetention = 90 * day }, false},
+		{"admin token", map[string]string{"ADMIN_TOKEN": "secret"}, func(cfg *Config) { cfg.AdminToken = "secret" }, false},
+		{"backend", map[string]string{"REPOSITORY_BACKEND": "sqlite"}, func(cfg *Config) { cfg.Backend = BackendSQLite }, false},
+		{"unknown backend", map[string]string{"REPOSITORY_BACKEND": "mysql"}, nil, true},
+		{"invalid request timeout", map[string]string{"REQUEST_TIMEOUT": "10"}, nil, true},
+		{"invalid loan period", map[string]string{"STAFF_LOAN_PERIOD_DAYS": "two weeks"}, nil, true},
+		{"negative max loans", map[string]string{"GUEST_MAX_LOANS": "-1"}, nil, true},
+		{"invalid max renewals", map[string]string{"MAX_RENEWALS": "many"}, nil, true},
+		{"negative max renewals", map[string]string{"MAX_RENEWALS": "-1"}, nil, true},
+		{"fractional fine", map[string]string{"FINE_PER_DAY_CENTS": "0.5"}, nil, true},
+		{"negative fine cap", map[string]string{"FINE_CAP_CENTS": "-100"}, nil, true},
+		{"invalid deleted retention", map[string]string{"DELETED_RETENTION_DAYS": "forever"}, nil, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for key, value := range tc.env {
+				t.Setenv(key, value)
+			}
+			cfg, err := FromEnv()
+			if tc.expectedError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				expected := Default()
+				tc.expected(&expected)
+				assert.Equal(t, expected, cfg)
+			}
+		})
+	}
+}
+
+func TestOverdueFine(t *testing.T) {
+	testCases := []struct {
+		name        string
+		cfg         Config
+		daysOverdue int
+		expected    int64
+	}{
+		{"not overdue", Default(), 0, 0},
+		{"one day", Default(), 1, 50},
+		{"below cap", Default(), 19, 950},
+		{"capped", Default(), 30, 1000},
+		{"no cap", Config{FinePerDay: 50}, 30, 1500},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.cfg.OverdueFine(tc.daysOverdue))
+		})
+	}
+}
@@ -1,180 +1,1094 @@
 package inmemoryrepository
 
 import (
-	"errors"
+	"context"
+	"fmt"
 	"libary-service/internal/domain"
+	"libary-service/internal/injected-service/repository"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 type InMemoryRepository struct {
 	mu       sync.Mutex
 	books    map[string]domain.Book
+	copies   map[string]domain.BookCopy
 	users    map[string]domain.User
 	lendings map[string]domain.Lending
+	fines    map[string]domain.Fine
+	holds    map[string]domain.Hold
+	search   searchIndex
+	// store is set while the repository persists its data, and journal
+	// collects the rows the running write changes for its log. Transactions
+	// always have a journal, it also undoes them when they fail.
+	store   *store
+	journal *journal
 }
 
 func New() *InMemoryRepository {
 	return &InMemoryRepository{
 		books:    make(map[string]domain.Book),
+		copies:   make(map[string]domain.BookCopy),
 		users:    make(map[string]domain.User),
 		lendings: make(map[string]domain.Lending),
+		fines:    make(map[string]domain.Fine),
+		holds:    make(map[string]domain.Hold),
+		search:   make(searchIndex),
 	}
 }
 
-func (repo *InMemoryRepository) Connect() error {
+// Connect restores the data kept in the directory named by MEMORY_DATA_DIR
+// and persists every later write there. Without it the data only lives in
+// memory. MEMORY_SNAPSHOT_EVERY sets how many writes are logged before the
+// log is compacted into a new snapshot.
+func (repo *InMemoryRepository) Connect(ctx context.Context) error {
+	dir := os.Getenv("MEMORY_DATA_DIR")
+	if dir == "" {
+		return nil
+	}
+	snapshotEvery := defaultSnapshotEvery
+	if value := os.Getenv("MEMORY_SNAPSHOT_EVERY"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			return fmt.Errorf("MEMORY_SNAPSHOT_EVERY must be a positive integer, got %q", value)
+		}
+		snapshotEvery = parsed
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if err := repo.open(dir, snapshotEvery); err != nil {
+		return err
+	}
+	log.Printf("Successfully restored data from %s", dir)
 	return nil
 }
 
-func (repo *InMemoryRepository) Disconnect() error {
+// Disconnect takes a last snapshot of a persistent repository.
+func (repo *InMemoryRepository) Disconnect(ctx context.Context) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+	if repo.store == nil {
+		return nil
+	}
+	err := repo.store.close(repo.snapshot())
+	repo.store = nil
+	repo.journal = nil
+	return err
+}
+
+// WithTx runs fn on the data itself and undoes the rows fn changed when it
+// fails, so a failed transaction leaves nothing behind and only costs as much
+// as it writes. The repository stays locked until fn returns: fn must only use
+// the repository it is given, and transactions run one at a time. A persistent
+// repository logs all writes of the transaction as one line.
+func (repo *InMemoryRepository) WithTx(ctx context.Context, fn func(tx repository.Repository) error) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	tx := repo.begin()
+	if err := fn(tx); err != nil {
+		repo.rollback(tx.journal)
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		repo.rollback(tx.journal)
+		return err
+	}
+	if repo.store != nil {
+		if err := repo.store.append(tx.journal); err != nil {
+			repo.rollback(tx.journal)
+			return err
+		}
+		repo.compact()
+	} else if repo.journal != nil {
+		repo.journal.merge(tx.journal)
+	}
 	return nil
 }
 
-func (repo *InMemoryRepository) GetBooks() ([]domain.Book, error) {
+// begin returns a repository that works on the data of repo and journals the
+// rows it changes without logging them. The caller must hold repo.mu.
+func (repo *InMemoryRepository) begin() *InMemoryRepository {
+	return &InMemoryRepository{
+		journal:  newJournal(),
+		books:    repo.books,
+		copies:   repo.copies,
+		users:    repo.users,
+		lendings: repo.lendings,
+		fines:    repo.fines,
+		holds:    repo.holds,
+		search:   repo.search,
+	}
+}
+
+// rollback undoes the changes and puts the books among them back into the
+// search index as they were. The caller must hold repo.mu.
+func (repo *InMemoryRepository) rollback(changes *journal) {
+	for _, c := range changes.changes {
+		if c.table == "books" {
+			repo.search.remove(repo.books[c.id])
+		}
+	}
+	changes.undo()
+	for _, c := range changes.changes {
+		if book, ok := repo.books[c.id]; ok && c.table == "books" && book.DeletedAt == nil {
+			repo.search.add(book)
+		}
+	}
+}
+
+func (repo *InMemoryRepository) GetBooks(ctx context.Context, filter repository.BookFilter, options repository.ListOptions) (repository.Page[domain.Book], error) {
 	repo.mu.Lock()
 	defer repo.mu.Unlock()
 
 	books := make([]domain.Book, 0, len(repo.books))
 	for _, b := range repo.books {
+		if !filter.IncludeDeleted && b.DeletedAt != nil {
+			continue
+		}
+		if filter.Author != "" && b.Author != filter.Author && !slices.Contains(b.Authors, filter.Author) {
+			continue
+		}
+		if !strings.HasPrefix(b.Title, filter.TitlePrefix) {
+			continue
+		}
+		if filter.ISBN != "" && b.ISBN != filter.ISBN {
+			continue
+		}
 		books = append(books, b)
 	}
-	return books, nil
+	return repository.Paginate(books, options, "title", []string{"id", "title", "author"},
+		func(b domain.Book, field string) (string, string) {
+			switch field {
+			case "title":
+				return b.Title, b.ID
+			case "author":
+				return b.Author, b.ID
+			}
+			return b.ID, b.ID
+		})
 }
 
-func (repo *InMemoryRepository) GetBookByID(id string) (domain.Book, error) {
+func (repo *InMemoryRepository) GetBookByID(ctx context.Context, id string) (domain.Book, error) {
 	repo.mu.Lock()
 	defer repo.mu.Unlock()
 
-	if book, ok := repo.books[id]; ok {
+	if book, ok := repo.books[id]; ok && book.DeletedAt == nil {
 		return book, nil
 	}
-	return domain.Book{}, errors.New("book not found")
+	return domain.Book{}, repository.ErrBookNotFound
 }
 
-func (repo *InMemoryRepository) CreateBook(book domain.Book) (domain.Book, error) {
+func (repo *InMemoryRepository) CreateBook(ctx context.Context, book domain.Book) (domain.Book, error) {
 	repo.mu.Lock()
 	defer repo.mu.Unlock()
-	repo.books[book.ID] = book
+	if repo.isbnTaken(book.ISBN, book.ID) {
+		return domain.Book{}, repository.ErrISBNExists
+	}
+	if existing, ok := repo.books[book.ID]; ok {
+		repo.search.remove(existing)
+	}
+	book.DeletedAt = nil
+	book.Version = 1
+	put(repo.journal, repo.books, book.ID, book)
+	repo.search.add(book)
+	if err := repo.persist(); err != nil {
+		return domain.Book{}, err
+	}
 	return book, nil
 }
 
-func (repo *InMemoryRepository) UpdateBook(updated domain.Book) (domain.Book, error) {
+func (repo *InMemoryRepository) UpdateBook(ctx context.Context, updated domain.Book) (domain.Book, error) {
 	repo.mu.Lock()
 	defer repo.mu.Unlock()
 
-	if _, ok := repo.books[updated.ID]; !ok {
-		return domain.Book{}, errors.New("book not found")
+	current, ok := repo.books[updated.ID]
+	if !ok || current.DeletedAt != nil {
+		return domain.Book{}, repository.ErrBookNotFound
+	}
+	if current.Version != updated.Version {
+		return domain.Book{}, repository.ErrVersionMismatch
+	}
+	if repo.isbnTaken(updated.ISBN, updated.ID) {
+		return domain.Book{}, repository.ErrISBNExists
+	}
+	updated.DeletedAt = nil
+	updated.Version++
+	repo.search.remove(current)
+	repo.search.add(updated)
+	put(repo.journal, repo.books, updated.ID, updated)
+	if err := repo.persist(); err != nil {
+		return domain.Book{}, err
 	}
-	repo.books[updated.ID] = updated
 	return updated, nil
 }
 
-func (repo *InMemoryRepository) DeleteBook(id string) error {
+func (repo *InMemoryRepository) DeleteBook(ctx context.Context, id string, version int, deletedAt time.Time, force bool) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	book, ok := repo.books[id]
+	if !ok || book.DeletedAt != nil {
+		return repository.ErrBookNotFound
+	}
+	if book.Version != version {
+		return repository.ErrVersionMismatch
+	}
+	if !force {
+		if err := repo.checkNoActiveLendings("book", func(l domain.Lending) bool { return l.BookID == id }); err != nil {
+			return err
+		}
+	}
+	repo.search.remove(book)
+	book.DeletedAt = &deletedAt
+	put(repo.journal, repo.books, id, book)
+	repo.cancelHolds(func(h domain.Hold) bool { return h.BookID == id })
+	return repo.persist()
+}
+
+func (repo *InMemoryRepository) RestoreBook(ctx context.Context, id string) (domain.Book, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	book, ok := repo.books[id]
+	if !ok {
+		return domain.Book{}, repository.ErrBookNotFound
+	}
+	if book.DeletedAt == nil {
+		return book, nil
+	}
+	if repo.isbnTaken(book.ISBN, id) {
+		return domain.Book{}, repository.ErrISBNExists
+	}
+	book.DeletedAt = nil
+	put(repo.journal, repo.books, id, book)
+	repo.search.add(book)
+	if err := repo.persist(); err != nil {
+		return domain.Book{}, err
+	}
+	return book, nil
+}
+
+// purgeBook deletes a book with its copies, lendings and holds.
+// The caller must hold repo.mu.
+func (repo *InMemoryRepository) purgeBook(id string) {
+	remove(repo.journal, repo.books, id)
+	for copyID, c := range repo.copies {
+		if c.BookID == id {
+			remove(repo.journal, repo.copies, copyID)
+		}
+	}
+	repo.deleteLendings(func(l domain.Lending) bool { return l.BookID == id })
+	for holdID, h := range repo.holds {
+		if h.BookID == id {
+			remove(repo.journal, repo.holds, holdID)
+		}
+	}
+}
+
+func (repo *InMemoryRepository) GetBookCopies(ctx context.Context, bookID string) ([]domain.BookCopy, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	copies := make([]domain.BookCopy, 0)
+	for _, c := range repo.copies {
+		if c.BookID == bookID {
+			copies = append(copies, c)
+		}
+	}
+	return copies, nil
+}
+
+func (repo *InMemoryRepository) GetBookCopyByID(ctx context.Context, id string) (domain.BookCopy, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if c, ok := repo.copies[id]; ok {
+		return c, nil
+	}
+	return domain.BookCopy{}, repository.ErrBookCopyNotFound
+}
+
+func (repo *InMemoryRepository) CreateBookCopy(ctx context.Context, bookCopy domain.BookCopy) (domain.BookCopy, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if repo.barcodeTaken(bookCopy.Barcode, bookCopy.ID) {
+		return domain.BookCopy{}, repository.ErrBarcodeExists
+	}
+	if err := repo.checkReferences(references{bookID: bookCopy.BookID}); err != nil {
+		return domain.BookCopy{}, err
+	}
+	put(repo.journal, repo.copies, bookCopy.ID, bookCopy)
+	if err := repo.persist(); err != nil {
+		return domain.BookCopy{}, err
+	}
+	return bookCopy, nil
+}
+
+func (repo *InMemoryRepository) UpdateBookCopy(ctx context.Context, updated domain.BookCopy) (domain.BookCopy, error) {
 	repo.mu.Lock()
 	defer repo.mu.Unlock()
 
-	if _, ok := repo.books[id]; !ok {
-		return errors.New("book not found")
+	if _, ok := repo.copies[updated.ID]; !ok {
+		return domain.BookCopy{}, repository.ErrBookCopyNotFound
+	}
+	if repo.barcodeTaken(updated.Barcode, updated.ID) {
+		return domain.BookCopy{}, repository.ErrBarcodeExists
+	}
+	if err := repo.checkReferences(references{bookID: updated.BookID}); err != nil {
+		return domain.BookCopy{}, err
+	}
+	put(repo.journal, repo.copies, updated.ID, updated)
+	if err := repo.persist(); err != nil {
+		return domain.BookCopy{}, err
+	}
+	return updated, nil
+}
+
+func (repo *InMemoryRepository) DeleteBookCopy(ctx context.Context, id string) error {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.copies[id]; !ok {
+		return repository.ErrBookCopyNotFound
+	}
+	if err := repo.checkNoActiveLendings("copy", func(l domain.Lending) bool { return l.CopyID == id }); err != nil {
+		return err
+	}
+	remove(repo.journal, repo.copies, id)
+	for lendingID, l := range repo.lendings {
+		if l.CopyID == id {
+			l.CopyID = ""
+			put(repo.journal, repo.lendings, lendingID, l)
+		}
+	}
+	for holdID, h := range repo.holds {
+		if h.CopyID == id {
+			h.CopyID = ""
+			put(repo.journal, repo.holds, holdID, h)
+		}
+	}
+	return repo.persist()
+}
+
+// isbnTaken reports whether another book already uses the ISBN. Books without
+// an ISBN and deleted books never clash. The caller must hold repo.mu.
+func (repo *InMemoryRepository) isbnTaken(isbn, exceptID string) bool {
+	if isbn == "" {
+		return false
+	}
+	for id, b := range repo.books {
+		if id != exceptID && b.ISBN == isbn && b.DeletedAt == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// barcodeTaken reports whether another copy already uses the barcode.
+// The caller must hold repo.mu.
+func (repo *InMemoryRepository) barcodeTaken(barcode, exceptID string) bool {
+	for id, c := range repo.copies {
+		if id != exceptID && c.Barcode == barcode {
+			return true
+		}
+	}
+	return false
+}
+
+// references names the rows a write points at. Empty IDs point at nothing.
+type references struct {
+	bookID, copyID, userID, lendingID string
+}
+
+// checkReferences fails with the not-found error of the first referenced row
+// that does not exist, like a foreign key would. The caller must hold repo.mu.
+func (repo *InMemoryRepository) checkReferences(refs references) error {
+	if _, ok := repo.books[refs.bookID]; refs.bookID != "" && !ok {
+		return repository.ErrBookNotFound
+	}
+	if _, ok := repo.copies[refs.copyID]; refs.copyID != "" && !ok {
+		return repository.ErrBookCopyNotFound
+	}
+	if _, ok := repo.users[refs.userID]; refs.userID != "" && !ok {
+		return repository.ErrUserNotFound
+	}
+	if _, ok := repo.lendings[refs.lendingID]; refs.lendingID != "" && !ok {
+		return repository.ErrLendingNotFound
+	}
+	return nil
+}
+
+// deleteLendings deletes the lendings matched by drop and unlinks their fines.
+// The caller must hold repo.mu.
+func (repo *InMemoryRepository) deleteLendings(drop func(domain.Lending) bool) {
+	for id, l := range repo.lendings {
+		if drop(l) {
+			remove(repo.journal, repo.lendings, id)
+			repo.unlinkFines(id)
+		}
+	}
+}
+
+// checkNoActiveLendings fails with an ActiveLendingsError when any of the
+// lendings matched by owns is still out. The caller must hold repo.mu.
+func (repo *InMemoryRepository) checkNoActiveLendings(entity string, owns func(domain.Lending) bool) error {
+	var ids []string
+	for id, l := range repo.lendings {
+		if owns(l) && l.ReturnDate.IsZero() && l.DeletedAt == nil {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	sort.Strings(ids)
+	return &repository.ActiveLendingsError{Entity: entity, LendingIDs: ids}
+}
+
+// unlinkFines keeps the fines of a deleted lending without pointing at it.
+// The caller must hold repo.mu.
+func (repo *InMemoryRepository) unlinkFines(lendingID string) {
+	for fineID, f := range repo.fines {
+		if f.LendingID == lendingID {
+			f.LendingID = ""
+			put(repo.journal, repo.fines, fineID, f)
+		}
+	}
+}
+
+// emailTaken reports whether another user already uses the email, in any case.
+// Deleted users never clash. The caller must hold repo.mu.
+func (repo *InMemoryRepository) emailTaken(email, exceptID string) bool {
+	for id, u := range repo.users {
+		if id != exceptID && strings.EqualFold(u.Email, email) && u.DeletedAt == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// availableCopy picks the available copy of a book with the lowest barcode.
+// The caller must hold repo.mu.
+func (repo *InMemoryRepository) availableCopy(bookID string) (string, bool) {
+	var picked domain.BookCopy
+	for _, c := range repo.copies {
+		if c.BookID != bookID || c.Status != domain.CopyStatusAvailable {
+			continue
+		}
+		if picked.ID == "" || c.Barcode < picked.Barcode {
+			picked = c
+		}
+	}
+	return picked.ID, picked.ID != ""
+}
+
+// checkCopyAvailable fails if the copy is not on the shelf or another active
+// lending already holds it. The caller must hold repo.mu.
+func (repo *InMemoryRepository) checkCopyAvailable(copyID, lendingID string) error {
+	c, ok := repo.copies[copyID]
+	if !ok {
+		return repository.ErrBookCopyNotFound
+	}
+	if c.Status != domain.CopyStatusAvailable {
+		return repository.ErrCopyUnavailable
+	}
+	for _, l := range repo.lendings {
+		if l.ID != lendingID && l.CopyID == copyID && l.ReturnDate.IsZero() && l.DeletedAt == nil {
+			return repository.ErrCopyUnavailable
+		}
 	}
-	delete(repo.books, id)
 	return nil
 }
 
-func (repo *InMemoryRepository) GetUsers() ([]domain.User, error) {
+// setCopyStatus keeps the status of the copy referenced by a lending in sync.
+// The caller must hold repo.mu.
+func (repo *InMemoryRepository) setCopyStatus(copyID string, status domain.CopyStatus) {
+	if c, ok := repo.copies[copyID]; ok {
+		c.Status = status
+		put(repo.journal, repo.copies, copyID, c)
+	}
+}
+
+// readyHold finds the ready hold of the lending's user whose copy can be
+// handed out. The caller must hold repo.mu.
+func (repo *InMemoryRepository) readyHold(lending domain.Lending) (domain.Hold, bool) {
+	for _, h := range repo.holds {
+		if h.Status == domain.HoldStatusReady && h.BookID == lending.BookID && h.UserID == lending.UserID &&
+			h.CopyID != "" && (lending.CopyID == "" || lending.CopyID == h.CopyID) {
+			return h, true
+		}
+	}
+	return domain.Hold{}, false
+}
+
+func (repo *InMemoryRepository) GetUsers(ctx context.Context, filter repository.UserFilter, options repository.ListOptions) (repository.Page[domain.User], error) {
 	repo.mu.Lock()
 	defer repo.mu.Unlock()
 
 	users := make([]domain.User, 0, len(repo.users))
 	for _, u := range repo.users {
+		if !filter.IncludeDeleted && u.DeletedAt != nil {
+			continue
+		}
+		if filter.Category != "" && u.Category != filter.Category {
+			continue
+		}
+		if filter.Email != "" && !strings.EqualFold(u.Email, filter.Email) {
+			continue
+		}
 		users = append(users, u)
 	}
-	return users, nil
+	return repository.Paginate(users, options, "name", []string{"id", "name", "email"},
+		func(u domain.User, field string) (string, string) {
+			switch field {
+			case "name":
+				return u.Name, u.ID
+			case "email":
+				return u.Email, u.ID
+			}
+			return u.ID, u.ID
+		})
 }
 
-func (repo *InMemoryRepository) GetUserByID(id string) (domain.User, error) {
+func (repo *InMemoryRepository) GetUserByID(ctx context.Context, id string) (domain.User, error) {
 	repo.mu.Lock()
 	defer repo.mu.Unlock()
 
-	if user, ok := repo.users[id]; ok {
+	if user, ok := repo.users[id]; ok && user.DeletedAt == nil {
 		return user, nil
 	}
-	return domain.User{}, errors.New("user not found")
+	return domain.User{}, repository.ErrUserNotFound
+}
+
+// LockUser only reads the user, transactions run one at a time anyway.
+func (repo *InMemoryRepository) LockUser(ctx context.Context, id string) (domain.User, error) {
+	return repo.GetUserByID(ctx, id)
 }
 
-func (repo *InMemoryRepository) CreateUser(user domain.User) (domain.User, error) {
+func (repo *InMemoryRepository) CreateUser(ctx context.Context, user domain.User) (domain.User, error) {
 	repo.mu.Lock()
 	defer repo.mu.Unlock()
-	repo.users[user.ID] = user
+
+	if repo.emailTaken(user.Email, user.ID) {
+		return domain.User{}, repository.ErrEmailExists
+	}
+	user.DeletedAt = nil
+	user.Version = 1
+	put(repo.journal, repo.users, user.ID, user)
+	if err := repo.persist(); err != nil {
+		return domain.User{}, err
+	}
 	return user, nil
 }
 
-func (repo *InMemoryRepository) UpdateUser(updated domain.User) (domain.User, error) {
+func (repo *InMemoryRepository) UpdateUser(ctx context.Context, updated domain.User) (domain.User, error) {
 	repo.mu.Lock()
 	defer repo.mu.Unlock()
 
-	if _, ok := repo.users[updated.ID]; !ok {
-		return domain.User{}, errors.New("user not found")
+	current, ok := repo.users[updated.ID]
+	if !ok || current.DeletedAt != nil {
+		return domain.User{}, repository.ErrUserNotFound
+	}
+	if current.Version != updated.Version {
+		return domain.User{}, repository.ErrVersionMismatch
+	}
+	if repo.emailTaken(updated.Email, updated.ID) {
+		return domain.User{}, repository.ErrEmailExists
+	}
+	updated.DeletedAt = nil
+	updated.Version++
+	put(repo.journal, repo.users, updated.ID, updated)
+	if err := repo.persist(); err != nil {
+		return domain.User{}, err
 	}
-	repo.users[updated.ID] = updated
 	return updated, nil
 }
 
-func (repo *InMemoryRepository) DeleteUser(id string) error {
+func (repo *InMemoryRepository) DeleteUser(ctx context.Context, id string, version int, deletedAt time.Time, force bool) error {
 	repo.mu.Lock()
 	defer repo.mu.Unlock()
 
-	if _, ok := repo.users[id]; !ok {
-		return errors.New("user not found")
+	user, ok := repo.users[id]
+	if !ok || user.DeletedAt != nil {
+		return repository.ErrUserNotFound
 	}
-	delete(repo.users, id)
-	return nil
+	if user.Version != version {
+		return repository.ErrVersionMismatch
+	}
+	if !force {
+		if err := repo.checkNoActiveLendings("user", func(l domain.Lending) bool { return l.UserID == id }); err != nil {
+			return err
+		}
+	}
+	user.DeletedAt = &deletedAt
+	put(repo.journal, repo.users, id, user)
+	repo.cancelHolds(func(h domain.Hold) bool { return h.UserID == id })
+	return repo.persist()
 }
 
-func (repo *InMemoryRepository) GetLendings() ([]domain.Lending, error) {
+func (repo *InMemoryRepository) RestoreUser(ctx context.Context, id string) (domain.User, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	user, ok := repo.users[id]
+	if !ok {
+		return domain.User{}, repository.ErrUserNotFound
+	}
+	if user.DeletedAt == nil {
+		return user, nil
+	}
+	if repo.emailTaken(user.Email, id) {
+		return domain.User{}, repository.ErrEmailExists
+	}
+	user.DeletedAt = nil
+	put(repo.journal, repo.users, id, user)
+	if err := repo.persist(); err != nil {
+		return domain.User{}, err
+	}
+	return user, nil
+}
+
+// purgeUser deletes a user with their lendings, fines and holds.
+// The caller must hold repo.mu.
+func (repo *InMemoryRepository) purgeUser(id string) {
+	remove(repo.journal, repo.users, id)
+	repo.deleteLendings(func(l domain.Lending) bool { return l.UserID == id })
+	for fineID, f := range repo.fines {
+		if f.UserID == id {
+			remove(repo.journal, repo.fines, fineID)
+		}
+	}
+	for holdID, h := range repo.holds {
+		if h.UserID == id {
+			remove(repo.journal, repo.holds, holdID)
+		}
+	}
+}
+
+func (repo *InMemoryRepository) GetLendings(ctx context.Context, filter repository.LendingFilter, options repository.ListOptions) (repository.Page[domain.Lending], error) {
 	repo.mu.Lock()
 	defer repo.mu.Unlock()
 
 	lendings := make([]domain.Lending, 0, len(repo.lendings))
 	for _, l := range repo.lendings {
+		if !filter.IncludeDeleted && l.DeletedAt != nil {
+			continue
+		}
+		if filter.UserID != "" && l.UserID != filter.UserID {
+			continue
+		}
+		if filter.BookID != "" && l.BookID != filter.BookID {
+			continue
+		}
+		if filter.Active != nil && *filter.Active != l.ReturnDate.IsZero() {
+			continue
+		}
+		if filter.Overdue != nil && *filter.Overdue != l.IsOverdue(filter.Now) {
+			continue
+		}
 		lendings = append(lendings, l)
 	}
-	return lendings, nil
+	return repository.Paginate(lendings, options, "lend_date", []string{"id", "lend_date"},
+		func(l domain.Lending, field string) (string, string) {
+			if field == "lend_date" {
+				return repository.SortableTime(l.LendDate), l.ID
+			}
+			return l.ID, l.ID
+		})
 }
 
-func (repo *InMemoryRepository) GetLendingByID(id string) (domain.Lending, error) {
+func (repo *InMemoryRepository) GetLendingByID(ctx context.Context, id string) (domain.Lending, error) {
 	repo.mu.Lock()
 	defer repo.mu.Unlock()
 
-	if lending, ok := repo.lendings[id]; ok {
+	if lending, ok := repo.lendings[id]; ok && lending.DeletedAt == nil {
 		return lending, nil
 	}
-	return domain.Lending{}, errors.New("lending not found")
+	return domain.Lending{}, repository.ErrLendingNotFound
+}
+
+func (repo *InMemoryRepository) CountActiveLendings(ctx context.Context, userID string) (int, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	count := 0
+	for _, l := range repo.lendings {
+		if l.UserID == userID && l.ReturnDate.IsZero() && l.DeletedAt == nil {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (repo *InMemoryRepository) GetOverdueLendings(ctx context.Context, now time.Time) ([]domain.Lending, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	lendings := make([]domain.Lending, 0)
+	for _, l := range repo.lendings {
+		if l.IsOverdue(now) && l.DeletedAt == nil {
+			lendings = append(lendings, l)
+		}
+	}
+	sort.Slice(lendings, func(i, j int) bool {
+		return lendings[i].DueDate.Before(lendings[j].DueDate)
+	})
+	return lendings, nil
 }
 
-func (repo *InMemoryRepository) CreateLending(lending domain.Lending) (domain.Lending, error) {
+func (repo *InMemoryRepository) CreateLending(ctx context.Context, lending domain.Lending) (domain.Lending, error) {
 	repo.mu.Lock()
 	defer repo.mu.Unlock()
-	repo.lendings[lending.ID] = lending
+
+	var hold domain.Hold
+	var held bool
+	if lending.ReturnDate.IsZero() {
+		if hold, held = repo.readyHold(lending); held {
+			lending.CopyID = hold.CopyID
+		} else if lending.CopyID == "" {
+			copyID, ok := repo.availableCopy(lending.BookID)
+			if !ok {
+				return domain.Lending{}, repository.ErrCopyUnavailable
+			}
+			lending.CopyID = copyID
+		} else if err := repo.checkCopyAvailable(lending.CopyID, lending.ID); err != nil {
+			return domain.Lending{}, err
+		}
+	}
+	if err := repo.checkReferences(references{bookID: lending.BookID, copyID: lending.CopyID, userID: lending.UserID}); err != nil {
+		return domain.Lending{}, err
+	}
+	if held {
+		hold.Status = domain.HoldStatusFulfilled
+		put(repo.journal, repo.holds, hold.ID, hold)
+	}
+	lending.DeletedAt = nil
+	lending.Version = 1
+	put(repo.journal, repo.lendings, lending.ID, lending)
+	repo.setCopyStatus(lending.CopyID, domain.CopyStatusOnLoan)
+	if err := repo.persist(); err != nil {
+		return domain.Lending{}, err
+	}
 	return lending, nil
 }
 
-func (repo *InMemoryRepository) UpdateLending(updated domain.Lending) (domain.Lending, error) {
+func (repo *InMemoryRepository) UpdateLending(ctx context.Context, updated domain.Lending) (domain.Lending, error) {
 	repo.mu.Lock()
 	defer repo.mu.Unlock()
 
-	if _, ok := repo.lendings[updated.ID]; !ok {
-		return domain.Lending{}, errors.New("lending not found")
+	stored, ok := repo.lendings[updated.ID]
+	if !ok || stored.DeletedAt != nil {
+		return domain.Lending{}, repository.ErrLendingNotFound
+	}
+	if stored.Version != updated.Version {
+		return domain.Lending{}, repository.ErrVersionMismatch
+	}
+	updated.DeletedAt = nil
+	reopened := updated.ReturnDate.IsZero() && (!stored.ReturnDate.IsZero() || stored.CopyID != updated.CopyID)
+	if reopened && updated.CopyID != "" {
+		if err := repo.checkCopyAvailable(updated.CopyID, updated.ID); err != nil {
+			return domain.Lending{}, err
+		}
+	}
+	if err := repo.checkReferences(references{bookID: updated.BookID, copyID: updated.CopyID, userID: updated.UserID}); err != nil {
+		return domain.Lending{}, err
+	}
+	updated.Version++
+	put(repo.journal, repo.lendings, updated.ID, updated)
+	if stored.CopyID != updated.CopyID && stored.ReturnDate.IsZero() && repo.copies[stored.CopyID].Status == domain.CopyStatusOnLoan {
+		repo.setCopyStatus(stored.CopyID, domain.CopyStatusAvailable)
+	}
+	if updated.ReturnDate.IsZero() {
+		repo.setCopyStatus(updated.CopyID, domain.CopyStatusOnLoan)
+	} else if repo.copies[updated.CopyID].Status == domain.CopyStatusOnLoan {
+		repo.setCopyStatus(updated.CopyID, domain.CopyStatusAvailable)
+	}
+	if err := repo.persist(); err != nil {
+		return domain.Lending{}, err
 	}
-	repo.lendings[updated.ID] = updated
 	return updated, nil
 }
 
-func (repo *InMemoryRepository) DeleteLending(id string) error {
+func (repo *InMemoryRepository) DeleteLending(ctx context.Context, id string, version int, deletedAt time.Time) error {
 	repo.mu.Lock()
 	defer repo.mu.Unlock()
 
-	if _, ok := repo.lendings[id]; !ok {
-		return errors.New("lending not found")
+	lending, ok := repo.lendings[id]
+	if !ok || lending.DeletedAt != nil {
+		return repository.ErrLendingNotFound
+	}
+	if lending.Version != version {
+		return repository.ErrVersionMismatch
+	}
+	lending.DeletedAt = &deletedAt
+	put(repo.journal, repo.lendings, id, lending)
+	if lending.ReturnDate.IsZero() && repo.copies[lending.CopyID].Status == domain.CopyStatusOnLoan {
+		repo.setCopyStatus(lending.CopyID, domain.CopyStatusAvailable)
+	}
+	return repo.persist()
+}
+
+func (repo *InMemoryRepository) RestoreLending(ctx context.Context, id string) (domain.Lending, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	lending, ok := repo.lendings[id]
+	if !ok {
+		return domain.Lending{}, repository.ErrLendingNotFound
+	}
+	if lending.DeletedAt == nil {
+		return lending, nil
+	}
+	active := lending.ReturnDate.IsZero() && lending.CopyID != ""
+	if active {
+		if err := repo.checkCopyAvailable(lending.CopyID, id); err != nil {
+			return domain.Lending{}, err
+		}
+	}
+	lending.DeletedAt = nil
+	put(repo.journal, repo.lendings, id, lending)
+	if active {
+		repo.setCopyStatus(lending.CopyID, domain.CopyStatusOnLoan)
+	}
+	if err := repo.persist(); err != nil {
+		return domain.Lending{}, err
+	}
+	return lending, nil
+}
+
+func (repo *InMemoryRepository) PurgeDeleted(ctx context.Context, before time.Time) (domain.PurgeReport, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	var report domain.PurgeReport
+	for id, l := range repo.lendings {
+		if deletedBefore(l.DeletedAt, before) {
+			remove(repo.journal, repo.lendings, id)
+			repo.unlinkFines(id)
+			report.Lendings++
+		}
+	}
+	for id, u := range repo.users {
+		if deletedBefore(u.DeletedAt, before) {
+			repo.purgeUser(id)
+			report.Users++
+		}
+	}
+	for id, b := range repo.books {
+		if deletedBefore(b.DeletedAt, before) {
+			repo.purgeBook(id)
+			report.Books++
+		}
+	}
+	if err := repo.persist(); err != nil {
+		return domain.PurgeReport{}, err
+	}
+	return report, nil
+}
+
+// deletedBefore reports whether a row was deleted before the cutoff.
+func deletedBefore(deletedAt *time.Time, before time.Time) bool {
+	return deletedAt != nil && deletedAt.Before(before)
+}
+
+func (repo *InMemoryRepository) GetFinesByUserID(ctx context.Context, userID string) ([]domain.Fine, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	fines := make([]domain.Fine, 0)
+	for _, f := range repo.fines {
+		if f.UserID == userID {
+			fines = append(fines, f)
+		}
+	}
+	sort.Slice(fines, func(i, j int) bool {
+		return fines[i].CreatedAt.Before(fines[j].CreatedAt)
+	})
+	return fines, nil
+}
+
+func (repo *InMemoryRepository) GetFineByID(ctx context.Context, id string) (domain.Fine, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if fine, ok := repo.fines[id]; ok {
+		return fine, nil
+	}
+	return domain.Fine{}, repository.ErrFineNotFound
+}
+
+func (repo *InMemoryRepository) CreateFine(ctx context.Context, fine domain.Fine) (domain.Fine, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if err := repo.checkReferences(references{userID: fine.UserID, lendingID: fine.LendingID}); err != nil {
+		return domain.Fine{}, err
+	}
+	put(repo.journal, repo.fines, fine.ID, fine)
+	if err := repo.persist(); err != nil {
+		return domain.Fine{}, err
+	}
+	return fine, nil
+}
+
+func (repo *InMemoryRepository) UpdateFine(ctx context.Context, updated domain.Fine) (domain.Fine, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if _, ok := repo.fines[updated.ID]; !ok {
+		return domain.Fine{}, repository.ErrFineNotFound
+	}
+	if err := repo.checkReferences(references{userID: updated.UserID, lendingID: updated.LendingID}); err != nil {
+		return domain.Fine{}, err
+	}
+	put(repo.journal, repo.fines, updated.ID, updated)
+	if err := repo.persist(); err != nil {
+		return domain.Fine{}, err
+	}
+	return updated, nil
+}
+
+func (repo *InMemoryRepository) GetHolds(ctx context.Context, bookID string) ([]domain.Hold, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	holds := make([]domain.Hold, 0)
+	for _, h := range repo.holds {
+		if h.BookID == bookID && h.IsActive() {
+			holds = append(holds, h)
+		}
+	}
+	sort.Slice(holds, func(i, j int) bool {
+		return holds[i].CreatedAt.Before(holds[j].CreatedAt)
+	})
+	return holds, nil
+}
+
+func (repo *InMemoryRepository) GetHoldByID(ctx context.Context, id string) (domain.Hold, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	if hold, ok := repo.holds[id]; ok {
+		return hold, nil
+	}
+	return domain.Hold{}, repository.ErrHoldNotFound
+}
+
+func (repo *InMemoryRepository) CreateHold(ctx context.Context, hold domain.Hold) (domain.Hold, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	for _, h := range repo.holds {
+		if h.BookID == hold.BookID && h.UserID == hold.UserID && h.IsActive() {
+			return domain.Hold{}, repository.ErrHoldExists
+		}
+	}
+	if err := repo.checkReferences(references{bookID: hold.BookID, copyID: hold.CopyID, userID: hold.UserID}); err != nil {
+		return domain.Hold{}, err
+	}
+	put(repo.journal, repo.holds, hold.ID, hold)
+	if err := repo.persist(); err != nil {
+		return domain.Hold{}, err
+	}
+	return hold, nil
+}
+
+func (repo *InMemoryRepository) UpdateHold(ctx context.Context, updated domain.Hold) (domain.Hold, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	stored, ok := repo.holds[updated.ID]
+	if !ok {
+		return domain.Hold{}, repository.ErrHoldNotFound
+	}
+	if err := repo.checkReferences(references{bookID: updated.BookID, copyID: updated.CopyID, userID: updated.UserID}); err != nil {
+		return domain.Hold{}, err
+	}
+	put(repo.journal, repo.holds, updated.ID, updated)
+	if stored.Status == domain.HoldStatusReady && updated.Status != domain.HoldStatusFulfilled &&
+		(updated.Status != domain.HoldStatusReady || updated.CopyID != stored.CopyID) {
+		repo.releaseHeldCopy(stored.CopyID)
+	}
+	if err := repo.persist(); err != nil {
+		return domain.Hold{}, err
+	}
+	return updated, nil
+}
+
+func (repo *InMemoryRepository) ReadyNextHold(ctx context.Context, copyID string, readyAt, expiresAt time.Time) (domain.Hold, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	c, ok := repo.copies[copyID]
+	if !ok || c.Status != domain.CopyStatusAvailable {
+		return domain.Hold{}, nil
+	}
+	var next domain.Hold
+	for _, h := range repo.holds {
+		if h.BookID == c.BookID && h.Status == domain.HoldStatusWaiting && (next.ID == "" || h.CreatedAt.Before(next.CreatedAt)) {
+			next = h
+		}
+	}
+	if next.ID == "" {
+		return domain.Hold{}, nil
+	}
+	next.Status = domain.HoldStatusReady
+	next.CopyID = copyID
+	next.ReadyAt = readyAt
+	next.ExpiresAt = expiresAt
+	put(repo.journal, repo.holds, next.ID, next)
+	repo.setCopyStatus(copyID, domain.CopyStatusOnHold)
+	if err := repo.persist(); err != nil {
+		return domain.Hold{}, err
+	}
+	return next, nil
+}
+
+func (repo *InMemoryRepository) ExpireHolds(ctx context.Context, now time.Time) ([]domain.Hold, error) {
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	expired := make([]domain.Hold, 0)
+	for id, h := range repo.holds {
+		if h.Status != domain.HoldStatusReady || !h.ExpiresAt.Before(now) {
+			continue
+		}
+		h.Status = domain.HoldStatusExpired
+		put(repo.journal, repo.holds, id, h)
+		repo.releaseHeldCopy(h.CopyID)
+		expired = append(expired, h)
+	}
+	if err := repo.persist(); err != nil {
+		return nil, err
+	}
+	return expired, nil
+}
+
+// cancelHolds cancels the active holds matched by cancel and puts the copies
+// set aside for them back on the shelf. The caller must hold repo.mu.
+func (repo *InMemoryRepository) cancelHolds(cancel func(domain.Hold) bool) {
+	for id, h := range repo.holds {
+		if !h.IsActive() || !cancel(h) {
+			continue
+		}
+		h.Status = domain.HoldStatusCancelled
+		put(repo.journal, repo.holds, id, h)
+		repo.releaseHeldCopy(h.CopyID)
+	}
+}
+
+// releaseHeldCopy puts a copy that was set aside for a hold back on the shelf.
+// The caller must hold repo.mu.
+func (repo *InMemoryRepository) releaseHeldCopy(copyID string) {
+	if repo.copies[copyID].Status == domain.CopyStatusOnHold {
+		repo.setCopyStatus(copyID, domain.CopyStatusAvailable)
 	}
-	delete(repo.lendings, id)
-	return nil
 }
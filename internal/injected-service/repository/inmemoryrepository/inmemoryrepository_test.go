@@ -1,33 +1,61 @@
 package inmemoryrepository
 
 import (
+	"context"
+	"errors"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 	"libary-service/internal/domain"
+	"libary-service/internal/injected-service/repository"
+	"libary-service/internal/injected-service/repository/repositorytest"
+	"sync"
 	"testing"
 	"time"
 )
 
+var ctx = context.Background()
+
+// createBook stores a book for the rows of a test that must reference one.
+func createBook(t *testing.T, repo *InMemoryRepository) string {
+	book := domain.Book{ID: uuid.New().String(), Title: "The Hobbit", Author: "J. R. R. Tolkien"}
+	_, err := repo.CreateBook(ctx, book)
+	assert.NoError(t, err)
+	return book.ID
+}
+
+// createUser stores a user for the rows of a test that must reference one.
+func createUser(t *testing.T, repo *InMemoryRepository) string {
+	id := uuid.New().String()
+	_, err := repo.CreateUser(ctx, domain.User{ID: id, Name: "Max Mustermann", Email: id + "@example.com"})
+	assert.NoError(t, err)
+	return id
+}
+
+func TestConformance(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.Repository { return New() })
+}
+
 func TestConnect(t *testing.T) {
-	assert.Nil(t, New().Connect())
+	assert.Nil(t, New().Connect(ctx))
 }
 
 func TestDisconnect(t *testing.T) {
-	assert.Nil(t, New().Disconnect())
+	assert.Nil(t, New().Disconnect(ctx))
 }
 
 func TestCreateBook(t *testing.T) {
 	repo := New()
 	book := domain.Book{
-		ID:     uuid.New().String(),
-		Title:  "The Fellowship of the Ring",
-		Author: "J. R. R. Tolkien",
+		ID:      uuid.New().String(),
+		Title:   "The Fellowship of the Ring",
+		Author:  "J. R. R. Tolkien",
+		Version: 1,
 	}
 
-	result, err := repo.CreateBook(book)
+	result, err := repo.CreateBook(ctx, book)
 	assert.NoError(t, err)
 	assert.Equal(t, book, result)
-	storedBook, err := repo.GetBookByID(book.ID)
+	storedBook, err := repo.GetBookByID(ctx, book.ID)
 	assert.NoError(t, err)
 	assert.Equal(t, book, storedBook)
 }
@@ -35,44 +63,157 @@ func TestCreateBook(t *testing.T) {
 func TestGetBooks(t *testing.T) {
 	repo := New()
 	book1 := domain.Book{
-		ID:     uuid.New().String(),
-		Title:  "The Two Towers",
-		Author: "J. R. R. Tolkien",
+		ID:      uuid.New().String(),
+		Title:   "The Two Towers",
+		Author:  "J. R. R. Tolkien",
+		Version: 1,
 	}
 	book2 := domain.Book{
-		ID:     uuid.New().String(),
-		Title:  "The Return of the King",
-		Author: "J. R. R. Tolkien",
+		ID:      uuid.New().String(),
+		Title:   "The Return of the King",
+		Author:  "J. R. R. Tolkien",
+		Version: 1,
+	}
+
+	_, err := repo.CreateBook(ctx, book1)
+	assert.NoError(t, err)
+	_, err = repo.CreateBook(ctx, book2)
+	assert.NoError(t, err)
+
+	books, err := repo.GetBooks(ctx, repository.BookFilter{}, repository.ListOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, books.Total)
+	assert.Equal(t, []domain.Book{book2, book1}, books.Items)
+	assert.Empty(t, books.NextCursor)
+}
+
+func TestGetBooksFilter(t *testing.T) {
+	repo := New()
+	towers := domain.Book{ID: uuid.New().String(), Title: "The Two Towers", Author: "J. R. R. Tolkien"}
+	king := domain.Book{ID: uuid.New().String(), Title: "The Return of the King", Author: "J. R. R. Tolkien"}
+	hobbit := domain.Book{ID: uuid.New().String(), Title: "The Hobbit", Author: "Someone Else"}
+	for _, b := range []domain.Book{towers, king, hobbit} {
+		repo.books[b.ID] = b
+	}
+
+	books, err := repo.GetBooks(ctx, repository.BookFilter{Author: "J. R. R. Tolkien", TitlePrefix: "The T"}, repository.ListOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, books.Total)
+	assert.Equal(t, []domain.Book{towers}, books.Items)
+}
+
+func TestGetBooksPagination(t *testing.T) {
+	repo := New()
+	var all []domain.Book
+	for _, title := range []string{"A", "B", "B", "C", "D"} {
+		b := domain.Book{ID: uuid.New().String(), Title: title, Author: "Author"}
+		repo.books[b.ID] = b
+		all = append(all, b)
+	}
+	// The two books titled "B" are ordered by id.
+	if all[1].ID > all[2].ID {
+		all[1], all[2] = all[2], all[1]
+	}
+
+	var seen []domain.Book
+	options := repository.ListOptions{Limit: 2}
+	for {
+		page, err := repo.GetBooks(ctx, repository.BookFilter{}, options)
+		assert.NoError(t, err)
+		assert.Equal(t, 5, page.Total)
+		seen = append(seen, page.Items...)
+		if page.NextCursor == "" {
+			break
+		}
+		options.Cursor = page.NextCursor
+	}
+	assert.Equal(t, all, seen)
+
+	descending, err := repo.GetBooks(ctx, repository.BookFilter{}, repository.ListOptions{Limit: 1, Sort: "-title"})
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.Book{all[4]}, descending.Items)
+
+	_, err = repo.GetBooks(ctx, repository.BookFilter{}, repository.ListOptions{Sort: "pages"})
+	assert.ErrorIs(t, err, repository.ErrInvalidSort)
+
+	_, err = repo.GetBooks(ctx, repository.BookFilter{}, repository.ListOptions{Sort: "author", Cursor: descending.NextCursor})
+	assert.ErrorIs(t, err, repository.ErrInvalidCursor)
+}
+
+func TestSearchBooks(t *testing.T) {
+	repo := New()
+	towers := domain.Book{ID: uuid.New().String(), Title: "The Two Towers", Author: "J. R. R. Tolkien"}
+	king := domain.Book{ID: uuid.New().String(), Title: "The Return of the King", Author: "J. R. R. Tolkien"}
+	tolkien := domain.Book{ID: uuid.New().String(), Title: "Tolkien: A Biography", Author: "Humphrey Carpenter"}
+	for _, b := range []domain.Book{towers, king, tolkien} {
+		_, err := repo.CreateBook(ctx, b)
+		assert.NoError(t, err)
+	}
+
+	result, err := repo.SearchBooks(ctx, "TOLKIEN", repository.ListOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result.Total)
+	assert.Equal(t, tolkien.ID, result.Items[0].ID, "title matches rank first")
+	assert.Equal(t, "<b>Tolkien</b>: A Biography", result.Items[0].TitleHighlight)
+	assert.Equal(t, "J. R. R. <b>Tolkien</b>", result.Items[1].AuthorHighlight)
+
+	result, err = repo.SearchBooks(ctx, "the king", repository.ListOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, result.Items, 1)
+	assert.Equal(t, king.ID, result.Items[0].ID)
+
+	var seen []string
+	options := repository.ListOptions{Limit: 2}
+	for {
+		page, err := repo.SearchBooks(ctx, "tolkien", options)
+		assert.NoError(t, err)
+		for _, hit := range page.Items {
+			seen = append(seen, hit.ID)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		options.Cursor = page.NextCursor
 	}
+	assert.ElementsMatch(t, []string{towers.ID, king.ID, tolkien.ID}, seen)
 
-	_, err := repo.CreateBook(book1)
+	_, err = repo.UpdateBook(ctx, domain.Book{ID: towers.ID, Title: "The Two Towers", Author: "Anonymous", Version: 1})
 	assert.NoError(t, err)
-	_, err = repo.CreateBook(book2)
+	assert.NoError(t, repo.DeleteBook(ctx, king.ID, 1, time.Now(), false))
+	result, err = repo.SearchBooks(ctx, "tolkien", repository.ListOptions{})
 	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Total)
 
-	books, err := repo.GetBooks()
+	_, err = repo.SearchBooks(ctx, "tolkien", repository.ListOptions{Sort: "title"})
+	assert.ErrorIs(t, err, repository.ErrInvalidSort)
+
+	_, err = repo.CreateBook(ctx, domain.Book{ID: uuid.New().String(), Title: "Smith & Sons <Ltd>", Author: `"O'Brien"`})
+	assert.NoError(t, err)
+	result, err = repo.SearchBooks(ctx, "smith", repository.ListOptions{})
 	assert.NoError(t, err)
-	assert.Len(t, books, 2)
-	assert.Contains(t, books, book1)
-	assert.Contains(t, books, book2)
+	if assert.Len(t, result.Items, 1) {
+		assert.Equal(t, "<b>Smith</b> &amp; Sons &lt;Ltd&gt;", result.Items[0].TitleHighlight, "escaped title")
+		assert.Equal(t, "&#34;O&#39;Brien&#34;", result.Items[0].AuthorHighlight, "escaped author")
+	}
 }
 
 func TestGetBookByID(t *testing.T) {
 	repo := New()
 	book := domain.Book{
-		ID:     uuid.New().String(),
-		Title:  "The Fellowship of the Ring",
-		Author: "J. R. R. Tolkien",
+		ID:      uuid.New().String(),
+		Title:   "The Fellowship of the Ring",
+		Author:  "J. R. R. Tolkien",
+		Version: 1,
 	}
 
-	_, err := repo.CreateBook(book)
+	_, err := repo.CreateBook(ctx, book)
 	assert.NoError(t, err)
 
-	result, err := repo.GetBookByID(book.ID)
+	result, err := repo.GetBookByID(ctx, book.ID)
 	assert.NoError(t, err)
 	assert.Equal(t, book, result)
 
-	_, err = repo.GetBookByID(uuid.New().String())
+	_, err = repo.GetBookByID(ctx, uuid.New().String())
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "book not found")
 }
@@ -80,26 +221,30 @@ func TestGetBookByID(t *testing.T) {
 func TestUpdateBook(t *testing.T) {
 	repo := New()
 	book := domain.Book{
-		ID:     uuid.New().String(),
-		Title:  "The Two Towers",
-		Author: "J. R. R. Tolkien",
+		ID:      uuid.New().String(),
+		Title:   "The Two Towers",
+		Author:  "J. R. R. Tolkien",
+		Version: 1,
 	}
 
-	_, err := repo.CreateBook(book)
+	_, err := repo.CreateBook(ctx, book)
 	assert.NoError(t, err)
 
 	updated := book
 	updated.Title = "The Two Towers (Revised Edition)"
-	result, err := repo.UpdateBook(updated)
+	result, err := repo.UpdateBook(ctx, updated)
 	assert.NoError(t, err)
+	updated.Version = 2
 	assert.Equal(t, updated, result)
+	_, err = repo.UpdateBook(ctx, book)
+	assert.ErrorIs(t, err, repository.ErrVersionMismatch, "update based on an outdated version")
 
-	stored, err := repo.GetBookByID(book.ID)
+	stored, err := repo.GetBookByID(ctx, book.ID)
 	assert.NoError(t, err)
 	assert.Equal(t, "The Two Towers (Revised Edition)", stored.Title)
 
 	nonExistent := domain.Book{ID: uuid.New().String()}
-	_, err = repo.UpdateBook(nonExistent)
+	_, err = repo.UpdateBook(ctx, nonExistent)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "book not found")
 }
@@ -112,33 +257,209 @@ func TestDeleteBook(t *testing.T) {
 		Author: "J. R. R. Tolkien",
 	}
 
-	_, err := repo.CreateBook(book)
+	_, err := repo.CreateBook(ctx, book)
 	assert.NoError(t, err)
 
-	err = repo.DeleteBook(book.ID)
+	err = repo.DeleteBook(ctx, book.ID, 1, time.Now(), false)
 	assert.NoError(t, err)
 
-	_, err = repo.GetBookByID(book.ID)
+	_, err = repo.GetBookByID(ctx, book.ID)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "book not found")
 
-	err = repo.DeleteBook(uuid.New().String())
+	err = repo.DeleteBook(ctx, uuid.New().String(), 1, time.Now(), false)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "book not found")
 }
 
+func TestBookCopies(t *testing.T) {
+	repo := New()
+	book := domain.Book{
+		ID:     uuid.New().String(),
+		Title:  "The Fellowship of the Ring",
+		Author: "J. R. R. Tolkien",
+	}
+	_, err := repo.CreateBook(ctx, book)
+	assert.NoError(t, err)
+
+	bookCopy := domain.BookCopy{
+		ID:        uuid.New().String(),
+		BookID:    book.ID,
+		Barcode:   "LIB-0001",
+		Condition: domain.CopyConditionNew,
+		Status:    domain.CopyStatusAvailable,
+	}
+	result, err := repo.CreateBookCopy(ctx, bookCopy)
+	assert.NoError(t, err)
+	assert.Equal(t, bookCopy, result)
+
+	duplicate := bookCopy
+	duplicate.ID = uuid.New().String()
+	_, err = repo.CreateBookCopy(ctx, duplicate)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "barcode already exists")
+
+	copies, err := repo.GetBookCopies(ctx, book.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.BookCopy{bookCopy}, copies)
+
+	updated := bookCopy
+	updated.Condition = domain.CopyConditionDamaged
+	updated.Status = domain.CopyStatusInRepair
+	result, err = repo.UpdateBookCopy(ctx, updated)
+	assert.NoError(t, err)
+	assert.Equal(t, updated, result)
+
+	stored, err := repo.GetBookCopyByID(ctx, bookCopy.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, updated, stored)
+
+	_, err = repo.UpdateBookCopy(ctx, domain.BookCopy{ID: uuid.New().String()})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "book copy not found")
+
+	err = repo.DeleteBookCopy(ctx, bookCopy.ID)
+	assert.NoError(t, err)
+
+	_, err = repo.GetBookCopyByID(ctx, bookCopy.ID)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "book copy not found")
+
+	err = repo.DeleteBookCopy(ctx, bookCopy.ID)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "book copy not found")
+}
+
+func TestPurgeDeletedBookRemovesCopies(t *testing.T) {
+	repo := New()
+	book := domain.Book{ID: uuid.New().String(), Title: "The Two Towers", Author: "J. R. R. Tolkien"}
+	_, err := repo.CreateBook(ctx, book)
+	assert.NoError(t, err)
+	bookCopy := domain.BookCopy{ID: uuid.New().String(), BookID: book.ID, Barcode: "LIB-0002", Condition: domain.CopyConditionGood, Status: domain.CopyStatusAvailable}
+	_, err = repo.CreateBookCopy(ctx, bookCopy)
+	assert.NoError(t, err)
+
+	deletedAt := time.Now()
+	assert.NoError(t, repo.DeleteBook(ctx, book.ID, 1, deletedAt, false))
+	_, err = repo.GetBookCopyByID(ctx, bookCopy.ID)
+	assert.NoError(t, err, "copies stay until the book is purged")
+
+	report, err := repo.PurgeDeleted(ctx, deletedAt.Add(time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Books)
+	_, err = repo.GetBookCopyByID(ctx, bookCopy.ID)
+	assert.Error(t, err)
+}
+
+func TestLendingUpdatesCopyStatus(t *testing.T) {
+	repo := New()
+	book := domain.Book{ID: uuid.New().String(), Title: "The Return of the King", Author: "J. R. R. Tolkien"}
+	user := domain.User{ID: uuid.New().String(), Name: "Max Mustermann", Email: "max@mustermann.de"}
+	bookCopy := domain.BookCopy{ID: uuid.New().String(), BookID: book.ID, Barcode: "LIB-0003", Condition: domain.CopyConditionGood, Status: domain.CopyStatusAvailable}
+	_, err := repo.CreateBook(ctx, book)
+	assert.NoError(t, err)
+	_, err = repo.CreateUser(ctx, user)
+	assert.NoError(t, err)
+	_, err = repo.CreateBookCopy(ctx, bookCopy)
+	assert.NoError(t, err)
+
+	lending := domain.Lending{ID: uuid.New().String(), BookID: book.ID, CopyID: bookCopy.ID, UserID: user.ID, LendDate: time.Now()}
+	lending, err = repo.CreateLending(ctx, lending)
+	assert.NoError(t, err)
+	stored, _ := repo.GetBookCopyByID(ctx, bookCopy.ID)
+	assert.Equal(t, domain.CopyStatusOnLoan, stored.Status)
+
+	lending.ReturnDate = time.Now().Add(time.Hour)
+	_, err = repo.UpdateLending(ctx, lending)
+	assert.NoError(t, err)
+	stored, _ = repo.GetBookCopyByID(ctx, bookCopy.ID)
+	assert.Equal(t, domain.CopyStatusAvailable, stored.Status)
+
+	active := domain.Lending{ID: uuid.New().String(), BookID: book.ID, CopyID: bookCopy.ID, UserID: user.ID, LendDate: time.Now()}
+	_, err = repo.CreateLending(ctx, active)
+	assert.NoError(t, err)
+	assert.NoError(t, repo.DeleteLending(ctx, active.ID, 1, time.Now()))
+	stored, _ = repo.GetBookCopyByID(ctx, bookCopy.ID)
+	assert.Equal(t, domain.CopyStatusAvailable, stored.Status)
+}
+
+func TestCreateLendingRejectsUnavailableCopy(t *testing.T) {
+	repo := New()
+	book := domain.Book{ID: uuid.New().String(), Title: "The Hobbit", Author: "J. R. R. Tolkien"}
+	user := domain.User{ID: uuid.New().String(), Name: "Max Mustermann", Email: "max@mustermann.de"}
+	lost := domain.BookCopy{ID: uuid.New().String(), BookID: book.ID, Barcode: "LIB-0001", Condition: domain.CopyConditionGood, Status: domain.CopyStatusLost}
+	shelved := domain.BookCopy{ID: uuid.New().String(), BookID: book.ID, Barcode: "LIB-0002", Condition: domain.CopyConditionGood, Status: domain.CopyStatusAvailable}
+	_, err := repo.CreateBook(ctx, book)
+	assert.NoError(t, err)
+	_, err = repo.CreateUser(ctx, user)
+	assert.NoError(t, err)
+	_, err = repo.CreateBookCopy(ctx, lost)
+	assert.NoError(t, err)
+	_, err = repo.CreateBookCopy(ctx, shelved)
+	assert.NoError(t, err)
+
+	_, err = repo.CreateLending(ctx, domain.Lending{ID: uuid.New().String(), BookID: book.ID, CopyID: lost.ID, UserID: user.ID, LendDate: time.Now()})
+	assert.ErrorIs(t, err, repository.ErrCopyUnavailable)
+
+	picked, err := repo.CreateLending(ctx, domain.Lending{ID: uuid.New().String(), BookID: book.ID, UserID: user.ID, LendDate: time.Now()})
+	assert.NoError(t, err)
+	assert.Equal(t, shelved.ID, picked.CopyID)
+
+	_, err = repo.CreateLending(ctx, domain.Lending{ID: uuid.New().String(), BookID: book.ID, CopyID: shelved.ID, UserID: user.ID, LendDate: time.Now()})
+	assert.ErrorIs(t, err, repository.ErrCopyUnavailable)
+
+	_, err = repo.CreateLending(ctx, domain.Lending{ID: uuid.New().String(), BookID: book.ID, UserID: user.ID, LendDate: time.Now()})
+	assert.ErrorIs(t, err, repository.ErrCopyUnavailable)
+}
+
+func TestConcurrentLendingsOfSameCopy(t *testing.T) {
+	repo := New()
+	book := domain.Book{ID: uuid.New().String(), Title: "The Silmarillion", Author: "J. R. R. Tolkien"}
+	bookCopy := domain.BookCopy{ID: uuid.New().String(), BookID: book.ID, Barcode: "LIB-0001", Condition: domain.CopyConditionGood, Status: domain.CopyStatusAvailable}
+	_, err := repo.CreateBook(ctx, book)
+	assert.NoError(t, err)
+	_, err = repo.CreateBookCopy(ctx, bookCopy)
+	assert.NoError(t, err)
+
+	const attempts = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, attempts)
+	for i := 0; i < attempts; i++ {
+		userID := createUser(t, repo)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := repo.CreateLending(ctx, domain.Lending{ID: uuid.New().String(), BookID: book.ID, CopyID: bookCopy.ID, UserID: userID, LendDate: time.Now()})
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+		} else {
+			assert.ErrorIs(t, err, repository.ErrCopyUnavailable)
+		}
+	}
+	assert.Equal(t, 1, succeeded)
+}
+
 func TestCreateUser(t *testing.T) {
 	repo := New()
 	user := domain.User{
-		ID:    uuid.New().String(),
-		Name:  "Max Mustermann",
-		Email: "max@mustermann.de",
+		ID:      uuid.New().String(),
+		Name:    "Max Mustermann",
+		Email:   "max@mustermann.de",
+		Version: 1,
 	}
 
-	result, err := repo.CreateUser(user)
+	result, err := repo.CreateUser(ctx, user)
 	assert.NoError(t, err)
 	assert.Equal(t, user, result)
-	storedUser, err := repo.GetUserByID(user.ID)
+	storedUser, err := repo.GetUserByID(ctx, user.ID)
 	assert.NoError(t, err)
 	assert.Equal(t, user, storedUser)
 }
@@ -146,44 +467,51 @@ func TestCreateUser(t *testing.T) {
 func TestGetUsers(t *testing.T) {
 	repo := New()
 	user1 := domain.User{
-		ID:    uuid.New().String(),
-		Name:  "Max Mustermann",
-		Email: "max@mustermann.de",
+		ID:      uuid.New().String(),
+		Name:    "Max Mustermann",
+		Email:   "max@mustermann.de",
+		Version: 1,
 	}
 	user2 := domain.User{
-		ID:    uuid.New().String(),
-		Name:  "Erika Mustermann",
-		Email: "erika@mustermann.de",
+		ID:      uuid.New().String(),
+		Name:    "Erika Mustermann",
+		Email:   "erika@mustermann.de",
+		Version: 1,
 	}
 
-	_, err := repo.CreateUser(user1)
+	_, err := repo.CreateUser(ctx, user1)
 	assert.NoError(t, err)
-	_, err = repo.CreateUser(user2)
+	_, err = repo.CreateUser(ctx, user2)
+	assert.NoError(t, err)
+
+	users, err := repo.GetUsers(ctx, repository.UserFilter{}, repository.ListOptions{})
 	assert.NoError(t, err)
+	assert.Equal(t, 2, users.Total)
+	assert.Equal(t, []domain.User{user2, user1}, users.Items)
 
-	users, err := repo.GetUsers()
+	staff, err := repo.GetUsers(ctx, repository.UserFilter{Category: domain.UserCategoryStaff}, repository.ListOptions{})
 	assert.NoError(t, err)
-	assert.Len(t, users, 2)
-	assert.Contains(t, users, user1)
-	assert.Contains(t, users, user2)
+	assert.Equal(t, 0, staff.Total)
+	assert.Empty(t, staff.Items)
 }
 
 func TestGetUserByID(t *testing.T) {
 	repo := New()
 	user := domain.User{
-		ID:    uuid.New().String(),
-		Name:  "Max Mustermann",
-		Email: "max@mustermann.de",
+		ID:      uuid.New().String(),
+		Name:    "Max Mustermann",
+		Email:   "max@mustermann.de",
+		Version: 1,
 	}
 
-	_, err := repo.CreateUser(user)
+	_, err := repo.CreateUser(ctx, user)
 	assert.NoError(t, err)
 
-	result, err := repo.GetUserByID(user.ID)
+	result, err := repo.GetUserByID(ctx, user.ID)
 	assert.NoError(t, err)
 	assert.Equal(t, user, result)
 
-	_, err = repo.GetUserByID(uuid.New().String())
+	_, err = repo.GetUserByID(ctx, uuid.New().String())
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "user not found")
 }
@@ -191,28 +519,32 @@ func TestGetUserByID(t *testing.T) {
 func TestUpdateUser(t *testing.T) {
 	repo := New()
 	user := domain.User{
-		ID:    uuid.New().String(),
-		Name:  "Erika Mustermann",
-		Email: "erika@mustermann.de",
+		ID:      uuid.New().String(),
+		Name:    "Erika Mustermann",
+		Email:   "erika@mustermann.de",
+		Version: 1,
 	}
 
-	_, err := repo.CreateUser(user)
+	_, err := repo.CreateUser(ctx, user)
 	assert.NoError(t, err)
 
 	updated := user
 	updated.Name = "Max Mustermann"
 	updated.Email = "max@mustermann.de"
-	result, err := repo.UpdateUser(updated)
+	result, err := repo.UpdateUser(ctx, updated)
 	assert.NoError(t, err)
+	updated.Version = 2
 	assert.Equal(t, updated, result)
+	_, err = repo.UpdateUser(ctx, user)
+	assert.ErrorIs(t, err, repository.ErrVersionMismatch, "update based on an outdated version")
 
-	stored, err := repo.GetUserByID(user.ID)
+	stored, err := repo.GetUserByID(ctx, user.ID)
 	assert.NoError(t, err)
 	assert.Equal(t, "max@mustermann.de", stored.Email)
 	assert.Equal(t, "Max Mustermann", stored.Name)
 
 	nonExistent := domain.User{ID: uuid.New().String()}
-	_, err = repo.UpdateUser(nonExistent)
+	_, err = repo.UpdateUser(ctx, nonExistent)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "user not found")
 }
@@ -225,17 +557,17 @@ func TestDeleteUser(t *testing.T) {
 		Email: "max@mustermann.de",
 	}
 
-	_, err := repo.CreateUser(user)
+	_, err := repo.CreateUser(ctx, user)
 	assert.NoError(t, err)
 
-	err = repo.DeleteUser(user.ID)
+	err = repo.DeleteUser(ctx, user.ID, 1, time.Now(), false)
 	assert.NoError(t, err)
 
-	_, err = repo.GetUserByID(user.ID)
+	_, err = repo.GetUserByID(ctx, user.ID)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "user not found")
 
-	err = repo.DeleteUser(uuid.New().String())
+	err = repo.DeleteUser(ctx, uuid.New().String(), 1, time.Now(), false)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "user not found")
 }
@@ -253,9 +585,9 @@ func TestCreateLending(t *testing.T) {
 		Email: "max@mustermann.de",
 	}
 
-	_, err := repo.CreateBook(book)
+	_, err := repo.CreateBook(ctx, book)
 	assert.NoError(t, err)
-	_, err = repo.CreateUser(user)
+	_, err = repo.CreateUser(ctx, user)
 	assert.NoError(t, err)
 
 	lending := domain.Lending{
@@ -264,12 +596,13 @@ func TestCreateLending(t *testing.T) {
 		UserID:     user.ID,
 		LendDate:   time.Now(),
 		ReturnDate: time.Now().AddDate(0, 0, 14),
+		Version:    1,
 	}
 
-	result, err := repo.CreateLending(lending)
+	result, err := repo.CreateLending(ctx, lending)
 	assert.NoError(t, err)
 	assert.Equal(t, lending, result)
-	storedLending, err := repo.GetLendingByID(lending.ID)
+	storedLending, err := repo.GetLendingByID(ctx, lending.ID)
 	assert.NoError(t, err)
 	assert.Equal(t, lending, storedLending)
 }
@@ -297,13 +630,13 @@ func TestGetLendings(t *testing.T) {
 		Email: "erika@mustermann.de",
 	}
 
-	_, err := repo.CreateBook(book1)
+	_, err := repo.CreateBook(ctx, book1)
 	assert.NoError(t, err)
-	_, err = repo.CreateBook(book2)
+	_, err = repo.CreateBook(ctx, book2)
 	assert.NoError(t, err)
-	_, err = repo.CreateUser(user1)
+	_, err = repo.CreateUser(ctx, user1)
 	assert.NoError(t, err)
-	_, err = repo.CreateUser(user2)
+	_, err = repo.CreateUser(ctx, user2)
 	assert.NoError(t, err)
 
 	lending1 := domain.Lending{
@@ -312,6 +645,7 @@ func TestGetLendings(t *testing.T) {
 		UserID:     user1.ID,
 		LendDate:   time.Now(),
 		ReturnDate: time.Now().AddDate(0, 0, 14),
+		Version:    1,
 	}
 	lending2 := domain.Lending{
 		ID:         uuid.New().String(),
@@ -319,18 +653,59 @@ func TestGetLendings(t *testing.T) {
 		UserID:     user2.ID,
 		LendDate:   time.Now(),
 		ReturnDate: time.Now().AddDate(0, 0, 7),
+		Version:    1,
+	}
+
+	_, err = repo.CreateLending(ctx, lending1)
+	assert.NoError(t, err)
+	_, err = repo.CreateLending(ctx, lending2)
+	assert.NoError(t, err)
+
+	lendings, err := repo.GetLendings(ctx, repository.LendingFilter{}, repository.ListOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, lendings.Total)
+	assert.ElementsMatch(t, []domain.Lending{lending1, lending2}, lendings.Items)
+
+	byUser, err := repo.GetLendings(ctx, repository.LendingFilter{UserID: user2.ID}, repository.ListOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.Lending{lending2}, byUser.Items)
+}
+
+func TestGetLendingsActiveFilter(t *testing.T) {
+	repo := New()
+	now := time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+	older := domain.Lending{ID: uuid.New().String(), LendDate: now.AddDate(0, 0, -10)}
+	newer := domain.Lending{ID: uuid.New().String(), LendDate: now.AddDate(0, 0, -1)}
+	returned := domain.Lending{ID: uuid.New().String(), LendDate: now.AddDate(0, 0, -20), ReturnDate: now.AddDate(0, 0, -5)}
+	for _, l := range []domain.Lending{older, newer, returned} {
+		repo.lendings[l.ID] = l
 	}
 
-	_, err = repo.CreateLending(lending1)
+	active := true
+	lendings, err := repo.GetLendings(ctx, repository.LendingFilter{Active: &active}, repository.ListOptions{Sort: "-lend_date"})
 	assert.NoError(t, err)
-	_, err = repo.CreateLending(lending2)
+	assert.Equal(t, []domain.Lending{newer, older}, lendings.Items)
+
+	active = false
+	lendings, err = repo.GetLendings(ctx, repository.LendingFilter{Active: &active}, repository.ListOptions{})
 	assert.NoError(t, err)
+	assert.Equal(t, []domain.Lending{returned}, lendings.Items)
+}
 
-	lendings, err := repo.GetLendings()
+func TestGetOverdueLendings(t *testing.T) {
+	repo := New()
+	now := time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+	overdueLate := domain.Lending{ID: uuid.New().String(), LendDate: now.AddDate(0, 0, -30), DueDate: now.AddDate(0, 0, -9), ReturnDate: time.Time{}}
+	overdueEarly := domain.Lending{ID: uuid.New().String(), LendDate: now.AddDate(0, 0, -40), DueDate: now.AddDate(0, 0, -19)}
+	notDue := domain.Lending{ID: uuid.New().String(), LendDate: now.AddDate(0, 0, -1), DueDate: now.AddDate(0, 0, 20)}
+	returned := domain.Lending{ID: uuid.New().String(), LendDate: now.AddDate(0, 0, -40), DueDate: now.AddDate(0, 0, -19), ReturnDate: now.AddDate(0, 0, -2)}
+	for _, l := range []domain.Lending{overdueLate, overdueEarly, notDue, returned} {
+		repo.lendings[l.ID] = l
+	}
+
+	lendings, err := repo.GetOverdueLendings(ctx, now)
 	assert.NoError(t, err)
-	assert.Len(t, lendings, 2)
-	assert.Contains(t, lendings, lending1)
-	assert.Contains(t, lendings, lending2)
+	assert.Equal(t, []domain.Lending{overdueEarly, overdueLate}, lendings)
 }
 
 func TestGetLendingByID(t *testing.T) {
@@ -346,9 +721,9 @@ func TestGetLendingByID(t *testing.T) {
 		Email: "erika@mustermann.de",
 	}
 
-	_, err := repo.CreateBook(book)
+	_, err := repo.CreateBook(ctx, book)
 	assert.NoError(t, err)
-	_, err = repo.CreateUser(user)
+	_, err = repo.CreateUser(ctx, user)
 	assert.NoError(t, err)
 
 	lending := domain.Lending{
@@ -357,16 +732,17 @@ func TestGetLendingByID(t *testing.T) {
 		UserID:     user.ID,
 		LendDate:   time.Now(),
 		ReturnDate: time.Now().AddDate(0, 0, 14),
+		Version:    1,
 	}
 
-	_, err = repo.CreateLending(lending)
+	_, err = repo.CreateLending(ctx, lending)
 	assert.NoError(t, err)
 
-	result, err := repo.GetLendingByID(lending.ID)
+	result, err := repo.GetLendingByID(ctx, lending.ID)
 	assert.NoError(t, err)
 	assert.Equal(t, lending, result)
 
-	_, err = repo.GetLendingByID(uuid.New().String())
+	_, err = repo.GetLendingByID(ctx, uuid.New().String())
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "lending not found")
 }
@@ -384,9 +760,9 @@ func TestUpdateLending(t *testing.T) {
 		Email: "max@mustermann.de",
 	}
 
-	_, err := repo.CreateBook(book)
+	_, err := repo.CreateBook(ctx, book)
 	assert.NoError(t, err)
-	_, err = repo.CreateUser(user)
+	_, err = repo.CreateUser(ctx, user)
 	assert.NoError(t, err)
 
 	lending := domain.Lending{
@@ -395,23 +771,27 @@ func TestUpdateLending(t *testing.T) {
 		UserID:     user.ID,
 		LendDate:   time.Now(),
 		ReturnDate: time.Now().AddDate(0, 0, 14),
+		Version:    1,
 	}
 
-	_, err = repo.CreateLending(lending)
+	_, err = repo.CreateLending(ctx, lending)
 	assert.NoError(t, err)
 
 	updated := lending
 	updated.ReturnDate = time.Now().AddDate(0, 0, 21)
-	result, err := repo.UpdateLending(updated)
+	result, err := repo.UpdateLending(ctx, updated)
 	assert.NoError(t, err)
+	updated.Version = 2
 	assert.Equal(t, updated, result)
+	_, err = repo.UpdateLending(ctx, lending)
+	assert.ErrorIs(t, err, repository.ErrVersionMismatch, "update based on an outdated version")
 
-	stored, err := repo.GetLendingByID(lending.ID)
+	stored, err := repo.GetLendingByID(ctx, lending.ID)
 	assert.NoError(t, err)
 	assert.Equal(t, updated.ReturnDate.Day(), stored.ReturnDate.Day())
 
 	nonExistent := domain.Lending{ID: uuid.New().String()}
-	_, err = repo.UpdateLending(nonExistent)
+	_, err = repo.UpdateLending(ctx, nonExistent)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "lending not found")
 }
@@ -429,9 +809,9 @@ func TestDeleteLending(t *testing.T) {
 		Email: "erika@mustermann.de",
 	}
 
-	_, err := repo.CreateBook(book)
+	_, err := repo.CreateBook(ctx, book)
 	assert.NoError(t, err)
-	_, err = repo.CreateUser(user)
+	_, err = repo.CreateUser(ctx, user)
 	assert.NoError(t, err)
 
 	lending := domain.Lending{
@@ -442,17 +822,294 @@ func TestDeleteLending(t *testing.T) {
 		ReturnDate: time.Now().AddDate(0, 0, 14),
 	}
 
-	_, err = repo.CreateLending(lending)
+	_, err = repo.CreateLending(ctx, lending)
 	assert.NoError(t, err)
 
-	err = repo.DeleteLending(lending.ID)
+	err = repo.DeleteLending(ctx, lending.ID, 1, time.Now())
 	assert.NoError(t, err)
 
-	_, err = repo.GetLendingByID(lending.ID)
+	_, err = repo.GetLendingByID(ctx, lending.ID)
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "lending not found")
 
-	err = repo.DeleteLending(uuid.New().String())
+	err = repo.DeleteLending(ctx, uuid.New().String(), 1, time.Now())
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "lending not found")
 }
+
+func TestFines(t *testing.T) {
+	repo := New()
+	userID := createUser(t, repo)
+	createdAt := time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+	later := domain.Fine{ID: uuid.New().String(), UserID: userID, Reason: domain.FineReasonLost, Amount: 2500, Status: domain.FineStatusUnpaid, CreatedAt: createdAt.AddDate(0, 0, 1)}
+	earlier := domain.Fine{ID: uuid.New().String(), UserID: userID, Reason: domain.FineReasonOverdue, Amount: 150, Status: domain.FineStatusUnpaid, CreatedAt: createdAt}
+	other := domain.Fine{ID: uuid.New().String(), UserID: createUser(t, repo), Reason: domain.FineReasonOverdue, Amount: 50, Status: domain.FineStatusUnpaid, CreatedAt: createdAt}
+	for _, f := range []domain.Fine{later, earlier, other} {
+		_, err := repo.CreateFine(ctx, f)
+		assert.NoError(t, err)
+	}
+
+	fines, err := repo.GetFinesByUserID(ctx, userID)
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.Fine{earlier, later}, fines)
+
+	earlier.Status = domain.FineStatusPaid
+	earlier.SettledAt = createdAt.AddDate(0, 0, 2)
+	_, err = repo.UpdateFine(ctx, earlier)
+	assert.NoError(t, err)
+	gotFine, err := repo.GetFineByID(ctx, earlier.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, earlier, gotFine)
+
+	_, err = repo.GetFineByID(ctx, uuid.New().String())
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "fine not found")
+	_, err = repo.UpdateFine(ctx, domain.Fine{ID: uuid.New().String()})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "fine not found")
+
+	_, err = repo.CreateFine(ctx, domain.Fine{ID: uuid.New().String(), UserID: uuid.New().String(), Reason: domain.FineReasonLost, Amount: 2500})
+	assert.ErrorIs(t, err, repository.ErrUserNotFound)
+}
+
+func TestFinesFollowUsersAndLendings(t *testing.T) {
+	repo := New()
+	user := domain.User{ID: uuid.New().String(), Name: "Max Mustermann", Email: "max@mustermann.de"}
+	_, err := repo.CreateUser(ctx, user)
+	assert.NoError(t, err)
+	lending := domain.Lending{ID: uuid.New().String(), UserID: user.ID, LendDate: time.Now(), ReturnDate: time.Now()}
+	_, err = repo.CreateLending(ctx, lending)
+	assert.NoError(t, err)
+	fine := domain.Fine{ID: uuid.New().String(), UserID: user.ID, LendingID: lending.ID, Reason: domain.FineReasonOverdue, Amount: 50, Status: domain.FineStatusUnpaid}
+	_, err = repo.CreateFine(ctx, fine)
+	assert.NoError(t, err)
+
+	deletedAt := time.Now()
+	assert.NoError(t, repo.DeleteLending(ctx, lending.ID, 1, deletedAt))
+	gotFine, err := repo.GetFineByID(ctx, fine.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, lending.ID, gotFine.LendingID, "deleted lendings keep their fines")
+	_, err = repo.PurgeDeleted(ctx, deletedAt.Add(time.Second))
+	assert.NoError(t, err)
+	gotFine, err = repo.GetFineByID(ctx, fine.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, gotFine.LendingID)
+
+	assert.NoError(t, repo.DeleteUser(ctx, user.ID, 1, deletedAt, false))
+	_, err = repo.PurgeDeleted(ctx, deletedAt.Add(time.Second))
+	assert.NoError(t, err)
+	_, err = repo.GetFineByID(ctx, fine.ID)
+	assert.Error(t, err)
+}
+
+func TestHoldQueue(t *testing.T) {
+	repo := New()
+	now := time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+	bookID := createBook(t, repo)
+	bookCopy := domain.BookCopy{ID: uuid.New().String(), BookID: bookID, Barcode: "LIB-0001", Condition: domain.CopyConditionGood, Status: domain.CopyStatusAvailable}
+	_, err := repo.CreateBookCopy(ctx, bookCopy)
+	assert.NoError(t, err)
+	lending, err := repo.CreateLending(ctx, domain.Lending{ID: uuid.New().String(), BookID: bookID, UserID: createUser(t, repo), LendDate: now})
+	assert.NoError(t, err)
+
+	first := domain.Hold{ID: uuid.New().String(), BookID: bookID, UserID: createUser(t, repo), Status: domain.HoldStatusWaiting, CreatedAt: now}
+	second := domain.Hold{ID: uuid.New().String(), BookID: bookID, UserID: createUser(t, repo), Status: domain.HoldStatusWaiting, CreatedAt: now.Add(time.Hour)}
+	for _, h := range []domain.Hold{second, first} {
+		_, err := repo.CreateHold(ctx, h)
+		assert.NoError(t, err)
+	}
+	_, err = repo.CreateHold(ctx, domain.Hold{ID: uuid.New().String(), BookID: bookID, UserID: first.UserID, Status: domain.HoldStatusWaiting})
+	assert.ErrorIs(t, err, repository.ErrHoldExists)
+
+	holds, err := repo.GetHolds(ctx, bookID)
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.Hold{first, second}, holds)
+
+	// Nothing happens while the copy is still lent out.
+	ready, err := repo.ReadyNextHold(ctx, bookCopy.ID, now, now.AddDate(0, 0, 7))
+	assert.NoError(t, err)
+	assert.Empty(t, ready.ID)
+
+	lending.ReturnDate = now.Add(2 * time.Hour)
+	_, err = repo.UpdateLending(ctx, lending)
+	assert.NoError(t, err)
+	ready, err = repo.ReadyNextHold(ctx, bookCopy.ID, now, now.AddDate(0, 0, 7))
+	assert.NoError(t, err)
+	assert.Equal(t, first.ID, ready.ID)
+	assert.Equal(t, domain.HoldStatusReady, ready.Status)
+	assert.Equal(t, bookCopy.ID, ready.CopyID)
+	assert.Equal(t, domain.CopyStatusOnHold, repo.copies[bookCopy.ID].Status)
+
+	// The copy is kept for the first patron in line.
+	_, err = repo.CreateLending(ctx, domain.Lending{ID: uuid.New().String(), BookID: bookID, UserID: second.UserID, LendDate: now})
+	assert.ErrorIs(t, err, repository.ErrCopyUnavailable)
+	pickup, err := repo.CreateLending(ctx, domain.Lending{ID: uuid.New().String(), BookID: bookID, UserID: first.UserID, LendDate: now})
+	assert.NoError(t, err)
+	assert.Equal(t, bookCopy.ID, pickup.CopyID)
+	assert.Equal(t, domain.CopyStatusOnLoan, repo.copies[bookCopy.ID].Status)
+	assert.Equal(t, domain.HoldStatusFulfilled, repo.holds[first.ID].Status)
+
+	holds, err = repo.GetHolds(ctx, bookID)
+	assert.NoError(t, err)
+	assert.Equal(t, []domain.Hold{second}, holds)
+}
+
+func TestCancelAndExpireReadyHolds(t *testing.T) {
+	repo := New()
+	now := time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+	bookID := createBook(t, repo)
+	bookCopy := domain.BookCopy{ID: uuid.New().String(), BookID: bookID, Barcode: "LIB-0001", Condition: domain.CopyConditionGood, Status: domain.CopyStatusAvailable}
+	_, err := repo.CreateBookCopy(ctx, bookCopy)
+	assert.NoError(t, err)
+	hold := domain.Hold{ID: uuid.New().String(), BookID: bookID, UserID: createUser(t, repo), Status: domain.HoldStatusWaiting, CreatedAt: now}
+	_, err = repo.CreateHold(ctx, hold)
+	assert.NoError(t, err)
+
+	hold, err = repo.ReadyNextHold(ctx, bookCopy.ID, now, now.AddDate(0, 0, 7))
+	assert.NoError(t, err)
+	hold.Status = domain.HoldStatusCancelled
+	_, err = repo.UpdateHold(ctx, hold)
+	assert.NoError(t, err)
+	assert.Equal(t, domain.CopyStatusAvailable, repo.copies[bookCopy.ID].Status)
+
+	late := domain.Hold{ID: uuid.New().String(), BookID: bookID, UserID: createUser(t, repo), Status: domain.HoldStatusWaiting, CreatedAt: now}
+	_, err = repo.CreateHold(ctx, late)
+	assert.NoError(t, err)
+	_, err = repo.ReadyNextHold(ctx, bookCopy.ID, now, now.AddDate(0, 0, 7))
+	assert.NoError(t, err)
+
+	expired, err := repo.ExpireHolds(ctx, now.AddDate(0, 0, 6))
+	assert.NoError(t, err)
+	assert.Empty(t, expired)
+	expired, err = repo.ExpireHolds(ctx, now.AddDate(0, 0, 8))
+	assert.NoError(t, err)
+	assert.Len(t, expired, 1)
+	assert.Equal(t, late.ID, expired[0].ID)
+	assert.Equal(t, domain.HoldStatusExpired, expired[0].Status)
+	assert.Equal(t, domain.CopyStatusAvailable, repo.copies[bookCopy.ID].Status)
+
+	_, err = repo.UpdateHold(ctx, domain.Hold{ID: uuid.New().String()})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "hold not found")
+}
+
+func TestCountActiveLendings(t *testing.T) {
+	repo := New()
+	userID := uuid.New().String()
+	for _, l := range []domain.Lending{
+		{ID: uuid.New().String(), UserID: userID, LendDate: time.Now()},
+		{ID: uuid.New().String(), UserID: userID, LendDate: time.Now()},
+		{ID: uuid.New().String(), UserID: userID, LendDate: time.Now().Add(-time.Hour), ReturnDate: time.Now()},
+		{ID: uuid.New().String(), UserID: uuid.New().String(), LendDate: time.Now()},
+	} {
+		repo.lendings[l.ID] = l
+	}
+
+	count, err := repo.CountActiveLendings(ctx, userID)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestErrorKinds(t *testing.T) {
+	repo := New()
+	id := uuid.New().String()
+
+	_, err := repo.UpdateBook(ctx, domain.Book{ID: id})
+	assert.ErrorIs(t, err, repository.ErrBookNotFound)
+	assert.ErrorIs(t, repo.DeleteBook(ctx, id, 1, time.Now(), false), domain.ErrNotFound)
+	assert.ErrorIs(t, repo.DeleteBookCopy(ctx, id), domain.ErrNotFound)
+	assert.ErrorIs(t, repo.DeleteUser(ctx, id, 1, time.Now(), false), domain.ErrNotFound)
+	assert.ErrorIs(t, repo.DeleteLending(ctx, id, 1, time.Now()), domain.ErrNotFound)
+	_, err = repo.GetFineByID(ctx, id)
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+	_, err = repo.GetHoldByID(ctx, id)
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+
+	book := domain.Book{ID: uuid.New().String(), Title: "The Hobbit", Author: "J. R. R. Tolkien"}
+	_, err = repo.CreateBook(ctx, book)
+	assert.NoError(t, err)
+	bookCopy := domain.BookCopy{ID: uuid.New().String(), BookID: book.ID, Barcode: "LIB-0001", Status: domain.CopyStatusAvailable}
+	_, err = repo.CreateBookCopy(ctx, bookCopy)
+	assert.NoError(t, err)
+	bookCopy.ID = uuid.New().String()
+	_, err = repo.CreateBookCopy(ctx, bookCopy)
+	assert.ErrorIs(t, err, domain.ErrConflict)
+}
+
+func TestWithTx(t *testing.T) {
+	repo := New()
+	book := domain.Book{ID: uuid.New().String(), Title: "Dune", Author: "Frank Herbert"}
+	user := domain.User{ID: uuid.New().String(), Name: "Paul", Email: "paul@example.com", Category: domain.UserCategoryStudent}
+	failure := errors.New("failure")
+
+	err := repo.WithTx(ctx, func(tx repository.Repository) error {
+		if _, err := tx.CreateBook(ctx, book); err != nil {
+			return err
+		}
+		_, err := tx.GetBookByID(ctx, book.ID)
+		return err
+	})
+	assert.NoError(t, err)
+	_, err = repo.GetBookByID(ctx, book.ID)
+	assert.NoError(t, err, "committed book")
+
+	err = repo.WithTx(ctx, func(tx repository.Repository) error {
+		if _, err := tx.CreateUser(ctx, user); err != nil {
+			return err
+		}
+		if err := tx.DeleteBook(ctx, book.ID, 1, time.Now(), false); err != nil {
+			return err
+		}
+		return failure
+	})
+	assert.ErrorIs(t, err, failure)
+	_, err = repo.GetUserByID(ctx, user.ID)
+	assert.ErrorIs(t, err, repository.ErrUserNotFound, "rolled back user")
+	_, err = repo.GetBookByID(ctx, book.ID)
+	assert.NoError(t, err, "rolled back delete")
+	hits, err := repo.SearchBooks(ctx, "dune", repository.ListOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, hits.Total, "search index rolled back")
+
+	err = repo.WithTx(ctx, func(tx repository.Repository) error {
+		if _, err := tx.CreateUser(ctx, user); err != nil {
+			return err
+		}
+		nested := tx.WithTx(ctx, func(nested repository.Repository) error {
+			if err := nested.DeleteBook(ctx, book.ID, 1, time.Now(), false); err != nil {
+				return err
+			}
+			return failure
+		})
+		assert.ErrorIs(t, nested, failure)
+		return nil
+	})
+	assert.NoError(t, err)
+	_, err = repo.GetUserByID(ctx, user.ID)
+	assert.NoError(t, err, "outer transaction committed")
+	_, err = repo.GetBookByID(ctx, book.ID)
+	assert.NoError(t, err, "nested transaction rolled back")
+
+	// A nested transaction that commits is still undone with the outer one.
+	err = repo.WithTx(ctx, func(tx repository.Repository) error {
+		err := tx.WithTx(ctx, func(nested repository.Repository) error {
+			_, err := nested.UpdateBook(ctx, domain.Book{ID: book.ID, Title: "Children of Dune", Author: "Frank Herbert", Version: 1})
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return failure
+	})
+	assert.ErrorIs(t, err, failure)
+	stored, err := repo.GetBookByID(ctx, book.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "Dune", stored.Title, "rolled back update")
+	assert.Equal(t, 1, stored.Version)
+	hits, err = repo.SearchBooks(ctx, "children", repository.ListOptions{})
+	assert.NoError(t, err)
+	assert.Zero(t, hits.Total, "search index rolled back")
+	hits, err = repo.SearchBooks(ctx, "dune", repository.ListOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, hits.Total)
+}
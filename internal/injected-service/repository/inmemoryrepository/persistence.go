@@ -0,0 +1,438 @@
+package inmemoryrepository
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"libary-service/internal/domain"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// A persistent repository keeps its data in a directory: snapshot.json holds
+// every row at the time it was taken, and wal.jsonl holds one line per write
+// since then with the rows that write changed. Rows are logged rather than
+// operations, so replaying a line twice is harmless, and a snapshot that was
+// taken right before a crash can be followed by the log it already contains.
+const (
+	snapshotFile = "snapshot.json"
+	logFile      = "wal.jsonl"
+)
+
+// defaultSnapshotEvery is how many logged writes trigger a new snapshot.
+const defaultSnapshotEvery = 1000
+
+// snapshot is every row of a repository.
+type snapshot struct {
+	Books    map[string]domain.Book     `json:"books"`
+	Copies   map[string]domain.BookCopy `json:"copies"`
+	Users    map[string]domain.User     `json:"users"`
+	Lendings map[string]domain.Lending  `json:"lendings"`
+	Fines    map[string]domain.Fine     `json:"fines"`
+	Holds    map[string]domain.Hold     `json:"holds"`
+}
+
+// logEntry is one changed row of a write.
+type logEntry struct {
+	Table string `json:"table"`
+	ID    string `json:"id"`
+	// Row is missing when the row was deleted.
+	Row json.RawMessage `json:"row,omitempty"`
+}
+
+// tableName names the table of a row type in the log.
+func tableName(row any) string {
+	switch row.(type) {
+	case domain.Book:
+		return "books"
+	case domain.BookCopy:
+		return "copies"
+	case domain.User:
+		return "users"
+	case domain.Lending:
+		return "lendings"
+	case domain.Fine:
+		return "fines"
+	case domain.Hold:
+		return "holds"
+	}
+	panic(fmt.Sprintf("no table for %T", row))
+}
+
+// change is a row that the running write touched.
+type change struct {
+	table, id string
+	// current returns the row as it is now and false once it is deleted.
+	current func() (any, bool)
+	// undo puts back the row as it was before the write.
+	undo func()
+}
+
+// journal collects the rows a write changes until they are logged or undone.
+// A nil journal collects nothing, which is what repositories that do not
+// persist use outside of transactions.
+type journal struct {
+	changes []change
+	seen    map[string]bool
+}
+
+func newJournal() *journal {
+	return &journal{seen: make(map[string]bool)}
+}
+
+// note records that the row id of rows is about to change.
+func note[T any](j *journal, rows map[string]T, id string) {
+	if j == nil {
+		return
+	}
+	var zero T
+	table := tableName(zero)
+	if j.seen[table+"/"+id] {
+		return
+	}
+	j.seen[table+"/"+id] = true
+	before, existed := rows[id]
+	j.changes = append(j.changes, change{
+		table: table,
+		id:    id,
+		current: func() (any, bool) {
+			row, ok := rows[id]
+			return row, ok
+		},
+		undo: func() {
+			if existed {
+				rows[id] = before
+			} else {
+				delete(rows, id)
+			}
+		},
+	})
+}
+
+// put stores a row and notes the change. The caller must hold repo.mu.
+func put[T any](j *journal, rows map[string]T, id string, row T) {
+	note(j, rows, id)
+	rows[id] = row
+}
+
+// remove deletes a row and notes the change. The caller must hold repo.mu.
+func remove[T any](j *journal, rows map[string]T, id string) {
+	note(j, rows, id)
+	delete(rows, id)
+}
+
+// entries turns the changes into log entries with the rows as they are now.
+func (j *journal) entries() ([]logEntry, error) {
+	entries := make([]logEntry, 0, len(j.changes))
+	for _, c := range j.changes {
+		entry := logEntry{Table: c.table, ID: c.id}
+		if row, ok := c.current(); ok {
+			data, err := json.Marshal(row)
+			if err != nil {
+				return nil, err
+			}
+			entry.Row = data
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (j *journal) undo() {
+	for i := len(j.changes) - 1; i >= 0; i-- {
+		j.changes[i].undo()
+	}
+}
+
+// merge adds the changes of a nested transaction.
+func (j *journal) merge(other *journal) {
+	for _, c := range other.changes {
+		j.seen[c.table+"/"+c.id] = true
+		j.changes = append(j.changes, c)
+	}
+}
+
+// store is the directory a persistent repository writes to.
+type store struct {
+	dir           string
+	wal           *os.File
+	snapshotEvery int
+	// logged counts the writes in the log since the last snapshot.
+	logged int
+}
+
+// open restores the rows of dir into repo and starts a fresh log: the
+// restored rows become the new snapshot, which also drops a torn last line
+// that a crash may have left in the log. The caller must hold repo.mu.
+func (repo *InMemoryRepository) open(dir string, snapshotEvery int) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if err := repo.readSnapshot(filepath.Join(dir, snapshotFile)); err != nil {
+		return err
+	}
+	if err := repo.replay(filepath.Join(dir, logFile)); err != nil {
+		return err
+	}
+	repo.clearZeroDeletedAt()
+	repo.rebuildSearch()
+	s := &store{dir: dir, snapshotEvery: snapshotEvery}
+	if err := s.snapshot(repo.snapshot()); err != nil {
+		return err
+	}
+	repo.store = s
+	repo.journal = newJournal()
+	return nil
+}
+
+func (repo *InMemoryRepository) readSnapshot(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var s snapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	for id, row := range s.Books {
+		repo.books[id] = row
+	}
+	for id, row := range s.Copies {
+		repo.copies[id] = row
+	}
+	for id, row := range s.Users {
+		repo.users[id] = row
+	}
+	for id, row := range s.Lendings {
+		repo.lendings[id] = row
+	}
+	for id, row := range s.Fines {
+		repo.fines[id] = row
+	}
+	for id, row := range s.Holds {
+		repo.holds[id] = row
+	}
+	return nil
+}
+
+// replay applies the writes of the log in order. A last line that cannot be
+// read was torn by a crash before its write was acknowledged and is skipped.
+func (repo *InMemoryRepository) replay(path string) error {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for line := 1; ; line++ {
+		data, readErr := reader.ReadBytes('\n')
+		if len(data) == 0 && readErr != nil {
+			break
+		}
+		var entries []logEntry
+		if err := json.Unmarshal(data, &entries); err != nil {
+			if readErr != nil {
+				log.Printf("Skipping torn last line %d of %s", line, path)
+				break
+			}
+			return fmt.Errorf("reading line %d of %s: %w", line, path, err)
+		}
+		for _, entry := range entries {
+			if err := repo.apply(entry); err != nil {
+				return fmt.Errorf("replaying line %d of %s: %w", line, path, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (repo *InMemoryRepository) apply(entry logEntry) error {
+	switch entry.Table {
+	case "books":
+		return restore(repo.books, entry)
+	case "copies":
+		return restore(repo.copies, entry)
+	case "users":
+		return restore(repo.users, entry)
+	case "lendings":
+		return restore(repo.lendings, entry)
+	case "fines":
+		return restore(repo.fines, entry)
+	case "holds":
+		return restore(repo.holds, entry)
+	}
+	return fmt.Errorf("unknown table %q", entry.Table)
+}
+
+func restore[T any](rows map[string]T, entry logEntry) error {
+	if entry.Row == nil {
+		delete(rows, entry.ID)
+		return nil
+	}
+	var row T
+	if err := json.Unmarshal(entry.Row, &row); err != nil {
+		return err
+	}
+	rows[entry.ID] = row
+	return nil
+}
+
+// clearZeroDeletedAt undeletes the rows that files written before DeletedAt
+// could be nil stored with the zero time. The caller must hold repo.mu.
+func (repo *InMemoryRepository) clearZeroDeletedAt() {
+	for id, b := range repo.books {
+		if b.DeletedAt != nil && b.DeletedAt.IsZero() {
+			b.DeletedAt = nil
+			repo.books[id] = b
+		}
+	}
+	for id, u := range repo.users {
+		if u.DeletedAt != nil && u.DeletedAt.IsZero() {
+			u.DeletedAt = nil
+			repo.users[id] = u
+		}
+	}
+	for id, l := range repo.lendings {
+		if l.DeletedAt != nil && l.DeletedAt.IsZero() {
+			l.DeletedAt = nil
+			repo.lendings[id] = l
+		}
+	}
+}
+
+// rebuildSearch indexes every book again. The caller must hold repo.mu.
+func (repo *InMemoryRepository) rebuildSearch() {
+	repo.search = make(searchIndex)
+	for _, book := range repo.books {
+		if book.DeletedAt == nil {
+			repo.search.add(book)
+		}
+	}
+}
+
+// snapshot returns the rows of repo. The caller must hold repo.mu.
+func (repo *InMemoryRepository) snapshot() snapshot {
+	return snapshot{
+		Books:    repo.books,
+		Copies:   repo.copies,
+		Users:    repo.users,
+		Lendings: repo.lendings,
+		Fines:    repo.fines,
+		Holds:    repo.holds,
+	}
+}
+
+// persist logs the rows the running write changed. When the log cannot be
+// written the changes are undone, so that memory never holds more than the
+// disk. Transactions keep collecting until WithTx logs them all at once.
+// The caller must hold repo.mu.
+func (repo *InMemoryRepository) persist() error {
+	if repo.store == nil {
+		return nil
+	}
+	changes := repo.journal
+	repo.journal = newJournal()
+	if err := repo.store.append(changes); err != nil {
+		repo.rollback(changes)
+		return err
+	}
+	repo.compact()
+	return nil
+}
+
+// compact takes a snapshot once enough writes have been logged. A failed
+// snapshot loses nothing because the log still holds every write.
+// The caller must hold repo.mu.
+func (repo *InMemoryRepository) compact() {
+	if repo.store.logged < repo.store.snapshotEvery {
+		return
+	}
+	if err := repo.store.snapshot(repo.snapshot()); err != nil {
+		log.Printf("Failed to take a snapshot of %s: %v", repo.store.dir, err)
+	}
+}
+
+// append writes the changes of one write as one line and waits for the disk.
+func (s *store) append(changes *journal) error {
+	if len(changes.changes) == 0 {
+		return nil
+	}
+	entries, err := changes.entries()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	if _, err := s.wal.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := s.wal.Sync(); err != nil {
+		return err
+	}
+	s.logged++
+	return nil
+}
+
+// snapshot replaces the snapshot file and starts an empty log. The new
+// snapshot is renamed into place, so a crash leaves either the old or the new.
+func (s *store) snapshot(rows snapshot) error {
+	data, err := json.Marshal(rows)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir, snapshotFile)
+	if err := writeFileSync(path+".tmp", data); err != nil {
+		return err
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return err
+	}
+	if s.wal != nil {
+		s.wal.Close()
+	}
+	wal, err := os.Create(filepath.Join(s.dir, logFile))
+	if err != nil {
+		s.wal = nil
+		return err
+	}
+	s.wal = wal
+	s.logged = 0
+	return nil
+}
+
+// close takes a last snapshot so that the next start has no log to replay.
+func (s *store) close(rows snapshot) error {
+	err := s.snapshot(rows)
+	if s.wal != nil {
+		if closeErr := s.wal.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func writeFileSync(path string, data []byte) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
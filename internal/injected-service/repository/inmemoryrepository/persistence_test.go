@@ -0,0 +1,155 @@
+package inmemoryrepository
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"libary-service/internal/domain"
+	"libary-service/internal/injected-service/repository"
+	"libary-service/internal/injected-service/repository/repositorytest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// connectPersistent returns a repository that persists to dir.
+func connectPersistent(t *testing.T, dir string) *InMemoryRepository {
+	t.Setenv("MEMORY_DATA_DIR", dir)
+	repo := New()
+	require.NoError(t, repo.Connect(ctx))
+	return repo
+}
+
+// assertRestored checks that a repository restored from dir holds the same
+// rows as repo, as if the process had crashed right now.
+func assertRestored(t *testing.T, repo *InMemoryRepository, dir string) {
+	restored := connectPersistent(t, dir)
+	defer restored.Disconnect(ctx)
+
+	repo.mu.Lock()
+	expected, err := json.Marshal(repo.snapshot())
+	repo.mu.Unlock()
+	require.NoError(t, err)
+	actual, err := json.Marshal(restored.snapshot())
+	require.NoError(t, err)
+	assert.JSONEq(t, string(expected), string(actual))
+}
+
+func TestConformancePersistent(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.Repository {
+		dir := t.TempDir()
+		repo := connectPersistent(t, dir)
+		t.Cleanup(func() { assertRestored(t, repo, dir) })
+		return repo
+	})
+}
+
+func TestPersistence(t *testing.T) {
+	dir := t.TempDir()
+	repo := connectPersistent(t, dir)
+	bookID := createBook(t, repo)
+	userID := createUser(t, repo)
+	err := repo.WithTx(ctx, func(tx repository.Repository) error {
+		_, err := tx.CreateBookCopy(ctx, domain.BookCopy{ID: uuid.NewString(), BookID: bookID, Barcode: "B1", Status: domain.CopyStatusAvailable})
+		return err
+	})
+	require.NoError(t, err)
+	err = repo.WithTx(ctx, func(tx repository.Repository) error {
+		if err := tx.DeleteUser(ctx, userID, 1, time.Now(), false); err != nil {
+			return err
+		}
+		return errors.New("failure")
+	})
+	assert.Error(t, err)
+
+	// The repository is not disconnected, like after a crash.
+	restored := connectPersistent(t, dir)
+	_, err = restored.GetUserByID(ctx, userID)
+	assert.NoError(t, err)
+	copies, err := restored.GetBookCopies(ctx, bookID)
+	assert.NoError(t, err)
+	assert.Len(t, copies, 1)
+	hits, err := restored.SearchBooks(ctx, "hobbit", repository.ListOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, hits.Total)
+
+	deletedAt := time.Now()
+	assert.NoError(t, restored.DeleteBook(ctx, bookID, 1, deletedAt, false))
+	_, err = restored.PurgeDeleted(ctx, deletedAt.Add(time.Second))
+	assert.NoError(t, err)
+	assert.NoError(t, restored.Disconnect(ctx))
+	wal, err := os.ReadFile(filepath.Join(dir, logFile))
+	assert.NoError(t, err)
+	assert.Empty(t, wal, "Disconnect compacts the log into the snapshot")
+
+	restored = connectPersistent(t, dir)
+	_, err = restored.GetBookByID(ctx, bookID)
+	assert.ErrorIs(t, err, repository.ErrBookNotFound)
+	copies, err = restored.GetBookCopies(ctx, bookID)
+	assert.NoError(t, err)
+	assert.Empty(t, copies)
+}
+
+func TestPersistenceCompaction(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("MEMORY_SNAPSHOT_EVERY", "2")
+	repo := connectPersistent(t, dir)
+	for i := 0; i < 3; i++ {
+		createBook(t, repo)
+	}
+
+	wal, err := os.ReadFile(filepath.Join(dir, logFile))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, bytes.Count(wal, []byte("\n")), "the first two writes are in the snapshot")
+	assertRestored(t, repo, dir)
+}
+
+func TestPersistenceTornLog(t *testing.T) {
+	dir := t.TempDir()
+	repo := connectPersistent(t, dir)
+	bookID := createBook(t, repo)
+
+	wal, err := os.OpenFile(filepath.Join(dir, logFile), os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = wal.WriteString(`[{"table":"books","id":"`)
+	require.NoError(t, err)
+	require.NoError(t, wal.Close())
+
+	restored := connectPersistent(t, dir)
+	_, err = restored.GetBookByID(ctx, bookID)
+	assert.NoError(t, err)
+	createBook(t, restored)
+	assertRestored(t, restored, dir)
+}
+
+func TestPersistenceZeroDeletedAt(t *testing.T) {
+	dir := t.TempDir()
+	bookID := uuid.NewString()
+	data := `{"books":{"` + bookID + `":{"id":"` + bookID + `","title":"The Hobbit","author":"J.R.R. Tolkien","deleted_at":"0001-01-01T00:00:00Z","version":1}}}`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, snapshotFile), []byte(data), 0o644))
+
+	repo := connectPersistent(t, dir)
+	book, err := repo.GetBookByID(ctx, bookID)
+	require.NoError(t, err, "book stored with a zero deleted_at")
+	assert.Nil(t, book.DeletedAt)
+	hits, err := repo.SearchBooks(ctx, "hobbit", repository.ListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, hits.Total)
+}
+
+func TestPersistenceCorruptLog(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, logFile), []byte("garbage\n[]\n"), 0o644))
+	t.Setenv("MEMORY_DATA_DIR", dir)
+	assert.Error(t, New().Connect(ctx))
+}
+
+func TestConnectInvalidSnapshotEvery(t *testing.T) {
+	t.Setenv("MEMORY_DATA_DIR", t.TempDir())
+	t.Setenv("MEMORY_SNAPSHOT_EVERY", "0")
+	assert.Error(t, New().Connect(ctx))
+}
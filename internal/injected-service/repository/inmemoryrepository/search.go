@@ -0,0 +1,73 @@
+package inmemoryrepository
+
+import (
+	"context"
+	"libary-service/internal/domain"
+	"libary-service/internal/injected-service/repository"
+)
+
+// searchIndex is an inverted index from lower-cased terms to the books that
+// contain them and the weight of the term in each book.
+type searchIndex map[string]map[string]float64
+
+func (index searchIndex) add(book domain.Book) {
+	for term, weight := range repository.TermWeights(book) {
+		if index[term] == nil {
+			index[term] = make(map[string]float64)
+		}
+		index[term][book.ID] = weight
+	}
+}
+
+func (index searchIndex) remove(book domain.Book) {
+	for term := range repository.TermWeights(book) {
+		delete(index[term], book.ID)
+		if len(index[term]) == 0 {
+			delete(index, term)
+		}
+	}
+}
+
+// match ranks the books that contain every term.
+func (index searchIndex) match(terms []string) map[string]float64 {
+	if len(terms) == 0 {
+		return nil
+	}
+	ranks := make(map[string]float64)
+	for id, weight := range index[terms[0]] {
+		ranks[id] = weight
+	}
+	for _, term := range terms[1:] {
+		for id, rank := range ranks {
+			weight, ok := index[term][id]
+			if !ok {
+				delete(ranks, id)
+				continue
+			}
+			ranks[id] = rank + weight
+		}
+	}
+	return ranks
+}
+
+func (repo *InMemoryRepository) SearchBooks(ctx context.Context, query string, options repository.ListOptions) (repository.Page[domain.BookSearchHit], error) {
+	if options.Sort != "" {
+		return repository.Page[domain.BookSearchHit]{}, repository.ErrInvalidSort
+	}
+	repo.mu.Lock()
+	defer repo.mu.Unlock()
+
+	queryTerms := repository.SearchTerms(query)
+	ranks := repo.search.match(queryTerms)
+	hits := make([]domain.BookSearchHit, 0, len(ranks))
+	for id, rank := range ranks {
+		book := repo.books[id]
+		hits = append(hits, domain.BookSearchHit{
+			Book:            book,
+			Rank:            rank,
+			TitleHighlight:  repository.Highlight(book.Title, queryTerms),
+			AuthorHighlight: repository.Highlight(repository.AuthorList(book), queryTerms),
+		})
+	}
+	return repository.PaginateSearchHits(hits, options)
+}
@@ -0,0 +1,163 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"libary-service/internal/domain"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrInvalidCursor is returned when a cursor was not issued for the requested sort.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// ErrInvalidSort is returned when a list cannot be sorted by the requested field.
+var ErrInvalidSort = errors.New("invalid sort")
+
+// ListOptions selects one page of a list.
+type ListOptions struct {
+	// Limit is the page size. Zero returns every remaining item.
+	Limit int
+	// Cursor is the NextCursor of the previous page, empty for the first page.
+	Cursor string
+	// Sort is a field name, prefixed with "-" for descending order.
+	// Ties are always broken by id.
+	Sort string
+}
+
+// Page is one slice of a list together with the size of the whole list.
+type Page[T any] struct {
+	Items []T
+	// NextCursor continues the list after Items. It is empty on the last page.
+	NextCursor string
+	// Total counts every item that matches the filter, not just this page.
+	Total int
+}
+
+type BookFilter struct {
+	// Author keeps the books this author wrote, alone or with others.
+	Author      string
+	TitlePrefix string
+	// ISBN keeps only the book with this ISBN-13.
+	ISBN string
+	// IncludeDeleted also lists books that were deleted.
+	IncludeDeleted bool
+}
+
+type UserFilter struct {
+	Category domain.UserCategory
+	// Email keeps only the user with this address, compared ignoring case.
+	Email string
+	// IncludeDeleted also lists users that were deleted.
+	IncludeDeleted bool
+}
+
+type LendingFilter struct {
+	UserID string
+	BookID string
+	// Active keeps only lendings that are (true) or are not (false) returned yet.
+	Active *bool
+	// Overdue keeps only lendings that are (true) or are not (false) still out
+	// after their due date at Now.
+	Overdue *bool
+	Now     time.Time
+	// IncludeDeleted also lists lendings that were deleted.
+	IncludeDeleted bool
+}
+
+// Cursor is the keyset position after the last item of a page: the value of
+// the sort field and the id of that item.
+type Cursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// EncodeCursor turns a cursor into the opaque string handed to clients.
+func EncodeCursor(c Cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor reads a cursor issued by EncodeCursor for the given sort.
+func DecodeCursor(encoded, sort string) (Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(data, &c); err != nil || c.Sort != sort || c.ID == "" {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// SortField splits a sort option into the field and its direction and checks
+// the field against the allowed ones. An empty sort selects the fallback field.
+func SortField(sort, fallback string, allowed ...string) (field string, descending bool, err error) {
+	if sort == "" {
+		return fallback, false, nil
+	}
+	field = strings.TrimPrefix(sort, "-")
+	for _, a := range allowed {
+		if a == field {
+			return field, field != sort, nil
+		}
+	}
+	return "", false, ErrInvalidSort
+}
+
+// SortableTime formats a time so that its string order matches time order.
+func SortableTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000000000Z")
+}
+
+// Paginate sorts the filtered items in (sort field, id) keyset order and cuts
+// out the page after the cursor. key returns the sort value and id of an item.
+func Paginate[T any](items []T, options ListOptions, fallback string, allowed []string,
+	key func(T, string) (string, string)) (Page[T], error) {
+	field, descending, err := SortField(options.Sort, fallback, allowed...)
+	if err != nil {
+		return Page[T]{}, err
+	}
+	less := func(aValue, aID, bValue, bID string) bool {
+		if aValue != bValue {
+			return aValue < bValue
+		}
+		return aID < bID
+	}
+	after := func(item T, value, id string) bool {
+		itemValue, itemID := key(item, field)
+		if descending {
+			return less(itemValue, itemID, value, id)
+		}
+		return less(value, id, itemValue, itemID)
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		aValue, aID := key(items[i], field)
+		bValue, bID := key(items[j], field)
+		if descending {
+			return less(bValue, bID, aValue, aID)
+		}
+		return less(aValue, aID, bValue, bID)
+	})
+
+	page := Page[T]{Items: items, Total: len(items)}
+	if options.Cursor != "" {
+		cursor, err := DecodeCursor(options.Cursor, options.Sort)
+		if err != nil {
+			return Page[T]{}, err
+		}
+		start := sort.Search(len(items), func(i int) bool { return after(items[i], cursor.Value, cursor.ID) })
+		page.Items = items[start:]
+	}
+	if options.Limit > 0 && len(page.Items) > options.Limit {
+		page.Items = page.Items[:options.Limit]
+		value, id := key(page.Items[options.Limit-1], field)
+		page.NextCursor = EncodeCursor(Cursor{Sort: options.Sort, Value: value, ID: id})
+	}
+	return page, nil
+}
@@ -0,0 +1,123 @@
+package postgresrepository
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"libary-service/internal/injected-service/repository"
+	"strings"
+	"time"
+)
+
+// listQuery collects the WHERE conditions of a list query. Conditions use
+// %d verbs that are replaced by the numbers of their arguments.
+type listQuery struct {
+	conditions []string
+	args       []interface{}
+}
+
+func (q *listQuery) where(condition string, args ...interface{}) {
+	placeholders := make([]interface{}, len(args))
+	for i, arg := range args {
+		q.args = append(q.args, arg)
+		placeholders[i] = len(q.args)
+	}
+	q.conditions = append(q.conditions, fmt.Sprintf(condition, placeholders...))
+}
+
+func (q listQuery) clause() string {
+	if len(q.conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(q.conditions, " AND ")
+}
+
+// sortColumns maps the sortable fields of a table to whether they hold timestamps.
+type sortColumns map[string]bool
+
+func (c sortColumns) names() []string {
+	names := make([]string, 0, len(c))
+	for name := range c {
+		names = append(names, name)
+	}
+	return names
+}
+
+// listPage counts every row of table that matches q and then selects the page
+// after the cursor in (sort column, id) keyset order.
+func listPage[T any](ctx context.Context, repo *PostgresRepository, table, columns string, q listQuery, options repository.ListOptions,
+	sortable sortColumns, fallback string, scan func(pgx.Row) (T, error), key func(T, string) (string, string)) (repository.Page[T], error) {
+	field, descending, err := repository.SortField(options.Sort, fallback, sortable.names()...)
+	if err != nil {
+		return repository.Page[T]{}, err
+	}
+
+	var total int
+	if err := repo.db.QueryRow(ctx, "SELECT COUNT(*) FROM "+table+q.clause(), q.args...).Scan(&total); err != nil {
+		return repository.Page[T]{}, err
+	}
+
+	order, after := "ASC", ">"
+	if descending {
+		order, after = "DESC", "<"
+	}
+	if options.Cursor != "" {
+		cursor, err := repository.DecodeCursor(options.Cursor, options.Sort)
+		if err != nil {
+			return repository.Page[T]{}, err
+		}
+		var value interface{} = cursor.Value
+		if sortable[field] {
+			if value, err = time.Parse(time.RFC3339Nano, cursor.Value); err != nil {
+				return repository.Page[T]{}, repository.ErrInvalidCursor
+			}
+		}
+		if field == "id" {
+			q.where("id "+after+" $%d", cursor.ID)
+		} else {
+			q.where("("+field+", id) "+after+" ($%d, $%d)", value, cursor.ID)
+		}
+	}
+
+	sql := "SELECT " + columns + " FROM " + table + q.clause() + " ORDER BY "
+	if field != "id" {
+		sql += field + " " + order + ", "
+	}
+	sql += "id " + order
+	if options.Limit > 0 {
+		// One extra row tells whether there is a next page.
+		sql += fmt.Sprintf(" LIMIT %d", options.Limit+1)
+	}
+
+	rows, err := repo.db.Query(ctx, sql, q.args...)
+	if err != nil {
+		return repository.Page[T]{}, err
+	}
+	defer rows.Close()
+
+	items := make([]T, 0)
+	for rows.Next() {
+		item, err := scan(rows)
+		if err != nil {
+			return repository.Page[T]{}, err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return repository.Page[T]{}, err
+	}
+
+	page := repository.Page[T]{Items: items, Total: total}
+	if options.Limit > 0 && len(items) > options.Limit {
+		page.Items = items[:options.Limit]
+		value, id := key(page.Items[options.Limit-1], field)
+		page.NextCursor = repository.EncodeCursor(repository.Cursor{Sort: options.Sort, Value: value, ID: id})
+	}
+	return page, nil
+}
+
+// likePrefix turns user input into a LIKE pattern that matches it as a prefix.
+func likePrefix(prefix string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return replacer.Replace(prefix) + "%"
+}
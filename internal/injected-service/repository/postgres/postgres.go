@@ -5,208 +5,474 @@ import (
 	"database/sql"
 	"errors"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"libary-service/internal/database"
 	"libary-service/internal/domain"
+	"libary-service/internal/injected-service/repository"
 	"log"
 	"os"
 	"time"
 )
 
 type PostgresRepository struct {
-	db *pgx.Conn
+	pool *pgxpool.Pool
+	db   querier
+}
+
+// querier is the part of a pool or a transaction the queries run on. Begin on
+// a transaction starts a savepoint.
+type querier interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
 }
 
 func New() *PostgresRepository {
 	return &PostgresRepository{}
 }
 
-func (repo *PostgresRepository) Connect() error {
-	dbURL := os.Getenv("DATABASE_URL")
-	var err error
-	conn, err := pgx.Connect(context.Background(), dbURL)
+func (repo *PostgresRepository) Connect(ctx context.Context) error {
+	pool, err := database.NewPool(ctx, os.Getenv("DATABASE_URL"))
 	if err != nil {
 		return err
 	}
-	repo.db = conn
+	repo.pool = pool
+	repo.db = pool
 	log.Printf("Successfully connected to database")
 	return nil
 }
 
-func (repo *PostgresRepository) Disconnect() error {
-	if repo.db != nil {
-		repo.db.Close(context.Background())
+func (repo *PostgresRepository) Disconnect(ctx context.Context) error {
+	if repo.pool != nil {
+		repo.pool.Close()
 		log.Printf("Successfully disconnected from database")
 	}
 	return errors.New("error closing database connection")
 }
 
-func (repo *PostgresRepository) GetBooks() ([]domain.Book, error) {
-	rows, err := repo.db.Query(context.Background(), "SELECT id, title, author FROM books")
+// PoolStats reports the state of the connection pool.
+func (repo *PostgresRepository) PoolStats() database.PoolStats {
+	return database.StatsOf(repo.pool)
+}
+
+// WithTx runs fn on a repository bound to a new transaction, or to a savepoint
+// when repo is already bound to one. Like a pgx.Tx, the repository passed to fn
+// must not be used concurrently.
+func (repo *PostgresRepository) WithTx(ctx context.Context, fn func(tx repository.Repository) error) error {
+	tx, err := repo.db.Begin(ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
+	defer tx.Rollback(ctx)
 
-	var books []domain.Book
-	for rows.Next() {
-		var b domain.Book
-		if err := rows.Scan(&b.ID, &b.Title, &b.Author); err != nil {
-			return nil, err
-		}
-		books = append(books, b)
+	if err := fn(&PostgresRepository{pool: repo.pool, db: tx}); err != nil {
+		return err
 	}
-	return books, nil
+	return tx.Commit(ctx)
 }
 
-var ErrBookNotFound = errors.New("book not found")
+// bookColumns selects a book from a table or subquery named books, together
+// with its authors from book_authors.
+const bookColumns = "id, title, author, " +
+	"ARRAY(SELECT name FROM book_authors WHERE book_authors.book_id = books.id ORDER BY position), " +
+	"isbn, publisher, publication_year, edition, language, page_count, subjects, description, deleted_at, version"
+
+// scanBook reads a row selected with bookColumns.
+func scanBook(row pgx.Row) (domain.Book, error) {
+	return scanBookWith(row)
+}
 
-func (repo *PostgresRepository) GetBookByID(id string) (domain.Book, error) {
+// scanBookWith reads a row selected with bookColumns followed by extra columns.
+func scanBookWith(row pgx.Row, extra ...any) (domain.Book, error) {
 	var b domain.Book
-	err := repo.db.QueryRow(context.Background(), "SELECT id, title, author FROM books WHERE id = $1", id).
-		Scan(&b.ID, &b.Title, &b.Author)
+	var isbn sql.NullString
+	var deletedAt sql.NullTime
+	dest := []any{&b.ID, &b.Title, &b.Author, &b.Authors, &isbn, &b.Publisher, &b.PublicationYear,
+		&b.Edition, &b.Language, &b.PageCount, &b.Subjects, &b.Description, &deletedAt, &b.Version}
+	err := row.Scan(append(dest, extra...)...)
+	b.Authors = nilIfEmpty(b.Authors)
+	b.ISBN = isbn.String
+	b.Subjects = nilIfEmpty(b.Subjects)
+	b.DeletedAt = nullTime(deletedAt)
+	return b, err
+}
+
+// nilIfEmpty turns the empty arrays Postgres returns into the nil slices
+// books without authors or subjects are created with.
+func nilIfEmpty(values []string) []string {
+	if len(values) == 0 {
+		return nil
+	}
+	return values
+}
+
+// nullTime turns a NULL timestamp into nil.
+func nullTime(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	return &t.Time
+}
+
+func (repo *PostgresRepository) GetBooks(ctx context.Context, filter repository.BookFilter, options repository.ListOptions) (repository.Page[domain.Book], error) {
+	var q listQuery
+	if !filter.IncludeDeleted {
+		q.where("deleted_at IS NULL")
+	}
+	if filter.Author != "" {
+		q.where("(author = $%[1]d OR id IN (SELECT book_id FROM book_authors WHERE name = $%[1]d))", filter.Author)
+	}
+	if filter.TitlePrefix != "" {
+		q.where("title LIKE $%d", likePrefix(filter.TitlePrefix))
+	}
+	if filter.ISBN != "" {
+		q.where("isbn = $%d", filter.ISBN)
+	}
+	return listPage(ctx, repo, "books", bookColumns, q, options,
+		sortColumns{"id": false, "title": false, "author": false}, "title", scanBook,
+		func(b domain.Book, field string) (string, string) {
+			switch field {
+			case "title":
+				return b.Title, b.ID
+			case "author":
+				return b.Author, b.ID
+			}
+			return b.ID, b.ID
+		})
+}
+
+func (repo *PostgresRepository) GetBookByID(ctx context.Context, id string) (domain.Book, error) {
+	b, err := scanBook(repo.db.QueryRow(ctx, "SELECT "+bookColumns+" FROM books WHERE id = $1 AND deleted_at IS NULL", id))
 	if errors.Is(err, pgx.ErrNoRows) {
-		return domain.Book{}, ErrBookNotFound
+		return domain.Book{}, repository.ErrBookNotFound
 	} else if err != nil {
 		return domain.Book{}, err
 	}
 	return b, nil
 }
 
-func (repo *PostgresRepository) CreateBook(book domain.Book) (domain.Book, error) {
-	_, err := repo.db.Exec(context.Background(), "INSERT INTO books (id, title, author) VALUES ($1, $2, $3)",
-		book.ID, book.Title, book.Author)
+func (repo *PostgresRepository) CreateBook(ctx context.Context, book domain.Book) (domain.Book, error) {
+	tx, err := repo.db.Begin(ctx)
 	if err != nil {
 		return domain.Book{}, err
 	}
-	return book, nil
+	defer tx.Rollback(ctx)
+
+	book.DeletedAt = nil
+	book.Version = 1
+	_, err = tx.Exec(ctx,
+		"INSERT INTO books (id, title, author, isbn, publisher, publication_year, edition, language, page_count, subjects, description, version)"+
+			" VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)",
+		book.ID, book.Title, book.Author, nullableID(book.ISBN), book.Publisher, book.PublicationYear,
+		book.Edition, book.Language, book.PageCount, subjectList(book.Subjects), book.Description, book.Version)
+	if err != nil {
+		return domain.Book{}, mapConstraintViolation(err)
+	}
+	if err := writeAuthors(ctx, tx, book); err != nil {
+		return domain.Book{}, err
+	}
+	return book, tx.Commit(ctx)
 }
 
-func (repo *PostgresRepository) UpdateBook(book domain.Book) (domain.Book, error) {
-	result, err := repo.db.Exec(context.Background(), "UPDATE books SET title = $2, author = $3 WHERE id = $1",
-		book.ID, book.Title, book.Author)
+func (repo *PostgresRepository) UpdateBook(ctx context.Context, book domain.Book) (domain.Book, error) {
+	tx, err := repo.db.Begin(ctx)
 	if err != nil {
 		return domain.Book{}, err
 	}
-	rowsAffected := result.RowsAffected()
-	if rowsAffected == 0 {
-		return domain.Book{}, ErrBookNotFound
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx,
+		"UPDATE books SET title = $2, author = $3, isbn = $4, publisher = $5, publication_year = $6, edition = $7,"+
+			" language = $8, page_count = $9, subjects = $10, description = $11, version = version + 1"+
+			" WHERE id = $1 AND deleted_at IS NULL AND version = $12",
+		book.ID, book.Title, book.Author, nullableID(book.ISBN), book.Publisher, book.PublicationYear,
+		book.Edition, book.Language, book.PageCount, subjectList(book.Subjects), book.Description, book.Version)
+	if err != nil {
+		return domain.Book{}, mapConstraintViolation(err)
+	}
+	if result.RowsAffected() == 0 {
+		return domain.Book{}, repo.missedUpdate(ctx, "books", book.ID, repository.ErrBookNotFound)
 	}
-	return book, nil
+	if _, err := tx.Exec(ctx, "DELETE FROM book_authors WHERE book_id = $1", book.ID); err != nil {
+		return domain.Book{}, err
+	}
+	if err := writeAuthors(ctx, tx, book); err != nil {
+		return domain.Book{}, err
+	}
+	book.DeletedAt = nil
+	book.Version++
+	return book, tx.Commit(ctx)
+}
+
+// writeAuthors stores the authors of a book in their order.
+func writeAuthors(ctx context.Context, tx pgx.Tx, book domain.Book) error {
+	_, err := tx.Exec(ctx,
+		"INSERT INTO book_authors (book_id, position, name)"+
+			" SELECT $1, position - 1, name FROM unnest($2::text[]) WITH ORDINALITY AS authors(name, position)",
+		book.ID, book.Authors)
+	return err
+}
+
+// subjectList stores a book without subjects as an empty array, not as NULL.
+func subjectList(subjects []string) []string {
+	if subjects == nil {
+		return []string{}
+	}
+	return subjects
 }
 
-func (repo *PostgresRepository) DeleteBook(id string) error {
-	result, err := repo.db.Exec(context.Background(), "DELETE FROM books WHERE id = $1", id)
+func (repo *PostgresRepository) DeleteBook(ctx context.Context, id string, version int, deletedAt time.Time, force bool) error {
+	tx, err := repo.db.Begin(ctx)
 	if err != nil {
 		return err
 	}
-	rowsAffected := result.RowsAffected()
-	if rowsAffected == 0 {
-		return ErrBookNotFound
+	defer tx.Rollback(ctx)
+
+	if !force {
+		if err := checkNoActiveLendings(ctx, tx, "book", id, repository.ErrBookNotFound); err != nil {
+			return err
+		}
 	}
-	return nil
+	result, err := tx.Exec(ctx,
+		"UPDATE books SET deleted_at = $2 WHERE id = $1 AND deleted_at IS NULL AND version = $3", id, deletedAt, version)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repo.missedUpdate(ctx, "books", id, repository.ErrBookNotFound)
+	}
+	if err := cancelHolds(ctx, tx, "book_id", id); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (repo *PostgresRepository) RestoreBook(ctx context.Context, id string) (domain.Book, error) {
+	b, err := scanBook(repo.db.QueryRow(ctx,
+		"UPDATE books SET deleted_at = NULL WHERE id = $1 RETURNING "+bookColumns, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.Book{}, repository.ErrBookNotFound
+	} else if err != nil {
+		return domain.Book{}, mapConstraintViolation(err)
+	}
+	return b, nil
 }
 
-func (repo *PostgresRepository) GetUsers() ([]domain.User, error) {
-	rows, err := repo.db.Query(context.Background(), "SELECT id, name, email FROM users")
+func (repo *PostgresRepository) GetBookCopies(ctx context.Context, bookID string) ([]domain.BookCopy, error) {
+	rows, err := repo.db.Query(ctx,
+		"SELECT id, book_id, barcode, condition, status FROM book_copies WHERE book_id = $1", bookID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var users []domain.User
+	copies := make([]domain.BookCopy, 0)
 	for rows.Next() {
-		var u domain.User
-		if err := rows.Scan(&u.ID, &u.Name, &u.Email); err != nil {
+		var c domain.BookCopy
+		if err := rows.Scan(&c.ID, &c.BookID, &c.Barcode, &c.Condition, &c.Status); err != nil {
 			return nil, err
 		}
-		users = append(users, u)
+		copies = append(copies, c)
 	}
-	return users, nil
+	return copies, nil
 }
 
-var ErrUserNotFound = errors.New("user not found")
+func (repo *PostgresRepository) GetBookCopyByID(ctx context.Context, id string) (domain.BookCopy, error) {
+	var c domain.BookCopy
+	err := repo.db.QueryRow(ctx,
+		"SELECT id, book_id, barcode, condition, status FROM book_copies WHERE id = $1", id).
+		Scan(&c.ID, &c.BookID, &c.Barcode, &c.Condition, &c.Status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.BookCopy{}, repository.ErrBookCopyNotFound
+	} else if err != nil {
+		return domain.BookCopy{}, err
+	}
+	return c, nil
+}
 
-func (repo *PostgresRepository) GetUserByID(id string) (domain.User, error) {
+func (repo *PostgresRepository) CreateBookCopy(ctx context.Context, bookCopy domain.BookCopy) (domain.BookCopy, error) {
+	_, err := repo.db.Exec(ctx,
+		"INSERT INTO book_copies (id, book_id, barcode, condition, status) VALUES ($1, $2, $3, $4, $5)",
+		bookCopy.ID, bookCopy.BookID, bookCopy.Barcode, bookCopy.Condition, bookCopy.Status)
+	if err != nil {
+		return domain.BookCopy{}, mapConstraintViolation(err)
+	}
+	return bookCopy, nil
+}
+
+func (repo *PostgresRepository) UpdateBookCopy(ctx context.Context, bookCopy domain.BookCopy) (domain.BookCopy, error) {
+	result, err := repo.db.Exec(ctx,
+		"UPDATE book_copies SET book_id = $2, barcode = $3, condition = $4, status = $5 WHERE id = $1",
+		bookCopy.ID, bookCopy.BookID, bookCopy.Barcode, bookCopy.Condition, bookCopy.Status)
+	if err != nil {
+		return domain.BookCopy{}, mapConstraintViolation(err)
+	}
+	rowsAffected := result.RowsAffected()
+	if rowsAffected == 0 {
+		return domain.BookCopy{}, repository.ErrBookCopyNotFound
+	}
+	return bookCopy, nil
+}
+
+// DeleteBookCopy refuses while the copy is lent out. Its past lendings are
+// kept without a copy.
+func (repo *PostgresRepository) DeleteBookCopy(ctx context.Context, id string) error {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// The lock keeps the copy from being lent out until it is gone.
+	var locked string
+	err = tx.QueryRow(ctx, "SELECT id FROM book_copies WHERE id = $1 FOR UPDATE", id).Scan(&locked)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return repository.ErrBookCopyNotFound
+	} else if err != nil {
+		return err
+	}
+	if err := checkLendingsReturned(ctx, tx, "copy", id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM book_copies WHERE id = $1", id); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+const userColumns = "id, name, email, category, deleted_at, version"
+
+// scanUser reads a row selected with userColumns.
+func scanUser(row pgx.Row) (domain.User, error) {
 	var u domain.User
-	err := repo.db.QueryRow(context.Background(), "SELECT id, name, email FROM users WHERE id = $1", id).
-		Scan(&u.ID, &u.Name, &u.Email)
+	var deletedAt sql.NullTime
+	err := row.Scan(&u.ID, &u.Name, &u.Email, &u.Category, &deletedAt, &u.Version)
+	u.DeletedAt = nullTime(deletedAt)
+	return u, err
+}
+
+func (repo *PostgresRepository) GetUsers(ctx context.Context, filter repository.UserFilter, options repository.ListOptions) (repository.Page[domain.User], error) {
+	var q listQuery
+	if !filter.IncludeDeleted {
+		q.where("deleted_at IS NULL")
+	}
+	if filter.Category != "" {
+		q.where("category = $%d", filter.Category)
+	}
+	if filter.Email != "" {
+		q.where("lower(email) = lower($%d)", filter.Email)
+	}
+	return listPage(ctx, repo, "users", userColumns, q, options,
+		sortColumns{"id": false, "name": false, "email": false}, "name", scanUser,
+		func(u domain.User, field string) (string, string) {
+			switch field {
+			case "name":
+				return u.Name, u.ID
+			case "email":
+				return u.Email, u.ID
+			}
+			return u.ID, u.ID
+		})
+}
+
+func (repo *PostgresRepository) GetUserByID(ctx context.Context, id string) (domain.User, error) {
+	u, err := scanUser(repo.db.QueryRow(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1 AND deleted_at IS NULL", id))
 	if errors.Is(err, pgx.ErrNoRows) {
-		return domain.User{}, ErrUserNotFound
+		return domain.User{}, repository.ErrUserNotFound
 	} else if err != nil {
 		return domain.User{}, err
 	}
 	return u, nil
 }
 
-func (repo *PostgresRepository) CreateUser(user domain.User) (domain.User, error) {
-	_, err := repo.db.Exec(context.Background(), "INSERT INTO users (id, name, email) VALUES ($1, $2, $3)",
-		user.ID, user.Name, user.Email)
-	if err != nil {
+func (repo *PostgresRepository) LockUser(ctx context.Context, id string) (domain.User, error) {
+	u, err := scanUser(repo.db.QueryRow(ctx, "SELECT "+userColumns+" FROM users WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.User{}, repository.ErrUserNotFound
+	} else if err != nil {
 		return domain.User{}, err
 	}
-	return user, nil
+	return u, nil
 }
 
-func (repo *PostgresRepository) UpdateUser(user domain.User) (domain.User, error) {
-	result, err := repo.db.Exec(context.Background(), "UPDATE users SET name = $2, email = $3 WHERE id = $1",
-		user.ID, user.Name, user.Email)
+func (repo *PostgresRepository) CreateUser(ctx context.Context, user domain.User) (domain.User, error) {
+	user.DeletedAt = nil
+	user.Version = 1
+	_, err := repo.db.Exec(ctx, "INSERT INTO users (id, name, email, category, version) VALUES ($1, $2, $3, $4, $5)",
+		user.ID, user.Name, user.Email, user.Category, user.Version)
 	if err != nil {
-		return domain.User{}, err
-	}
-	rowsAffected := result.RowsAffected()
-	if rowsAffected == 0 {
-		return domain.User{}, ErrUserNotFound
+		return domain.User{}, mapConstraintViolation(err)
 	}
 	return user, nil
 }
 
-func (repo *PostgresRepository) DeleteUser(id string) error {
-	result, err := repo.db.Exec(context.Background(), "DELETE FROM users WHERE id = $1", id)
+func (repo *PostgresRepository) UpdateUser(ctx context.Context, user domain.User) (domain.User, error) {
+	result, err := repo.db.Exec(ctx,
+		"UPDATE users SET name = $2, email = $3, category = $4, version = version + 1 WHERE id = $1 AND deleted_at IS NULL AND version = $5",
+		user.ID, user.Name, user.Email, user.Category, user.Version)
 	if err != nil {
-		return err
+		return domain.User{}, mapConstraintViolation(err)
 	}
-	rowsAffected := result.RowsAffected()
-	if rowsAffected == 0 {
-		return ErrUserNotFound
+	if result.RowsAffected() == 0 {
+		return domain.User{}, repo.missedUpdate(ctx, "users", user.ID, repository.ErrUserNotFound)
 	}
-	return nil
+	user.DeletedAt = nil
+	user.Version++
+	return user, nil
 }
 
-func (repo *PostgresRepository) GetLendings() ([]domain.Lending, error) {
-	rows, err := repo.db.Query(context.Background(), "SELECT id, book_id, user_id, lend_date, return_date FROM lendings")
+func (repo *PostgresRepository) DeleteUser(ctx context.Context, id string, version int, deletedAt time.Time, force bool) error {
+	tx, err := repo.db.Begin(ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
+	defer tx.Rollback(ctx)
 
-	var lendings []domain.Lending
-	for rows.Next() {
-		var l domain.Lending
-		var returnDate sql.NullTime
-		if err := rows.Scan(&l.ID, &l.BookID, &l.UserID, &l.LendDate, &returnDate); err != nil {
-			return nil, err
-		}
-		if returnDate.Valid {
-			l.ReturnDate = returnDate.Time
-		} else {
-			l.ReturnDate = time.Time{}
+	if !force {
+		if err := checkNoActiveLendings(ctx, tx, "user", id, repository.ErrUserNotFound); err != nil {
+			return err
 		}
-		lendings = append(lendings, l)
 	}
-	return lendings, nil
+	result, err := tx.Exec(ctx,
+		"UPDATE users SET deleted_at = $2 WHERE id = $1 AND deleted_at IS NULL AND version = $3", id, deletedAt, version)
+	if err != nil {
+		return err
+	}
+	if result.RowsAffected() == 0 {
+		return repo.missedUpdate(ctx, "users", id, repository.ErrUserNotFound)
+	}
+	if err := cancelHolds(ctx, tx, "user_id", id); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
 }
 
-var ErrLendingNotFound = errors.New("lending not found")
-
-func (repo *PostgresRepository) GetLendingByID(id string) (domain.Lending, error) {
-	var l domain.Lending
-	var returnDate sql.NullTime
-	err := repo.db.QueryRow(context.Background(), "SELECT id, book_id, user_id, lend_date, return_date FROM lendings WHERE id = $1", id).
-		Scan(&l.ID, &l.BookID, &l.UserID, &l.LendDate, &returnDate)
+func (repo *PostgresRepository) RestoreUser(ctx context.Context, id string) (domain.User, error) {
+	u, err := scanUser(repo.db.QueryRow(ctx,
+		"UPDATE users SET deleted_at = NULL WHERE id = $1 RETURNING "+userColumns, id))
 	if errors.Is(err, pgx.ErrNoRows) {
-		return domain.Lending{}, ErrLendingNotFound
+		return domain.User{}, repository.ErrUserNotFound
 	} else if err != nil {
+		return domain.User{}, mapConstraintViolation(err)
+	}
+	return u, nil
+}
+
+const lendingColumns = "id, book_id, copy_id, user_id, lend_date, due_date, return_date, renewal_count, deleted_at, version"
+
+// scanLending reads a row selected with lendingColumns.
+func scanLending(row pgx.Row) (domain.Lending, error) {
+	var l domain.Lending
+	var copyID sql.NullString
+	var dueDate, returnDate, deletedAt sql.NullTime
+	if err := row.Scan(&l.ID, &l.BookID, &copyID, &l.UserID, &l.LendDate, &dueDate, &returnDate, &l.RenewalCount, &deletedAt, &l.Version); err != nil {
 		return domain.Lending{}, err
 	}
+	l.CopyID = copyID.String
+	l.DueDate = dueDate.Time
+	l.DeletedAt = nullTime(deletedAt)
 	if returnDate.Valid {
 		l.ReturnDate = returnDate.Time
 	} else {
@@ -215,52 +481,707 @@ func (repo *PostgresRepository) GetLendingByID(id string) (domain.Lending, error
 	return l, nil
 }
 
-func (repo *PostgresRepository) CreateLending(lending domain.Lending) (domain.Lending, error) {
+func (repo *PostgresRepository) GetLendings(ctx context.Context, filter repository.LendingFilter, options repository.ListOptions) (repository.Page[domain.Lending], error) {
+	var q listQuery
+	if !filter.IncludeDeleted {
+		q.where("deleted_at IS NULL")
+	}
+	if filter.UserID != "" {
+		q.where("user_id = $%d", filter.UserID)
+	}
+	if filter.BookID != "" {
+		q.where("book_id = $%d", filter.BookID)
+	}
+	if filter.Active != nil && *filter.Active {
+		q.where("return_date IS NULL")
+	} else if filter.Active != nil {
+		q.where("return_date IS NOT NULL")
+	}
+	if filter.Overdue != nil && *filter.Overdue {
+		q.where("return_date IS NULL AND due_date < $%d", filter.Now)
+	} else if filter.Overdue != nil {
+		q.where("(return_date IS NOT NULL OR due_date IS NULL OR due_date >= $%d)", filter.Now)
+	}
+	return listPage(ctx, repo, "lendings", lendingColumns, q, options,
+		sortColumns{"id": false, "lend_date": true}, "lend_date", scanLending,
+		func(l domain.Lending, field string) (string, string) {
+			if field == "lend_date" {
+				return repository.SortableTime(l.LendDate), l.ID
+			}
+			return l.ID, l.ID
+		})
+}
+
+func (repo *PostgresRepository) GetLendingByID(ctx context.Context, id string) (domain.Lending, error) {
+	l, err := scanLending(repo.db.QueryRow(ctx, "SELECT "+lendingColumns+" FROM lendings WHERE id = $1 AND deleted_at IS NULL", id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.Lending{}, repository.ErrLendingNotFound
+	} else if err != nil {
+		return domain.Lending{}, err
+	}
+	return l, nil
+}
+
+// CountActiveLendings is served by the partial index lendings_active_user_idx.
+func (repo *PostgresRepository) CountActiveLendings(ctx context.Context, userID string) (int, error) {
+	var count int
+	err := repo.db.QueryRow(ctx,
+		"SELECT COUNT(*) FROM lendings WHERE user_id = $1 AND return_date IS NULL AND deleted_at IS NULL", userID).Scan(&count)
+	return count, err
+}
+
+// GetOverdueLendings is served by the partial index lendings_overdue_idx.
+func (repo *PostgresRepository) GetOverdueLendings(ctx context.Context, now time.Time) ([]domain.Lending, error) {
+	rows, err := repo.db.Query(ctx,
+		"SELECT "+lendingColumns+" FROM lendings WHERE return_date IS NULL AND deleted_at IS NULL AND due_date < $1 ORDER BY due_date", now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lendings := make([]domain.Lending, 0)
+	for rows.Next() {
+		l, err := scanLending(rows)
+		if err != nil {
+			return nil, err
+		}
+		lendings = append(lendings, l)
+	}
+	return lendings, nil
+}
+
+func (repo *PostgresRepository) CreateLending(ctx context.Context, lending domain.Lending) (domain.Lending, error) {
 	var returnDate interface{}
 	if lending.ReturnDate.IsZero() {
 		returnDate = nil
 	} else {
 		returnDate = lending.ReturnDate
 	}
-	_, err := repo.db.Exec(context.Background(),
-		"INSERT INTO lendings (id, book_id, user_id, lend_date, return_date) VALUES ($1, $2, $3, $4, $5)",
-		lending.ID, lending.BookID, lending.UserID, lending.LendDate, returnDate,
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return domain.Lending{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var holdID string
+	if lending.ReturnDate.IsZero() {
+		var copyID string
+		holdID, copyID, err = lockReadyHold(ctx, tx, lending)
+		if err != nil {
+			return domain.Lending{}, err
+		}
+		if holdID == "" {
+			copyID, err = lockAvailableCopy(ctx, tx, lending.BookID, lending.CopyID)
+			if err != nil {
+				return domain.Lending{}, err
+			}
+		}
+		lending.CopyID = copyID
+	}
+	lending.DeletedAt = nil
+	lending.Version = 1
+	_, err = tx.Exec(ctx,
+		"INSERT INTO lendings (id, book_id, copy_id, user_id, lend_date, due_date, return_date, renewal_count, version) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)",
+		lending.ID, lending.BookID, nullableID(lending.CopyID), lending.UserID, lending.LendDate, nullableTime(lending.DueDate), returnDate, lending.RenewalCount, lending.Version,
 	)
 	if err != nil {
+		return domain.Lending{}, mapConstraintViolation(err)
+	}
+	if err := syncCopyStatus(ctx, tx, lending); err != nil {
+		return domain.Lending{}, err
+	}
+	if holdID != "" {
+		if _, err := tx.Exec(ctx, "UPDATE holds SET status = 'fulfilled' WHERE id = $1", holdID); err != nil {
+			return domain.Lending{}, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
 		return domain.Lending{}, err
 	}
 	return lending, nil
 }
 
-func (repo *PostgresRepository) UpdateLending(lending domain.Lending) (domain.Lending, error) {
+func (repo *PostgresRepository) UpdateLending(ctx context.Context, lending domain.Lending) (domain.Lending, error) {
 	var returnDate interface{}
 	if lending.ReturnDate.IsZero() {
 		returnDate = nil
 	} else {
 		returnDate = lending.ReturnDate
 	}
-	result, err := repo.db.Exec(context.Background(),
-		"UPDATE lendings SET book_id = $2, user_id = $3, lend_date = $4, return_date = $5 WHERE id = $1",
-		lending.ID, lending.BookID, lending.UserID, lending.LendDate, returnDate,
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return domain.Lending{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var storedCopyID sql.NullString
+	var storedReturnDate sql.NullTime
+	var storedVersion int
+	err = tx.QueryRow(ctx, "SELECT copy_id, return_date, version FROM lendings WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", lending.ID).
+		Scan(&storedCopyID, &storedReturnDate, &storedVersion)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.Lending{}, repository.ErrLendingNotFound
+	} else if err != nil {
+		return domain.Lending{}, err
+	}
+	if storedVersion != lending.Version {
+		return domain.Lending{}, repository.ErrVersionMismatch
+	}
+	reopened := lending.ReturnDate.IsZero() && (storedReturnDate.Valid || storedCopyID.String != lending.CopyID)
+	if reopened && lending.CopyID != "" {
+		if _, err := lockAvailableCopy(ctx, tx, lending.BookID, lending.CopyID); err != nil {
+			return domain.Lending{}, err
+		}
+	}
+	lending.DeletedAt = nil
+	lending.Version++
+	_, err = tx.Exec(ctx,
+		"UPDATE lendings SET book_id = $2, copy_id = $3, user_id = $4, lend_date = $5, due_date = $6, return_date = $7, renewal_count = $8, version = $9 WHERE id = $1",
+		lending.ID, lending.BookID, nullableID(lending.CopyID), lending.UserID, lending.LendDate, nullableTime(lending.DueDate), returnDate, lending.RenewalCount, lending.Version,
 	)
 	if err != nil {
+		return domain.Lending{}, mapConstraintViolation(err)
+	}
+	if storedCopyID.String != lending.CopyID && !storedReturnDate.Valid {
+		_, err = tx.Exec(ctx,
+			"UPDATE book_copies SET status = 'available' WHERE id = $1 AND status = 'on_loan'", storedCopyID)
+		if err != nil {
+			return domain.Lending{}, err
+		}
+	}
+	if err := syncCopyStatus(ctx, tx, lending); err != nil {
 		return domain.Lending{}, err
 	}
-	rowsAffected := result.RowsAffected()
-	if rowsAffected == 0 {
-		return domain.Lending{}, ErrLendingNotFound
+	if err := tx.Commit(ctx); err != nil {
+		return domain.Lending{}, err
 	}
 	return lending, nil
 }
 
-func (repo *PostgresRepository) DeleteLending(id string) error {
-	result, err := repo.db.Exec(context.Background(), "DELETE FROM lendings WHERE id = $1", id)
+func (repo *PostgresRepository) DeleteLending(ctx context.Context, id string, version int, deletedAt time.Time) error {
+	tx, err := repo.db.Begin(ctx)
 	if err != nil {
 		return err
 	}
-	rowsAffected := result.RowsAffected()
-	if rowsAffected == 0 {
-		return ErrLendingNotFound
+	defer tx.Rollback(ctx)
+
+	var copyID sql.NullString
+	var returnDate sql.NullTime
+	err = tx.QueryRow(ctx,
+		"UPDATE lendings SET deleted_at = $2 WHERE id = $1 AND deleted_at IS NULL AND version = $3 RETURNING copy_id, return_date",
+		id, deletedAt, version).Scan(&copyID, &returnDate)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return repo.missedUpdate(ctx, "lendings", id, repository.ErrLendingNotFound)
+	} else if err != nil {
+		return err
+	}
+	if !returnDate.Valid {
+		_, err = tx.Exec(ctx,
+			"UPDATE book_copies SET status = 'available' WHERE id = $1 AND status = 'on_loan'", copyID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+func (repo *PostgresRepository) RestoreLending(ctx context.Context, id string) (domain.Lending, error) {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return domain.Lending{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	lending, err := scanLending(tx.QueryRow(ctx, "SELECT "+lendingColumns+" FROM lendings WHERE id = $1 FOR UPDATE", id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.Lending{}, repository.ErrLendingNotFound
+	} else if err != nil {
+		return domain.Lending{}, err
+	}
+	if lending.DeletedAt == nil {
+		return lending, nil
+	}
+	if lending.ReturnDate.IsZero() && lending.CopyID != "" {
+		if _, err := lockAvailableCopy(ctx, tx, lending.BookID, lending.CopyID); err != nil {
+			return domain.Lending{}, err
+		}
+	}
+	if _, err := tx.Exec(ctx, "UPDATE lendings SET deleted_at = NULL WHERE id = $1", id); err != nil {
+		return domain.Lending{}, mapConstraintViolation(err)
+	}
+	if err := syncCopyStatus(ctx, tx, lending); err != nil {
+		return domain.Lending{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return domain.Lending{}, err
+	}
+	lending.DeletedAt = nil
+	return lending, nil
+}
+
+// PurgeDeleted deletes lendings first, so that a deleted lending is counted
+// even when its user or book is purged as well.
+func (repo *PostgresRepository) PurgeDeleted(ctx context.Context, before time.Time) (domain.PurgeReport, error) {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return domain.PurgeReport{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var report domain.PurgeReport
+	for _, purge := range []struct {
+		table string
+		count *int
+	}{
+		{"lendings", &report.Lendings},
+		{"users", &report.Users},
+		{"books", &report.Books},
+	} {
+		result, err := tx.Exec(ctx, "DELETE FROM "+purge.table+" WHERE deleted_at < $1", before)
+		if err != nil {
+			return domain.PurgeReport{}, err
+		}
+		*purge.count = int(result.RowsAffected())
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return domain.PurgeReport{}, err
+	}
+	return report, nil
+}
+
+const fineColumns = "id, user_id, lending_id, reason, amount, status, created_at, settled_at"
+
+// scanFine reads a row selected with fineColumns.
+func scanFine(row pgx.Row) (domain.Fine, error) {
+	var f domain.Fine
+	var lendingID sql.NullString
+	var settledAt sql.NullTime
+	if err := row.Scan(&f.ID, &f.UserID, &lendingID, &f.Reason, &f.Amount, &f.Status, &f.CreatedAt, &settledAt); err != nil {
+		return domain.Fine{}, err
+	}
+	f.LendingID = lendingID.String
+	f.SettledAt = settledAt.Time
+	return f, nil
+}
+
+func (repo *PostgresRepository) GetFinesByUserID(ctx context.Context, userID string) ([]domain.Fine, error) {
+	rows, err := repo.db.Query(ctx,
+		"SELECT "+fineColumns+" FROM fines WHERE user_id = $1 ORDER BY created_at", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	fines := make([]domain.Fine, 0)
+	for rows.Next() {
+		f, err := scanFine(rows)
+		if err != nil {
+			return nil, err
+		}
+		fines = append(fines, f)
+	}
+	return fines, nil
+}
+
+func (repo *PostgresRepository) GetFineByID(ctx context.Context, id string) (domain.Fine, error) {
+	f, err := scanFine(repo.db.QueryRow(ctx, "SELECT "+fineColumns+" FROM fines WHERE id = $1", id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.Fine{}, repository.ErrFineNotFound
+	} else if err != nil {
+		return domain.Fine{}, err
+	}
+	return f, nil
+}
+
+func (repo *PostgresRepository) CreateFine(ctx context.Context, fine domain.Fine) (domain.Fine, error) {
+	_, err := repo.db.Exec(ctx,
+		"INSERT INTO fines ("+fineColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		fine.ID, fine.UserID, nullableID(fine.LendingID), fine.Reason, fine.Amount, fine.Status, fine.CreatedAt, nullableTime(fine.SettledAt))
+	if err != nil {
+		return domain.Fine{}, mapConstraintViolation(err)
+	}
+	return fine, nil
+}
+
+func (repo *PostgresRepository) UpdateFine(ctx context.Context, fine domain.Fine) (domain.Fine, error) {
+	result, err := repo.db.Exec(ctx,
+		"UPDATE fines SET user_id = $2, lending_id = $3, reason = $4, amount = $5, status = $6, created_at = $7, settled_at = $8 WHERE id = $1",
+		fine.ID, fine.UserID, nullableID(fine.LendingID), fine.Reason, fine.Amount, fine.Status, fine.CreatedAt, nullableTime(fine.SettledAt))
+	if err != nil {
+		return domain.Fine{}, mapConstraintViolation(err)
+	}
+	if result.RowsAffected() == 0 {
+		return domain.Fine{}, repository.ErrFineNotFound
+	}
+	return fine, nil
+}
+
+const holdColumns = "id, book_id, user_id, copy_id, status, created_at, ready_at, expires_at"
+
+// scanHold reads a row selected with holdColumns.
+func scanHold(row pgx.Row) (domain.Hold, error) {
+	var h domain.Hold
+	var copyID sql.NullString
+	var readyAt, expiresAt sql.NullTime
+	if err := row.Scan(&h.ID, &h.BookID, &h.UserID, &copyID, &h.Status, &h.CreatedAt, &readyAt, &expiresAt); err != nil {
+		return domain.Hold{}, err
+	}
+	h.CopyID = copyID.String
+	h.ReadyAt = readyAt.Time
+	h.ExpiresAt = expiresAt.Time
+	return h, nil
+}
+
+// GetHolds is served by the partial index holds_queue_idx.
+func (repo *PostgresRepository) GetHolds(ctx context.Context, bookID string) ([]domain.Hold, error) {
+	rows, err := repo.db.Query(ctx,
+		"SELECT "+holdColumns+" FROM holds WHERE book_id = $1 AND status IN ('waiting', 'ready') ORDER BY created_at", bookID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	holds := make([]domain.Hold, 0)
+	for rows.Next() {
+		h, err := scanHold(rows)
+		if err != nil {
+			return nil, err
+		}
+		holds = append(holds, h)
+	}
+	return holds, nil
+}
+
+func (repo *PostgresRepository) GetHoldByID(ctx context.Context, id string) (domain.Hold, error) {
+	h, err := scanHold(repo.db.QueryRow(ctx, "SELECT "+holdColumns+" FROM holds WHERE id = $1", id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.Hold{}, repository.ErrHoldNotFound
+	} else if err != nil {
+		return domain.Hold{}, err
+	}
+	return h, nil
+}
+
+func (repo *PostgresRepository) CreateHold(ctx context.Context, hold domain.Hold) (domain.Hold, error) {
+	_, err := repo.db.Exec(ctx,
+		"INSERT INTO holds ("+holdColumns+") VALUES ($1, $2, $3, $4, $5, $6, $7, $8)",
+		hold.ID, hold.BookID, hold.UserID, nullableID(hold.CopyID), hold.Status, hold.CreatedAt, nullableTime(hold.ReadyAt), nullableTime(hold.ExpiresAt))
+	if err != nil {
+		return domain.Hold{}, mapConstraintViolation(err)
+	}
+	return hold, nil
+}
+
+func (repo *PostgresRepository) UpdateHold(ctx context.Context, hold domain.Hold) (domain.Hold, error) {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return domain.Hold{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var storedStatus domain.HoldStatus
+	var storedCopyID sql.NullString
+	err = tx.QueryRow(ctx, "SELECT status, copy_id FROM holds WHERE id = $1 FOR UPDATE", hold.ID).
+		Scan(&storedStatus, &storedCopyID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.Hold{}, repository.ErrHoldNotFound
+	} else if err != nil {
+		return domain.Hold{}, err
+	}
+	_, err = tx.Exec(ctx,
+		"UPDATE holds SET book_id = $2, user_id = $3, copy_id = $4, status = $5, created_at = $6, ready_at = $7, expires_at = $8 WHERE id = $1",
+		hold.ID, hold.BookID, hold.UserID, nullableID(hold.CopyID), hold.Status, hold.CreatedAt, nullableTime(hold.ReadyAt), nullableTime(hold.ExpiresAt))
+	if err != nil {
+		return domain.Hold{}, mapConstraintViolation(err)
+	}
+	if storedStatus == domain.HoldStatusReady && hold.Status != domain.HoldStatusFulfilled &&
+		(hold.Status != domain.HoldStatusReady || hold.CopyID != storedCopyID.String) {
+		if err := releaseHeldCopy(ctx, tx, storedCopyID.String); err != nil {
+			return domain.Hold{}, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return domain.Hold{}, err
+	}
+	return hold, nil
+}
+
+func (repo *PostgresRepository) ReadyNextHold(ctx context.Context, copyID string, readyAt, expiresAt time.Time) (domain.Hold, error) {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return domain.Hold{}, err
+	}
+	defer tx.Rollback(ctx)
+
+	var bookID string
+	err = tx.QueryRow(ctx,
+		"SELECT book_id FROM book_copies WHERE id = $1 AND status = 'available' FOR UPDATE", copyID).Scan(&bookID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.Hold{}, nil
+	} else if err != nil {
+		return domain.Hold{}, err
+	}
+	next, err := scanHold(tx.QueryRow(ctx,
+		"SELECT "+holdColumns+" FROM holds WHERE book_id = $1 AND status = 'waiting' ORDER BY created_at LIMIT 1 FOR UPDATE", bookID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return domain.Hold{}, nil
+	} else if err != nil {
+		return domain.Hold{}, err
+	}
+	next.Status = domain.HoldStatusReady
+	next.CopyID = copyID
+	next.ReadyAt = readyAt
+	next.ExpiresAt = expiresAt
+	_, err = tx.Exec(ctx,
+		"UPDATE holds SET status = $2, copy_id = $3, ready_at = $4, expires_at = $5 WHERE id = $1",
+		next.ID, next.Status, next.CopyID, next.ReadyAt, next.ExpiresAt)
+	if err != nil {
+		return domain.Hold{}, err
+	}
+	if _, err := tx.Exec(ctx, "UPDATE book_copies SET status = 'on_hold' WHERE id = $1", copyID); err != nil {
+		return domain.Hold{}, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return domain.Hold{}, err
+	}
+	return next, nil
+}
+
+func (repo *PostgresRepository) ExpireHolds(ctx context.Context, now time.Time) ([]domain.Hold, error) {
+	tx, err := repo.db.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx,
+		"UPDATE holds SET status = 'expired' WHERE status = 'ready' AND expires_at < $1 RETURNING "+holdColumns, now)
+	if err != nil {
+		return nil, err
+	}
+	expired := make([]domain.Hold, 0)
+	for rows.Next() {
+		h, err := scanHold(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		expired = append(expired, h)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for _, h := range expired {
+		if err := releaseHeldCopy(ctx, tx, h.CopyID); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return expired, nil
+}
+
+// lockReadyHold locks the ready hold of the lending's user together with the
+// copy that was set aside for it. Both IDs are empty when there is none.
+func lockReadyHold(ctx context.Context, tx pgx.Tx, lending domain.Lending) (string, string, error) {
+	var holdID, copyID string
+	err := tx.QueryRow(ctx,
+		`SELECT id, copy_id FROM holds
+		 WHERE book_id = $1 AND user_id = $2 AND status = 'ready' AND copy_id IS NOT NULL
+		   AND ($3 = '' OR copy_id::text = $3)
+		 LIMIT 1 FOR UPDATE`, lending.BookID, lending.UserID, lending.CopyID).Scan(&holdID, &copyID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", "", nil
+	}
+	return holdID, copyID, err
+}
+
+// lockAvailableCopy locks the requested copy, or the first available copy of
+// the book when no copy was requested, so that concurrent lendings of the same
+// copy serialize on the row lock.
+func lockAvailableCopy(ctx context.Context, tx pgx.Tx, bookID, copyID string) (string, error) {
+	if copyID == "" {
+		err := tx.QueryRow(ctx,
+			`SELECT id FROM book_copies WHERE book_id = $1 AND status = 'available'
+			 ORDER BY barcode LIMIT 1 FOR UPDATE SKIP LOCKED`, bookID).Scan(&copyID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", repository.ErrCopyUnavailable
+		}
+		return copyID, err
+	}
+	var status domain.CopyStatus
+	err := tx.QueryRow(ctx,
+		"SELECT status FROM book_copies WHERE id = $1 FOR UPDATE", copyID).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", repository.ErrBookCopyNotFound
+	} else if err != nil {
+		return "", err
+	}
+	if status != domain.CopyStatusAvailable {
+		return "", repository.ErrCopyUnavailable
+	}
+	return copyID, nil
+}
+
+// constraintErrors maps unique indexes to the conflict they signal and
+// foreign keys to the missing row they point at.
+var constraintErrors = map[string]error{
+	"book_copies_barcode_key":  repository.ErrBarcodeExists,
+	"books_isbn_key":           repository.ErrISBNExists,
+	"users_email_key":          repository.ErrEmailExists,
+	"lendings_active_copy_idx": repository.ErrCopyUnavailable,
+	"holds_active_user_idx":    repository.ErrHoldExists,
+
+	"book_copies_book_id_fkey": repository.ErrBookNotFound,
+	"lendings_book_id_fkey":    repository.ErrBookNotFound,
+	"lendings_copy_id_fkey":    repository.ErrBookCopyNotFound,
+	"lendings_user_id_fkey":    repository.ErrUserNotFound,
+	"fines_user_id_fkey":       repository.ErrUserNotFound,
+	"fines_lending_id_fkey":    repository.ErrLendingNotFound,
+	"holds_book_id_fkey":       repository.ErrBookNotFound,
+	"holds_user_id_fkey":       repository.ErrUserNotFound,
+	"holds_copy_id_fkey":       repository.ErrBookCopyNotFound,
+}
+
+// mapConstraintViolation translates a unique or foreign key violation into
+// the repository error of its constraint.
+func mapConstraintViolation(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || (pgErr.Code != "23505" && pgErr.Code != "23503") {
+		return err
+	}
+	if mapped, ok := constraintErrors[pgErr.ConstraintName]; ok {
+		return mapped
+	}
+	return err
+}
+
+// syncCopyStatus marks the copy of an active lending as on loan and puts it
+// back on the shelf once the lending has been returned.
+func syncCopyStatus(ctx context.Context, tx pgx.Tx, lending domain.Lending) error {
+	if lending.CopyID == "" {
+		return nil
+	}
+	var err error
+	if lending.ReturnDate.IsZero() {
+		_, err = tx.Exec(ctx,
+			"UPDATE book_copies SET status = 'on_loan' WHERE id = $1", lending.CopyID)
+	} else {
+		_, err = tx.Exec(ctx,
+			"UPDATE book_copies SET status = 'available' WHERE id = $1 AND status = 'on_loan'", lending.CopyID)
+	}
+	return err
+}
+
+// missedUpdate explains why a compare-and-swap update of the row with id in
+// table touched nothing: the row is gone, or its version moved on.
+func (repo *PostgresRepository) missedUpdate(ctx context.Context, table, id string, notFound error) error {
+	var exists bool
+	err := repo.db.QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM "+table+" WHERE id = $1 AND deleted_at IS NULL)", id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return notFound
+	}
+	return repository.ErrVersionMismatch
+}
+
+// checkNoActiveLendings fails with an ActiveLendingsError when the book or
+// user named by entity still has lendings out. Its row stays locked until the
+// transaction ends, so that no lending of it can be created in the meantime.
+func checkNoActiveLendings(ctx context.Context, tx pgx.Tx, entity, id string, notFound error) error {
+	var locked string
+	err := tx.QueryRow(ctx,
+		"SELECT id FROM "+entity+"s WHERE id = $1 AND deleted_at IS NULL FOR UPDATE", id).Scan(&locked)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return notFound
+	} else if err != nil {
+		return err
+	}
+	return checkLendingsReturned(ctx, tx, entity, id)
+}
+
+// checkLendingsReturned fails with an ActiveLendingsError when the book, copy
+// or user named by entity still has lendings out.
+func checkLendingsReturned(ctx context.Context, tx pgx.Tx, entity, id string) error {
+	rows, err := tx.Query(ctx,
+		"SELECT id FROM lendings WHERE "+entity+"_id = $1 AND return_date IS NULL AND deleted_at IS NULL ORDER BY id", id)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var lendingIDs []string
+	for rows.Next() {
+		var lendingID string
+		if err := rows.Scan(&lendingID); err != nil {
+			return err
+		}
+		lendingIDs = append(lendingIDs, lendingID)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(lendingIDs) > 0 {
+		return &repository.ActiveLendingsError{Entity: entity, LendingIDs: lendingIDs}
 	}
 	return nil
 }
+
+// cancelHolds cancels the active holds whose column is id and puts the copies
+// set aside for them back on the shelf.
+func cancelHolds(ctx context.Context, tx pgx.Tx, column, id string) error {
+	rows, err := tx.Query(ctx,
+		"UPDATE holds SET status = 'cancelled' WHERE "+column+" = $1 AND status IN ('waiting', 'ready') RETURNING copy_id", id)
+	if err != nil {
+		return err
+	}
+	var copyIDs []string
+	for rows.Next() {
+		var copyID sql.NullString
+		if err := rows.Scan(&copyID); err != nil {
+			rows.Close()
+			return err
+		}
+		copyIDs = append(copyIDs, copyID.String)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, copyID := range copyIDs {
+		if err := releaseHeldCopy(ctx, tx, copyID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseHeldCopy puts a copy that was set aside for a hold back on the shelf.
+func releaseHeldCopy(ctx context.Context, tx pgx.Tx, copyID string) error {
+	if copyID == "" {
+		return nil
+	}
+	_, err := tx.Exec(ctx,
+		"UPDATE book_copies SET status = 'available' WHERE id = $1 AND status = 'on_hold'", copyID)
+	return err
+}
+
+// nullableTime maps the zero time to SQL NULL.
+func nullableTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
+
+// nullableID maps an empty ID to SQL NULL.
+func nullableID(id string) interface{} {
+	if id == "" {
+		return nil
+	}
+	return id
+}
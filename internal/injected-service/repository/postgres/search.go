@@ -0,0 +1,92 @@
+package postgresrepository
+
+import (
+	"context"
+	"fmt"
+	"libary-service/internal/domain"
+	"libary-service/internal/injected-service/repository"
+	"strconv"
+)
+
+// searchHeadline marks every matched term of a field in the search results.
+// ts_headline does not escape the field, so it is HTML escaped first, the way
+// html.EscapeString does it. The parser reads the entities as entities, so
+// they never match a search term.
+const searchHeadline = "ts_headline('simple', " +
+	"replace(replace(replace(replace(replace(%s, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '\"', '&#34;'), '''', '&#39;'), " +
+	"query, 'StartSel=<b>, StopSel=</b>, HighlightAll=true')"
+
+// searchAuthors joins the authors of a book like repository.AuthorList.
+const searchAuthors = "coalesce((SELECT string_agg(name, ', ' ORDER BY position) FROM book_authors" +
+	" WHERE book_authors.book_id = books.id), author) AS authors"
+
+func (repo *PostgresRepository) SearchBooks(ctx context.Context, query string, options repository.ListOptions) (repository.Page[domain.BookSearchHit], error) {
+	if options.Sort != "" {
+		return repository.Page[domain.BookSearchHit]{}, repository.ErrInvalidSort
+	}
+	// Search results are always ranked, so their cursors carry the rank.
+	options.Sort = "-rank"
+
+	var total int
+	err := repo.db.QueryRow(ctx,
+		"SELECT COUNT(*) FROM books WHERE deleted_at IS NULL AND search @@ plainto_tsquery('simple', $1)", query).Scan(&total)
+	if err != nil {
+		return repository.Page[domain.BookSearchHit]{}, err
+	}
+
+	q := listQuery{args: []interface{}{query}}
+	if options.Cursor != "" {
+		cursor, err := repository.DecodeCursor(options.Cursor, options.Sort)
+		if err != nil {
+			return repository.Page[domain.BookSearchHit]{}, err
+		}
+		rank, err := strconv.ParseFloat(cursor.Value, 32)
+		if err != nil {
+			return repository.Page[domain.BookSearchHit]{}, repository.ErrInvalidCursor
+		}
+		q.where("(rank, id) < ($%d, $%d)", float32(rank), cursor.ID)
+	}
+
+	sql := "SELECT " + bookColumns + ", rank, " +
+		fmt.Sprintf(searchHeadline, "title") + ", " + fmt.Sprintf(searchHeadline, "authors") +
+		" FROM (SELECT books.*, ts_rank(search, query) AS rank, query, " + searchAuthors +
+		" FROM books, plainto_tsquery('simple', $1) AS query WHERE deleted_at IS NULL AND search @@ query) AS books" +
+		q.clause() + " ORDER BY rank DESC, id DESC"
+	if options.Limit > 0 {
+		sql += fmt.Sprintf(" LIMIT %d", options.Limit+1)
+	}
+
+	rows, err := repo.db.Query(ctx, sql, q.args...)
+	if err != nil {
+		return repository.Page[domain.BookSearchHit]{}, err
+	}
+	defer rows.Close()
+
+	hits := make([]domain.BookSearchHit, 0)
+	for rows.Next() {
+		var hit domain.BookSearchHit
+		var rank float32
+		book, err := scanBookWith(rows, &rank, &hit.TitleHighlight, &hit.AuthorHighlight)
+		if err != nil {
+			return repository.Page[domain.BookSearchHit]{}, err
+		}
+		hit.Book = book
+		hit.Rank = float64(rank)
+		hits = append(hits, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return repository.Page[domain.BookSearchHit]{}, err
+	}
+
+	page := repository.Page[domain.BookSearchHit]{Items: hits, Total: total}
+	if options.Limit > 0 && len(hits) > options.Limit {
+		page.Items = hits[:options.Limit]
+		last := page.Items[options.Limit-1]
+		page.NextCursor = repository.EncodeCursor(repository.Cursor{
+			Sort:  options.Sort,
+			Value: strconv.FormatFloat(last.Rank, 'g', -1, 32),
+			ID:    last.ID,
+		})
+	}
+	return page, nil
+}
@@ -2,29 +2,169 @@
 package repository
 
 import (
+	"context"
+	"libary-service/internal/database"
 	"libary-service/internal/domain"
+	"time"
 )
 
-// Repository aggregates all data access methods.
+// The not-found errors every repository returns for unknown IDs.
+var (
+	ErrBookNotFound     = domain.NotFoundError("book")
+	ErrBookCopyNotFound = domain.NotFoundError("book copy")
+	ErrUserNotFound     = domain.NotFoundError("user")
+	ErrLendingNotFound  = domain.NotFoundError("lending")
+	ErrFineNotFound     = domain.NotFoundError("fine")
+	ErrHoldNotFound     = domain.NotFoundError("hold")
+)
+
+// ErrCopyUnavailable is returned when a lending would take a copy that is
+// already lent out or otherwise not on the shelf.
+var ErrCopyUnavailable = domain.ConflictError("book copy is not available")
+
+// ErrHoldExists is returned when a patron already waits for the same book.
+var ErrHoldExists = domain.ConflictError("user already holds this book")
+
+// ErrBarcodeExists is returned when a copy would reuse the barcode of another.
+var ErrBarcodeExists = domain.ConflictError("barcode already exists")
+
+// ErrISBNExists is returned when a book would reuse the ISBN of another.
+// Deleted books give their ISBN free.
+var ErrISBNExists = domain.ConflictError("isbn already exists")
+
+// ErrEmailExists is returned when a user would reuse the email of another.
+// Deleted users give their email free.
+var ErrEmailExists = domain.ConflictError("email already exists")
+
+// ErrVersionMismatch is returned when an update or delete was based on an
+// outdated version of a book, user or lending.
+var ErrVersionMismatch = domain.ConflictError("version does not match")
+
+// ActiveLendingsError is returned when a book, copy or user cannot be deleted
+// because some of its lendings have not been returned yet.
+type ActiveLendingsError struct {
+	// Entity names what was to be deleted, like "book".
+	Entity string
+	// LendingIDs lists the active lendings in ID order.
+	LendingIDs []string
+}
+
+func (e *ActiveLendingsError) Error() string {
+	return e.Entity + " has active lendings"
+}
+
+func (e *ActiveLendingsError) Unwrap() error {
+	return domain.ErrConflict
+}
+
+// FineRepository is the data access for the fines ledger.
+type FineRepository interface {
+	GetFinesByUserID(ctx context.Context, userID string) ([]domain.Fine, error)
+	GetFineByID(ctx context.Context, id string) (domain.Fine, error)
+	CreateFine(ctx context.Context, fine domain.Fine) (domain.Fine, error)
+	UpdateFine(ctx context.Context, fine domain.Fine) (domain.Fine, error)
+}
+
+// HoldRepository is the data access for the hold queues.
+type HoldRepository interface {
+	// GetHolds returns the waiting and ready holds of a book in queue order.
+	GetHolds(ctx context.Context, bookID string) ([]domain.Hold, error)
+	GetHoldByID(ctx context.Context, id string) (domain.Hold, error)
+	CreateHold(ctx context.Context, hold domain.Hold) (domain.Hold, error)
+	// UpdateHold puts a copy set aside for a ready hold back on the shelf
+	// once the hold is cancelled or expired.
+	UpdateHold(ctx context.Context, hold domain.Hold) (domain.Hold, error)
+	// ReadyNextHold sets an available copy aside for the first waiting hold
+	// of its book. It returns the zero Hold when nobody is waiting.
+	ReadyNextHold(ctx context.Context, copyID string, readyAt, expiresAt time.Time) (domain.Hold, error)
+	// ExpireHolds lapses ready holds whose pickup window closed before now
+	// and puts their copies back on the shelf.
+	ExpireHolds(ctx context.Context, now time.Time) ([]domain.Hold, error)
+}
+
+// StatsReporter is implemented by repositories that run on a connection pool.
+type StatsReporter interface {
+	PoolStats() database.PoolStats
+}
+
+// Repository aggregates all data access methods. Writes that reference a
+// missing row fail with the not-found error of that row, and deleting a row
+// deletes what depends on it. The repositorytest package checks that an
+// implementation behaves like this.
+//
+// Books, users and lendings are soft-deleted: deleting one sets its DeletedAt,
+// after which it is not found by ID and left out of lists, searches and counts
+// until it is restored. Create and Update ignore DeletedAt. PurgeDeleted
+// removes them for good, together with what depends on them.
+//
+// Books, users and lendings carry a version. Create starts it at 1 and Update
+// only applies when the version passed in is the stored one, failing with
+// ErrVersionMismatch otherwise, and returns the next version. Delete checks the
+// version it is given the same way.
 type Repository interface {
-	Connect() error
-	Disconnect() error
-
-	GetBooks() ([]domain.Book, error)
-	GetBookByID(id string) (domain.Book, error)
-	CreateBook(book domain.Book) (domain.Book, error)
-	UpdateBook(book domain.Book) (domain.Book, error)
-	DeleteBook(id string) error
-
-	GetUsers() ([]domain.User, error)
-	GetUserByID(id string) (domain.User, error)
-	CreateUser(user domain.User) (domain.User, error)
-	UpdateUser(user domain.User) (domain.User, error)
-	DeleteUser(id string) error
-
-	GetLendings() ([]domain.Lending, error)
-	GetLendingByID(id string) (domain.Lending, error)
-	CreateLending(lending domain.Lending) (domain.Lending, error)
-	UpdateLending(lending domain.Lending) (domain.Lending, error)
-	DeleteLending(id string) error
+	FineRepository
+	HoldRepository
+
+	Connect(ctx context.Context) error
+	Disconnect(ctx context.Context) error
+
+	// WithTx runs fn in a transaction. Everything fn does through tx is
+	// committed when it returns nil and rolled back when it returns an error,
+	// which WithTx passes on. Nested calls on tx roll back on their own.
+	WithTx(ctx context.Context, fn func(tx Repository) error) error
+
+	// GetBooks returns one page of the books that match the filter.
+	GetBooks(ctx context.Context, filter BookFilter, options ListOptions) (Page[domain.Book], error)
+	// SearchBooks returns one page of the books whose title or author contain
+	// every term of the query, best match first. Search results cannot be sorted.
+	SearchBooks(ctx context.Context, query string, options ListOptions) (Page[domain.BookSearchHit], error)
+	GetBookByID(ctx context.Context, id string) (domain.Book, error)
+	CreateBook(ctx context.Context, book domain.Book) (domain.Book, error)
+	UpdateBook(ctx context.Context, book domain.Book) (domain.Book, error)
+	// DeleteBook cancels the holds on the book. It fails with an
+	// ActiveLendingsError while the book is lent out, unless force is set.
+	DeleteBook(ctx context.Context, id string, version int, deletedAt time.Time, force bool) error
+	// RestoreBook undeletes a book. Restoring a book that is not deleted does
+	// nothing, and a book whose ISBN was taken since fails with ErrISBNExists.
+	RestoreBook(ctx context.Context, id string) (domain.Book, error)
+
+	GetBookCopies(ctx context.Context, bookID string) ([]domain.BookCopy, error)
+	GetBookCopyByID(ctx context.Context, id string) (domain.BookCopy, error)
+	CreateBookCopy(ctx context.Context, bookCopy domain.BookCopy) (domain.BookCopy, error)
+	UpdateBookCopy(ctx context.Context, bookCopy domain.BookCopy) (domain.BookCopy, error)
+	DeleteBookCopy(ctx context.Context, id string) error
+
+	GetUsers(ctx context.Context, filter UserFilter, options ListOptions) (Page[domain.User], error)
+	GetUserByID(ctx context.Context, id string) (domain.User, error)
+	// LockUser reads a user like GetUserByID. Inside WithTx it also keeps
+	// other transactions from locking the user until this one ends, so that a
+	// check of the user's lendings still holds when the next one is written.
+	LockUser(ctx context.Context, id string) (domain.User, error)
+	CreateUser(ctx context.Context, user domain.User) (domain.User, error)
+	UpdateUser(ctx context.Context, user domain.User) (domain.User, error)
+	// DeleteUser cancels the holds of the user. It fails with an
+	// ActiveLendingsError while the user has books out, unless force is set.
+	DeleteUser(ctx context.Context, id string, version int, deletedAt time.Time, force bool) error
+	// RestoreUser undeletes a user. A user whose email address was taken
+	// since fails with ErrEmailExists.
+	RestoreUser(ctx context.Context, id string) (domain.User, error)
+
+	GetLendings(ctx context.Context, filter LendingFilter, options ListOptions) (Page[domain.Lending], error)
+	GetLendingByID(ctx context.Context, id string) (domain.Lending, error)
+	GetOverdueLendings(ctx context.Context, now time.Time) ([]domain.Lending, error)
+	// CountActiveLendings counts the lendings a user has not returned yet.
+	CountActiveLendings(ctx context.Context, userID string) (int, error)
+	// CreateLending hands out the copy set aside for a ready hold of the same
+	// user before it falls back to any available copy.
+	CreateLending(ctx context.Context, lending domain.Lending) (domain.Lending, error)
+	UpdateLending(ctx context.Context, lending domain.Lending) (domain.Lending, error)
+	// DeleteLending puts the copy of an active lending back on the shelf.
+	DeleteLending(ctx context.Context, id string, version int, deletedAt time.Time) error
+	// RestoreLending takes the copy of an active lending off the shelf again,
+	// or fails with ErrCopyUnavailable when it is no longer available.
+	RestoreLending(ctx context.Context, id string) (domain.Lending, error)
+
+	// PurgeDeleted removes the books, users and lendings deleted before the
+	// cutoff for good.
+	PurgeDeleted(ctx context.Context, before time.Time) (domain.PurgeReport, error)
 }
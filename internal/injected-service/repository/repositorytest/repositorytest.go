@@ -0,0 +1,969 @@
+// Package repositorytest is the conformance suite for implementations of
+// repository.Repository. Every backend runs it from its own tests:
+//
+//	func TestConformance(t *testing.T) {
+//		repositorytest.Run(t, func(t *testing.T) repository.Repository { return New() })
+//	}
+package repositorytest
+
+import (
+	"context"
+	"errors"
+	"libary-service/internal/domain"
+	"libary-service/internal/injected-service/repository"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var ctx = context.Background()
+
+// lendDate is the day every lending of the suite starts on.
+var lendDate = time.Date(2025, time.March, 1, 0, 0, 0, 0, time.UTC)
+
+// deletedAt is when the suite deletes rows.
+var deletedAt = lendDate.AddDate(0, 1, 0)
+
+// Run runs the suite. newRepository is called once per subtest and must
+// return a connected repository without any data in it. Subtests run one
+// after the other, so backends may share a database between them.
+func Run(t *testing.T, newRepository func(t *testing.T) repository.Repository) {
+	tests := []struct {
+		name string
+		run  func(t *testing.T, f fixture)
+	}{
+		{"Books", testBooks},
+		{"SearchCoAuthors", testSearchCoAuthors},
+		{"BookCopies", testBookCopies},
+		{"Users", testUsers},
+		{"Lendings", testLendings},
+		{"MoveLending", testMoveLending},
+		{"Fines", testFines},
+		{"Holds", testHolds},
+		{"NotFound", testNotFound},
+		{"Uniqueness", testUniqueness},
+		{"ReferentialIntegrity", testReferentialIntegrity},
+		{"SoftDelete", testSoftDelete},
+		{"StaleDelete", testStaleDelete},
+		{"DeleteWithActiveLendings", testDeleteWithActiveLendings},
+		{"Restore", testRestore},
+		{"Purge", testPurge},
+		{"ConcurrentLendings", testConcurrentLendings},
+		{"ConcurrentHolds", testConcurrentHolds},
+		{"ConcurrentLoanLimit", testConcurrentLoanLimit},
+		{"Transactions", testTransactions},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.run(t, fixture{t: t, repo: newRepository(t)})
+		})
+	}
+}
+
+// fixture creates the rows a subtest builds on and fails it when that does
+// not work.
+type fixture struct {
+	t    *testing.T
+	repo repository.Repository
+}
+
+func (f fixture) book() domain.Book {
+	book, err := f.repo.CreateBook(ctx, domain.Book{ID: uuid.NewString(), Title: "The Hobbit", Author: "J. R. R. Tolkien"})
+	require.NoError(f.t, err, "CreateBook")
+	return book
+}
+
+func (f fixture) copy(bookID string) domain.BookCopy {
+	bookCopy := domain.BookCopy{ID: uuid.NewString(), BookID: bookID, Barcode: "LIB-" + uuid.NewString(),
+		Condition: domain.CopyConditionGood, Status: domain.CopyStatusAvailable}
+	_, err := f.repo.CreateBookCopy(ctx, bookCopy)
+	require.NoError(f.t, err, "CreateBookCopy")
+	return bookCopy
+}
+
+func (f fixture) user() domain.User {
+	id := uuid.NewString()
+	user, err := f.repo.CreateUser(ctx, domain.User{ID: id, Name: "Max Mustermann", Email: id + "@example.com", Category: domain.UserCategoryStudent})
+	require.NoError(f.t, err, "CreateUser")
+	return user
+}
+
+// lending lends the copy to the user.
+func (f fixture) lending(bookCopy domain.BookCopy, userID string) domain.Lending {
+	lending, err := f.repo.CreateLending(ctx, domain.Lending{ID: uuid.NewString(), BookID: bookCopy.BookID,
+		CopyID: bookCopy.ID, UserID: userID, LendDate: lendDate, DueDate: lendDate.AddDate(0, 0, 21)})
+	require.NoError(f.t, err, "CreateLending")
+	return lending
+}
+
+func (f fixture) fine(userID, lendingID string) domain.Fine {
+	fine := domain.Fine{ID: uuid.NewString(), UserID: userID, LendingID: lendingID, Reason: domain.FineReasonOverdue,
+		Amount: 150, Status: domain.FineStatusUnpaid, CreatedAt: lendDate}
+	_, err := f.repo.CreateFine(ctx, fine)
+	require.NoError(f.t, err, "CreateFine")
+	return fine
+}
+
+func (f fixture) hold(bookID, userID string) domain.Hold {
+	hold := domain.Hold{ID: uuid.NewString(), BookID: bookID, UserID: userID, Status: domain.HoldStatusWaiting, CreatedAt: lendDate}
+	_, err := f.repo.CreateHold(ctx, hold)
+	require.NoError(f.t, err, "CreateHold")
+	return hold
+}
+
+func (f fixture) copyStatus(id string) domain.CopyStatus {
+	bookCopy, err := f.repo.GetBookCopyByID(ctx, id)
+	require.NoError(f.t, err, "GetBookCopyByID")
+	return bookCopy.Status
+}
+
+func testBooks(t *testing.T, f fixture) {
+	book := f.book()
+	assert.Equal(t, 1, book.Version)
+	got, err := f.repo.GetBookByID(ctx, book.ID)
+	require.NoError(t, err)
+	assert.Equal(t, book, got)
+
+	book.Title = "The Two Towers"
+	book, err = f.repo.UpdateBook(ctx, book)
+	require.NoError(t, err)
+	assert.Equal(t, 2, book.Version)
+	got, err = f.repo.GetBookByID(ctx, book.ID)
+	require.NoError(t, err)
+	assert.Equal(t, book, got)
+
+	f.book()
+	page, err := f.repo.GetBooks(ctx, repository.BookFilter{}, repository.ListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, page.Total)
+	assert.Len(t, page.Items, 2)
+
+	detailed, err := f.repo.CreateBook(ctx, domain.Book{ID: uuid.NewString(), Title: "Good Omens",
+		Author: "Terry Pratchett", Authors: []string{"Terry Pratchett", "Neil Gaiman"}, Publisher: "Gollancz",
+		PublicationYear: 1990, Edition: "1st", Language: "en", PageCount: 288,
+		Subjects: []string{"Fantasy", "Comedy"}, Description: "The end of the world is nigh."})
+	require.NoError(t, err)
+	got, err = f.repo.GetBookByID(ctx, detailed.ID)
+	require.NoError(t, err)
+	assert.Equal(t, detailed, got, "book with all details")
+	page, err = f.repo.GetBooks(ctx, repository.BookFilter{Author: "Neil Gaiman"}, repository.ListOptions{})
+	require.NoError(t, err)
+	if assert.Len(t, page.Items, 1, "books of a co-author") {
+		assert.Equal(t, detailed, page.Items[0], "book of a co-author")
+	}
+
+	detailed.Authors = []string{"Terry Pratchett"}
+	detailed.Subjects = nil
+	detailed, err = f.repo.UpdateBook(ctx, detailed)
+	require.NoError(t, err)
+	got, err = f.repo.GetBookByID(ctx, detailed.ID)
+	require.NoError(t, err)
+	assert.Equal(t, detailed, got, "book without its co-author and subjects")
+	page, err = f.repo.GetBooks(ctx, repository.BookFilter{Author: "Neil Gaiman"}, repository.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, page.Items, "books of a removed co-author")
+
+	require.NoError(t, f.repo.DeleteBook(ctx, book.ID, book.Version, deletedAt, false))
+	_, err = f.repo.GetBookByID(ctx, book.ID)
+	assert.ErrorIs(t, err, repository.ErrBookNotFound)
+}
+
+// testSearchCoAuthors finds a book by an author that is not its first one.
+func testSearchCoAuthors(t *testing.T, f fixture) {
+	f.book()
+	book, err := f.repo.CreateBook(ctx, domain.Book{ID: uuid.NewString(), Title: "Good Omens",
+		Author: "Terry Pratchett", Authors: []string{"Terry Pratchett", "Neil Gaiman"}})
+	require.NoError(t, err)
+
+	hits, err := f.repo.SearchBooks(ctx, "gaiman", repository.ListOptions{})
+	require.NoError(t, err)
+	if assert.Len(t, hits.Items, 1, "books of a co-author") {
+		assert.Equal(t, book.ID, hits.Items[0].ID)
+		assert.Equal(t, "Terry Pratchett, Neil <b>Gaiman</b>", hits.Items[0].AuthorHighlight)
+	}
+	hits, err = f.repo.SearchBooks(ctx, "pratchett gaiman omens", repository.ListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, hits.Total, "terms spread over title and authors")
+
+	book.Authors = []string{"Terry Pratchett"}
+	_, err = f.repo.UpdateBook(ctx, book)
+	require.NoError(t, err)
+	hits, err = f.repo.SearchBooks(ctx, "gaiman", repository.ListOptions{})
+	require.NoError(t, err)
+	assert.Zero(t, hits.Total, "books of a removed co-author")
+}
+
+func testBookCopies(t *testing.T, f fixture) {
+	book := f.book()
+	bookCopy := f.copy(book.ID)
+	copies, err := f.repo.GetBookCopies(ctx, book.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []domain.BookCopy{bookCopy}, copies)
+
+	bookCopy.Condition = domain.CopyConditionDamaged
+	bookCopy.Status = domain.CopyStatusInRepair
+	_, err = f.repo.UpdateBookCopy(ctx, bookCopy)
+	require.NoError(t, err)
+	got, err := f.repo.GetBookCopyByID(ctx, bookCopy.ID)
+	require.NoError(t, err)
+	assert.Equal(t, bookCopy, got)
+
+	require.NoError(t, f.repo.DeleteBookCopy(ctx, bookCopy.ID))
+	_, err = f.repo.GetBookCopyByID(ctx, bookCopy.ID)
+	assert.ErrorIs(t, err, repository.ErrBookCopyNotFound)
+}
+
+func testUsers(t *testing.T, f fixture) {
+	user := f.user()
+	got, err := f.repo.GetUserByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, user, got)
+
+	user.Name = "Erika Mustermann"
+	user.Category = domain.UserCategoryStaff
+	user, err = f.repo.UpdateUser(ctx, user)
+	require.NoError(t, err)
+	got, err = f.repo.GetUserByID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, user, got)
+
+	f.user()
+	page, err := f.repo.GetUsers(ctx, repository.UserFilter{Category: domain.UserCategoryStaff}, repository.ListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []domain.User{user}, page.Items)
+	page, err = f.repo.GetUsers(ctx, repository.UserFilter{Email: strings.ToUpper(user.Email)}, repository.ListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []domain.User{user}, page.Items, "the email filter ignores case")
+
+	require.NoError(t, f.repo.DeleteUser(ctx, user.ID, user.Version, deletedAt, false))
+	_, err = f.repo.GetUserByID(ctx, user.ID)
+	assert.ErrorIs(t, err, repository.ErrUserNotFound)
+}
+
+func testLendings(t *testing.T, f fixture) {
+	book := f.book()
+	bookCopy := f.copy(book.ID)
+	user := f.user()
+
+	// Without a requested copy the lending takes one from the shelf.
+	lending, err := f.repo.CreateLending(ctx, domain.Lending{ID: uuid.NewString(), BookID: book.ID, UserID: user.ID, LendDate: lendDate})
+	require.NoError(t, err)
+	assert.Equal(t, bookCopy.ID, lending.CopyID)
+	assert.Equal(t, domain.CopyStatusOnLoan, f.copyStatus(bookCopy.ID))
+	count, err := f.repo.CountActiveLendings(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	got, err := f.repo.GetLendingByID(ctx, lending.ID)
+	require.NoError(t, err)
+	assert.Equal(t, lending.CopyID, got.CopyID)
+	assert.True(t, got.LendDate.Equal(lendDate), "lend date %v", got.LendDate)
+	assert.True(t, got.ReturnDate.IsZero(), "return date %v", got.ReturnDate)
+
+	lending.ReturnDate = lendDate.AddDate(0, 0, 7)
+	_, err = f.repo.UpdateLending(ctx, lending)
+	require.NoError(t, err)
+	assert.Equal(t, domain.CopyStatusAvailable, f.copyStatus(bookCopy.ID))
+
+	active := false
+	page, err := f.repo.GetLendings(ctx, repository.LendingFilter{UserID: user.ID, Active: &active}, repository.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, page.Items, 1)
+	assert.True(t, page.Items[0].ReturnDate.Equal(lending.ReturnDate), "return date %v", page.Items[0].ReturnDate)
+
+	overdue := f.lending(bookCopy, user.ID)
+	lendings, err := f.repo.GetOverdueLendings(ctx, lendDate.AddDate(0, 0, 22))
+	require.NoError(t, err)
+	require.Len(t, lendings, 1)
+	assert.Equal(t, overdue.ID, lendings[0].ID)
+
+	isOverdue := true
+	page, err = f.repo.GetLendings(ctx, repository.LendingFilter{UserID: user.ID, Overdue: &isOverdue, Now: lendDate.AddDate(0, 0, 22)}, repository.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, page.Items, 1, "overdue lendings")
+	assert.Equal(t, overdue.ID, page.Items[0].ID)
+	isOverdue = false
+	page, err = f.repo.GetLendings(ctx, repository.LendingFilter{UserID: user.ID, Overdue: &isOverdue, Now: lendDate.AddDate(0, 0, 22)}, repository.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, page.Items, 1, "lendings that are not overdue")
+	assert.Equal(t, lending.ID, page.Items[0].ID, "returned lending")
+	page, err = f.repo.GetLendings(ctx, repository.LendingFilter{UserID: user.ID, Overdue: &isOverdue, Now: lendDate.AddDate(0, 0, 21)}, repository.ListOptions{})
+	require.NoError(t, err)
+	assert.Len(t, page.Items, 2, "lendings that are not overdue on their due date")
+
+	require.NoError(t, f.repo.DeleteLending(ctx, overdue.ID, overdue.Version, deletedAt))
+	assert.Equal(t, domain.CopyStatusAvailable, f.copyStatus(bookCopy.ID))
+	_, err = f.repo.GetLendingByID(ctx, overdue.ID)
+	assert.ErrorIs(t, err, repository.ErrLendingNotFound)
+}
+
+// testMoveLending moves an active lending between copies of its book. Only a
+// copy on the shelf can take it over.
+func testMoveLending(t *testing.T, f fixture) {
+	book := f.book()
+	bookCopy := f.copy(book.ID)
+	onLoan := f.copy(book.ID)
+	withdrawn := f.copy(book.ID)
+	free := f.copy(book.ID)
+	withdrawn.Status = domain.CopyStatusWithdrawn
+	_, err := f.repo.UpdateBookCopy(ctx, withdrawn)
+	require.NoError(t, err, "UpdateBookCopy")
+	lending := f.lending(bookCopy, f.user().ID)
+	f.lending(onLoan, f.user().ID)
+
+	for _, target := range []domain.BookCopy{onLoan, withdrawn} {
+		moved := lending
+		moved.CopyID = target.ID
+		_, err = f.repo.UpdateLending(ctx, moved)
+		assert.ErrorIs(t, err, repository.ErrCopyUnavailable, "move to copy %s", target.Barcode)
+	}
+	got, err := f.repo.GetLendingByID(ctx, lending.ID)
+	require.NoError(t, err)
+	assert.Equal(t, bookCopy.ID, got.CopyID, "copy of a refused move")
+	assert.Equal(t, domain.CopyStatusOnLoan, f.copyStatus(bookCopy.ID))
+	assert.Equal(t, domain.CopyStatusWithdrawn, f.copyStatus(withdrawn.ID))
+
+	lending.CopyID = free.ID
+	_, err = f.repo.UpdateLending(ctx, lending)
+	require.NoError(t, err)
+	assert.Equal(t, domain.CopyStatusAvailable, f.copyStatus(bookCopy.ID))
+	assert.Equal(t, domain.CopyStatusOnLoan, f.copyStatus(free.ID))
+}
+
+func testFines(t *testing.T, f fixture) {
+	user := f.user()
+	fine := f.fine(user.ID, "")
+	f.fine(f.user().ID, "")
+
+	fine.Status = domain.FineStatusPaid
+	fine.SettledAt = lendDate.AddDate(0, 0, 1)
+	_, err := f.repo.UpdateFine(ctx, fine)
+	require.NoError(t, err)
+	got, err := f.repo.GetFineByID(ctx, fine.ID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.FineStatusPaid, got.Status)
+	assert.True(t, got.SettledAt.Equal(fine.SettledAt), "settled at %v", got.SettledAt)
+
+	fines, err := f.repo.GetFinesByUserID(ctx, user.ID)
+	require.NoError(t, err)
+	require.Len(t, fines, 1)
+	assert.Equal(t, fine.ID, fines[0].ID)
+}
+
+func testHolds(t *testing.T, f fixture) {
+	book := f.book()
+	bookCopy := f.copy(book.ID)
+	lending := f.lending(bookCopy, f.user().ID)
+	first := f.hold(book.ID, f.user().ID)
+	second := f.hold(book.ID, f.user().ID)
+	second.CreatedAt = lendDate.Add(time.Hour)
+	_, err := f.repo.UpdateHold(ctx, second)
+	require.NoError(t, err)
+
+	holds, err := f.repo.GetHolds(ctx, book.ID)
+	require.NoError(t, err)
+	require.Len(t, holds, 2)
+	assert.Equal(t, first.ID, holds[0].ID)
+
+	// A copy that is lent out is not set aside.
+	ready, err := f.repo.ReadyNextHold(ctx, bookCopy.ID, lendDate, lendDate.AddDate(0, 0, 7))
+	require.NoError(t, err)
+	assert.Empty(t, ready.ID)
+
+	lending.ReturnDate = lendDate.AddDate(0, 0, 1)
+	_, err = f.repo.UpdateLending(ctx, lending)
+	require.NoError(t, err)
+	ready, err = f.repo.ReadyNextHold(ctx, bookCopy.ID, lendDate, lendDate.AddDate(0, 0, 7))
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, ready.ID)
+	assert.Equal(t, domain.HoldStatusReady, ready.Status)
+	assert.Equal(t, domain.CopyStatusOnHold, f.copyStatus(bookCopy.ID))
+
+	expired, err := f.repo.ExpireHolds(ctx, lendDate.AddDate(0, 0, 8))
+	require.NoError(t, err)
+	require.Len(t, expired, 1)
+	assert.Equal(t, first.ID, expired[0].ID)
+	assert.Equal(t, domain.CopyStatusAvailable, f.copyStatus(bookCopy.ID))
+
+	second.Status = domain.HoldStatusCancelled
+	_, err = f.repo.UpdateHold(ctx, second)
+	require.NoError(t, err)
+	holds, err = f.repo.GetHolds(ctx, book.ID)
+	require.NoError(t, err)
+	assert.Empty(t, holds)
+}
+
+func testNotFound(t *testing.T, f fixture) {
+	id := uuid.NewString()
+	book := f.book()
+	bookCopy := f.copy(book.ID)
+	user := f.user()
+
+	_, err := f.repo.GetBookByID(ctx, id)
+	assert.ErrorIs(t, err, repository.ErrBookNotFound, "GetBookByID")
+	_, err = f.repo.UpdateBook(ctx, domain.Book{ID: id, Title: "X", Author: "Y"})
+	assert.ErrorIs(t, err, repository.ErrBookNotFound, "UpdateBook")
+	assert.ErrorIs(t, f.repo.DeleteBook(ctx, id, 1, deletedAt, false), repository.ErrBookNotFound, "DeleteBook")
+	_, err = f.repo.RestoreBook(ctx, id)
+	assert.ErrorIs(t, err, repository.ErrBookNotFound, "RestoreBook")
+
+	_, err = f.repo.GetBookCopyByID(ctx, id)
+	assert.ErrorIs(t, err, repository.ErrBookCopyNotFound, "GetBookCopyByID")
+	missingCopy := bookCopy
+	missingCopy.ID = id
+	missingCopy.Barcode = "LIB-" + id
+	_, err = f.repo.UpdateBookCopy(ctx, missingCopy)
+	assert.ErrorIs(t, err, repository.ErrBookCopyNotFound, "UpdateBookCopy")
+	assert.ErrorIs(t, f.repo.DeleteBookCopy(ctx, id), repository.ErrBookCopyNotFound, "DeleteBookCopy")
+
+	_, err = f.repo.GetUserByID(ctx, id)
+	assert.ErrorIs(t, err, repository.ErrUserNotFound, "GetUserByID")
+	_, err = f.repo.LockUser(ctx, id)
+	assert.ErrorIs(t, err, repository.ErrUserNotFound, "LockUser")
+	_, err = f.repo.UpdateUser(ctx, domain.User{ID: id, Name: "X", Email: id + "@example.com", Category: domain.UserCategoryStudent})
+	assert.ErrorIs(t, err, repository.ErrUserNotFound, "UpdateUser")
+	assert.ErrorIs(t, f.repo.DeleteUser(ctx, id, 1, deletedAt, false), repository.ErrUserNotFound, "DeleteUser")
+	_, err = f.repo.RestoreUser(ctx, id)
+	assert.ErrorIs(t, err, repository.ErrUserNotFound, "RestoreUser")
+
+	_, err = f.repo.GetLendingByID(ctx, id)
+	assert.ErrorIs(t, err, repository.ErrLendingNotFound, "GetLendingByID")
+	_, err = f.repo.UpdateLending(ctx, domain.Lending{ID: id, BookID: book.ID, CopyID: bookCopy.ID, UserID: user.ID, LendDate: lendDate, ReturnDate: lendDate})
+	assert.ErrorIs(t, err, repository.ErrLendingNotFound, "UpdateLending")
+	assert.ErrorIs(t, f.repo.DeleteLending(ctx, id, 1, deletedAt), repository.ErrLendingNotFound, "DeleteLending")
+	_, err = f.repo.RestoreLending(ctx, id)
+	assert.ErrorIs(t, err, repository.ErrLendingNotFound, "RestoreLending")
+
+	_, err = f.repo.GetFineByID(ctx, id)
+	assert.ErrorIs(t, err, repository.ErrFineNotFound, "GetFineByID")
+	_, err = f.repo.UpdateFine(ctx, domain.Fine{ID: id, UserID: user.ID, Reason: domain.FineReasonLost, Status: domain.FineStatusUnpaid, CreatedAt: lendDate})
+	assert.ErrorIs(t, err, repository.ErrFineNotFound, "UpdateFine")
+
+	_, err = f.repo.GetHoldByID(ctx, id)
+	assert.ErrorIs(t, err, repository.ErrHoldNotFound, "GetHoldByID")
+	_, err = f.repo.UpdateHold(ctx, domain.Hold{ID: id, BookID: book.ID, UserID: user.ID, Status: domain.HoldStatusWaiting, CreatedAt: lendDate})
+	assert.ErrorIs(t, err, repository.ErrHoldNotFound, "UpdateHold")
+
+	assert.ErrorIs(t, err, domain.ErrNotFound, "not-found errors are of the not-found kind")
+}
+
+func testUniqueness(t *testing.T, f fixture) {
+	book := f.book()
+	bookCopy := f.copy(book.ID)
+	other := f.copy(book.ID)
+	duplicate := bookCopy
+	duplicate.ID = uuid.NewString()
+	_, err := f.repo.CreateBookCopy(ctx, duplicate)
+	assert.ErrorIs(t, err, repository.ErrBarcodeExists, "CreateBookCopy")
+	other.Barcode = bookCopy.Barcode
+	_, err = f.repo.UpdateBookCopy(ctx, other)
+	assert.ErrorIs(t, err, repository.ErrBarcodeExists, "UpdateBookCopy")
+
+	user := f.user()
+	second := f.user()
+	_, err = f.repo.CreateUser(ctx, domain.User{ID: uuid.NewString(), Name: "X", Email: user.Email, Category: domain.UserCategoryStudent})
+	assert.ErrorIs(t, err, repository.ErrEmailExists, "CreateUser")
+	second.Email = user.Email
+	_, err = f.repo.UpdateUser(ctx, second)
+	assert.ErrorIs(t, err, repository.ErrEmailExists, "UpdateUser")
+	_, err = f.repo.CreateUser(ctx, domain.User{ID: uuid.NewString(), Name: "X", Email: strings.ToUpper(user.Email), Category: domain.UserCategoryStudent})
+	assert.ErrorIs(t, err, repository.ErrEmailExists, "CreateUser with the address in upper case")
+
+	// Books without an ISBN never clash, the fixture creates them all the time.
+	isbn := uuid.NewString()
+	book.ISBN = isbn
+	book, err = f.repo.UpdateBook(ctx, book)
+	require.NoError(t, err, "UpdateBook")
+	_, err = f.repo.CreateBook(ctx, domain.Book{ID: uuid.NewString(), Title: "X", Author: "Y", ISBN: isbn})
+	assert.ErrorIs(t, err, repository.ErrISBNExists, "CreateBook")
+	unnumbered := f.book()
+	unnumbered.ISBN = isbn
+	_, err = f.repo.UpdateBook(ctx, unnumbered)
+	assert.ErrorIs(t, err, repository.ErrISBNExists, "UpdateBook")
+	books, err := f.repo.GetBooks(ctx, repository.BookFilter{ISBN: isbn}, repository.ListOptions{})
+	require.NoError(t, err, "GetBooks by ISBN")
+	if assert.Len(t, books.Items, 1, "books with the ISBN") {
+		assert.Equal(t, book.ID, books.Items[0].ID, "book with the ISBN")
+		assert.Equal(t, isbn, books.Items[0].ISBN, "ISBN of the book")
+	}
+
+	f.hold(book.ID, user.ID)
+	_, err = f.repo.CreateHold(ctx, domain.Hold{ID: uuid.NewString(), BookID: book.ID, UserID: user.ID, Status: domain.HoldStatusWaiting, CreatedAt: lendDate})
+	assert.ErrorIs(t, err, repository.ErrHoldExists, "CreateHold")
+
+	f.lending(bookCopy, user.ID)
+	_, err = f.repo.CreateLending(ctx, domain.Lending{ID: uuid.NewString(), BookID: book.ID, CopyID: bookCopy.ID, UserID: second.ID, LendDate: lendDate})
+	assert.ErrorIs(t, err, repository.ErrCopyUnavailable, "CreateLending")
+
+	assert.ErrorIs(t, err, domain.ErrConflict, "uniqueness errors are of the conflict kind")
+}
+
+func testReferentialIntegrity(t *testing.T, f fixture) {
+	missing := uuid.NewString()
+	book := f.book()
+	bookCopy := f.copy(book.ID)
+	user := f.user()
+
+	_, err := f.repo.CreateBookCopy(ctx, domain.BookCopy{ID: uuid.NewString(), BookID: missing, Barcode: "LIB-" + missing,
+		Condition: domain.CopyConditionGood, Status: domain.CopyStatusAvailable})
+	assert.ErrorIs(t, err, repository.ErrBookNotFound, "copy of a missing book")
+
+	_, err = f.repo.CreateLending(ctx, domain.Lending{ID: uuid.NewString(), BookID: book.ID, CopyID: bookCopy.ID, UserID: missing, LendDate: lendDate})
+	assert.ErrorIs(t, err, repository.ErrUserNotFound, "lending to a missing user")
+	assert.Equal(t, domain.CopyStatusAvailable, f.copyStatus(bookCopy.ID), "copy of a refused lending")
+	_, err = f.repo.CreateLending(ctx, domain.Lending{ID: uuid.NewString(), BookID: book.ID, CopyID: missing, UserID: user.ID, LendDate: lendDate})
+	assert.ErrorIs(t, err, repository.ErrBookCopyNotFound, "lending of a missing copy")
+	_, err = f.repo.CreateLending(ctx, domain.Lending{ID: uuid.NewString(), BookID: missing, UserID: user.ID, LendDate: lendDate, ReturnDate: lendDate})
+	assert.ErrorIs(t, err, repository.ErrBookNotFound, "returned lending of a missing book")
+
+	_, err = f.repo.CreateFine(ctx, domain.Fine{ID: uuid.NewString(), UserID: missing, Reason: domain.FineReasonLost,
+		Amount: 2500, Status: domain.FineStatusUnpaid, CreatedAt: lendDate})
+	assert.ErrorIs(t, err, repository.ErrUserNotFound, "fine of a missing user")
+	_, err = f.repo.CreateFine(ctx, domain.Fine{ID: uuid.NewString(), UserID: user.ID, LendingID: missing, Reason: domain.FineReasonLost,
+		Amount: 2500, Status: domain.FineStatusUnpaid, CreatedAt: lendDate})
+	assert.ErrorIs(t, err, repository.ErrLendingNotFound, "fine for a missing lending")
+
+	_, err = f.repo.CreateHold(ctx, domain.Hold{ID: uuid.NewString(), BookID: missing, UserID: user.ID, Status: domain.HoldStatusWaiting, CreatedAt: lendDate})
+	assert.ErrorIs(t, err, repository.ErrBookNotFound, "hold on a missing book")
+	_, err = f.repo.CreateHold(ctx, domain.Hold{ID: uuid.NewString(), BookID: book.ID, UserID: missing, Status: domain.HoldStatusWaiting, CreatedAt: lendDate})
+	assert.ErrorIs(t, err, repository.ErrUserNotFound, "hold of a missing user")
+}
+
+func testSoftDelete(t *testing.T, f fixture) {
+	book := f.book()
+	bookCopy := f.copy(book.ID)
+	user := f.user()
+	lending := f.lending(bookCopy, user.ID)
+	other := f.user()
+	hold := f.hold(book.ID, other.ID)
+
+	// A deleted lending gives its copy back and no longer counts.
+	require.NoError(t, f.repo.DeleteLending(ctx, lending.ID, lending.Version, deletedAt))
+	assert.Equal(t, domain.CopyStatusAvailable, f.copyStatus(bookCopy.ID))
+	count, err := f.repo.CountActiveLendings(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Zero(t, count)
+	overdue, err := f.repo.GetOverdueLendings(ctx, lendDate.AddDate(1, 0, 0))
+	require.NoError(t, err)
+	assert.Empty(t, overdue)
+	lending.ReturnDate = lendDate.AddDate(0, 0, 1)
+	_, err = f.repo.UpdateLending(ctx, lending)
+	assert.ErrorIs(t, err, repository.ErrLendingNotFound, "UpdateLending of a deleted lending")
+	assert.ErrorIs(t, f.repo.DeleteLending(ctx, lending.ID, lending.Version, deletedAt), repository.ErrLendingNotFound, "DeleteLending twice")
+
+	page, err := f.repo.GetLendings(ctx, repository.LendingFilter{UserID: user.ID}, repository.ListOptions{})
+	require.NoError(t, err)
+	assert.Zero(t, page.Total, "deleted lendings are left out of lists")
+	page, err = f.repo.GetLendings(ctx, repository.LendingFilter{UserID: user.ID, IncludeDeleted: true}, repository.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, page.Items, 1)
+	require.NotNil(t, page.Items[0].DeletedAt)
+	assert.True(t, page.Items[0].DeletedAt.Equal(deletedAt), "deleted at %v", page.Items[0].DeletedAt)
+
+	// A deleted user keeps their lending history, but loses their holds.
+	lending = f.lending(bookCopy, other.ID)
+	require.NoError(t, f.repo.DeleteUser(ctx, other.ID, other.Version, deletedAt, true))
+	_, err = f.repo.GetLendingByID(ctx, lending.ID)
+	assert.NoError(t, err, "lending of a deleted user")
+	got, err := f.repo.GetHoldByID(ctx, hold.ID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.HoldStatusCancelled, got.Status)
+	_, err = f.repo.UpdateUser(ctx, other)
+	assert.ErrorIs(t, err, repository.ErrUserNotFound, "UpdateUser of a deleted user")
+	users, err := f.repo.GetUsers(ctx, repository.UserFilter{}, repository.ListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, []domain.User{user}, users.Items)
+	users, err = f.repo.GetUsers(ctx, repository.UserFilter{IncludeDeleted: true}, repository.ListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, users.Total)
+
+	// A deleted book keeps its copies and lendings, but releases the copy set
+	// aside for a hold on it.
+	lending.ReturnDate = lendDate.AddDate(0, 0, 1)
+	_, err = f.repo.UpdateLending(ctx, lending)
+	require.NoError(t, err)
+	hold = f.hold(book.ID, user.ID)
+	_, err = f.repo.ReadyNextHold(ctx, bookCopy.ID, lendDate, lendDate.AddDate(0, 0, 7))
+	require.NoError(t, err)
+	require.NoError(t, f.repo.DeleteBook(ctx, book.ID, book.Version, deletedAt, false))
+	assert.Equal(t, domain.CopyStatusAvailable, f.copyStatus(bookCopy.ID))
+	got, err = f.repo.GetHoldByID(ctx, hold.ID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.HoldStatusCancelled, got.Status)
+	_, err = f.repo.GetLendingByID(ctx, lending.ID)
+	assert.NoError(t, err, "lending of a deleted book")
+	_, err = f.repo.UpdateBook(ctx, book)
+	assert.ErrorIs(t, err, repository.ErrBookNotFound, "UpdateBook of a deleted book")
+	books, err := f.repo.GetBooks(ctx, repository.BookFilter{}, repository.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, books.Items)
+	books, err = f.repo.GetBooks(ctx, repository.BookFilter{IncludeDeleted: true}, repository.ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, books.Items, 1)
+	require.NotNil(t, books.Items[0].DeletedAt)
+	assert.True(t, books.Items[0].DeletedAt.Equal(deletedAt), "deleted at %v", books.Items[0].DeletedAt)
+	hits, err := f.repo.SearchBooks(ctx, "hobbit", repository.ListOptions{})
+	require.NoError(t, err)
+	assert.Zero(t, hits.Total, "deleted books are left out of the search")
+}
+
+// testStaleDelete deletes rows with the version they had before an update.
+func testStaleDelete(t *testing.T, f fixture) {
+	book := f.book()
+	bookCopy := f.copy(book.ID)
+	user := f.user()
+	lending := f.lending(bookCopy, user.ID)
+
+	updatedLending, err := f.repo.UpdateLending(ctx, lending)
+	require.NoError(t, err, "UpdateLending")
+	err = f.repo.DeleteLending(ctx, lending.ID, lending.Version, deletedAt)
+	assert.ErrorIs(t, err, repository.ErrVersionMismatch, "DeleteLending")
+	assert.Equal(t, domain.CopyStatusOnLoan, f.copyStatus(bookCopy.ID))
+	require.NoError(t, f.repo.DeleteLending(ctx, lending.ID, updatedLending.Version, deletedAt))
+
+	updatedUser, err := f.repo.UpdateUser(ctx, user)
+	require.NoError(t, err, "UpdateUser")
+	err = f.repo.DeleteUser(ctx, user.ID, user.Version, deletedAt, false)
+	assert.ErrorIs(t, err, repository.ErrVersionMismatch, "DeleteUser")
+	_, err = f.repo.GetUserByID(ctx, user.ID)
+	assert.NoError(t, err, "user after a stale delete")
+	require.NoError(t, f.repo.DeleteUser(ctx, user.ID, updatedUser.Version, deletedAt, false))
+
+	updatedBook, err := f.repo.UpdateBook(ctx, book)
+	require.NoError(t, err, "UpdateBook")
+	err = f.repo.DeleteBook(ctx, book.ID, book.Version, deletedAt, false)
+	assert.ErrorIs(t, err, repository.ErrVersionMismatch, "DeleteBook")
+	_, err = f.repo.GetBookByID(ctx, book.ID)
+	assert.NoError(t, err, "book after a stale delete")
+	require.NoError(t, f.repo.DeleteBook(ctx, book.ID, updatedBook.Version, deletedAt, false))
+}
+
+func testDeleteWithActiveLendings(t *testing.T, f fixture) {
+	book := f.book()
+	user := f.user()
+	lendings := []domain.Lending{f.lending(f.copy(book.ID), user.ID), f.lending(f.copy(book.ID), user.ID)}
+	returned := f.lending(f.copy(book.ID), user.ID)
+	returned.ReturnDate = lendDate.AddDate(0, 0, 1)
+	_, err := f.repo.UpdateLending(ctx, returned)
+	require.NoError(t, err)
+	hold := f.hold(book.ID, f.user().ID)
+	expectedIDs := []string{lendings[0].ID, lendings[1].ID}
+	sort.Strings(expectedIDs)
+
+	// Active lendings block the delete and leave everything as it was.
+	var active *repository.ActiveLendingsError
+	err = f.repo.DeleteBook(ctx, book.ID, book.Version, deletedAt, false)
+	assert.ErrorIs(t, err, domain.ErrConflict)
+	require.ErrorAs(t, err, &active)
+	assert.Equal(t, "book", active.Entity)
+	assert.Equal(t, expectedIDs, active.LendingIDs)
+	_, err = f.repo.GetBookByID(ctx, book.ID)
+	assert.NoError(t, err, "book whose delete was refused")
+	got, err := f.repo.GetHoldByID(ctx, hold.ID)
+	require.NoError(t, err)
+	assert.Equal(t, domain.HoldStatusWaiting, got.Status, "hold on a book whose delete was refused")
+
+	err = f.repo.DeleteUser(ctx, user.ID, user.Version, deletedAt, false)
+	require.ErrorAs(t, err, &active)
+	assert.Equal(t, "user", active.Entity)
+	assert.Equal(t, expectedIDs, active.LendingIDs)
+	_, err = f.repo.GetUserByID(ctx, user.ID)
+	assert.NoError(t, err, "user whose delete was refused")
+
+	err = f.repo.DeleteBookCopy(ctx, lendings[1].CopyID)
+	require.ErrorAs(t, err, &active)
+	assert.Equal(t, "copy", active.Entity)
+	assert.Equal(t, []string{lendings[1].ID}, active.LendingIDs)
+	_, err = f.repo.GetBookCopyByID(ctx, lendings[1].CopyID)
+	assert.NoError(t, err, "copy whose delete was refused")
+
+	// Deleted lendings do not count as active.
+	require.NoError(t, f.repo.DeleteLending(ctx, lendings[0].ID, lendings[0].Version, deletedAt))
+	err = f.repo.DeleteUser(ctx, user.ID, user.Version, deletedAt, false)
+	require.ErrorAs(t, err, &active)
+	assert.Equal(t, []string{lendings[1].ID}, active.LendingIDs)
+
+	// Forcing the delete keeps the lending out.
+	require.NoError(t, f.repo.DeleteUser(ctx, user.ID, user.Version, deletedAt, true))
+	require.NoError(t, f.repo.DeleteBook(ctx, book.ID, book.Version, deletedAt, true))
+	out, err := f.repo.GetLendingByID(ctx, lendings[1].ID)
+	require.NoError(t, err)
+	assert.True(t, out.ReturnDate.IsZero(), "lending of a forcibly deleted book")
+	assert.Equal(t, domain.CopyStatusOnLoan, f.copyStatus(lendings[1].CopyID))
+
+	// Once the books are back, deleting needs no force.
+	other := f.book()
+	otherUser := f.user()
+	lending := f.lending(f.copy(other.ID), otherUser.ID)
+	lending.ReturnDate = lendDate.AddDate(0, 0, 1)
+	_, err = f.repo.UpdateLending(ctx, lending)
+	require.NoError(t, err)
+	assert.NoError(t, f.repo.DeleteBook(ctx, other.ID, other.Version, deletedAt, false))
+	assert.NoError(t, f.repo.DeleteUser(ctx, otherUser.ID, otherUser.Version, deletedAt, false))
+}
+
+func testRestore(t *testing.T, f fixture) {
+	book := f.book()
+	bookCopy := f.copy(book.ID)
+	user := f.user()
+
+	require.NoError(t, f.repo.DeleteBook(ctx, book.ID, book.Version, deletedAt, false))
+	restoredBook, err := f.repo.RestoreBook(ctx, book.ID)
+	require.NoError(t, err)
+	assert.Equal(t, book, restoredBook)
+	hits, err := f.repo.SearchBooks(ctx, "hobbit", repository.ListOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, hits.Total, "restored books are searched again")
+	restoredBook, err = f.repo.RestoreBook(ctx, book.ID)
+	require.NoError(t, err, "restoring twice")
+	assert.Equal(t, book, restoredBook)
+
+	require.NoError(t, f.repo.DeleteUser(ctx, user.ID, user.Version, deletedAt, false))
+	restoredUser, err := f.repo.RestoreUser(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, user, restoredUser)
+	_, err = f.repo.GetUserByID(ctx, user.ID)
+	assert.NoError(t, err)
+
+	// A restored active lending takes its copy again, if nobody else did.
+	lending := f.lending(bookCopy, user.ID)
+	require.NoError(t, f.repo.DeleteLending(ctx, lending.ID, lending.Version, deletedAt))
+	restoredLending, err := f.repo.RestoreLending(ctx, lending.ID)
+	require.NoError(t, err)
+	assert.Equal(t, lending.ID, restoredLending.ID)
+	assert.True(t, restoredLending.DeletedAt == nil, "deleted at %v", restoredLending.DeletedAt)
+	assert.Equal(t, domain.CopyStatusOnLoan, f.copyStatus(bookCopy.ID))
+	count, err := f.repo.CountActiveLendings(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	require.NoError(t, f.repo.DeleteLending(ctx, lending.ID, lending.Version, deletedAt))
+	f.lending(bookCopy, f.user().ID)
+	_, err = f.repo.RestoreLending(ctx, lending.ID)
+	assert.ErrorIs(t, err, repository.ErrCopyUnavailable)
+	_, err = f.repo.GetLendingByID(ctx, lending.ID)
+	assert.ErrorIs(t, err, repository.ErrLendingNotFound, "lending that could not be restored")
+
+	// A deleted book gives its ISBN free, and cannot be restored once another
+	// book took it.
+	numbered := f.book()
+	numbered.ISBN = uuid.NewString()
+	numbered, err = f.repo.UpdateBook(ctx, numbered)
+	require.NoError(t, err)
+	require.NoError(t, f.repo.DeleteBook(ctx, numbered.ID, numbered.Version, deletedAt, false))
+	_, err = f.repo.CreateBook(ctx, domain.Book{ID: uuid.NewString(), Title: "X", Author: "Y", ISBN: numbered.ISBN})
+	require.NoError(t, err, "CreateBook with the ISBN of a deleted book")
+	_, err = f.repo.RestoreBook(ctx, numbered.ID)
+	assert.ErrorIs(t, err, repository.ErrISBNExists)
+	_, err = f.repo.GetBookByID(ctx, numbered.ID)
+	assert.ErrorIs(t, err, repository.ErrBookNotFound, "book that could not be restored")
+
+	// Deleted users give their address free in the same way.
+	require.NoError(t, f.repo.DeleteUser(ctx, user.ID, user.Version, deletedAt, true))
+	_, err = f.repo.CreateUser(ctx, domain.User{ID: uuid.NewString(), Name: "X", Email: strings.ToUpper(user.Email), Category: domain.UserCategoryStudent})
+	require.NoError(t, err, "CreateUser with the address of a deleted user")
+	_, err = f.repo.RestoreUser(ctx, user.ID)
+	assert.ErrorIs(t, err, repository.ErrEmailExists)
+	_, err = f.repo.GetUserByID(ctx, user.ID)
+	assert.ErrorIs(t, err, repository.ErrUserNotFound, "user that could not be restored")
+}
+
+func testPurge(t *testing.T, f fixture) {
+	book := f.book()
+	bookCopy := f.copy(book.ID)
+	user := f.user()
+	lending := f.lending(bookCopy, user.ID)
+	fine := f.fine(user.ID, lending.ID)
+	hold := f.hold(book.ID, f.user().ID)
+	kept := f.book()
+
+	// Deleted lendings are purged once the cutoff has passed; fines stay with the user.
+	require.NoError(t, f.repo.DeleteLending(ctx, lending.ID, lending.Version, deletedAt))
+	report, err := f.repo.PurgeDeleted(ctx, deletedAt)
+	require.NoError(t, err)
+	assert.Equal(t, domain.PurgeReport{}, report, "nothing was deleted before the cutoff")
+	report, err = f.repo.PurgeDeleted(ctx, deletedAt.Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, domain.PurgeReport{Lendings: 1}, report)
+	_, err = f.repo.RestoreLending(ctx, lending.ID)
+	assert.ErrorIs(t, err, repository.ErrLendingNotFound, "purged lending")
+	got, err := f.repo.GetFineByID(ctx, fine.ID)
+	require.NoError(t, err, "fine for a purged lending")
+	assert.Empty(t, got.LendingID)
+
+	// Purging a book purges its copies, lendings and holds.
+	lending = f.lending(bookCopy, user.ID)
+	require.NoError(t, f.repo.DeleteBook(ctx, book.ID, book.Version, deletedAt, true))
+	report, err = f.repo.PurgeDeleted(ctx, deletedAt.Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, domain.PurgeReport{Books: 1}, report)
+	_, err = f.repo.RestoreBook(ctx, book.ID)
+	assert.ErrorIs(t, err, repository.ErrBookNotFound, "purged book")
+	_, err = f.repo.GetBookCopyByID(ctx, bookCopy.ID)
+	assert.ErrorIs(t, err, repository.ErrBookCopyNotFound, "copy of a purged book")
+	_, err = f.repo.GetLendingByID(ctx, lending.ID)
+	assert.ErrorIs(t, err, repository.ErrLendingNotFound, "lending of a purged book")
+	_, err = f.repo.GetHoldByID(ctx, hold.ID)
+	assert.ErrorIs(t, err, repository.ErrHoldNotFound, "hold on a purged book")
+	_, err = f.repo.GetBookByID(ctx, kept.ID)
+	assert.NoError(t, err, "book that was not deleted")
+
+	// Deleting a copy keeps its lendings without it.
+	bookCopy = f.copy(kept.ID)
+	lending = f.lending(bookCopy, user.ID)
+	lending.ReturnDate = lendDate.AddDate(0, 0, 1)
+	_, err = f.repo.UpdateLending(ctx, lending)
+	require.NoError(t, err)
+	require.NoError(t, f.repo.DeleteBookCopy(ctx, bookCopy.ID))
+	history, err := f.repo.GetLendingByID(ctx, lending.ID)
+	require.NoError(t, err, "lending of a deleted copy")
+	assert.Empty(t, history.CopyID, "copy of a lending whose copy was deleted")
+
+	// Purging a user purges their lendings, fines and holds.
+	bookCopy = f.copy(kept.ID)
+	lending = f.lending(bookCopy, user.ID)
+	lending.ReturnDate = lendDate.AddDate(0, 0, 1)
+	_, err = f.repo.UpdateLending(ctx, lending)
+	require.NoError(t, err)
+	hold = f.hold(kept.ID, user.ID)
+	require.NoError(t, f.repo.DeleteUser(ctx, user.ID, user.Version, deletedAt, false))
+	report, err = f.repo.PurgeDeleted(ctx, deletedAt.Add(time.Second))
+	require.NoError(t, err)
+	assert.Equal(t, domain.PurgeReport{Users: 1}, report)
+	page, err := f.repo.GetLendings(ctx, repository.LendingFilter{UserID: user.ID, IncludeDeleted: true}, repository.ListOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, page.Items, "lendings of a purged user")
+	_, err = f.repo.GetFineByID(ctx, fine.ID)
+	assert.ErrorIs(t, err, repository.ErrFineNotFound, "fine of a purged user")
+	_, err = f.repo.GetHoldByID(ctx, hold.ID)
+	assert.ErrorIs(t, err, repository.ErrHoldNotFound, "hold of a purged user")
+}
+
+// attempts is how many goroutines race for the same row.
+const attempts = 10
+
+// race runs attempt concurrently and counts the attempts that succeeded. It
+// fails the test for errors other than expected.
+func race(t *testing.T, expected error, attempt func(i int) error) int {
+	var wg sync.WaitGroup
+	errs := make(chan error, attempts)
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- attempt(i)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		if err == nil {
+			succeeded++
+		} else {
+			assert.ErrorIs(t, err, expected)
+		}
+	}
+	return succeeded
+}
+
+func testConcurrentLendings(t *testing.T, f fixture) {
+	book := f.book()
+	bookCopy := f.copy(book.ID)
+	users := make([]domain.User, attempts)
+	for i := range users {
+		users[i] = f.user()
+	}
+
+	succeeded := race(t, repository.ErrCopyUnavailable, func(i int) error {
+		_, err := f.repo.CreateLending(ctx, domain.Lending{ID: uuid.NewString(), BookID: book.ID, CopyID: bookCopy.ID, UserID: users[i].ID, LendDate: lendDate})
+		return err
+	})
+	assert.Equal(t, 1, succeeded)
+}
+
+func testConcurrentHolds(t *testing.T, f fixture) {
+	book := f.book()
+	user := f.user()
+
+	succeeded := race(t, repository.ErrHoldExists, func(int) error {
+		_, err := f.repo.CreateHold(ctx, domain.Hold{ID: uuid.NewString(), BookID: book.ID, UserID: user.ID, Status: domain.HoldStatusWaiting, CreatedAt: lendDate})
+		return err
+	})
+	assert.Equal(t, 1, succeeded)
+}
+
+// testConcurrentLoanLimit lets a user have one lending at a time, checked
+// after LockUser in the same transaction as the new lending.
+func testConcurrentLoanLimit(t *testing.T, f fixture) {
+	book := f.book()
+	for i := 0; i < attempts; i++ {
+		f.copy(book.ID)
+	}
+	user := f.user()
+	limitReached := errors.New("limit reached")
+
+	succeeded := race(t, limitReached, func(int) error {
+		return f.repo.WithTx(ctx, func(tx repository.Repository) error {
+			if _, err := tx.LockUser(ctx, user.ID); err != nil {
+				return err
+			}
+			active, err := tx.CountActiveLendings(ctx, user.ID)
+			if err != nil {
+				return err
+			}
+			if active >= 1 {
+				return limitReached
+			}
+			_, err = tx.CreateLending(ctx, domain.Lending{ID: uuid.NewString(), BookID: book.ID, UserID: user.ID, LendDate: lendDate})
+			return err
+		})
+	})
+	assert.Equal(t, 1, succeeded)
+}
+
+func testTransactions(t *testing.T, f fixture) {
+	book := f.book()
+	bookCopy := f.copy(book.ID)
+	user := f.user()
+	failure := errors.New("failure")
+
+	// A failed transaction leaves nothing behind, not even the copy status.
+	var lending domain.Lending
+	err := f.repo.WithTx(ctx, func(tx repository.Repository) error {
+		var err error
+		lending, err = tx.CreateLending(ctx, domain.Lending{ID: uuid.NewString(), BookID: book.ID, UserID: user.ID, LendDate: lendDate})
+		if err != nil {
+			return err
+		}
+		if _, err := tx.CreateFine(ctx, domain.Fine{ID: uuid.NewString(), UserID: user.ID, LendingID: lending.ID,
+			Reason: domain.FineReasonLost, Amount: 2500, Status: domain.FineStatusUnpaid, CreatedAt: lendDate}); err != nil {
+			return err
+		}
+		return failure
+	})
+	assert.ErrorIs(t, err, failure)
+	_, err = f.repo.GetLendingByID(ctx, lending.ID)
+	assert.ErrorIs(t, err, repository.ErrLendingNotFound, "lending of a rolled back transaction")
+	fines, err := f.repo.GetFinesByUserID(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, fines, "fine of a rolled back transaction")
+	assert.Equal(t, domain.CopyStatusAvailable, f.copyStatus(bookCopy.ID))
+
+	err = f.repo.WithTx(ctx, func(tx repository.Repository) error {
+		var err error
+		lending, err = tx.CreateLending(ctx, domain.Lending{ID: uuid.NewString(), BookID: book.ID, UserID: user.ID, LendDate: lendDate})
+		return err
+	})
+	require.NoError(t, err)
+	_, err = f.repo.GetLendingByID(ctx, lending.ID)
+	assert.NoError(t, err, "lending of a committed transaction")
+	assert.Equal(t, domain.CopyStatusOnLoan, f.copyStatus(bookCopy.ID))
+}
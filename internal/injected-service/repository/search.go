@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"fmt"
+	"html"
+	"libary-service/internal/domain"
+	"strings"
+	"unicode"
+)
+
+// Matches in the title weigh more than matches in the authors, like the A and
+// B weights of the Postgres search column.
+const (
+	TitleWeight  = 1.0
+	AuthorWeight = 0.4
+)
+
+// SearchTerms splits text into lower-cased words.
+func SearchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool { return !isTermRune(r) })
+}
+
+// TermWeights returns the summed weight of every term of a book.
+func TermWeights(book domain.Book) map[string]float64 {
+	weights := make(map[string]float64)
+	for _, term := range SearchTerms(book.Title) {
+		weights[term] += TitleWeight
+	}
+	for _, term := range SearchTerms(AuthorList(book)) {
+		weights[term] += AuthorWeight
+	}
+	return weights
+}
+
+// AuthorList joins every author of a book the way the search shows them. A
+// book stored without its list of authors only has its author.
+func AuthorList(book domain.Book) string {
+	if len(book.Authors) == 0 {
+		return book.Author
+	}
+	return strings.Join(book.Authors, ", ")
+}
+
+// SearchHit ranks a book for the query terms and highlights them. It reports
+// false when the book does not contain every term.
+func SearchHit(book domain.Book, terms []string, weights map[string]float64) (domain.BookSearchHit, bool) {
+	if len(terms) == 0 {
+		return domain.BookSearchHit{}, false
+	}
+	var rank float64
+	for _, term := range terms {
+		weight, ok := weights[term]
+		if !ok {
+			return domain.BookSearchHit{}, false
+		}
+		rank += weight
+	}
+	return domain.BookSearchHit{
+		Book:            book,
+		Rank:            rank,
+		TitleHighlight:  Highlight(book.Title, terms),
+		AuthorHighlight: Highlight(AuthorList(book), terms),
+	}, true
+}
+
+// PaginateSearchHits orders search hits best match first and cuts out one page.
+// Search results are always ranked, so their cursors carry the rank.
+func PaginateSearchHits(hits []domain.BookSearchHit, options ListOptions) (Page[domain.BookSearchHit], error) {
+	if options.Sort != "" {
+		return Page[domain.BookSearchHit]{}, ErrInvalidSort
+	}
+	options.Sort = "-rank"
+	return Paginate(hits, options, "rank", []string{"rank"},
+		func(hit domain.BookSearchHit, _ string) (string, string) {
+			// Zero padding makes the string order of ranks match their numeric order.
+			return fmt.Sprintf("%016.6f", hit.Rank), hit.ID
+		})
+}
+
+// Highlight wraps every word of text that is one of the terms in <b></b>. The
+// rest of text is HTML escaped, so the tags are its only markup.
+func Highlight(text string, terms []string) string {
+	var b strings.Builder
+	runes := []rune(text)
+	for start := 0; start < len(runes); {
+		if !isTermRune(runes[start]) {
+			b.WriteString(html.EscapeString(string(runes[start])))
+			start++
+			continue
+		}
+		end := start
+		for end < len(runes) && isTermRune(runes[end]) {
+			end++
+		}
+		word := string(runes[start:end])
+		if contains(terms, strings.ToLower(word)) {
+			b.WriteString("<b>" + html.EscapeString(word) + "</b>")
+		} else {
+			b.WriteString(html.EscapeString(word))
+		}
+		start = end
+	}
+	return b.String()
+}
+
+func isTermRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,19 @@
+package sqliterepository
+
+import (
+	_ "modernc.org/sqlite"
+)
+
+// driverName is the database/sql driver the repository runs on. modernc.org/sqlite
+// is written in Go, so the service builds without cgo. The rest of the package
+// only uses database/sql and plain SQLite, so swapping the driver only touches
+// this file.
+const driverName = "sqlite"
+
+// dataSourceName opens the database file at path with foreign keys enforced,
+// write-ahead logging so that readers do not block the writer, and
+// transactions that take the write lock up front, which serializes them the
+// way row locks do in Postgres. Writers wait up to five seconds for the lock.
+func dataSourceName(path string) string {
+	return "file:" + path + "?_pragma=busy_timeout(5000)&_pragma=foreign_keys(1)&_pragma=journal_mode(WAL)&_txlock=immediate"
+}
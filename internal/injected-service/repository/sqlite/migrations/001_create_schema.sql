@@ -0,0 +1,93 @@
+-- The schema of migrations/001 to 010 in SQLite. Tables, columns, constraints
+-- and indexes keep their Postgres names so that both backends stay comparable.
+-- IDs are TEXT and timestamps are TEXT in repository.SortableTime format, whose
+-- string order matches time order. Full-text search is ranked in Go, so the
+-- search column and its GIN index have no counterpart here.
+
+CREATE TABLE books (
+    id TEXT PRIMARY KEY,
+    title TEXT NOT NULL,
+    author TEXT NOT NULL
+);
+
+CREATE TABLE users (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    email TEXT NOT NULL CONSTRAINT users_email_key UNIQUE,
+    category TEXT NOT NULL DEFAULT 'student'
+        CONSTRAINT users_category_check CHECK (category IN ('student', 'staff', 'guest'))
+);
+
+CREATE TABLE book_copies (
+    id TEXT PRIMARY KEY,
+    book_id TEXT NOT NULL CONSTRAINT book_copies_book_id_fkey REFERENCES books(id) ON DELETE CASCADE,
+    barcode TEXT NOT NULL CONSTRAINT book_copies_barcode_key UNIQUE,
+    condition TEXT NOT NULL DEFAULT 'good'
+        CONSTRAINT book_copies_condition_check CHECK (condition IN ('new', 'good', 'fair', 'poor', 'damaged')),
+    status TEXT NOT NULL DEFAULT 'available'
+        CONSTRAINT book_copies_status_check
+        CHECK (status IN ('available', 'on_loan', 'on_hold', 'in_repair', 'lost', 'withdrawn'))
+);
+
+CREATE INDEX book_copies_book_id_idx ON book_copies (book_id);
+
+CREATE TABLE lendings (
+    id TEXT PRIMARY KEY,
+    book_id TEXT NOT NULL CONSTRAINT lendings_book_id_fkey REFERENCES books(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL CONSTRAINT lendings_user_id_fkey REFERENCES users(id) ON DELETE CASCADE,
+    lend_date TEXT NOT NULL,
+    return_date TEXT,
+    copy_id TEXT CONSTRAINT lendings_copy_id_fkey REFERENCES book_copies(id) ON DELETE CASCADE,
+    renewal_count INTEGER NOT NULL DEFAULT 0,
+    due_date TEXT
+);
+
+-- A copy can only be part of one lending that has not been returned yet.
+CREATE UNIQUE INDEX lendings_active_copy_idx ON lendings (copy_id) WHERE return_date IS NULL;
+
+-- Serves the overdue report: only lendings that are still out are indexed.
+CREATE INDEX lendings_overdue_idx ON lendings (due_date) WHERE return_date IS NULL;
+
+-- Serves the loan limit check: only lendings that are still out are indexed.
+CREATE INDEX lendings_active_user_idx ON lendings (user_id) WHERE return_date IS NULL;
+
+CREATE TABLE fines (
+    id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL CONSTRAINT fines_user_id_fkey REFERENCES users(id) ON DELETE CASCADE,
+    lending_id TEXT CONSTRAINT fines_lending_id_fkey REFERENCES lendings(id) ON DELETE SET NULL,
+    reason TEXT NOT NULL CONSTRAINT fines_reason_check CHECK (reason IN ('overdue', 'lost')),
+    -- Minor currency units, never floats.
+    amount INTEGER NOT NULL CONSTRAINT fines_amount_check CHECK (amount >= 0),
+    status TEXT NOT NULL DEFAULT 'unpaid' CONSTRAINT fines_status_check CHECK (status IN ('unpaid', 'paid', 'waived')),
+    created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%f000000Z', 'now')),
+    settled_at TEXT
+);
+
+CREATE INDEX fines_user_id_idx ON fines (user_id);
+
+CREATE TABLE holds (
+    id TEXT PRIMARY KEY,
+    book_id TEXT NOT NULL CONSTRAINT holds_book_id_fkey REFERENCES books(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL CONSTRAINT holds_user_id_fkey REFERENCES users(id) ON DELETE CASCADE,
+    copy_id TEXT CONSTRAINT holds_copy_id_fkey REFERENCES book_copies(id) ON DELETE SET NULL,
+    status TEXT NOT NULL DEFAULT 'waiting'
+        CONSTRAINT holds_status_check CHECK (status IN ('waiting', 'ready', 'fulfilled', 'cancelled', 'expired')),
+    created_at TEXT NOT NULL DEFAULT (strftime('%Y-%m-%dT%H:%M:%f000000Z', 'now')),
+    ready_at TEXT,
+    expires_at TEXT
+);
+
+-- The FIFO queue of a book: only holds that still wait or are ready are indexed.
+CREATE INDEX holds_queue_idx ON holds (book_id, created_at) WHERE status IN ('waiting', 'ready');
+
+-- A patron can only queue once per book.
+CREATE UNIQUE INDEX holds_active_user_idx ON holds (book_id, user_id) WHERE status IN ('waiting', 'ready');
+
+-- Keyset pagination orders every list by (sort column, id).
+CREATE INDEX books_title_id_idx ON books (title, id);
+CREATE INDEX books_author_id_idx ON books (author, id);
+CREATE INDEX users_name_id_idx ON users (name, id);
+CREATE INDEX lendings_lend_date_id_idx ON lendings (lend_date, id);
+
+-- Serves the book_id filter of lendings.
+CREATE INDEX lendings_book_id_idx ON lendings (book_id);
@@ -0,0 +1,18 @@
+-- migrations/011: deleting a book, user or lending only sets deleted_at.
+
+ALTER TABLE books ADD COLUMN deleted_at TEXT;
+ALTER TABLE users ADD COLUMN deleted_at TEXT;
+ALTER TABLE lendings ADD COLUMN deleted_at TEXT;
+
+-- Deleted lendings no longer hold their copy or count as active.
+DROP INDEX lendings_active_copy_idx;
+CREATE UNIQUE INDEX lendings_active_copy_idx ON lendings (copy_id) WHERE return_date IS NULL AND deleted_at IS NULL;
+DROP INDEX lendings_overdue_idx;
+CREATE INDEX lendings_overdue_idx ON lendings (due_date) WHERE return_date IS NULL AND deleted_at IS NULL;
+DROP INDEX lendings_active_user_idx;
+CREATE INDEX lendings_active_user_idx ON lendings (user_id) WHERE return_date IS NULL AND deleted_at IS NULL;
+
+-- Serves the purge.
+CREATE INDEX books_deleted_at_idx ON books (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX lendings_deleted_at_idx ON lendings (deleted_at) WHERE deleted_at IS NOT NULL;
@@ -0,0 +1,5 @@
+-- migrations/012: every update of a book, user or lending bumps its version.
+
+ALTER TABLE books ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE lendings ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
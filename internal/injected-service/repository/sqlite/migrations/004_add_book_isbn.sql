@@ -0,0 +1,4 @@
+-- migrations/013: books are identified by their ISBN-13, which is unique.
+
+ALTER TABLE books ADD COLUMN isbn TEXT;
+CREATE UNIQUE INDEX books_isbn_key ON books (isbn);
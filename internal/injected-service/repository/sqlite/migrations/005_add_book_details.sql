@@ -0,0 +1,23 @@
+-- migrations/014: the catalogue details of a book and all of its authors.
+-- Subjects are a JSON array of strings instead of a Postgres TEXT[].
+
+ALTER TABLE books ADD COLUMN publisher TEXT NOT NULL DEFAULT '';
+ALTER TABLE books ADD COLUMN publication_year INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE books ADD COLUMN edition TEXT NOT NULL DEFAULT '';
+ALTER TABLE books ADD COLUMN language TEXT NOT NULL DEFAULT '';
+ALTER TABLE books ADD COLUMN page_count INTEGER NOT NULL DEFAULT 0
+    CONSTRAINT books_page_count_check CHECK (page_count >= 0);
+ALTER TABLE books ADD COLUMN subjects TEXT NOT NULL DEFAULT '[]';
+ALTER TABLE books ADD COLUMN description TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE book_authors (
+    book_id TEXT NOT NULL CONSTRAINT book_authors_book_id_fkey REFERENCES books(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    CONSTRAINT book_authors_pkey PRIMARY KEY (book_id, position)
+);
+
+CREATE INDEX book_authors_name_idx ON book_authors (name);
+
+INSERT INTO book_authors (book_id, position, name)
+SELECT id, 0, author FROM books;
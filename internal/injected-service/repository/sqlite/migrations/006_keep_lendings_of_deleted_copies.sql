@@ -0,0 +1,28 @@
+-- migrations/015: deleting a copy keeps its lendings, they just lose the copy.
+-- SQLite cannot alter a foreign key, so the table is rebuilt with its indexes.
+
+CREATE TABLE lendings_new (
+    id TEXT PRIMARY KEY,
+    book_id TEXT NOT NULL CONSTRAINT lendings_book_id_fkey REFERENCES books(id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL CONSTRAINT lendings_user_id_fkey REFERENCES users(id) ON DELETE CASCADE,
+    lend_date TEXT NOT NULL,
+    return_date TEXT,
+    copy_id TEXT CONSTRAINT lendings_copy_id_fkey REFERENCES book_copies(id) ON DELETE SET NULL,
+    renewal_count INTEGER NOT NULL DEFAULT 0,
+    due_date TEXT,
+    deleted_at TEXT,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+INSERT INTO lendings_new (id, book_id, user_id, lend_date, return_date, copy_id, renewal_count, due_date, deleted_at, version)
+SELECT id, book_id, user_id, lend_date, return_date, copy_id, renewal_count, due_date, deleted_at, version FROM lendings;
+
+DROP TABLE lendings;
+ALTER TABLE lendings_new RENAME TO lendings;
+
+CREATE UNIQUE INDEX lendings_active_copy_idx ON lendings (copy_id) WHERE return_date IS NULL AND deleted_at IS NULL;
+CREATE INDEX lendings_overdue_idx ON lendings (due_date) WHERE return_date IS NULL AND deleted_at IS NULL;
+CREATE INDEX lendings_active_user_idx ON lendings (user_id) WHERE return_date IS NULL AND deleted_at IS NULL;
+CREATE INDEX lendings_deleted_at_idx ON lendings (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX lendings_lend_date_id_idx ON lendings (lend_date, id);
+CREATE INDEX lendings_book_id_idx ON lendings (book_id);
@@ -0,0 +1,24 @@
+-- migrations/016: email addresses are stored trimmed and lowercased, and an
+-- address is taken however it was typed. SQLite cannot drop the UNIQUE column
+-- constraint, so the table is rebuilt with its indexes. Users that only
+-- differ in the case of their address make the new index fail.
+
+CREATE TABLE users_new (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    email TEXT NOT NULL,
+    category TEXT NOT NULL DEFAULT 'student'
+        CONSTRAINT users_category_check CHECK (category IN ('student', 'staff', 'guest')),
+    deleted_at TEXT,
+    version INTEGER NOT NULL DEFAULT 1
+);
+
+INSERT INTO users_new (id, name, email, category, deleted_at, version)
+SELECT id, name, lower(trim(email)), category, deleted_at, version FROM users;
+
+DROP TABLE users;
+ALTER TABLE users_new RENAME TO users;
+
+CREATE UNIQUE INDEX users_email_key ON users (lower(email));
+CREATE INDEX users_name_id_idx ON users (name, id);
+CREATE INDEX users_deleted_at_idx ON users (deleted_at) WHERE deleted_at IS NOT NULL;
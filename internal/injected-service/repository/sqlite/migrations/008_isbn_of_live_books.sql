@@ -0,0 +1,5 @@
+-- migrations/018: deleted books give their ISBN free, restoring one fails
+-- while another book has its ISBN.
+
+DROP INDEX books_isbn_key;
+CREATE UNIQUE INDEX books_isbn_key ON books (isbn) WHERE deleted_at IS NULL;
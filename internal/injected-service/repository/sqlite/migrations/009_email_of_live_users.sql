@@ -0,0 +1,5 @@
+-- migrations/019: deleted users give their email address free, restoring one
+-- fails while another user has the address.
+
+DROP INDEX users_email_key;
+CREATE UNIQUE INDEX users_email_key ON users (lower(email)) WHERE deleted_at IS NULL;
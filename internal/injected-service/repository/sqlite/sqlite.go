@@ -0,0 +1,1203 @@
+package sqliterepository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"libary-service/internal/domain"
+	"libary-service/internal/injected-service/repository"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// SQLiteRepository stores everything in a single SQLite file, for
+// installations that do not run a Postgres server.
+type SQLiteRepository struct {
+	pool *sql.DB
+	db   querier
+	// tx is set on repositories bound to a transaction. Nested transactions
+	// are savepoints in it, numbered by their depth.
+	tx    *sql.Tx
+	depth int
+}
+
+// querier is the part of a database or a transaction the queries run on.
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// scanner is a single row or the current row of a result set.
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func New() *SQLiteRepository {
+	return &SQLiteRepository{}
+}
+
+// Connect opens the database file named by SQLITE_PATH, library.db by
+// default, and migrates it to the current schema.
+func (repo *SQLiteRepository) Connect(ctx context.Context) error {
+	path := os.Getenv("SQLITE_PATH")
+	if path == "" {
+		path = "library.db"
+	}
+	pool, err := sql.Open(driverName, dataSourceName(path))
+	if err != nil {
+		return err
+	}
+	if err := pool.PingContext(ctx); err != nil {
+		pool.Close()
+		return err
+	}
+	if err := migrate(ctx, pool); err != nil {
+		pool.Close()
+		return err
+	}
+	repo.pool = pool
+	repo.db = pool
+	log.Printf("Successfully connected to database")
+	return nil
+}
+
+func (repo *SQLiteRepository) Disconnect(ctx context.Context) error {
+	if repo.pool == nil {
+		return nil
+	}
+	if err := repo.pool.Close(); err != nil {
+		return err
+	}
+	log.Printf("Successfully disconnected from database")
+	return nil
+}
+
+// WithTx runs fn on a repository bound to a new transaction, or to a savepoint
+// when repo is already bound to one. Like a sql.Tx, the repository passed to
+// fn must not be used concurrently.
+func (repo *SQLiteRepository) WithTx(ctx context.Context, fn func(tx repository.Repository) error) error {
+	return repo.transaction(ctx, func(tx *SQLiteRepository) error {
+		return fn(tx)
+	})
+}
+
+func (repo *SQLiteRepository) transaction(ctx context.Context, fn func(tx *SQLiteRepository) error) error {
+	if repo.tx != nil {
+		return repo.savepoint(ctx, fn)
+	}
+	tx, err := repo.pool.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(&SQLiteRepository{pool: repo.pool, db: tx, tx: tx}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (repo *SQLiteRepository) savepoint(ctx context.Context, fn func(tx *SQLiteRepository) error) error {
+	name := fmt.Sprintf("sp%d", repo.depth+1)
+	if _, err := repo.tx.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return err
+	}
+	if err := fn(&SQLiteRepository{pool: repo.pool, db: repo.tx, tx: repo.tx, depth: repo.depth + 1}); err != nil {
+		// Rolling back to a savepoint keeps it open, so it is released as well.
+		ctx := context.WithoutCancel(ctx)
+		repo.tx.ExecContext(ctx, "ROLLBACK TO "+name)
+		repo.tx.ExecContext(ctx, "RELEASE "+name)
+		return err
+	}
+	_, err := repo.tx.ExecContext(ctx, "RELEASE "+name)
+	return err
+}
+
+// bookColumns selects a book together with its authors from book_authors as
+// a JSON array.
+const bookColumns = "id, title, author, " +
+	"(SELECT json_group_array(name ORDER BY position) FROM book_authors WHERE book_authors.book_id = books.id), " +
+	"isbn, publisher, publication_year, edition, language, page_count, subjects, description, deleted_at, version"
+
+// scanBook reads a row selected with bookColumns.
+func scanBook(row scanner) (domain.Book, error) {
+	var b domain.Book
+	var isbn sql.NullString
+	err := row.Scan(&b.ID, &b.Title, &b.Author, listColumn{&b.Authors}, &isbn, &b.Publisher, &b.PublicationYear,
+		&b.Edition, &b.Language, &b.PageCount, listColumn{&b.Subjects}, &b.Description, nullTimeColumn{&b.DeletedAt}, &b.Version)
+	b.ISBN = isbn.String
+	return b, err
+}
+
+func (repo *SQLiteRepository) GetBooks(ctx context.Context, filter repository.BookFilter, options repository.ListOptions) (repository.Page[domain.Book], error) {
+	var q listQuery
+	if !filter.IncludeDeleted {
+		q.where("deleted_at IS NULL")
+	}
+	if filter.Author != "" {
+		q.where("(author = ?%[1]d OR id IN (SELECT book_id FROM book_authors WHERE name = ?%[1]d))", filter.Author)
+	}
+	if filter.TitlePrefix != "" {
+		// LIKE ignores the case of ASCII letters in SQLite, a prefix comparison does not.
+		q.where("substr(title, 1, length(?%[1]d)) = ?%[1]d", filter.TitlePrefix)
+	}
+	if filter.ISBN != "" {
+		q.where("isbn = ?%d", filter.ISBN)
+	}
+	return listPage(ctx, repo, "books", bookColumns, q, options,
+		sortColumns{"id": false, "title": false, "author": false}, "title", scanBook,
+		func(b domain.Book, field string) (string, string) {
+			switch field {
+			case "title":
+				return b.Title, b.ID
+			case "author":
+				return b.Author, b.ID
+			}
+			return b.ID, b.ID
+		})
+}
+
+// SearchBooks ranks the catalogue in Go with the weights of the in-memory
+// index, which is fast enough for the small catalogues SQLite is meant for.
+func (repo *SQLiteRepository) SearchBooks(ctx context.Context, query string, options repository.ListOptions) (repository.Page[domain.BookSearchHit], error) {
+	if options.Sort != "" {
+		return repository.Page[domain.BookSearchHit]{}, repository.ErrInvalidSort
+	}
+	rows, err := repo.db.QueryContext(ctx, "SELECT "+bookColumns+" FROM books WHERE deleted_at IS NULL")
+	if err != nil {
+		return repository.Page[domain.BookSearchHit]{}, err
+	}
+	defer rows.Close()
+
+	terms := repository.SearchTerms(query)
+	hits := make([]domain.BookSearchHit, 0)
+	for rows.Next() {
+		book, err := scanBook(rows)
+		if err != nil {
+			return repository.Page[domain.BookSearchHit]{}, err
+		}
+		if hit, ok := repository.SearchHit(book, terms, repository.TermWeights(book)); ok {
+			hits = append(hits, hit)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return repository.Page[domain.BookSearchHit]{}, err
+	}
+	return repository.PaginateSearchHits(hits, options)
+}
+
+func (repo *SQLiteRepository) GetBookByID(ctx context.Context, id string) (domain.Book, error) {
+	b, err := scanBook(repo.db.QueryRowContext(ctx, "SELECT "+bookColumns+" FROM books WHERE id = ?1 AND deleted_at IS NULL", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Book{}, repository.ErrBookNotFound
+	} else if err != nil {
+		return domain.Book{}, err
+	}
+	return b, nil
+}
+
+func (repo *SQLiteRepository) CreateBook(ctx context.Context, book domain.Book) (domain.Book, error) {
+	book.DeletedAt = nil
+	book.Version = 1
+	err := repo.transaction(ctx, func(tx *SQLiteRepository) error {
+		_, err := tx.db.ExecContext(ctx,
+			"INSERT INTO books (id, title, author, isbn, publisher, publication_year, edition, language, page_count, subjects, description, version)"+
+				" VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10, ?11, ?12)",
+			book.ID, book.Title, book.Author, nullableID(book.ISBN), book.Publisher, book.PublicationYear,
+			book.Edition, book.Language, book.PageCount, listValue(book.Subjects), book.Description, book.Version)
+		if err != nil {
+			return tx.mapConstraintViolation(ctx, err, references{})
+		}
+		return tx.writeAuthors(ctx, book)
+	})
+	if err != nil {
+		return domain.Book{}, err
+	}
+	return book, nil
+}
+
+func (repo *SQLiteRepository) UpdateBook(ctx context.Context, book domain.Book) (domain.Book, error) {
+	err := repo.transaction(ctx, func(tx *SQLiteRepository) error {
+		result, err := tx.db.ExecContext(ctx,
+			"UPDATE books SET title = ?2, author = ?3, isbn = ?4, publisher = ?5, publication_year = ?6, edition = ?7,"+
+				" language = ?8, page_count = ?9, subjects = ?10, description = ?11, version = version + 1"+
+				" WHERE id = ?1 AND deleted_at IS NULL AND version = ?12",
+			book.ID, book.Title, book.Author, nullableID(book.ISBN), book.Publisher, book.PublicationYear,
+			book.Edition, book.Language, book.PageCount, listValue(book.Subjects), book.Description, book.Version)
+		if err != nil {
+			return tx.mapConstraintViolation(ctx, err, references{})
+		}
+		if err := tx.expectVersion(ctx, result, "books", book.ID, repository.ErrBookNotFound); err != nil {
+			return err
+		}
+		if _, err := tx.db.ExecContext(ctx, "DELETE FROM book_authors WHERE book_id = ?1", book.ID); err != nil {
+			return err
+		}
+		return tx.writeAuthors(ctx, book)
+	})
+	if err != nil {
+		return domain.Book{}, err
+	}
+	book.DeletedAt = nil
+	book.Version++
+	return book, nil
+}
+
+// writeAuthors stores the authors of a book in their order.
+func (repo *SQLiteRepository) writeAuthors(ctx context.Context, book domain.Book) error {
+	for position, name := range book.Authors {
+		_, err := repo.db.ExecContext(ctx,
+			"INSERT INTO book_authors (book_id, position, name) VALUES (?1, ?2, ?3)", book.ID, position, name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (repo *SQLiteRepository) DeleteBook(ctx context.Context, id string, version int, deletedAt time.Time, force bool) error {
+	return repo.transaction(ctx, func(tx *SQLiteRepository) error {
+		result, err := tx.db.ExecContext(ctx,
+			"UPDATE books SET deleted_at = ?2 WHERE id = ?1 AND deleted_at IS NULL AND version = ?3",
+			id, storedTime(deletedAt), version)
+		if err != nil {
+			return err
+		}
+		if err := tx.expectVersion(ctx, result, "books", id, repository.ErrBookNotFound); err != nil {
+			return err
+		}
+		if !force {
+			if err := tx.checkNoActiveLendings(ctx, "book", id); err != nil {
+				return err
+			}
+		}
+		return tx.cancelHolds(ctx, "book_id", id)
+	})
+}
+
+func (repo *SQLiteRepository) RestoreBook(ctx context.Context, id string) (domain.Book, error) {
+	b, err := scanBook(repo.db.QueryRowContext(ctx,
+		"UPDATE books SET deleted_at = NULL WHERE id = ?1 RETURNING "+bookColumns, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Book{}, repository.ErrBookNotFound
+	} else if err != nil {
+		return domain.Book{}, repo.mapConstraintViolation(ctx, err, references{})
+	}
+	return b, nil
+}
+
+const copyColumns = "id, book_id, barcode, condition, status"
+
+// scanBookCopy reads a row selected with copyColumns.
+func scanBookCopy(row scanner) (domain.BookCopy, error) {
+	var c domain.BookCopy
+	err := row.Scan(&c.ID, &c.BookID, &c.Barcode, &c.Condition, &c.Status)
+	return c, err
+}
+
+func (repo *SQLiteRepository) GetBookCopies(ctx context.Context, bookID string) ([]domain.BookCopy, error) {
+	return queryAll(ctx, repo.db, scanBookCopy,
+		"SELECT "+copyColumns+" FROM book_copies WHERE book_id = ?1", bookID)
+}
+
+func (repo *SQLiteRepository) GetBookCopyByID(ctx context.Context, id string) (domain.BookCopy, error) {
+	c, err := scanBookCopy(repo.db.QueryRowContext(ctx, "SELECT "+copyColumns+" FROM book_copies WHERE id = ?1", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.BookCopy{}, repository.ErrBookCopyNotFound
+	} else if err != nil {
+		return domain.BookCopy{}, err
+	}
+	return c, nil
+}
+
+func (repo *SQLiteRepository) CreateBookCopy(ctx context.Context, bookCopy domain.BookCopy) (domain.BookCopy, error) {
+	_, err := repo.db.ExecContext(ctx,
+		"INSERT INTO book_copies ("+copyColumns+") VALUES (?1, ?2, ?3, ?4, ?5)",
+		bookCopy.ID, bookCopy.BookID, bookCopy.Barcode, bookCopy.Condition, bookCopy.Status)
+	if err != nil {
+		return domain.BookCopy{}, repo.mapConstraintViolation(ctx, err, references{bookID: bookCopy.BookID})
+	}
+	return bookCopy, nil
+}
+
+func (repo *SQLiteRepository) UpdateBookCopy(ctx context.Context, bookCopy domain.BookCopy) (domain.BookCopy, error) {
+	result, err := repo.db.ExecContext(ctx,
+		"UPDATE book_copies SET book_id = ?2, barcode = ?3, condition = ?4, status = ?5 WHERE id = ?1",
+		bookCopy.ID, bookCopy.BookID, bookCopy.Barcode, bookCopy.Condition, bookCopy.Status)
+	if err != nil {
+		return domain.BookCopy{}, repo.mapConstraintViolation(ctx, err, references{bookID: bookCopy.BookID})
+	}
+	if err := expectRow(result, repository.ErrBookCopyNotFound); err != nil {
+		return domain.BookCopy{}, err
+	}
+	return bookCopy, nil
+}
+
+// DeleteBookCopy refuses while the copy is lent out. Its past lendings are
+// kept without a copy. The check comes first because deleting the copy
+// unlinks its lendings.
+func (repo *SQLiteRepository) DeleteBookCopy(ctx context.Context, id string) error {
+	return repo.transaction(ctx, func(tx *SQLiteRepository) error {
+		if err := tx.checkNoActiveLendings(ctx, "copy", id); err != nil {
+			return err
+		}
+		result, err := tx.db.ExecContext(ctx, "DELETE FROM book_copies WHERE id = ?1", id)
+		if err != nil {
+			return err
+		}
+		return expectRow(result, repository.ErrBookCopyNotFound)
+	})
+}
+
+const userColumns = "id, name, email, category, deleted_at, version"
+
+// scanUser reads a row selected with userColumns.
+func scanUser(row scanner) (domain.User, error) {
+	var u domain.User
+	err := row.Scan(&u.ID, &u.Name, &u.Email, &u.Category, nullTimeColumn{&u.DeletedAt}, &u.Version)
+	return u, err
+}
+
+func (repo *SQLiteRepository) GetUsers(ctx context.Context, filter repository.UserFilter, options repository.ListOptions) (repository.Page[domain.User], error) {
+	var q listQuery
+	if !filter.IncludeDeleted {
+		q.where("deleted_at IS NULL")
+	}
+	if filter.Category != "" {
+		q.where("category = ?%d", filter.Category)
+	}
+	if filter.Email != "" {
+		q.where("lower(email) = lower(?%d)", filter.Email)
+	}
+	return listPage(ctx, repo, "users", userColumns, q, options,
+		sortColumns{"id": false, "name": false, "email": false}, "name", scanUser,
+		func(u domain.User, field string) (string, string) {
+			switch field {
+			case "name":
+				return u.Name, u.ID
+			case "email":
+				return u.Email, u.ID
+			}
+			return u.ID, u.ID
+		})
+}
+
+func (repo *SQLiteRepository) GetUserByID(ctx context.Context, id string) (domain.User, error) {
+	u, err := scanUser(repo.db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = ?1 AND deleted_at IS NULL", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.User{}, repository.ErrUserNotFound
+	} else if err != nil {
+		return domain.User{}, err
+	}
+	return u, nil
+}
+
+// LockUser only reads the user: transactions take the write lock when they
+// begin, so they run one at a time anyway.
+func (repo *SQLiteRepository) LockUser(ctx context.Context, id string) (domain.User, error) {
+	return repo.GetUserByID(ctx, id)
+}
+
+func (repo *SQLiteRepository) CreateUser(ctx context.Context, user domain.User) (domain.User, error) {
+	user.DeletedAt = nil
+	user.Version = 1
+	_, err := repo.db.ExecContext(ctx, "INSERT INTO users (id, name, email, category, version) VALUES (?1, ?2, ?3, ?4, ?5)",
+		user.ID, user.Name, user.Email, user.Category, user.Version)
+	if err != nil {
+		return domain.User{}, repo.mapConstraintViolation(ctx, err, references{})
+	}
+	return user, nil
+}
+
+func (repo *SQLiteRepository) UpdateUser(ctx context.Context, user domain.User) (domain.User, error) {
+	result, err := repo.db.ExecContext(ctx,
+		"UPDATE users SET name = ?2, email = ?3, category = ?4, version = version + 1 WHERE id = ?1 AND deleted_at IS NULL AND version = ?5",
+		user.ID, user.Name, user.Email, user.Category, user.Version)
+	if err != nil {
+		return domain.User{}, repo.mapConstraintViolation(ctx, err, references{})
+	}
+	if err := repo.expectVersion(ctx, result, "users", user.ID, repository.ErrUserNotFound); err != nil {
+		return domain.User{}, err
+	}
+	user.DeletedAt = nil
+	user.Version++
+	return user, nil
+}
+
+func (repo *SQLiteRepository) DeleteUser(ctx context.Context, id string, version int, deletedAt time.Time, force bool) error {
+	return repo.transaction(ctx, func(tx *SQLiteRepository) error {
+		result, err := tx.db.ExecContext(ctx,
+			"UPDATE users SET deleted_at = ?2 WHERE id = ?1 AND deleted_at IS NULL AND version = ?3",
+			id, storedTime(deletedAt), version)
+		if err != nil {
+			return err
+		}
+		if err := tx.expectVersion(ctx, result, "users", id, repository.ErrUserNotFound); err != nil {
+			return err
+		}
+		if !force {
+			if err := tx.checkNoActiveLendings(ctx, "user", id); err != nil {
+				return err
+			}
+		}
+		return tx.cancelHolds(ctx, "user_id", id)
+	})
+}
+
+func (repo *SQLiteRepository) RestoreUser(ctx context.Context, id string) (domain.User, error) {
+	u, err := scanUser(repo.db.QueryRowContext(ctx,
+		"UPDATE users SET deleted_at = NULL WHERE id = ?1 RETURNING "+userColumns, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.User{}, repository.ErrUserNotFound
+	} else if err != nil {
+		return domain.User{}, repo.mapConstraintViolation(ctx, err, references{})
+	}
+	return u, nil
+}
+
+const lendingColumns = "id, book_id, copy_id, user_id, lend_date, due_date, return_date, renewal_count, deleted_at, version"
+
+// scanLending reads a row selected with lendingColumns.
+func scanLending(row scanner) (domain.Lending, error) {
+	var l domain.Lending
+	var copyID sql.NullString
+	err := row.Scan(&l.ID, &l.BookID, &copyID, &l.UserID,
+		timeColumn{&l.LendDate}, timeColumn{&l.DueDate}, timeColumn{&l.ReturnDate}, &l.RenewalCount, nullTimeColumn{&l.DeletedAt}, &l.Version)
+	l.CopyID = copyID.String
+	return l, err
+}
+
+func (repo *SQLiteRepository) GetLendings(ctx context.Context, filter repository.LendingFilter, options repository.ListOptions) (repository.Page[domain.Lending], error) {
+	var q listQuery
+	if !filter.IncludeDeleted {
+		q.where("deleted_at IS NULL")
+	}
+	if filter.UserID != "" {
+		q.where("user_id = ?%d", filter.UserID)
+	}
+	if filter.BookID != "" {
+		q.where("book_id = ?%d", filter.BookID)
+	}
+	if filter.Active != nil && *filter.Active {
+		q.where("return_date IS NULL")
+	} else if filter.Active != nil {
+		q.where("return_date IS NOT NULL")
+	}
+	if filter.Overdue != nil && *filter.Overdue {
+		q.where("return_date IS NULL AND due_date < ?%d", storedTime(filter.Now))
+	} else if filter.Overdue != nil {
+		q.where("(return_date IS NOT NULL OR due_date IS NULL OR due_date >= ?%d)", storedTime(filter.Now))
+	}
+	return listPage(ctx, repo, "lendings", lendingColumns, q, options,
+		sortColumns{"id": false, "lend_date": true}, "lend_date", scanLending,
+		func(l domain.Lending, field string) (string, string) {
+			if field == "lend_date" {
+				return repository.SortableTime(l.LendDate), l.ID
+			}
+			return l.ID, l.ID
+		})
+}
+
+func (repo *SQLiteRepository) GetLendingByID(ctx context.Context, id string) (domain.Lending, error) {
+	l, err := scanLending(repo.db.QueryRowContext(ctx, "SELECT "+lendingColumns+" FROM lendings WHERE id = ?1 AND deleted_at IS NULL", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Lending{}, repository.ErrLendingNotFound
+	} else if err != nil {
+		return domain.Lending{}, err
+	}
+	return l, nil
+}
+
+// CountActiveLendings is served by the partial index lendings_active_user_idx.
+func (repo *SQLiteRepository) CountActiveLendings(ctx context.Context, userID string) (int, error) {
+	var count int
+	err := repo.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM lendings WHERE user_id = ?1 AND return_date IS NULL AND deleted_at IS NULL", userID).Scan(&count)
+	return count, err
+}
+
+// GetOverdueLendings is served by the partial index lendings_overdue_idx.
+func (repo *SQLiteRepository) GetOverdueLendings(ctx context.Context, now time.Time) ([]domain.Lending, error) {
+	return queryAll(ctx, repo.db, scanLending,
+		"SELECT "+lendingColumns+" FROM lendings WHERE return_date IS NULL AND deleted_at IS NULL AND due_date < ?1 ORDER BY due_date", storedTime(now))
+}
+
+func (repo *SQLiteRepository) CreateLending(ctx context.Context, lending domain.Lending) (domain.Lending, error) {
+	err := repo.transaction(ctx, func(tx *SQLiteRepository) error {
+		var holdID string
+		if lending.ReturnDate.IsZero() {
+			var copyID string
+			var err error
+			holdID, copyID, err = tx.readyHold(ctx, lending)
+			if err != nil {
+				return err
+			}
+			if holdID == "" {
+				copyID, err = tx.availableCopy(ctx, lending.BookID, lending.CopyID)
+				if err != nil {
+					return err
+				}
+			}
+			lending.CopyID = copyID
+		}
+		lending.DeletedAt = nil
+		lending.Version = 1
+		_, err := tx.db.ExecContext(ctx,
+			"INSERT INTO lendings (id, book_id, copy_id, user_id, lend_date, due_date, return_date, renewal_count, version) VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9)",
+			lending.ID, lending.BookID, nullableID(lending.CopyID), lending.UserID,
+			storedTime(lending.LendDate), nullableTime(lending.DueDate), nullableTime(lending.ReturnDate), lending.RenewalCount, lending.Version)
+		if err != nil {
+			return tx.mapConstraintViolation(ctx, err,
+				references{bookID: lending.BookID, copyID: lending.CopyID, userID: lending.UserID})
+		}
+		if err := tx.syncCopyStatus(ctx, lending); err != nil {
+			return err
+		}
+		if holdID != "" {
+			_, err := tx.db.ExecContext(ctx, "UPDATE holds SET status = 'fulfilled' WHERE id = ?1", holdID)
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return domain.Lending{}, err
+	}
+	return lending, nil
+}
+
+func (repo *SQLiteRepository) UpdateLending(ctx context.Context, lending domain.Lending) (domain.Lending, error) {
+	err := repo.transaction(ctx, func(tx *SQLiteRepository) error {
+		var storedCopyID, storedReturnDate sql.NullString
+		var storedVersion int
+		err := tx.db.QueryRowContext(ctx, "SELECT copy_id, return_date, version FROM lendings WHERE id = ?1 AND deleted_at IS NULL", lending.ID).
+			Scan(&storedCopyID, &storedReturnDate, &storedVersion)
+		if errors.Is(err, sql.ErrNoRows) {
+			return repository.ErrLendingNotFound
+		} else if err != nil {
+			return err
+		}
+		if storedVersion != lending.Version {
+			return repository.ErrVersionMismatch
+		}
+		reopened := lending.ReturnDate.IsZero() && (storedReturnDate.Valid || storedCopyID.String != lending.CopyID)
+		if reopened && lending.CopyID != "" {
+			if _, err := tx.availableCopy(ctx, lending.BookID, lending.CopyID); err != nil {
+				return err
+			}
+		}
+		lending.DeletedAt = nil
+		lending.Version++
+		_, err = tx.db.ExecContext(ctx,
+			"UPDATE lendings SET book_id = ?2, copy_id = ?3, user_id = ?4, lend_date = ?5, due_date = ?6, return_date = ?7, renewal_count = ?8, version = ?9 WHERE id = ?1",
+			lending.ID, lending.BookID, nullableID(lending.CopyID), lending.UserID,
+			storedTime(lending.LendDate), nullableTime(lending.DueDate), nullableTime(lending.ReturnDate), lending.RenewalCount, lending.Version)
+		if err != nil {
+			return tx.mapConstraintViolation(ctx, err,
+				references{bookID: lending.BookID, copyID: lending.CopyID, userID: lending.UserID})
+		}
+		if storedCopyID.String != lending.CopyID && !storedReturnDate.Valid {
+			_, err = tx.db.ExecContext(ctx,
+				"UPDATE book_copies SET status = 'available' WHERE id = ?1 AND status = 'on_loan'", storedCopyID)
+			if err != nil {
+				return err
+			}
+		}
+		return tx.syncCopyStatus(ctx, lending)
+	})
+	if err != nil {
+		return domain.Lending{}, err
+	}
+	return lending, nil
+}
+
+func (repo *SQLiteRepository) DeleteLending(ctx context.Context, id string, version int, deletedAt time.Time) error {
+	return repo.transaction(ctx, func(tx *SQLiteRepository) error {
+		var copyID, returnDate sql.NullString
+		err := tx.db.QueryRowContext(ctx,
+			"UPDATE lendings SET deleted_at = ?2 WHERE id = ?1 AND deleted_at IS NULL AND version = ?3 RETURNING copy_id, return_date",
+			id, storedTime(deletedAt), version).Scan(&copyID, &returnDate)
+		if errors.Is(err, sql.ErrNoRows) {
+			return tx.missedUpdate(ctx, "lendings", id, repository.ErrLendingNotFound)
+		} else if err != nil {
+			return err
+		}
+		if !returnDate.Valid {
+			_, err = tx.db.ExecContext(ctx,
+				"UPDATE book_copies SET status = 'available' WHERE id = ?1 AND status = 'on_loan'", copyID)
+		}
+		return err
+	})
+}
+
+func (repo *SQLiteRepository) RestoreLending(ctx context.Context, id string) (domain.Lending, error) {
+	var lending domain.Lending
+	err := repo.transaction(ctx, func(tx *SQLiteRepository) error {
+		var err error
+		lending, err = scanLending(tx.db.QueryRowContext(ctx, "SELECT "+lendingColumns+" FROM lendings WHERE id = ?1", id))
+		if errors.Is(err, sql.ErrNoRows) {
+			return repository.ErrLendingNotFound
+		} else if err != nil || lending.DeletedAt == nil {
+			return err
+		}
+		if lending.ReturnDate.IsZero() && lending.CopyID != "" {
+			if _, err := tx.availableCopy(ctx, lending.BookID, lending.CopyID); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.db.ExecContext(ctx, "UPDATE lendings SET deleted_at = NULL WHERE id = ?1", id); err != nil {
+			return tx.mapConstraintViolation(ctx, err, references{})
+		}
+		lending.DeletedAt = nil
+		return tx.syncCopyStatus(ctx, lending)
+	})
+	if err != nil {
+		return domain.Lending{}, err
+	}
+	return lending, nil
+}
+
+// PurgeDeleted deletes lendings first, so that a deleted lending is counted
+// even when its user or book is purged as well.
+func (repo *SQLiteRepository) PurgeDeleted(ctx context.Context, before time.Time) (domain.PurgeReport, error) {
+	var report domain.PurgeReport
+	err := repo.transaction(ctx, func(tx *SQLiteRepository) error {
+		for _, purge := range []struct {
+			table string
+			count *int
+		}{
+			{"lendings", &report.Lendings},
+			{"users", &report.Users},
+			{"books", &report.Books},
+		} {
+			result, err := tx.db.ExecContext(ctx, "DELETE FROM "+purge.table+" WHERE deleted_at < ?1", storedTime(before))
+			if err != nil {
+				return err
+			}
+			purged, err := result.RowsAffected()
+			if err != nil {
+				return err
+			}
+			*purge.count = int(purged)
+		}
+		return nil
+	})
+	if err != nil {
+		return domain.PurgeReport{}, err
+	}
+	return report, nil
+}
+
+const fineColumns = "id, user_id, lending_id, reason, amount, status, created_at, settled_at"
+
+// scanFine reads a row selected with fineColumns.
+func scanFine(row scanner) (domain.Fine, error) {
+	var f domain.Fine
+	var lendingID sql.NullString
+	err := row.Scan(&f.ID, &f.UserID, &lendingID, &f.Reason, &f.Amount, &f.Status,
+		timeColumn{&f.CreatedAt}, timeColumn{&f.SettledAt})
+	f.LendingID = lendingID.String
+	return f, err
+}
+
+func (repo *SQLiteRepository) GetFinesByUserID(ctx context.Context, userID string) ([]domain.Fine, error) {
+	return queryAll(ctx, repo.db, scanFine,
+		"SELECT "+fineColumns+" FROM fines WHERE user_id = ?1 ORDER BY created_at", userID)
+}
+
+func (repo *SQLiteRepository) GetFineByID(ctx context.Context, id string) (domain.Fine, error) {
+	f, err := scanFine(repo.db.QueryRowContext(ctx, "SELECT "+fineColumns+" FROM fines WHERE id = ?1", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Fine{}, repository.ErrFineNotFound
+	} else if err != nil {
+		return domain.Fine{}, err
+	}
+	return f, nil
+}
+
+func (repo *SQLiteRepository) CreateFine(ctx context.Context, fine domain.Fine) (domain.Fine, error) {
+	_, err := repo.db.ExecContext(ctx,
+		"INSERT INTO fines ("+fineColumns+") VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8)",
+		fine.ID, fine.UserID, nullableID(fine.LendingID), fine.Reason, fine.Amount, fine.Status,
+		storedTime(fine.CreatedAt), nullableTime(fine.SettledAt))
+	if err != nil {
+		return domain.Fine{}, repo.mapConstraintViolation(ctx, err, references{userID: fine.UserID, lendingID: fine.LendingID})
+	}
+	return fine, nil
+}
+
+func (repo *SQLiteRepository) UpdateFine(ctx context.Context, fine domain.Fine) (domain.Fine, error) {
+	result, err := repo.db.ExecContext(ctx,
+		"UPDATE fines SET user_id = ?2, lending_id = ?3, reason = ?4, amount = ?5, status = ?6, created_at = ?7, settled_at = ?8 WHERE id = ?1",
+		fine.ID, fine.UserID, nullableID(fine.LendingID), fine.Reason, fine.Amount, fine.Status,
+		storedTime(fine.CreatedAt), nullableTime(fine.SettledAt))
+	if err != nil {
+		return domain.Fine{}, repo.mapConstraintViolation(ctx, err, references{userID: fine.UserID, lendingID: fine.LendingID})
+	}
+	if err := expectRow(result, repository.ErrFineNotFound); err != nil {
+		return domain.Fine{}, err
+	}
+	return fine, nil
+}
+
+const holdColumns = "id, book_id, user_id, copy_id, status, created_at, ready_at, expires_at"
+
+// scanHold reads a row selected with holdColumns.
+func scanHold(row scanner) (domain.Hold, error) {
+	var h domain.Hold
+	var copyID sql.NullString
+	err := row.Scan(&h.ID, &h.BookID, &h.UserID, &copyID, &h.Status,
+		timeColumn{&h.CreatedAt}, timeColumn{&h.ReadyAt}, timeColumn{&h.ExpiresAt})
+	h.CopyID = copyID.String
+	return h, err
+}
+
+// GetHolds is served by the partial index holds_queue_idx.
+func (repo *SQLiteRepository) GetHolds(ctx context.Context, bookID string) ([]domain.Hold, error) {
+	return queryAll(ctx, repo.db, scanHold,
+		"SELECT "+holdColumns+" FROM holds WHERE book_id = ?1 AND status IN ('waiting', 'ready') ORDER BY created_at", bookID)
+}
+
+func (repo *SQLiteRepository) GetHoldByID(ctx context.Context, id string) (domain.Hold, error) {
+	h, err := scanHold(repo.db.QueryRowContext(ctx, "SELECT "+holdColumns+" FROM holds WHERE id = ?1", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return domain.Hold{}, repository.ErrHoldNotFound
+	} else if err != nil {
+		return domain.Hold{}, err
+	}
+	return h, nil
+}
+
+func (repo *SQLiteRepository) CreateHold(ctx context.Context, hold domain.Hold) (domain.Hold, error) {
+	_, err := repo.db.ExecContext(ctx,
+		"INSERT INTO holds ("+holdColumns+") VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8)",
+		hold.ID, hold.BookID, hold.UserID, nullableID(hold.CopyID), hold.Status,
+		storedTime(hold.CreatedAt), nullableTime(hold.ReadyAt), nullableTime(hold.ExpiresAt))
+	if err != nil {
+		return domain.Hold{}, repo.mapConstraintViolation(ctx, err,
+			references{bookID: hold.BookID, copyID: hold.CopyID, userID: hold.UserID})
+	}
+	return hold, nil
+}
+
+func (repo *SQLiteRepository) UpdateHold(ctx context.Context, hold domain.Hold) (domain.Hold, error) {
+	err := repo.transaction(ctx, func(tx *SQLiteRepository) error {
+		var storedStatus domain.HoldStatus
+		var storedCopyID sql.NullString
+		err := tx.db.QueryRowContext(ctx, "SELECT status, copy_id FROM holds WHERE id = ?1", hold.ID).
+			Scan(&storedStatus, &storedCopyID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return repository.ErrHoldNotFound
+		} else if err != nil {
+			return err
+		}
+		_, err = tx.db.ExecContext(ctx,
+			"UPDATE holds SET book_id = ?2, user_id = ?3, copy_id = ?4, status = ?5, created_at = ?6, ready_at = ?7, expires_at = ?8 WHERE id = ?1",
+			hold.ID, hold.BookID, hold.UserID, nullableID(hold.CopyID), hold.Status,
+			storedTime(hold.CreatedAt), nullableTime(hold.ReadyAt), nullableTime(hold.ExpiresAt))
+		if err != nil {
+			return tx.mapConstraintViolation(ctx, err,
+				references{bookID: hold.BookID, copyID: hold.CopyID, userID: hold.UserID})
+		}
+		if storedStatus == domain.HoldStatusReady && hold.Status != domain.HoldStatusFulfilled &&
+			(hold.Status != domain.HoldStatusReady || hold.CopyID != storedCopyID.String) {
+			return tx.releaseHeldCopy(ctx, storedCopyID.String)
+		}
+		return nil
+	})
+	if err != nil {
+		return domain.Hold{}, err
+	}
+	return hold, nil
+}
+
+func (repo *SQLiteRepository) ReadyNextHold(ctx context.Context, copyID string, readyAt, expiresAt time.Time) (domain.Hold, error) {
+	var next domain.Hold
+	err := repo.transaction(ctx, func(tx *SQLiteRepository) error {
+		var bookID string
+		err := tx.db.QueryRowContext(ctx,
+			"SELECT book_id FROM book_copies WHERE id = ?1 AND status = 'available'", copyID).Scan(&bookID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		waiting, err := scanHold(tx.db.QueryRowContext(ctx,
+			"SELECT "+holdColumns+" FROM holds WHERE book_id = ?1 AND status = 'waiting' ORDER BY created_at LIMIT 1", bookID))
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		waiting.Status = domain.HoldStatusReady
+		waiting.CopyID = copyID
+		waiting.ReadyAt = readyAt
+		waiting.ExpiresAt = expiresAt
+		_, err = tx.db.ExecContext(ctx,
+			"UPDATE holds SET status = ?2, copy_id = ?3, ready_at = ?4, expires_at = ?5 WHERE id = ?1",
+			waiting.ID, waiting.Status, waiting.CopyID, storedTime(waiting.ReadyAt), storedTime(waiting.ExpiresAt))
+		if err != nil {
+			return err
+		}
+		if _, err := tx.db.ExecContext(ctx, "UPDATE book_copies SET status = 'on_hold' WHERE id = ?1", copyID); err != nil {
+			return err
+		}
+		next = waiting
+		return nil
+	})
+	if err != nil {
+		return domain.Hold{}, err
+	}
+	return next, nil
+}
+
+func (repo *SQLiteRepository) ExpireHolds(ctx context.Context, now time.Time) ([]domain.Hold, error) {
+	var expired []domain.Hold
+	err := repo.transaction(ctx, func(tx *SQLiteRepository) error {
+		var err error
+		expired, err = queryAll(ctx, tx.db, scanHold,
+			"UPDATE holds SET status = 'expired' WHERE status = 'ready' AND expires_at < ?1 RETURNING "+holdColumns, storedTime(now))
+		if err != nil {
+			return err
+		}
+		for _, h := range expired {
+			if err := tx.releaseHeldCopy(ctx, h.CopyID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return expired, nil
+}
+
+// readyHold finds the ready hold of the lending's user together with the copy
+// that was set aside for it. Both IDs are empty when there is none.
+func (repo *SQLiteRepository) readyHold(ctx context.Context, lending domain.Lending) (string, string, error) {
+	var holdID, copyID string
+	err := repo.db.QueryRowContext(ctx,
+		`SELECT id, copy_id FROM holds
+		 WHERE book_id = ?1 AND user_id = ?2 AND status = 'ready' AND copy_id IS NOT NULL
+		   AND (?3 = '' OR copy_id = ?3)
+		 LIMIT 1`, lending.BookID, lending.UserID, lending.CopyID).Scan(&holdID, &copyID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", "", nil
+	}
+	return holdID, copyID, err
+}
+
+// availableCopy checks that the requested copy is on the shelf, or picks the
+// first available copy of the book when no copy was requested. Transactions
+// hold the write lock of the whole database, so the copy stays available
+// until the lending is written.
+func (repo *SQLiteRepository) availableCopy(ctx context.Context, bookID, copyID string) (string, error) {
+	if copyID == "" {
+		err := repo.db.QueryRowContext(ctx,
+			"SELECT id FROM book_copies WHERE book_id = ?1 AND status = 'available' ORDER BY barcode LIMIT 1", bookID).
+			Scan(&copyID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", repository.ErrCopyUnavailable
+		}
+		return copyID, err
+	}
+	var status domain.CopyStatus
+	err := repo.db.QueryRowContext(ctx, "SELECT status FROM book_copies WHERE id = ?1", copyID).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", repository.ErrBookCopyNotFound
+	} else if err != nil {
+		return "", err
+	}
+	if status != domain.CopyStatusAvailable {
+		return "", repository.ErrCopyUnavailable
+	}
+	return copyID, nil
+}
+
+// syncCopyStatus marks the copy of an active lending as on loan and puts it
+// back on the shelf once the lending has been returned.
+func (repo *SQLiteRepository) syncCopyStatus(ctx context.Context, lending domain.Lending) error {
+	if lending.CopyID == "" {
+		return nil
+	}
+	var err error
+	if lending.ReturnDate.IsZero() {
+		_, err = repo.db.ExecContext(ctx,
+			"UPDATE book_copies SET status = 'on_loan' WHERE id = ?1", lending.CopyID)
+	} else {
+		_, err = repo.db.ExecContext(ctx,
+			"UPDATE book_copies SET status = 'available' WHERE id = ?1 AND status = 'on_loan'", lending.CopyID)
+	}
+	return err
+}
+
+// checkNoActiveLendings fails with an ActiveLendingsError when the book, copy
+// or user named by entity still has lendings out. Transactions take the write
+// lock when they begin, so no lending can be created until they end.
+func (repo *SQLiteRepository) checkNoActiveLendings(ctx context.Context, entity, id string) error {
+	lendingIDs, err := queryAll(ctx, repo.db, func(row scanner) (string, error) {
+		var lendingID string
+		err := row.Scan(&lendingID)
+		return lendingID, err
+	}, "SELECT id FROM lendings WHERE "+entity+"_id = ?1 AND return_date IS NULL AND deleted_at IS NULL ORDER BY id", id)
+	if err != nil {
+		return err
+	}
+	if len(lendingIDs) > 0 {
+		return &repository.ActiveLendingsError{Entity: entity, LendingIDs: lendingIDs}
+	}
+	return nil
+}
+
+// cancelHolds cancels the active holds whose column is id and puts the copies
+// set aside for them back on the shelf.
+func (repo *SQLiteRepository) cancelHolds(ctx context.Context, column, id string) error {
+	copyIDs, err := queryAll(ctx, repo.db, func(row scanner) (string, error) {
+		var copyID sql.NullString
+		err := row.Scan(&copyID)
+		return copyID.String, err
+	}, "UPDATE holds SET status = 'cancelled' WHERE "+column+" = ?1 AND status IN ('waiting', 'ready') RETURNING copy_id", id)
+	if err != nil {
+		return err
+	}
+	for _, copyID := range copyIDs {
+		if err := repo.releaseHeldCopy(ctx, copyID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// releaseHeldCopy puts a copy that was set aside for a hold back on the shelf.
+func (repo *SQLiteRepository) releaseHeldCopy(ctx context.Context, copyID string) error {
+	if copyID == "" {
+		return nil
+	}
+	_, err := repo.db.ExecContext(ctx,
+		"UPDATE book_copies SET status = 'available' WHERE id = ?1 AND status = 'on_hold'", copyID)
+	return err
+}
+
+// uniqueErrors maps the columns SQLite names in a unique violation to the
+// conflict they signal. Indexes on expressions are named instead.
+var uniqueErrors = map[string]error{
+	"book_copies.barcode":          repository.ErrBarcodeExists,
+	"books.isbn":                   repository.ErrISBNExists,
+	"index 'users_email_key'":      repository.ErrEmailExists,
+	"lendings.copy_id":             repository.ErrCopyUnavailable,
+	"holds.book_id, holds.user_id": repository.ErrHoldExists,
+}
+
+// references names the rows a write points at. Empty IDs point at nothing.
+type references struct {
+	bookID, copyID, userID, lendingID string
+}
+
+// mapConstraintViolation translates a unique or foreign key violation into a
+// repository error. SQLite does not say which foreign key failed, so the
+// referenced rows are looked up to find the missing one.
+func (repo *SQLiteRepository) mapConstraintViolation(ctx context.Context, err error, refs references) error {
+	message := err.Error()
+	for columns, mapped := range uniqueErrors {
+		if strings.Contains(message, "UNIQUE constraint failed: "+columns) {
+			return mapped
+		}
+	}
+	if !strings.Contains(message, "FOREIGN KEY constraint failed") {
+		return err
+	}
+	checks := []struct {
+		table, id string
+		missing   error
+	}{
+		{"books", refs.bookID, repository.ErrBookNotFound},
+		{"book_copies", refs.copyID, repository.ErrBookCopyNotFound},
+		{"users", refs.userID, repository.ErrUserNotFound},
+		{"lendings", refs.lendingID, repository.ErrLendingNotFound},
+	}
+	for _, check := range checks {
+		if check.id == "" {
+			continue
+		}
+		var exists bool
+		query := "SELECT EXISTS (SELECT 1 FROM " + check.table + " WHERE id = ?1)"
+		if lookupErr := repo.db.QueryRowContext(ctx, query, check.id).Scan(&exists); lookupErr != nil {
+			return err
+		}
+		if !exists {
+			return check.missing
+		}
+	}
+	return err
+}
+
+// expectRow returns notFound when a statement did not touch any row.
+func expectRow(result sql.Result, notFound error) error {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return notFound
+	}
+	return nil
+}
+
+// expectVersion explains a compare-and-swap update of the row with id in table
+// that touched nothing, see missedUpdate.
+func (repo *SQLiteRepository) expectVersion(ctx context.Context, result sql.Result, table, id string, notFound error) error {
+	if err := expectRow(result, notFound); !errors.Is(err, notFound) {
+		return err
+	}
+	return repo.missedUpdate(ctx, table, id, notFound)
+}
+
+// missedUpdate explains why a compare-and-swap update of the row with id in
+// table touched nothing: the row is gone, or its version moved on.
+func (repo *SQLiteRepository) missedUpdate(ctx context.Context, table, id string, notFound error) error {
+	var exists bool
+	err := repo.db.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM "+table+" WHERE id = ?1 AND deleted_at IS NULL)", id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return notFound
+	}
+	return repository.ErrVersionMismatch
+}
+
+// queryAll scans every row a query returns.
+func queryAll[T any](ctx context.Context, db querier, scan func(scanner) (T, error), query string, args ...any) ([]T, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]T, 0)
+	for rows.Next() {
+		item, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// storedTime formats a time the way the timestamp columns store it, so that
+// comparing the text compares the times.
+func storedTime(t time.Time) string {
+	return repository.SortableTime(t)
+}
+
+// nullableTime maps the zero time to SQL NULL.
+func nullableTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return storedTime(t)
+}
+
+// nullableID maps an empty ID to SQL NULL.
+func nullableID(id string) interface{} {
+	if id == "" {
+		return nil
+	}
+	return id
+}
+
+// listValue stores a list of strings as a JSON array. Nil is stored as an
+// empty array.
+func listValue(values []string) string {
+	if len(values) == 0 {
+		return "[]"
+	}
+	data, _ := json.Marshal(values)
+	return string(data)
+}
+
+// listColumn scans a JSON array of strings into values. An empty array scans
+// to nil.
+type listColumn struct {
+	values *[]string
+}
+
+func (c listColumn) Scan(src any) error {
+	var data []byte
+	switch value := src.(type) {
+	case nil:
+	case string:
+		data = []byte(value)
+	case []byte:
+		data = value
+	default:
+		return fmt.Errorf("cannot scan %T into a list", src)
+	}
+	*c.values = nil
+	if len(data) == 0 {
+		return nil
+	}
+	var values []string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	if len(values) > 0 {
+		*c.values = values
+	}
+	return nil
+}
+
+// timeColumn scans a timestamp column into t. NULL scans to the zero time.
+type timeColumn struct {
+	t *time.Time
+}
+
+func (c timeColumn) Scan(src any) error {
+	switch value := src.(type) {
+	case nil:
+		*c.t = time.Time{}
+		return nil
+	case string:
+		return c.parse(value)
+	case []byte:
+		return c.parse(string(value))
+	case time.Time:
+		*c.t = value
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into a time", src)
+}
+
+func (c timeColumn) parse(value string) error {
+	parsed, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return err
+	}
+	*c.t = parsed
+	return nil
+}
+
+// nullTimeColumn scans a timestamp column into t. NULL scans to nil.
+type nullTimeColumn struct {
+	t **time.Time
+}
+
+func (c nullTimeColumn) Scan(src any) error {
+	if src == nil {
+		*c.t = nil
+		return nil
+	}
+	var t time.Time
+	if err := (timeColumn{&t}).Scan(src); err != nil {
+		return err
+	}
+	*c.t = &t
+	return nil
+}
@@ -0,0 +1,225 @@
+package sqliterepository
+
+import (
+	"context"
+	"errors"
+	"github.com/google/uuid"
+	"libary-service/internal/domain"
+	"libary-service/internal/injected-service/repository"
+	"libary-service/internal/injected-service/repository/repositorytest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var ctx = context.Background()
+
+// connect opens a fresh database file that is removed after the test.
+func connect(t *testing.T) *SQLiteRepository {
+	t.Setenv("SQLITE_PATH", filepath.Join(t.TempDir(), "library.db"))
+	r := New()
+	if err := r.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	t.Cleanup(func() { r.Disconnect(ctx) })
+	return r
+}
+
+func TestConformance(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.Repository {
+		return connect(t)
+	})
+}
+
+func TestConnectKeepsData(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "library.db")
+	t.Setenv("SQLITE_PATH", path)
+	book := domain.Book{ID: uuid.NewString(), Title: "Dune", Author: "Frank Herbert"}
+
+	r := New()
+	if err := r.Connect(ctx); err != nil {
+		t.Fatalf("Failed to connect: %v", err)
+	}
+	book, err := r.CreateBook(ctx, book)
+	if err != nil {
+		t.Fatalf("CreateBook failed: %v", err)
+	}
+	if err := r.Disconnect(ctx); err != nil {
+		t.Fatalf("Disconnect failed: %v", err)
+	}
+
+	// Connecting again must not run the migrations a second time.
+	r = New()
+	if err := r.Connect(ctx); err != nil {
+		t.Fatalf("Failed to reconnect: %v", err)
+	}
+	defer r.Disconnect(ctx)
+	if got, err := r.GetBookByID(ctx, book.ID); err != nil || !reflect.DeepEqual(got, book) {
+		t.Errorf("GetBookByID after reconnect: expected %+v, got %+v, %v", book, got, err)
+	}
+}
+
+func TestConnectFailure(t *testing.T) {
+	t.Setenv("SQLITE_PATH", filepath.Join(t.TempDir(), "missing", "library.db"))
+	if err := New().Connect(ctx); err == nil {
+		t.Error("Expected error when the directory of the database does not exist, got nil")
+	}
+}
+
+func TestTimestamps(t *testing.T) {
+	r := connect(t)
+	book := domain.Book{ID: uuid.NewString(), Title: "Dune", Author: "Frank Herbert"}
+	user := domain.User{ID: uuid.NewString(), Name: "Paul", Email: "paul@example.com", Category: domain.UserCategoryStudent}
+	r.CreateBook(ctx, book)
+	r.CreateUser(ctx, user)
+	r.CreateBookCopy(ctx, domain.BookCopy{ID: uuid.NewString(), BookID: book.ID, Barcode: "B1", Condition: domain.CopyConditionGood, Status: domain.CopyStatusAvailable})
+
+	berlin := time.FixedZone("CET", 60*60)
+	lendDate := time.Date(2025, time.January, 1, 12, 30, 0, 123456789, berlin)
+	created, err := r.CreateLending(ctx, domain.Lending{ID: uuid.NewString(), BookID: book.ID, UserID: user.ID,
+		LendDate: lendDate, DueDate: lendDate.Add(21 * 24 * time.Hour)})
+	if err != nil {
+		t.Fatalf("CreateLending failed: %v", err)
+	}
+	got, err := r.GetLendingByID(ctx, created.ID)
+	if err != nil {
+		t.Fatalf("GetLendingByID failed: %v", err)
+	}
+	if !got.LendDate.Equal(lendDate) || !got.DueDate.Equal(created.DueDate) || !got.ReturnDate.IsZero() {
+		t.Errorf("GetLendingByID: expected the stored times back, got %+v", got)
+	}
+
+	overdue, err := r.GetOverdueLendings(ctx, lendDate.Add(22*24*time.Hour))
+	if err != nil || len(overdue) != 1 {
+		t.Errorf("GetOverdueLendings: expected the lending to be overdue, got %+v, %v", overdue, err)
+	}
+	overdue, err = r.GetOverdueLendings(ctx, lendDate.Add(20*24*time.Hour))
+	if err != nil || len(overdue) != 0 {
+		t.Errorf("GetOverdueLendings: expected no overdue lending yet, got %+v, %v", overdue, err)
+	}
+}
+
+func TestListPagination(t *testing.T) {
+	r := connect(t)
+	for _, title := range []string{"B", "A", "C", "Ab", "A_", "ab"} {
+		if _, err := r.CreateBook(ctx, domain.Book{ID: uuid.NewString(), Title: title, Author: "Author " + title}); err != nil {
+			t.Fatalf("CreateBook failed: %v", err)
+		}
+	}
+
+	var titles []string
+	options := repository.ListOptions{Limit: 2, Sort: "-title"}
+	for {
+		page, err := r.GetBooks(ctx, repository.BookFilter{}, options)
+		if err != nil {
+			t.Fatalf("GetBooks failed: %v", err)
+		}
+		if page.Total != 6 {
+			t.Errorf("GetBooks: expected total 6, got %d", page.Total)
+		}
+		for _, b := range page.Items {
+			titles = append(titles, b.Title)
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		options.Cursor = page.NextCursor
+	}
+	if len(titles) != 6 || titles[0] != "ab" || titles[1] != "C" {
+		t.Errorf("GetBooks: expected six titles from ab down, got %v", titles)
+	}
+
+	prefixed, err := r.GetBooks(ctx, repository.BookFilter{TitlePrefix: "A_"}, repository.ListOptions{})
+	if err != nil {
+		t.Fatalf("GetBooks with title prefix failed: %v", err)
+	}
+	if len(prefixed.Items) != 1 || prefixed.Items[0].Title != "A_" {
+		t.Errorf("GetBooks: expected the underscore to match literally, got %+v", prefixed.Items)
+	}
+
+	prefixed, err = r.GetBooks(ctx, repository.BookFilter{TitlePrefix: "A"}, repository.ListOptions{})
+	if err != nil {
+		t.Fatalf("GetBooks with title prefix failed: %v", err)
+	}
+	if prefixed.Total != 3 {
+		t.Errorf("GetBooks: expected the prefix to match case-sensitively, got %+v", prefixed.Items)
+	}
+}
+
+func TestSearchBooks(t *testing.T) {
+	r := connect(t)
+	books := []domain.Book{
+		{ID: uuid.NewString(), Title: "The Two Towers", Author: "J.R.R. Tolkien"},
+		{ID: uuid.NewString(), Title: "The Return of the King", Author: "J.R.R. Tolkien"},
+		{ID: uuid.NewString(), Title: "Tolkien: A Biography", Author: "Humphrey Carpenter"},
+	}
+	for _, b := range books {
+		if _, err := r.CreateBook(ctx, b); err != nil {
+			t.Fatalf("CreateBook failed: %v", err)
+		}
+	}
+
+	result, err := r.SearchBooks(ctx, "tolkien", repository.ListOptions{})
+	if err != nil {
+		t.Fatalf("SearchBooks failed: %v", err)
+	}
+	if result.Total != 3 || len(result.Items) != 3 {
+		t.Fatalf("SearchBooks: expected 3 hits, got %d of %d", len(result.Items), result.Total)
+	}
+	if result.Items[0].ID != books[2].ID {
+		t.Errorf("SearchBooks: expected the title match first, got %+v", result.Items[0])
+	}
+	if result.Items[0].TitleHighlight != "<b>Tolkien</b>: A Biography" {
+		t.Errorf("SearchBooks: unexpected title highlight %q", result.Items[0].TitleHighlight)
+	}
+
+	result, err = r.SearchBooks(ctx, "king return", repository.ListOptions{})
+	if err != nil {
+		t.Fatalf("SearchBooks failed: %v", err)
+	}
+	if len(result.Items) != 1 || result.Items[0].ID != books[1].ID {
+		t.Errorf("SearchBooks: expected only the book with both terms, got %+v", result.Items)
+	}
+
+	_, err = r.SearchBooks(ctx, "tolkien", repository.ListOptions{Sort: "title"})
+	if !errors.Is(err, repository.ErrInvalidSort) {
+		t.Errorf("SearchBooks with sort: expected ErrInvalidSort, got %v", err)
+	}
+
+	if _, err := r.CreateBook(ctx, domain.Book{ID: uuid.NewString(), Title: "Smith & Sons <Ltd>", Author: `"O'Brien"`}); err != nil {
+		t.Fatalf("CreateBook failed: %v", err)
+	}
+	result, err = r.SearchBooks(ctx, "smith", repository.ListOptions{})
+	if err != nil {
+		t.Fatalf("SearchBooks failed: %v", err)
+	}
+	if len(result.Items) != 1 {
+		t.Fatalf("SearchBooks: expected 1 hit, got %d", len(result.Items))
+	}
+	if result.Items[0].TitleHighlight != "<b>Smith</b> &amp; Sons &lt;Ltd&gt;" {
+		t.Errorf("SearchBooks: title highlight not escaped: %q", result.Items[0].TitleHighlight)
+	}
+	if result.Items[0].AuthorHighlight != "&#34;O&#39;Brien&#34;" {
+		t.Errorf("SearchBooks: author highlight not escaped: %q", result.Items[0].AuthorHighlight)
+	}
+}
+
+func TestMethodsAfterDisconnect(t *testing.T) {
+	r := connect(t)
+	r.Disconnect(ctx)
+
+	if _, err := r.GetBookByID(ctx, uuid.NewString()); err == nil {
+		t.Error("GetBookByID after disconnect: expected an error")
+	}
+	if _, err := r.CreateBook(ctx, domain.Book{ID: uuid.NewString(), Title: "Dune", Author: "Frank Herbert"}); err == nil {
+		t.Error("CreateBook after disconnect: expected an error")
+	}
+	if _, err := r.GetLendings(ctx, repository.LendingFilter{}, repository.ListOptions{}); err == nil {
+		t.Error("GetLendings after disconnect: expected an error")
+	}
+	err := r.WithTx(ctx, func(tx repository.Repository) error { return nil })
+	if err == nil {
+		t.Error("WithTx after disconnect: expected an error")
+	}
+}
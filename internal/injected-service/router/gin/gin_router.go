@@ -14,22 +14,53 @@ func NewGinRouter(service app.Service) *GinRouter {
 	r := GinRouter{Engine: gin.Default()}
 
 	r.GET("/books", service.GetBooks)
+	r.GET("/books/search", service.SearchBooks)
+	r.GET("/books/isbn/:isbn", service.GetBookByISBN)
 	r.GET("/books/:id", service.GetBookByID)
 	r.POST("/books", service.CreateBook)
 	r.PUT("/books/:id", service.UpdateBook)
+	r.PATCH("/books/:id", service.PatchBook)
 	r.DELETE("/books/:id", service.DeleteBook)
+	r.POST("/books/:id/restore", service.RestoreBook)
+
+	r.GET("/books/:id/copies", service.GetBookCopies)
+	r.POST("/books/:id/copies", service.CreateBookCopy)
+	r.GET("/copies/:id", service.GetBookCopyByID)
+	r.PUT("/copies/:id", service.UpdateBookCopy)
+	r.DELETE("/copies/:id", service.DeleteBookCopy)
+
+	r.GET("/books/:id/holds", service.GetBookHolds)
+	r.POST("/books/:id/holds", service.CreateHold)
+	r.GET("/holds/:id", service.GetHoldByID)
+	r.DELETE("/holds/:id", service.CancelHold)
 
 	r.GET("/users", service.GetUsers)
 	r.GET("/users/:id", service.GetUserByID)
 	r.POST("/users", service.CreateUser)
 	r.PUT("/users/:id", service.UpdateUser)
+	r.PATCH("/users/:id", service.PatchUser)
 	r.DELETE("/users/:id", service.DeleteUser)
+	r.POST("/users/:id/restore", service.RestoreUser)
+	r.GET("/users/:id/fines", service.GetUserFines)
 
 	r.GET("/lendings", service.GetLendings)
+	r.GET("/lendings/overdue", service.GetOverdueLendings)
 	r.GET("/lendings/:id", service.GetLendingByID)
 	r.POST("/lendings", service.CreateLending)
 	r.PUT("/lendings/:id", service.UpdateLending)
+	r.PATCH("/lendings/:id", service.PatchLending)
 	r.DELETE("/lendings/:id", service.DeleteLending)
+	r.POST("/lendings/:id/restore", service.RestoreLending)
+	r.POST("/lendings/:id/return", service.ReturnLending)
+	r.POST("/lendings/:id/renew", service.RenewLending)
+	r.POST("/lendings/:id/lost", service.ReportLostLending)
+
+	r.POST("/fines/:id/pay", service.PayFine)
+	r.POST("/fines/:id/waive", service.WaiveFine)
+
+	r.POST("/admin/purge", service.PurgeDeleted)
+
+	r.GET("/stats/database", service.GetDatabaseStats)
 
 	return &r
 }
@@ -46,6 +77,10 @@ func (r *GinRouter) PUT(path string, handler http.HandlerFunc) {
 	r.Engine.PUT(path, gin.WrapF(handler))
 }
 
+func (r *GinRouter) PATCH(path string, handler http.HandlerFunc) {
+	r.Engine.PATCH(path, gin.WrapF(handler))
+}
+
 func (r *GinRouter) DELETE(path string, handler http.HandlerFunc) {
 	r.Engine.DELETE(path, gin.WrapF(handler))
 }
@@ -22,23 +22,49 @@ func TestRoutes(t *testing.T) {
 		response      string
 	}{
 		{"GET", "/books", "GetBooks", http.StatusOK, "mocked GetBooks"},
+		{"GET", "/books/search", "SearchBooks", http.StatusOK, "mocked SearchBooks"},
+		{"GET", "/books/isbn/9780261102217", "GetBookByISBN", http.StatusOK, "mocked GetBookByISBN"},
 		{"GET", "/books/123", "GetBookByID", http.StatusOK, "mocked GetBookByID"},
 		{"POST", "/books", "CreateBook", http.StatusCreated, "mocked CreateBook"},
 		{"PUT", "/books/123", "UpdateBook", http.StatusOK, "mocked UpdateBook"},
+		{"PATCH", "/books/123", "PatchBook", http.StatusOK, "mocked PatchBook"},
 		{"DELETE", "/books/123", "DeleteBook", http.StatusNoContent, ""},
+		{"POST", "/books/123/restore", "RestoreBook", http.StatusOK, "mocked RestoreBook"},
+		{"GET", "/books/123/copies", "GetBookCopies", http.StatusOK, "mocked GetBookCopies"},
+		{"POST", "/books/123/copies", "CreateBookCopy", http.StatusCreated, "mocked CreateBookCopy"},
+		{"GET", "/copies/456", "GetBookCopyByID", http.StatusOK, "mocked GetBookCopyByID"},
+		{"PUT", "/copies/456", "UpdateBookCopy", http.StatusOK, "mocked UpdateBookCopy"},
+		{"DELETE", "/copies/456", "DeleteBookCopy", http.StatusNoContent, ""},
+		{"GET", "/books/123/holds", "GetBookHolds", http.StatusOK, "mocked GetBookHolds"},
+		{"POST", "/books/123/holds", "CreateHold", http.StatusCreated, "mocked CreateHold"},
+		{"GET", "/holds/321", "GetHoldByID", http.StatusOK, "mocked GetHoldByID"},
+		{"DELETE", "/holds/321", "CancelHold", http.StatusNoContent, ""},
 		{"GET", "/users", "GetUsers", http.StatusOK, "mocked GetUsers"},
 		{"GET", "/users/123", "GetUserByID", http.StatusOK, "mocked GetUserByID"},
 		{"POST", "/users", "CreateUser", http.StatusCreated, "mocked CreateUser"},
 		{"PUT", "/users/123", "UpdateUser", http.StatusOK, "mocked UpdateUser"},
+		{"PATCH", "/users/123", "PatchUser", http.StatusOK, "mocked PatchUser"},
 		{"DELETE", "/users/123", "DeleteUser", http.StatusNoContent, ""},
+		{"POST", "/users/123/restore", "RestoreUser", http.StatusOK, "mocked RestoreUser"},
+		{"GET", "/users/123/fines", "GetUserFines", http.StatusOK, "mocked GetUserFines"},
 		{"GET", "/lendings", "GetLendings", http.StatusOK, "mocked GetLendings"},
+		{"GET", "/lendings/overdue", "GetOverdueLendings", http.StatusOK, "mocked GetOverdueLendings"},
 		{"GET", "/lendings/123", "GetLendingByID", http.StatusOK, "mocked GetLendingByID"},
 		{"POST", "/lendings", "CreateLending", http.StatusCreated, "mocked CreateLending"},
 		{"PUT", "/lendings/123", "UpdateLending", http.StatusOK, "mocked UpdateLending"},
+		{"PATCH", "/lendings/123", "PatchLending", http.StatusOK, "mocked PatchLending"},
 		{"DELETE", "/lendings/123", "DeleteLending", http.StatusNoContent, ""},
+		{"POST", "/lendings/123/restore", "RestoreLending", http.StatusOK, "mocked RestoreLending"},
+		{"POST", "/lendings/123/return", "ReturnLending", http.StatusOK, "mocked ReturnLending"},
+		{"POST", "/lendings/123/renew", "RenewLending", http.StatusOK, "mocked RenewLending"},
+		{"POST", "/lendings/123/lost", "ReportLostLending", http.StatusOK, "mocked ReportLostLending"},
+		{"POST", "/fines/789/pay", "PayFine", http.StatusOK, "mocked PayFine"},
+		{"POST", "/fines/789/waive", "WaiveFine", http.StatusOK, "mocked WaiveFine"},
+		{"POST", "/admin/purge", "PurgeDeleted", http.StatusOK, "mocked PurgeDeleted"},
+		{"GET", "/stats/database", "GetDatabaseStats", http.StatusOK, "mocked GetDatabaseStats"},
 	}
 	for _, route := range routes {
-		if route.serviceMethod == "DeleteBook" || route.serviceMethod == "DeleteUser" || route.serviceMethod == "DeleteLending" {
+		if route.response == "" {
 			mockService.On(route.serviceMethod, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
 				w := args.Get(0).(http.ResponseWriter)
 				w.WriteHeader(route.status)
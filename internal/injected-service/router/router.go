@@ -9,6 +9,7 @@ type Router interface {
 	GET(path string, handler http.HandlerFunc)
 	POST(path string, handler http.HandlerFunc)
 	PUT(path string, handler http.HandlerFunc)
+	PATCH(path string, handler http.HandlerFunc)
 	DELETE(path string, handler http.HandlerFunc)
 	Serve(addr string) error
 }
@@ -0,0 +1,53 @@
+package validation
+
+import (
+	"libary-service/internal/domain"
+	"strings"
+)
+
+// FieldError is a problem with one field of a request body.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// Errors collects the field errors of one validation. It matches
+// domain.ErrValidation with errors.Is.
+type Errors []FieldError
+
+// Add records a problem with field.
+func (e *Errors) Add(field, message string) {
+	*e = append(*e, FieldError{Field: field, Message: message})
+}
+
+// Err returns the collected errors, or nil when there are none.
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e Errors) Error() string {
+	messages := make([]string, len(e))
+	for i, fieldError := range e {
+		messages[i] = fieldError.Message
+	}
+	return strings.Join(messages, "\n")
+}
+
+func (e Errors) Unwrap() error {
+	return domain.ErrValidation
+}
+
+// Conflict is a field whose value another entity already has, like the email
+// address of another user. It matches domain.ErrConflict with errors.Is.
+type Conflict FieldError
+
+func (c Conflict) Error() string {
+	return c.Message
+}
+
+func (c Conflict) Unwrap() error {
+	return domain.ErrConflict
+}
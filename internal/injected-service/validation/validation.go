@@ -2,11 +2,22 @@
 package validation
 
 import (
+	"context"
 	"libary-service/internal/domain"
 )
 
+// Validation checks request bodies before they reach the repository.
+// CheckBook, CheckUser and CheckLending hold the rules for creating an entity.
+// The matching update checks also receive the stored entity, so that their
+// rules can depend on the transition from stored to updated.
 type Validation interface {
-	CheckBook(book domain.Book) error
-	CheckUser(user domain.User) error
-	CheckLending(lending domain.Lending) error
+	CheckBook(ctx context.Context, book domain.Book) error
+	CheckBookUpdate(ctx context.Context, stored, updated domain.Book) error
+	CheckBookCopy(ctx context.Context, bookCopy domain.BookCopy) error
+	CheckBookCopyUpdate(ctx context.Context, stored, updated domain.BookCopy) error
+	CheckUser(ctx context.Context, user domain.User) error
+	CheckUserUpdate(ctx context.Context, stored, updated domain.User) error
+	CheckLending(ctx context.Context, lending domain.Lending) error
+	CheckLendingUpdate(ctx context.Context, stored, updated domain.Lending) error
+	CheckHold(ctx context.Context, hold domain.Hold) error
 }
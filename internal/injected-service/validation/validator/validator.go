@@ -1,10 +1,14 @@
 package validator
 
 import (
-	"errors"
-	"fmt"
+	"context"
 	"libary-service/internal/domain"
 	"libary-service/internal/injected-service/repository"
+	"libary-service/internal/injected-service/validation"
+	"net/mail"
+	"slices"
+	"strings"
+	"time"
 )
 
 type Validator struct {
@@ -15,60 +19,311 @@ func New(repository repository.Repository) *Validator {
 	return &Validator{repository}
 }
 
-func (v Validator) CheckBook(book domain.Book) error {
-	var errs []error
+// CheckBook expects the ISBN to be normalized already, see domain.NormalizeISBN.
+// An ISBN used by another book is reported as a validation.Conflict once all
+// fields are valid.
+func (v Validator) CheckBook(ctx context.Context, book domain.Book) error {
+	errs := checkBookFields(book)
+	if book.ID != "" {
+		errs.Add("id", "id should be empty")
+	}
+	if err := errs.Err(); err != nil {
+		return err
+	}
+	return v.checkISBNFree(ctx, book.ISBN, "")
+}
+
+func (v Validator) CheckBookUpdate(ctx context.Context, stored, updated domain.Book) error {
+	errs := checkBookFields(updated)
+	checkSameID(&errs, stored.ID, updated.ID)
+	if err := errs.Err(); err != nil {
+		return err
+	}
+	if updated.ISBN == stored.ISBN {
+		return nil
+	}
+	return v.checkISBNFree(ctx, updated.ISBN, stored.ID)
+}
+
+func checkBookFields(book domain.Book) validation.Errors {
+	var errs validation.Errors
 	if book.Title == "" {
-		errs = append(errs, fmt.Errorf("title is required"))
+		errs.Add("title", "title is required")
 	}
 	if book.Author == "" {
-		errs = append(errs, fmt.Errorf("author is required"))
+		errs.Add("author", "author is required")
 	}
-	if book.ID != "" {
-		errs = append(errs, fmt.Errorf("id should be empty"))
+	if slices.Contains(book.Authors, "") {
+		errs.Add("authors", "authors cannot contain empty names")
+	}
+	if len(book.Authors) > 0 && book.Authors[0] != book.Author {
+		errs.Add("author", "author must be the first of authors")
+	}
+	if book.ISBN != "" && !domain.ValidISBN13(book.ISBN) {
+		errs.Add("isbn", "isbn is not a valid ISBN-10 or ISBN-13")
+	}
+	if book.PublicationYear < 0 {
+		errs.Add("publication_year", "publication_year cannot be negative")
+	} else if book.PublicationYear > time.Now().Year() {
+		errs.Add("publication_year", "publication_year cannot be in the future")
+	}
+	if book.Language != "" && !validLanguage(book.Language) {
+		errs.Add("language", "language must be a lowercase ISO 639-1 code")
+	}
+	if book.PageCount < 0 {
+		errs.Add("page_count", "page_count cannot be negative")
+	}
+	if slices.Contains(book.Subjects, "") {
+		errs.Add("subjects", "subjects cannot contain empty entries")
+	}
+	return errs
+}
+
+// validLanguage reports whether language looks like an ISO 639-1 code: two
+// lowercase letters.
+func validLanguage(language string) bool {
+	return len(language) == 2 &&
+		language[0] >= 'a' && language[0] <= 'z' && language[1] >= 'a' && language[1] <= 'z'
+}
+
+// checkISBNFree looks for another book with the ISBN. Deleted books give their
+// ISBN free, restoring one fails instead. Books without an ISBN are never
+// checked. A failed lookup is returned as is.
+func (v Validator) checkISBNFree(ctx context.Context, isbn, bookID string) error {
+	if isbn == "" {
+		return nil
+	}
+	page, err := v.repository.GetBooks(ctx, repository.BookFilter{ISBN: isbn}, repository.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, other := range page.Items {
+		if other.ID != bookID {
+			return validation.Conflict{Field: "isbn", Message: "isbn is already taken"}
+		}
+	}
+	return nil
+}
+
+// validEmail reports whether email is a bare RFC 5322 address, without a
+// display name, comments or surrounding whitespace.
+func validEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Name == "" && address.Address == email
+}
+
+// checkSameID rejects an update that tries to give an entity another ID.
+// Updates may leave the ID out, it is taken from the path.
+func checkSameID(errs *validation.Errors, storedID, updatedID string) {
+	if updatedID != "" && updatedID != storedID {
+		errs.Add("id", "id cannot be changed")
+	}
+}
+
+func (v Validator) CheckBookCopy(ctx context.Context, bookCopy domain.BookCopy) error {
+	errs := checkBookCopyFields(bookCopy, "")
+	if bookCopy.ID != "" {
+		errs.Add("id", "id should be empty")
+	}
+	_, bookMissing := v.repository.GetBookByID(ctx, bookCopy.BookID)
+	if bookMissing != nil {
+		errs.Add("book_id", "book not found")
+	}
+	return errs.Err()
+}
+
+// CheckBookCopyUpdate lets a copy keep the on_loan or on_hold status that its
+// lending or hold gave it, but a client can never set either one.
+func (v Validator) CheckBookCopyUpdate(ctx context.Context, stored, updated domain.BookCopy) error {
+	errs := checkBookCopyFields(updated, stored.Status)
+	checkSameID(&errs, stored.ID, updated.ID)
+	return errs.Err()
+}
+
+func checkBookCopyFields(bookCopy domain.BookCopy, storedStatus domain.CopyStatus) validation.Errors {
+	var errs validation.Errors
+	if bookCopy.Barcode == "" {
+		errs.Add("barcode", "barcode is required")
+	}
+	switch bookCopy.Condition {
+	case domain.CopyConditionNew, domain.CopyConditionGood, domain.CopyConditionFair,
+		domain.CopyConditionPoor, domain.CopyConditionDamaged:
+	default:
+		errs.Add("condition", "condition must be one of new, good, fair, poor, damaged")
+	}
+	switch bookCopy.Status {
+	case domain.CopyStatusAvailable, domain.CopyStatusInRepair, domain.CopyStatusLost, domain.CopyStatusWithdrawn:
+	case domain.CopyStatusOnLoan:
+		if storedStatus != domain.CopyStatusOnLoan {
+			errs.Add("status", "status on_loan is managed by lendings")
+		}
+	case domain.CopyStatusOnHold:
+		if storedStatus != domain.CopyStatusOnHold {
+			errs.Add("status", "status on_hold is managed by holds")
+		}
+	default:
+		errs.Add("status", "status must be one of available, in_repair, lost, withdrawn")
+	}
+	return errs
+}
+
+// CheckUser also makes sure that the email address is not taken yet. A taken
+// address is reported as a validation.Conflict once all fields are valid.
+func (v Validator) CheckUser(ctx context.Context, user domain.User) error {
+	errs := checkUserFields(user)
+	if user.ID != "" {
+		errs.Add("id", "id should be empty")
+	}
+	if err := errs.Err(); err != nil {
+		return err
+	}
+	return v.checkEmailFree(ctx, user.Email, "")
+}
+
+func (v Validator) CheckUserUpdate(ctx context.Context, stored, updated domain.User) error {
+	errs := checkUserFields(updated)
+	checkSameID(&errs, stored.ID, updated.ID)
+	if err := errs.Err(); err != nil {
+		return err
+	}
+	if strings.EqualFold(updated.Email, stored.Email) {
+		return nil
 	}
-	return errors.Join(errs...)
+	return v.checkEmailFree(ctx, updated.Email, stored.ID)
 }
 
-func (v Validator) CheckUser(user domain.User) error {
-	var errs []error
+// checkEmailFree looks for another user with the email address. Deleted users
+// give their address free, restoring one fails instead. A failed lookup is
+// returned as is, since the address could not be checked.
+func (v Validator) checkEmailFree(ctx context.Context, email, userID string) error {
+	page, err := v.repository.GetUsers(ctx, repository.UserFilter{Email: email}, repository.ListOptions{})
+	if err != nil {
+		return err
+	}
+	for _, other := range page.Items {
+		if other.ID != userID {
+			return validation.Conflict{Field: "email", Message: "email is already taken"}
+		}
+	}
+	return nil
+}
+
+func checkUserFields(user domain.User) validation.Errors {
+	var errs validation.Errors
 	if user.Name == "" {
-		errs = append(errs, fmt.Errorf("name is required"))
+		errs.Add("name", "name is required")
 	}
 	if user.Email == "" {
-		errs = append(errs, fmt.Errorf("email is required"))
+		errs.Add("email", "email is required")
+	} else if !validEmail(user.Email) {
+		errs.Add("email", "email is not a valid address")
 	}
-	if user.ID != "" {
-		errs = append(errs, fmt.Errorf("id should be empty"))
+	switch user.Category {
+	case "", domain.UserCategoryStudent, domain.UserCategoryStaff, domain.UserCategoryGuest:
+	default:
+		errs.Add("category", "category must be one of student, staff, guest")
 	}
-	return errors.Join(errs...)
+	return errs
 }
 
-func (v Validator) CheckLending(lending domain.Lending) error {
-	var errs []error
+// CheckLending leaves the return date and the renewal count to returning and
+// renewing, so a new lending cannot bring either along.
+func (v Validator) CheckLending(ctx context.Context, lending domain.Lending) error {
+	var errs validation.Errors
 
 	if lending.ID != "" {
-		errs = append(errs, fmt.Errorf("id should be empty"))
+		errs.Add("id", "id should be empty")
 	}
 
-	_, bookMissing := v.repository.GetBookByID(lending.BookID)
+	_, bookMissing := v.repository.GetBookByID(ctx, lending.BookID)
 	if bookMissing != nil {
-		errs = append(errs, fmt.Errorf("book not found"))
+		errs.Add("book_id", "book not found")
 	}
 
-	_, userMissing := v.repository.GetUserByID(lending.UserID)
+	if lending.CopyID != "" {
+		v.checkLendingCopy(ctx, &errs, lending)
+	}
+
+	_, userMissing := v.repository.GetUserByID(ctx, lending.UserID)
 	if userMissing != nil {
-		errs = append(errs, fmt.Errorf("user not found"))
+		errs.Add("user_id", "user not found")
 	}
 
 	if lending.LendDate.IsZero() {
-		errs = append(errs, fmt.Errorf("lend_date is required"))
+		errs.Add("lend_date", "lend_date is required")
 	}
-
 	if !lending.ReturnDate.IsZero() {
-		if !lending.LendDate.Before(lending.ReturnDate) {
-			errs = append(errs, fmt.Errorf("lend_date is less than return_date"))
-		}
+		errs.Add("return_date", "return_date can only be set by returning the lending")
+	}
+	if lending.RenewalCount != 0 {
+		errs.Add("renewal_count", "renewal_count can only be changed by renewing the lending")
+	}
+
+	return errs.Err()
+}
+
+// CheckLendingUpdate keeps the book and the user of a lending fixed once it
+// was lent. Both were checked when the lending was created, so only a new
+// copy is looked up again. Dates and renewals are left to returning and
+// renewing, which also apply the due date rules and charge fines.
+func (v Validator) CheckLendingUpdate(ctx context.Context, stored, updated domain.Lending) error {
+	var errs validation.Errors
+
+	checkSameID(&errs, stored.ID, updated.ID)
+
+	if updated.BookID != stored.BookID {
+		errs.Add("book_id", "book_id cannot be changed after lend")
+	}
+
+	if updated.CopyID != "" && updated.CopyID != stored.CopyID {
+		v.checkLendingCopy(ctx, &errs, updated)
+	}
+
+	if updated.UserID != stored.UserID {
+		errs.Add("user_id", "user_id cannot be changed after lend")
+	}
+
+	if !updated.LendDate.Equal(stored.LendDate) {
+		errs.Add("lend_date", "lend_date cannot be changed after lend")
+	}
+	if !updated.DueDate.Equal(stored.DueDate) {
+		errs.Add("due_date", "due_date can only be changed by renewing the lending")
+	}
+	if updated.RenewalCount != stored.RenewalCount {
+		errs.Add("renewal_count", "renewal_count can only be changed by renewing the lending")
+	}
+	if !updated.ReturnDate.Equal(stored.ReturnDate) {
+		errs.Add("return_date", "return_date can only be set by returning the lending")
+	}
+
+	return errs.Err()
+}
+
+func (v Validator) checkLendingCopy(ctx context.Context, errs *validation.Errors, lending domain.Lending) {
+	bookCopy, copyMissing := v.repository.GetBookCopyByID(ctx, lending.CopyID)
+	if copyMissing != nil {
+		errs.Add("copy_id", "copy not found")
+	} else if bookCopy.BookID != lending.BookID {
+		errs.Add("copy_id", "copy does not belong to book")
+	}
+}
+
+func (v Validator) CheckHold(ctx context.Context, hold domain.Hold) error {
+	var errs validation.Errors
+
+	if hold.ID != "" {
+		errs.Add("id", "id should be empty")
+	}
+
+	_, bookMissing := v.repository.GetBookByID(ctx, hold.BookID)
+	if bookMissing != nil {
+		errs.Add("book_id", "book not found")
+	}
+
+	_, userMissing := v.repository.GetUserByID(ctx, hold.UserID)
+	if userMissing != nil {
+		errs.Add("user_id", "user not found")
 	}
 
-	return errors.Join(errs...)
+	return errs.Err()
 }
@@ -1,19 +1,26 @@
 package validator
 
 import (
+	"context"
 	"errors"
 	"libary-service/internal/domain"
+	"libary-service/internal/injected-service/repository"
+	"libary-service/internal/injected-service/validation"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 
 	"libary-service/generated/mocks"
 )
 
 func TestCheckBook(t *testing.T) {
-	v := New(nil)
+	// No other book has any of the ISBNs.
+	mockRepo := new(mocks.Repository)
+	mockRepo.On("GetBooks", mock.Anything, mock.AnythingOfType("repository.BookFilter"), mock.Anything).Return(repository.Page[domain.Book]{}, nil)
+	v := New(mockRepo)
 
 	testCases := []struct {
 		name           string
@@ -65,15 +72,313 @@ func TestCheckBook(t *testing.T) {
 			},
 			expectedErrors: []string{"title is required", "author is required", "id should be empty"},
 		},
+		{
+			name: "valid isbn",
+			book: domain.Book{
+				Title:  "The Fellowship of the Ring",
+				Author: "J.R.R. Tolkien",
+				ISBN:   "9780261102354",
+			},
+			expectedErrors: nil,
+		},
+		{
+			name: "isbn with wrong check digit",
+			book: domain.Book{
+				Title:  "The Fellowship of the Ring",
+				Author: "J.R.R. Tolkien",
+				ISBN:   "9780261102355",
+			},
+			expectedErrors: []string{"isbn is not a valid ISBN-10 or ISBN-13"},
+		},
+		{
+			name: "invalid isbn-10",
+			book: domain.Book{
+				Title:  "The Hobbit",
+				Author: "J.R.R. Tolkien",
+				ISBN:   "026110221X",
+			},
+			expectedErrors: []string{"isbn is not a valid ISBN-10 or ISBN-13"},
+		},
+		{
+			name: "all details",
+			book: domain.Book{
+				Title:           "Good Omens",
+				Author:          "Terry Pratchett",
+				Authors:         []string{"Terry Pratchett", "Neil Gaiman"},
+				Publisher:       "Gollancz",
+				PublicationYear: 1990,
+				Edition:         "1st",
+				Language:        "en",
+				PageCount:       288,
+				Subjects:        []string{"Fantasy", "Comedy"},
+				Description:     "The end of the world is nigh.",
+			},
+			expectedErrors: nil,
+		},
+		{
+			name: "author not first of authors",
+			book: domain.Book{
+				Title:   "Good Omens",
+				Author:  "Neil Gaiman",
+				Authors: []string{"Terry Pratchett", "Neil Gaiman"},
+			},
+			expectedErrors: []string{"author must be the first of authors"},
+		},
+		{
+			name: "empty author name",
+			book: domain.Book{
+				Title:   "Good Omens",
+				Author:  "Terry Pratchett",
+				Authors: []string{"Terry Pratchett", ""},
+			},
+			expectedErrors: []string{"authors cannot contain empty names"},
+		},
+		{
+			name: "invalid details",
+			book: domain.Book{
+				Title:           "Good Omens",
+				Author:          "Terry Pratchett",
+				PublicationYear: 2999,
+				Language:        "English",
+				PageCount:       -1,
+				Subjects:        []string{""},
+			},
+			expectedErrors: []string{
+				"publication_year cannot be in the future",
+				"language must be a lowercase ISO 639-1 code",
+				"page_count cannot be negative",
+				"subjects cannot contain empty entries",
+			},
+		},
+		{
+			name: "negative publication year",
+			book: domain.Book{
+				Title:           "Good Omens",
+				Author:          "Terry Pratchett",
+				PublicationYear: -1,
+			},
+			expectedErrors: []string{"publication_year cannot be negative"},
+		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			err := v.CheckBook(tc.book)
+			err := v.CheckBook(context.Background(), tc.book)
 			if len(tc.expectedErrors) == 0 {
 				assert.NoError(t, err)
 			} else {
-				assert.Error(t, err)
+				assert.ErrorIs(t, err, domain.ErrValidation)
+				for _, substr := range tc.expectedErrors {
+					assert.Contains(t, err.Error(), substr)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckBookUpdate(t *testing.T) {
+	mockRepo := new(mocks.Repository)
+	mockRepo.On("GetBooks", mock.Anything, mock.AnythingOfType("repository.BookFilter"), mock.Anything).Return(repository.Page[domain.Book]{}, nil)
+	v := New(mockRepo)
+	stored := domain.Book{ID: uuid.New().String(), Title: "The Two Towers", Author: "J.R.R. Tolkien"}
+
+	testCases := []struct {
+		name           string
+		book           domain.Book
+		expectedErrors []string
+	}{
+		{"valid update", domain.Book{ID: stored.ID, Title: "The Return of the King", Author: "J.R.R. Tolkien"}, nil},
+		{"id left out", domain.Book{Title: "The Return of the King", Author: "J.R.R. Tolkien"}, nil},
+		{"changed id", domain.Book{ID: uuid.New().String(), Title: "The Two Towers", Author: "J.R.R. Tolkien"}, []string{"id cannot be changed"}},
+		{"missing title", domain.Book{ID: stored.ID, Author: "J.R.R. Tolkien"}, []string{"title is required"}},
+		{"added isbn", domain.Book{ID: stored.ID, Title: "The Two Towers", Author: "J.R.R. Tolkien", ISBN: "9780261102361"}, nil},
+		{"invalid isbn", domain.Book{ID: stored.ID, Title: "The Two Towers", Author: "J.R.R. Tolkien", ISBN: "0261102361"},
+			[]string{"isbn is not a valid ISBN-10 or ISBN-13"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := v.CheckBookUpdate(context.Background(), stored, tc.book)
+			if len(tc.expectedErrors) == 0 {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, domain.ErrValidation)
+				for _, substr := range tc.expectedErrors {
+					assert.Contains(t, err.Error(), substr)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckBookISBNTaken(t *testing.T) {
+	stored := domain.Book{ID: uuid.New().String(), Title: "The Hobbit", Author: "J.R.R. Tolkien", ISBN: "9780261102217"}
+	other := domain.Book{ID: uuid.New().String(), Title: "The Fellowship of the Ring", Author: "J.R.R. Tolkien", ISBN: "9780261102354"}
+	mockRepo := new(mocks.Repository)
+	mockRepo.On("GetBooks", mock.Anything, repository.BookFilter{ISBN: other.ISBN}, mock.Anything).
+		Return(repository.Page[domain.Book]{Items: []domain.Book{other}, Total: 1}, nil)
+	v := New(mockRepo)
+
+	err := v.CheckBook(context.Background(), domain.Book{Title: "The Fellowship of the Ring", Author: "J.R.R. Tolkien", ISBN: other.ISBN})
+	var conflict validation.Conflict
+	assert.ErrorAs(t, err, &conflict)
+	assert.Equal(t, validation.Conflict{Field: "isbn", Message: "isbn is already taken"}, conflict)
+
+	updated := stored
+	updated.ISBN = other.ISBN
+	err = v.CheckBookUpdate(context.Background(), stored, updated)
+	assert.ErrorIs(t, err, domain.ErrConflict, "update to the ISBN of another book")
+
+	err = v.CheckBookUpdate(context.Background(), stored, stored)
+	assert.NoError(t, err, "update that keeps the ISBN")
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCheckBookISBNLookupFails(t *testing.T) {
+	stored := domain.Book{ID: uuid.New().String(), Title: "The Hobbit", Author: "J.R.R. Tolkien", ISBN: "9780261102217"}
+	mockRepo := new(mocks.Repository)
+	mockRepo.On("GetBooks", mock.Anything, mock.AnythingOfType("repository.BookFilter"), mock.Anything).
+		Return(repository.Page[domain.Book]{}, context.DeadlineExceeded)
+	v := New(mockRepo)
+
+	err := v.CheckBook(context.Background(), domain.Book{Title: "The Fellowship of the Ring", Author: "J.R.R. Tolkien", ISBN: "9780261102354"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	updated := stored
+	updated.ISBN = "9780261102354"
+	err = v.CheckBookUpdate(context.Background(), stored, updated)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestCheckBookCopy(t *testing.T) {
+	bookID := uuid.New().String()
+
+	testCases := []struct {
+		name           string
+		bookCopy       domain.BookCopy
+		bookErr        error
+		expectedErrors []string
+	}{
+		{
+			name: "valid copy",
+			bookCopy: domain.BookCopy{
+				BookID:    bookID,
+				Barcode:   "LIB-0001",
+				Condition: domain.CopyConditionGood,
+				Status:    domain.CopyStatusAvailable,
+			},
+			expectedErrors: nil,
+		},
+		{
+			name: "missing barcode",
+			bookCopy: domain.BookCopy{
+				BookID:    bookID,
+				Condition: domain.CopyConditionNew,
+				Status:    domain.CopyStatusAvailable,
+			},
+			expectedErrors: []string{"barcode is required"},
+		},
+		{
+			name: "invalid condition and status",
+			bookCopy: domain.BookCopy{
+				BookID:    bookID,
+				Barcode:   "LIB-0001",
+				Condition: "mint",
+				Status:    "borrowed",
+			},
+			expectedErrors: []string{"condition must be one of", "status must be one of"},
+		},
+		{
+			name: "on loan status",
+			bookCopy: domain.BookCopy{
+				BookID:    bookID,
+				Barcode:   "LIB-0001",
+				Condition: domain.CopyConditionGood,
+				Status:    domain.CopyStatusOnLoan,
+			},
+			expectedErrors: []string{"status on_loan is managed by lendings"},
+		},
+		{
+			name: "on hold status",
+			bookCopy: domain.BookCopy{
+				BookID:    bookID,
+				Barcode:   "LIB-0001",
+				Condition: domain.CopyConditionGood,
+				Status:    domain.CopyStatusOnHold,
+			},
+			expectedErrors: []string{"status on_hold is managed by holds"},
+		},
+		{
+			name: "non-empty id and missing book",
+			bookCopy: domain.BookCopy{
+				ID:        uuid.New().String(),
+				BookID:    bookID,
+				Barcode:   "LIB-0001",
+				Condition: domain.CopyConditionGood,
+				Status:    domain.CopyStatusAvailable,
+			},
+			bookErr:        errors.New("not found"),
+			expectedErrors: []string{"id should be empty", "book not found"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			mockRepo.On("GetBookByID", mock.Anything, bookID).Return(domain.Book{ID: bookID}, tc.bookErr)
+
+			val := New(mockRepo)
+			err := val.CheckBookCopy(context.Background(), tc.bookCopy)
+			if len(tc.expectedErrors) == 0 {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, domain.ErrValidation)
+				for _, substr := range tc.expectedErrors {
+					assert.Contains(t, err.Error(), substr)
+				}
+			}
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCheckBookCopyUpdate(t *testing.T) {
+	v := New(new(mocks.Repository))
+	stored := domain.BookCopy{ID: uuid.New().String(), BookID: uuid.New().String(), Barcode: "LIB-0001", Condition: domain.CopyConditionGood, Status: domain.CopyStatusAvailable}
+	onLoan := stored
+	onLoan.Status = domain.CopyStatusOnLoan
+	onHold := stored
+	onHold.Status = domain.CopyStatusOnHold
+
+	testCases := []struct {
+		name           string
+		stored         domain.BookCopy
+		bookCopy       domain.BookCopy
+		expectedErrors []string
+	}{
+		{"valid update", stored, domain.BookCopy{ID: stored.ID, Barcode: "LIB-0002", Condition: domain.CopyConditionFair, Status: domain.CopyStatusInRepair}, nil},
+		{"changed id", stored, domain.BookCopy{ID: uuid.New().String(), Barcode: "LIB-0001", Condition: domain.CopyConditionGood, Status: domain.CopyStatusAvailable},
+			[]string{"id cannot be changed"}},
+		{"missing barcode", stored, domain.BookCopy{Condition: domain.CopyConditionGood, Status: domain.CopyStatusAvailable}, []string{"barcode is required"}},
+		{"set on loan", stored, domain.BookCopy{Barcode: "LIB-0001", Condition: domain.CopyConditionGood, Status: domain.CopyStatusOnLoan},
+			[]string{"status on_loan is managed by lendings"}},
+		{"set on hold", stored, domain.BookCopy{Barcode: "LIB-0001", Condition: domain.CopyConditionGood, Status: domain.CopyStatusOnHold},
+			[]string{"status on_hold is managed by holds"}},
+		{"keeps on loan", onLoan, domain.BookCopy{Barcode: "LIB-0001", Condition: domain.CopyConditionPoor, Status: domain.CopyStatusOnLoan}, nil},
+		{"keeps on hold", onHold, domain.BookCopy{Barcode: "LIB-0001", Condition: domain.CopyConditionPoor, Status: domain.CopyStatusOnHold}, nil},
+		{"on loan to on hold", onLoan, domain.BookCopy{Barcode: "LIB-0001", Condition: domain.CopyConditionGood, Status: domain.CopyStatusOnHold},
+			[]string{"status on_hold is managed by holds"}},
+		{"kept status with invalid condition", onLoan, domain.BookCopy{Barcode: "LIB-0001", Condition: "mint", Status: domain.CopyStatusOnLoan},
+			[]string{"condition must be one of"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := v.CheckBookCopyUpdate(context.Background(), tc.stored, tc.bookCopy)
+			if len(tc.expectedErrors) == 0 {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, domain.ErrValidation)
 				for _, substr := range tc.expectedErrors {
 					assert.Contains(t, err.Error(), substr)
 				}
@@ -83,7 +388,10 @@ func TestCheckBook(t *testing.T) {
 }
 
 func TestCheckUser(t *testing.T) {
-	v := New(nil)
+	// No other user has any of the addresses.
+	mockRepo := new(mocks.Repository)
+	mockRepo.On("GetUsers", mock.Anything, mock.AnythingOfType("repository.UserFilter"), mock.Anything).Return(repository.Page[domain.User]{}, nil)
+	v := New(mockRepo)
 
 	testCases := []struct {
 		name           string
@@ -135,15 +443,57 @@ func TestCheckUser(t *testing.T) {
 			},
 			expectedErrors: []string{"name is required", "email is required", "id should be empty"},
 		},
+		{
+			name: "invalid email",
+			user: domain.User{
+				Name:  "Max Mustermann",
+				Email: "max.mustermann.de",
+			},
+			expectedErrors: []string{"email is not a valid address"},
+		},
+		{
+			name: "email with display name",
+			user: domain.User{
+				Name:  "Max Mustermann",
+				Email: "Max <max@mustermann.de>",
+			},
+			expectedErrors: []string{"email is not a valid address"},
+		},
+		{
+			name: "email without domain",
+			user: domain.User{
+				Name:  "Max Mustermann",
+				Email: "max@",
+			},
+			expectedErrors: []string{"email is not a valid address"},
+		},
+		{
+			name: "known category",
+			user: domain.User{
+				Name:     "Max Mustermann",
+				Email:    "max@mustermann.de",
+				Category: domain.UserCategoryStaff,
+			},
+			expectedErrors: nil,
+		},
+		{
+			name: "unknown category",
+			user: domain.User{
+				Name:     "Max Mustermann",
+				Email:    "max@mustermann.de",
+				Category: "visitor",
+			},
+			expectedErrors: []string{"category must be one of student, staff, guest"},
+		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			err := v.CheckUser(tc.user)
+			err := v.CheckUser(context.Background(), tc.user)
 			if len(tc.expectedErrors) == 0 {
 				assert.NoError(t, err)
 			} else {
-				assert.Error(t, err)
+				assert.ErrorIs(t, err, domain.ErrValidation)
 				for _, substr := range tc.expectedErrors {
 					assert.Contains(t, err.Error(), substr)
 				}
@@ -152,6 +502,76 @@ func TestCheckUser(t *testing.T) {
 	}
 }
 
+func TestCheckUserUpdate(t *testing.T) {
+	// No other user has any of the addresses.
+	mockRepo := new(mocks.Repository)
+	mockRepo.On("GetUsers", mock.Anything, mock.AnythingOfType("repository.UserFilter"), mock.Anything).Return(repository.Page[domain.User]{}, nil)
+	v := New(mockRepo)
+	stored := domain.User{ID: uuid.New().String(), Name: "Max Mustermann", Email: "max@mustermann.de", Category: domain.UserCategoryStudent}
+
+	testCases := []struct {
+		name           string
+		user           domain.User
+		expectedErrors []string
+	}{
+		{"valid update", domain.User{ID: stored.ID, Name: "Max Mustermann", Email: "max@example.com", Category: domain.UserCategoryStaff}, nil},
+		{"changed id", domain.User{ID: uuid.New().String(), Name: "Max Mustermann", Email: "max@mustermann.de"}, []string{"id cannot be changed"}},
+		{"missing email", domain.User{ID: stored.ID, Name: "Max Mustermann"}, []string{"email is required"}},
+		{"unknown category", domain.User{ID: stored.ID, Name: "Max Mustermann", Email: "max@mustermann.de", Category: "visitor"},
+			[]string{"category must be one of student, staff, guest"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := v.CheckUserUpdate(context.Background(), stored, tc.user)
+			if len(tc.expectedErrors) == 0 {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, domain.ErrValidation)
+				for _, substr := range tc.expectedErrors {
+					assert.Contains(t, err.Error(), substr)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckUserEmailTaken(t *testing.T) {
+	stored := domain.User{ID: uuid.New().String(), Name: "Max Mustermann", Email: "max@mustermann.de"}
+	other := domain.User{ID: uuid.New().String(), Name: "Erika Mustermann", Email: "erika@mustermann.de"}
+	mockRepo := new(mocks.Repository)
+	mockRepo.On("GetUsers", mock.Anything, repository.UserFilter{Email: other.Email}, mock.Anything).
+		Return(repository.Page[domain.User]{Items: []domain.User{other}, Total: 1}, nil)
+	v := New(mockRepo)
+
+	err := v.CheckUser(context.Background(), domain.User{Name: "Max Mustermann", Email: other.Email})
+	var conflict validation.Conflict
+	assert.ErrorAs(t, err, &conflict)
+	assert.Equal(t, validation.Conflict{Field: "email", Message: "email is already taken"}, conflict)
+	assert.ErrorIs(t, err, domain.ErrConflict)
+
+	err = v.CheckUserUpdate(context.Background(), stored, domain.User{ID: stored.ID, Name: "Max Mustermann", Email: other.Email})
+	assert.ErrorIs(t, err, domain.ErrConflict, "update to the address of another user")
+
+	err = v.CheckUserUpdate(context.Background(), stored, domain.User{ID: stored.ID, Name: "Max Mustermann", Email: stored.Email})
+	assert.NoError(t, err, "update that keeps the address")
+	mockRepo.AssertExpectations(t)
+}
+
+func TestCheckUserEmailLookupFails(t *testing.T) {
+	stored := domain.User{ID: uuid.New().String(), Name: "Max Mustermann", Email: "max@mustermann.de"}
+	mockRepo := new(mocks.Repository)
+	mockRepo.On("GetUsers", mock.Anything, mock.AnythingOfType("repository.UserFilter"), mock.Anything).
+		Return(repository.Page[domain.User]{}, context.DeadlineExceeded)
+	v := New(mockRepo)
+
+	err := v.CheckUser(context.Background(), domain.User{Name: "Erika Mustermann", Email: "erika@mustermann.de"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	err = v.CheckUserUpdate(context.Background(), stored, domain.User{ID: stored.ID, Name: "Max Mustermann", Email: "erika@mustermann.de"})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
 func TestCheckLending(t *testing.T) {
 	lendDate := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
 	returnDate := lendDate.Add(24 * time.Hour)
@@ -161,6 +581,13 @@ func TestCheckLending(t *testing.T) {
 		Title:  "The Fellowship of the Ring",
 		Author: "J.R.R. Tolkien",
 	}
+	validCopy := domain.BookCopy{
+		ID:        uuid.New().String(),
+		BookID:    validBook.ID,
+		Barcode:   "LIB-0001",
+		Condition: domain.CopyConditionGood,
+		Status:    domain.CopyStatusAvailable,
+	}
 	validUser := domain.User{
 		ID:    uuid.New().String(),
 		Name:  "Max Mustermann",
@@ -171,6 +598,8 @@ func TestCheckLending(t *testing.T) {
 		name           string
 		lending        domain.Lending
 		bookErr        error
+		bookCopy       domain.BookCopy
+		copyErr        error
 		userErr        error
 		expectedErrors []string
 	}{
@@ -179,37 +608,67 @@ func TestCheckLending(t *testing.T) {
 			lending: domain.Lending{
 				ID:         "",
 				BookID:     validBook.ID,
+				CopyID:     validCopy.ID,
 				UserID:     validUser.ID,
 				LendDate:   lendDate,
 				ReturnDate: time.Time{},
 			},
 			bookErr:        nil,
+			bookCopy:       validCopy,
 			userErr:        nil,
 			expectedErrors: nil,
 		},
 		{
-			name: "valid lending with valid return date",
+			name: "return date set",
 			lending: domain.Lending{
 				ID:         "",
 				BookID:     validBook.ID,
+				CopyID:     validCopy.ID,
 				UserID:     validUser.ID,
 				LendDate:   lendDate,
 				ReturnDate: returnDate,
 			},
 			bookErr:        nil,
+			bookCopy:       validCopy,
 			userErr:        nil,
-			expectedErrors: nil,
+			expectedErrors: []string{"return_date can only be set by returning the lending"},
+		},
+		{
+			name: "negative renewal count",
+			lending: domain.Lending{
+				BookID:       validBook.ID,
+				CopyID:       validCopy.ID,
+				UserID:       validUser.ID,
+				LendDate:     lendDate,
+				RenewalCount: -100,
+			},
+			bookCopy:       validCopy,
+			expectedErrors: []string{"renewal_count can only be changed by renewing the lending"},
+		},
+		{
+			name: "renewal count set",
+			lending: domain.Lending{
+				BookID:       validBook.ID,
+				CopyID:       validCopy.ID,
+				UserID:       validUser.ID,
+				LendDate:     lendDate,
+				RenewalCount: 5,
+			},
+			bookCopy:       validCopy,
+			expectedErrors: []string{"renewal_count can only be changed by renewing the lending"},
 		},
 		{
 			name: "non-empty id",
 			lending: domain.Lending{
 				ID:         uuid.New().String(),
 				BookID:     validBook.ID,
+				CopyID:     validCopy.ID,
 				UserID:     validUser.ID,
 				LendDate:   lendDate,
-				ReturnDate: returnDate,
+				ReturnDate: time.Time{},
 			},
 			bookErr:        nil,
+			bookCopy:       validCopy,
 			userErr:        nil,
 			expectedErrors: []string{"id should be empty"},
 		},
@@ -218,11 +677,13 @@ func TestCheckLending(t *testing.T) {
 			lending: domain.Lending{
 				ID:         "",
 				BookID:     uuid.New().String(),
+				CopyID:     validCopy.ID,
 				UserID:     validUser.ID,
 				LendDate:   lendDate,
-				ReturnDate: returnDate,
+				ReturnDate: time.Time{},
 			},
 			bookErr:        errors.New("not found"),
+			bookCopy:       validCopy,
 			userErr:        nil,
 			expectedErrors: []string{"book not found"},
 		},
@@ -231,11 +692,13 @@ func TestCheckLending(t *testing.T) {
 			lending: domain.Lending{
 				ID:         "",
 				BookID:     validBook.ID,
+				CopyID:     validCopy.ID,
 				UserID:     uuid.New().String(),
 				LendDate:   lendDate,
-				ReturnDate: returnDate,
+				ReturnDate: time.Time{},
 			},
 			bookErr:        nil,
+			bookCopy:       validCopy,
 			userErr:        errors.New("not found"),
 			expectedErrors: []string{"user not found"},
 		},
@@ -244,54 +707,79 @@ func TestCheckLending(t *testing.T) {
 			lending: domain.Lending{
 				ID:         "",
 				BookID:     validBook.ID,
+				CopyID:     validCopy.ID,
 				UserID:     validUser.ID,
 				LendDate:   time.Time{},
 				ReturnDate: time.Time{},
 			},
 			bookErr:        nil,
+			bookCopy:       validCopy,
 			userErr:        nil,
 			expectedErrors: []string{"lend_date is required"},
 		},
-		{
-			name: "invalid return date (lend_date not before return_date)",
-			lending: domain.Lending{
-				ID:         "",
-				BookID:     validBook.ID,
-				UserID:     validUser.ID,
-				LendDate:   lendDate,
-				ReturnDate: lendDate,
-			},
-			bookErr:        nil,
-			userErr:        nil,
-			expectedErrors: []string{"lend_date is less than return_date"},
-		},
 		{
 			name: "multiple errors in lending",
 			lending: domain.Lending{
 				ID:         uuid.New().String(),
 				BookID:     uuid.New().String(),
+				CopyID:     validCopy.ID,
 				UserID:     uuid.New().String(),
 				LendDate:   lendDate,
 				ReturnDate: lendDate,
 			},
 			bookErr:        errors.New("not found"),
+			bookCopy:       validCopy,
 			userErr:        errors.New("not found"),
-			expectedErrors: []string{"id should be empty", "book not found", "user not found", "lend_date is less than return_date"},
+			expectedErrors: []string{"id should be empty", "book not found", "user not found", "return_date can only be set by returning the lending"},
+		},
+		{
+			name: "lending without copy_id",
+			lending: domain.Lending{
+				BookID:   validBook.ID,
+				UserID:   validUser.ID,
+				LendDate: lendDate,
+			},
+			expectedErrors: nil,
+		},
+		{
+			name: "missing copy",
+			lending: domain.Lending{
+				BookID:   validBook.ID,
+				CopyID:   uuid.New().String(),
+				UserID:   validUser.ID,
+				LendDate: lendDate,
+			},
+			copyErr:        errors.New("not found"),
+			expectedErrors: []string{"copy not found"},
+		},
+		{
+			name: "copy of another book",
+			lending: domain.Lending{
+				BookID:   validBook.ID,
+				CopyID:   validCopy.ID,
+				UserID:   validUser.ID,
+				LendDate: lendDate,
+			},
+			bookCopy:       domain.BookCopy{ID: validCopy.ID, BookID: uuid.New().String()},
+			expectedErrors: []string{"copy does not belong to book"},
 		},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			mockRepo := new(mocks.Repository)
-			mockRepo.On("GetBookByID", tc.lending.BookID).Return(validBook, tc.bookErr)
-			mockRepo.On("GetUserByID", tc.lending.UserID).Return(validUser, tc.userErr)
+			mockRepo.On("GetBookByID", mock.Anything, tc.lending.BookID).Return(validBook, tc.bookErr)
+			mockRepo.On("GetUserByID", mock.Anything, tc.lending.UserID).Return(validUser, tc.userErr)
+			if tc.lending.CopyID != "" {
+				mockRepo.On("GetBookCopyByID", mock.Anything, tc.lending.CopyID).Return(tc.bookCopy, tc.copyErr)
+			}
 
 			val := New(mockRepo)
-			err := val.CheckLending(tc.lending)
+			err := val.CheckLending(context.Background(), tc.lending)
 			if len(tc.expectedErrors) == 0 {
 				assert.NoError(t, err)
 			} else {
-				assert.Error(t, err)
+				assert.ErrorIs(t, err, domain.ErrValidation)
 				for _, substr := range tc.expectedErrors {
 					assert.Contains(t, err.Error(), substr)
 				}
@@ -300,3 +788,133 @@ func TestCheckLending(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckLendingUpdate(t *testing.T) {
+	lendDate := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+	bookID := uuid.New().String()
+	stored := domain.Lending{
+		ID:           uuid.New().String(),
+		BookID:       bookID,
+		CopyID:       uuid.New().String(),
+		UserID:       uuid.New().String(),
+		LendDate:     lendDate,
+		DueDate:      lendDate.AddDate(0, 0, 28),
+		RenewalCount: 2,
+	}
+	newCopyID := uuid.New().String()
+
+	testCases := []struct {
+		name           string
+		update         func(l *domain.Lending)
+		bookCopy       domain.BookCopy
+		copyErr        error
+		expectedErrors []string
+	}{
+		{"changed book", func(l *domain.Lending) { l.BookID = uuid.New().String() }, domain.BookCopy{}, nil,
+			[]string{"book_id cannot be changed after lend"}},
+		{"changed user", func(l *domain.Lending) { l.UserID = uuid.New().String() }, domain.BookCopy{}, nil,
+			[]string{"user_id cannot be changed after lend"}},
+		{"changed id", func(l *domain.Lending) { l.ID = uuid.New().String() }, domain.BookCopy{}, nil,
+			[]string{"id cannot be changed"}},
+		{"other copy of the book", func(l *domain.Lending) { l.CopyID = newCopyID }, domain.BookCopy{ID: newCopyID, BookID: bookID}, nil, nil},
+		{"copy of another book", func(l *domain.Lending) { l.CopyID = newCopyID }, domain.BookCopy{ID: newCopyID, BookID: uuid.New().String()}, nil,
+			[]string{"copy does not belong to book"}},
+		{"missing copy", func(l *domain.Lending) { l.CopyID = newCopyID }, domain.BookCopy{}, errors.New("not found"),
+			[]string{"copy not found"}},
+		{"unchanged", func(l *domain.Lending) {}, domain.BookCopy{}, nil, nil},
+		{"same dates in another zone", func(l *domain.Lending) { l.LendDate = lendDate.In(time.FixedZone("CET", 3600)) }, domain.BookCopy{}, nil, nil},
+		{"changed lend date", func(l *domain.Lending) { l.LendDate = lendDate.Add(-time.Hour) }, domain.BookCopy{}, nil,
+			[]string{"lend_date cannot be changed after lend"}},
+		{"changed due date", func(l *domain.Lending) { l.DueDate = time.Time{} }, domain.BookCopy{}, nil,
+			[]string{"due_date can only be changed by renewing the lending"}},
+		{"reset renewals", func(l *domain.Lending) { l.RenewalCount = 0 }, domain.BookCopy{}, nil,
+			[]string{"renewal_count can only be changed by renewing the lending"}},
+		{"return", func(l *domain.Lending) { l.ReturnDate = lendDate.Add(24 * time.Hour) }, domain.BookCopy{}, nil,
+			[]string{"return_date can only be set by returning the lending"}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			updated := stored
+			tc.update(&updated)
+			// Book and user were checked when the lending was created, so only a new copy is looked up.
+			mockRepo := new(mocks.Repository)
+			if updated.CopyID != stored.CopyID {
+				mockRepo.On("GetBookCopyByID", mock.Anything, updated.CopyID).Return(tc.bookCopy, tc.copyErr)
+			}
+
+			err := New(mockRepo).CheckLendingUpdate(context.Background(), stored, updated)
+			if len(tc.expectedErrors) == 0 {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, domain.ErrValidation)
+				for _, substr := range tc.expectedErrors {
+					assert.Contains(t, err.Error(), substr)
+				}
+			}
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestCheckHold(t *testing.T) {
+	validBook := domain.Book{ID: uuid.New().String(), Title: "The Two Towers", Author: "J.R.R. Tolkien"}
+	validUser := domain.User{ID: uuid.New().String(), Name: "Max Mustermann", Email: "max@mustermann.de"}
+
+	testCases := []struct {
+		name           string
+		hold           domain.Hold
+		bookErr        error
+		userErr        error
+		expectedErrors []string
+	}{
+		{
+			name:           "valid hold",
+			hold:           domain.Hold{BookID: validBook.ID, UserID: validUser.ID},
+			expectedErrors: nil,
+		},
+		{
+			name:           "non-empty id",
+			hold:           domain.Hold{ID: uuid.New().String(), BookID: validBook.ID, UserID: validUser.ID},
+			expectedErrors: []string{"id should be empty"},
+		},
+		{
+			name:           "missing book and user",
+			hold:           domain.Hold{BookID: uuid.New().String(), UserID: uuid.New().String()},
+			bookErr:        errors.New("not found"),
+			userErr:        errors.New("not found"),
+			expectedErrors: []string{"book not found", "user not found"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mockRepo := new(mocks.Repository)
+			mockRepo.On("GetBookByID", mock.Anything, tc.hold.BookID).Return(validBook, tc.bookErr)
+			mockRepo.On("GetUserByID", mock.Anything, tc.hold.UserID).Return(validUser, tc.userErr)
+
+			val := New(mockRepo)
+			err := val.CheckHold(context.Background(), tc.hold)
+			if len(tc.expectedErrors) == 0 {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, domain.ErrValidation)
+				for _, substr := range tc.expectedErrors {
+					assert.Contains(t, err.Error(), substr)
+				}
+			}
+			mockRepo.AssertExpectations(t)
+		})
+	}
+}
+
+func TestFieldErrors(t *testing.T) {
+	v := New(new(mocks.Repository))
+	err := v.CheckBook(context.Background(), domain.Book{ID: uuid.NewString(), Author: "J.R.R. Tolkien"})
+	var fieldErrors validation.Errors
+	assert.True(t, errors.As(err, &fieldErrors))
+	assert.Equal(t, validation.Errors{
+		{Field: "title", Message: "title is required"},
+		{Field: "id", Message: "id should be empty"},
+	}, fieldErrors)
+}
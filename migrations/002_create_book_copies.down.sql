@@ -0,0 +1,2 @@
+ALTER TABLE lendings DROP COLUMN IF EXISTS copy_id;
+DROP TABLE IF EXISTS book_copies;
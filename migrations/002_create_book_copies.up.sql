@@ -0,0 +1,38 @@
+CREATE TABLE book_copies (
+    id UUID PRIMARY KEY,
+    book_id UUID NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    barcode TEXT NOT NULL UNIQUE,
+    condition TEXT NOT NULL DEFAULT 'good'
+        CHECK (condition IN ('new', 'good', 'fair', 'poor', 'damaged')),
+    status TEXT NOT NULL DEFAULT 'available'
+        CHECK (status IN ('available', 'on_loan', 'in_repair', 'lost', 'withdrawn'))
+);
+
+CREATE INDEX book_copies_book_id_idx ON book_copies (book_id);
+
+-- Every existing title gets one copy so that current lendings can point at it.
+INSERT INTO book_copies (id, book_id, barcode)
+SELECT gen_random_uuid(), id, 'LEGACY-' || id FROM books;
+
+ALTER TABLE lendings ADD COLUMN copy_id UUID REFERENCES book_copies(id) ON DELETE CASCADE;
+
+UPDATE lendings l SET copy_id = c.id FROM book_copies c WHERE c.book_id = l.book_id;
+
+-- Books used to be lent out any number of times at once. Every active lending
+-- after the first of its book gets a copy of its own, so that no copy is part
+-- of two active lendings once migration 003 forbids that.
+WITH extra AS (
+    SELECT id, book_id FROM (
+        SELECT id, book_id, row_number() OVER (PARTITION BY book_id ORDER BY lend_date, id) AS n
+        FROM lendings WHERE return_date IS NULL
+    ) AS active
+    WHERE n > 1
+), copies AS (
+    INSERT INTO book_copies (id, book_id, barcode)
+    SELECT gen_random_uuid(), book_id, 'LEGACY-' || id FROM extra
+    RETURNING id, barcode
+)
+UPDATE lendings l SET copy_id = copies.id FROM copies WHERE copies.barcode = 'LEGACY-' || l.id;
+
+UPDATE book_copies c SET status = 'on_loan'
+WHERE EXISTS (SELECT 1 FROM lendings l WHERE l.copy_id = c.id AND l.return_date IS NULL);
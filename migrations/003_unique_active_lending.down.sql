@@ -0,0 +1 @@
+DROP INDEX IF EXISTS lendings_active_copy_idx;
@@ -0,0 +1,2 @@
+-- A copy can only be part of one lending that has not been returned yet.
+CREATE UNIQUE INDEX lendings_active_copy_idx ON lendings (copy_id) WHERE return_date IS NULL;
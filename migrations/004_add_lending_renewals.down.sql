@@ -0,0 +1 @@
+ALTER TABLE lendings DROP COLUMN IF EXISTS renewal_count;
@@ -0,0 +1 @@
+ALTER TABLE lendings ADD COLUMN renewal_count INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS lendings_overdue_idx;
+ALTER TABLE lendings DROP COLUMN IF EXISTS due_date;
@@ -0,0 +1,7 @@
+ALTER TABLE lendings ADD COLUMN due_date TIMESTAMP WITH TIME ZONE;
+
+-- Existing lendings get the default loan period of 21 days.
+UPDATE lendings SET due_date = lend_date + INTERVAL '21 days';
+
+-- Serves the overdue report: only lendings that are still out are indexed.
+CREATE INDEX lendings_overdue_idx ON lendings (due_date) WHERE return_date IS NULL;
@@ -0,0 +1,13 @@
+CREATE TABLE fines (
+    id UUID PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    lending_id UUID REFERENCES lendings(id) ON DELETE SET NULL,
+    reason TEXT NOT NULL CHECK (reason IN ('overdue', 'lost')),
+    -- Minor currency units, never floats.
+    amount BIGINT NOT NULL CHECK (amount >= 0),
+    status TEXT NOT NULL DEFAULT 'unpaid' CHECK (status IN ('unpaid', 'paid', 'waived')),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    settled_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX fines_user_id_idx ON fines (user_id);
@@ -0,0 +1,21 @@
+ALTER TABLE book_copies DROP CONSTRAINT IF EXISTS book_copies_status_check;
+ALTER TABLE book_copies ADD CONSTRAINT book_copies_status_check
+    CHECK (status IN ('available', 'on_loan', 'on_hold', 'in_repair', 'lost', 'withdrawn'));
+
+CREATE TABLE holds (
+    id UUID PRIMARY KEY,
+    book_id UUID NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    copy_id UUID REFERENCES book_copies(id) ON DELETE SET NULL,
+    status TEXT NOT NULL DEFAULT 'waiting'
+        CHECK (status IN ('waiting', 'ready', 'fulfilled', 'cancelled', 'expired')),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    ready_at TIMESTAMP WITH TIME ZONE,
+    expires_at TIMESTAMP WITH TIME ZONE
+);
+
+-- The FIFO queue of a book: only holds that still wait or are ready are indexed.
+CREATE INDEX holds_queue_idx ON holds (book_id, created_at) WHERE status IN ('waiting', 'ready');
+
+-- A patron can only queue once per book.
+CREATE UNIQUE INDEX holds_active_user_idx ON holds (book_id, user_id) WHERE status IN ('waiting', 'ready');
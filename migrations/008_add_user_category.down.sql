@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS lendings_active_user_idx;
+ALTER TABLE users DROP COLUMN IF EXISTS category;
@@ -0,0 +1,6 @@
+-- Existing patrons keep borrowing as students.
+ALTER TABLE users ADD COLUMN category TEXT NOT NULL DEFAULT 'student'
+    CHECK (category IN ('student', 'staff', 'guest'));
+
+-- Serves the loan limit check: only lendings that are still out are indexed.
+CREATE INDEX lendings_active_user_idx ON lendings (user_id) WHERE return_date IS NULL;
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS lendings_book_id_idx;
+DROP INDEX IF EXISTS books_title_pattern_idx;
+DROP INDEX IF EXISTS lendings_lend_date_id_idx;
+DROP INDEX IF EXISTS users_name_id_idx;
+DROP INDEX IF EXISTS books_author_id_idx;
+DROP INDEX IF EXISTS books_title_id_idx;
@@ -0,0 +1,11 @@
+-- Keyset pagination orders every list by (sort column, id).
+CREATE INDEX books_title_id_idx ON books (title, id);
+CREATE INDEX books_author_id_idx ON books (author, id);
+CREATE INDEX users_name_id_idx ON users (name, id);
+CREATE INDEX lendings_lend_date_id_idx ON lendings (lend_date, id);
+
+-- Serves the title_prefix filter, which LIKE can only use with pattern ops.
+CREATE INDEX books_title_pattern_idx ON books (title text_pattern_ops);
+
+-- Serves the book_id filter of lendings.
+CREATE INDEX lendings_book_id_idx ON lendings (book_id);
@@ -0,0 +1,8 @@
+-- The catalogue search matches whole words without stemming so that the
+-- in-memory index can behave the same. Title matches outrank author matches.
+ALTER TABLE books ADD COLUMN search TSVECTOR GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', title), 'A') ||
+    setweight(to_tsvector('simple', author), 'B')
+) STORED;
+
+CREATE INDEX books_search_idx ON books USING GIN (search);
@@ -0,0 +1,19 @@
+DROP INDEX IF EXISTS lendings_deleted_at_idx;
+DROP INDEX IF EXISTS users_deleted_at_idx;
+DROP INDEX IF EXISTS books_deleted_at_idx;
+
+-- Without the column deleted rows would come back, so they go for good.
+DELETE FROM lendings WHERE deleted_at IS NOT NULL;
+DELETE FROM users WHERE deleted_at IS NOT NULL;
+DELETE FROM books WHERE deleted_at IS NOT NULL;
+
+DROP INDEX IF EXISTS lendings_active_user_idx;
+CREATE INDEX lendings_active_user_idx ON lendings (user_id) WHERE return_date IS NULL;
+DROP INDEX IF EXISTS lendings_overdue_idx;
+CREATE INDEX lendings_overdue_idx ON lendings (due_date) WHERE return_date IS NULL;
+DROP INDEX IF EXISTS lendings_active_copy_idx;
+CREATE UNIQUE INDEX lendings_active_copy_idx ON lendings (copy_id) WHERE return_date IS NULL;
+
+ALTER TABLE lendings DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE books DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,5 @@
+-- Every update of a book, user or lending bumps its version, which the API
+-- hands out as an ETag so that concurrent edits cannot overwrite each other.
+ALTER TABLE books ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE users ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE lendings ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
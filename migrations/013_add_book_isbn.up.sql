@@ -0,0 +1,4 @@
+-- Books are identified by their ISBN-13, ISBN-10s are converted before they
+-- are stored. Books catalogued before have none, which the index allows.
+ALTER TABLE books ADD COLUMN isbn TEXT;
+CREATE UNIQUE INDEX books_isbn_key ON books (isbn);
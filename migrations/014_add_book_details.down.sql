@@ -0,0 +1,9 @@
+DROP TABLE IF EXISTS book_authors;
+ALTER TABLE books
+    DROP COLUMN IF EXISTS description,
+    DROP COLUMN IF EXISTS subjects,
+    DROP COLUMN IF EXISTS page_count,
+    DROP COLUMN IF EXISTS language,
+    DROP COLUMN IF EXISTS edition,
+    DROP COLUMN IF EXISTS publication_year,
+    DROP COLUMN IF EXISTS publisher;
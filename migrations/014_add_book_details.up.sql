@@ -0,0 +1,25 @@
+-- The catalogue details of a book. Existing books start without any.
+ALTER TABLE books
+    ADD COLUMN publisher TEXT NOT NULL DEFAULT '',
+    ADD COLUMN publication_year INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN edition TEXT NOT NULL DEFAULT '',
+    ADD COLUMN language TEXT NOT NULL DEFAULT '',
+    ADD COLUMN page_count INTEGER NOT NULL DEFAULT 0 CHECK (page_count >= 0),
+    ADD COLUMN subjects TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN description TEXT NOT NULL DEFAULT '';
+
+-- Every author of a book in the order of the title page. books.author keeps
+-- the first of them for sorting and the search index.
+CREATE TABLE book_authors (
+    book_id UUID NOT NULL REFERENCES books(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    name TEXT NOT NULL,
+    PRIMARY KEY (book_id, position)
+);
+
+-- Serves the author filter of the book list.
+CREATE INDEX book_authors_name_idx ON book_authors (name);
+
+-- Every existing book gets its author as the only one.
+INSERT INTO book_authors (book_id, position, name)
+SELECT id, 0, author FROM books;
@@ -0,0 +1,3 @@
+ALTER TABLE lendings
+    DROP CONSTRAINT lendings_copy_id_fkey,
+    ADD CONSTRAINT lendings_copy_id_fkey FOREIGN KEY (copy_id) REFERENCES book_copies(id) ON DELETE CASCADE;
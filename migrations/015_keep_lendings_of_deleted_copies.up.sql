@@ -0,0 +1,5 @@
+-- Deleting a copy keeps its lendings as history, they just lose the copy.
+-- Copies that are lent out cannot be deleted.
+ALTER TABLE lendings
+    DROP CONSTRAINT lendings_copy_id_fkey,
+    ADD CONSTRAINT lendings_copy_id_fkey FOREIGN KEY (copy_id) REFERENCES book_copies(id) ON DELETE SET NULL;
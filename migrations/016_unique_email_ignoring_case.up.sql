@@ -0,0 +1,13 @@
+-- Email addresses are stored trimmed and lowercased, and an address is taken
+-- however it was typed. Users that only differ in the case of their address
+-- have to be merged by hand before this migration can run.
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM users GROUP BY lower(trim(email)) HAVING COUNT(*) > 1) THEN
+        RAISE EXCEPTION 'users share an email address that differs only in case or whitespace';
+    END IF;
+END $$;
+
+ALTER TABLE users DROP CONSTRAINT users_email_key;
+UPDATE users SET email = lower(trim(email)) WHERE email <> lower(trim(email));
+CREATE UNIQUE INDEX users_email_key ON users (lower(email));
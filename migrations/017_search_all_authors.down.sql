@@ -0,0 +1,13 @@
+DROP TRIGGER IF EXISTS book_authors_search ON book_authors;
+DROP TRIGGER IF EXISTS books_search ON books;
+DROP FUNCTION IF EXISTS book_authors_search_update();
+DROP FUNCTION IF EXISTS books_search_update();
+DROP FUNCTION IF EXISTS book_search(UUID, TEXT, TEXT);
+ALTER TABLE books DROP COLUMN IF EXISTS search;
+
+ALTER TABLE books ADD COLUMN search TSVECTOR GENERATED ALWAYS AS (
+    setweight(to_tsvector('simple', title), 'A') ||
+    setweight(to_tsvector('simple', author), 'B')
+) STORED;
+
+CREATE INDEX books_search_idx ON books USING GIN (search);
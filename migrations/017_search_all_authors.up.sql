@@ -0,0 +1,37 @@
+-- The search column covers every author from book_authors, not only the first.
+-- A generated column cannot read another table, so triggers keep it up to date.
+-- Books without rows in book_authors fall back to their author.
+ALTER TABLE books DROP COLUMN search;
+ALTER TABLE books ADD COLUMN search TSVECTOR;
+
+CREATE FUNCTION book_search(book UUID, title TEXT, author TEXT) RETURNS TSVECTOR AS $$
+    SELECT setweight(to_tsvector('simple', $2), 'A') ||
+        setweight(to_tsvector('simple', coalesce(
+            (SELECT string_agg(name, ' ' ORDER BY position) FROM book_authors WHERE book_authors.book_id = $1),
+            $3)), 'B')
+$$ LANGUAGE sql STABLE;
+
+CREATE FUNCTION books_search_update() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.search := book_search(NEW.id, NEW.title, NEW.author);
+    RETURN NEW;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER books_search BEFORE INSERT OR UPDATE OF title, author ON books
+    FOR EACH ROW EXECUTE FUNCTION books_search_update();
+
+-- OLD is NULL for inserts and NEW for deletes.
+CREATE FUNCTION book_authors_search_update() RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE books SET search = book_search(id, title, author) WHERE id IN (OLD.book_id, NEW.book_id);
+    RETURN NULL;
+END
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER book_authors_search AFTER INSERT OR UPDATE OR DELETE ON book_authors
+    FOR EACH ROW EXECUTE FUNCTION book_authors_search_update();
+
+UPDATE books SET search = book_search(id, title, author);
+
+CREATE INDEX books_search_idx ON books USING GIN (search);
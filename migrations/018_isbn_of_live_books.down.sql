@@ -0,0 +1,3 @@
+-- Fails while a deleted book shares its ISBN with another book.
+DROP INDEX IF EXISTS books_isbn_key;
+CREATE UNIQUE INDEX books_isbn_key ON books (isbn);
@@ -0,0 +1,4 @@
+-- Deleted books give their ISBN free, so the book can be catalogued again.
+-- Restoring a deleted book fails while another book has its ISBN.
+DROP INDEX books_isbn_key;
+CREATE UNIQUE INDEX books_isbn_key ON books (isbn) WHERE deleted_at IS NULL;
@@ -0,0 +1,3 @@
+-- Fails while a deleted user shares their address with another user.
+DROP INDEX IF EXISTS users_email_key;
+CREATE UNIQUE INDEX users_email_key ON users (lower(email));
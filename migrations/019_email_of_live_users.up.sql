@@ -0,0 +1,4 @@
+-- Deleted users give their email address free, so the person can sign up
+-- again. Restoring a deleted user fails while another user has the address.
+DROP INDEX users_email_key;
+CREATE UNIQUE INDEX users_email_key ON users (lower(email)) WHERE deleted_at IS NULL;